@@ -0,0 +1,121 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchContentType is the media type of the batched content mode of the
+// CloudEvents HTTP binding: a JSON array of structured events.
+const BatchContentType = "application/cloudevents-batch+json"
+
+// structuredEvent is an event that is not a cloud event, sent as the data of
+// a new one, like in binary content mode.
+type structuredEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// ToStructuredEvent returns an event as a member of a batch. A cloud event
+// is taken as is; any other JSON event, like the Redfish sample events,
+// becomes the data of a new cloud event with the Id of the event, if it has
+// one.
+func ToStructuredEvent(event []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return nil, fmt.Errorf("event is not a JSON object: %w", err)
+	}
+	if _, ok := fields["specversion"]; ok {
+		return bytes.TrimSpace(event), nil
+	}
+	var id string
+	json.Unmarshal(fields["Id"], &id) //nolint: errcheck
+	if id == "" {
+		id = NewUUID()
+	}
+	return json.Marshal(&structuredEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Type:            defaultEventType,
+		Source:          DefaultEventSource,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            bytes.TrimSpace(event),
+	})
+}
+
+// NewBatch returns the body of a batch of size copies of an event, for the
+// runs that send the same event over and over.
+func NewBatch(event []byte, size int) ([]byte, error) {
+	member, err := ToStructuredEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(size * (len(member) + 1))
+	buf.WriteByte('[')
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(member)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// BatchRenderer renders a batch of events of a template or a generator for
+// every send. Like them it is safe for the send shards to call
+// concurrently.
+type BatchRenderer struct {
+	events Renderer
+	size   int
+	// check is called with every event of a batch; the batch is not sent
+	// if it returns false
+	check   func(event []byte) bool
+	scratch sync.Pool
+}
+
+// NewBatchRenderer returns the renderer of the batches of size events of
+// events. The events are checked with check, if not nil.
+func NewBatchRenderer(events Renderer, size int, check func(event []byte) bool) *BatchRenderer {
+	return &BatchRenderer{
+		events:  events,
+		size:    size,
+		check:   check,
+		scratch: sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+}
+
+func (b *BatchRenderer) Render(buf *bytes.Buffer) error {
+	scratch := b.scratch.Get().(*bytes.Buffer)
+	defer b.scratch.Put(scratch)
+	buf.Reset()
+	buf.WriteByte('[')
+	for i := 0; i < b.size; i++ {
+		if err := b.events.Render(scratch); err != nil {
+			return err
+		}
+		if b.check != nil && !b.check(scratch.Bytes()) {
+			return fmt.Errorf("event %d of the batch violates its schema", i+1)
+		}
+		member, err := ToStructuredEvent(scratch.Bytes())
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(member)
+	}
+	buf.WriteByte(']')
+	return nil
+}
@@ -0,0 +1,237 @@
+package events
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// CloudEvents content modes of the sent events
+const (
+	ContentStructured = "structured"
+	ContentBinary     = "binary"
+)
+
+// Content-Type values of the events: -content-type auto sends structured
+// cloud events as application/cloudevents+json and other JSON events as
+// application/json
+const (
+	ContentTypeAuto       = "auto"
+	JSONContentType       = "application/json"
+	StructuredContentType = "application/cloudevents+json"
+)
+
+// Attributes of events that are not cloud events, in binary content mode.
+const (
+	defaultEventType   = "com.github.jzding.cloud-event-tools.event"
+	DefaultEventSource = "/cloud-event-tester"
+)
+
+// binaryEvent is an event in binary content mode: its context attributes are
+// sent as ce- headers and only its data as the body.
+type binaryEvent struct {
+	attrs       map[string]string
+	contentType string
+	data        []byte
+}
+
+// toBinaryEvent maps an event to binary content mode. A structured cloud event
+// has its attributes and extensions mapped to headers and its data, decoded
+// from data_base64 or a string for non-JSON content types, sent as the body.
+// Any other JSON event, like the Redfish sample events, is sent whole as the
+// data of a new cloud event with the Id of the event, if it has one.
+func toBinaryEvent(event []byte) (*binaryEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return nil, fmt.Errorf("event is not a JSON object: %w", err)
+	}
+	if _, ok := fields["specversion"]; !ok {
+		var id string
+		json.Unmarshal(fields["Id"], &id) //nolint: errcheck
+		if id == "" {
+			id = NewUUID()
+		}
+		return &binaryEvent{
+			attrs: map[string]string{
+				"specversion": "1.0",
+				"id":          id,
+				"type":        defaultEventType,
+				"source":      DefaultEventSource,
+				"time":        time.Now().UTC().Format(time.RFC3339Nano),
+			},
+			contentType: "application/json",
+			data:        bytes.TrimSpace(event),
+		}, nil
+	}
+
+	e := &binaryEvent{attrs: map[string]string{}, contentType: "application/json"}
+	for name, raw := range fields {
+		switch name {
+		case "data", "data_base64":
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			e.attrs[name] = v
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("attribute %s is not a scalar", name)
+		default:
+			e.attrs[name] = string(raw)
+		}
+	}
+	for _, attr := range []string{"id", "type", "source"} {
+		if e.attrs[attr] == "" {
+			return nil, fmt.Errorf("cloud event without %s", attr)
+		}
+	}
+	if ct := e.attrs["datacontenttype"]; ct != "" {
+		e.contentType = ct
+		delete(e.attrs, "datacontenttype")
+	}
+
+	if raw, ok := fields["data_base64"]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("data_base64 is not a string: %w", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("data_base64: %w", err)
+		}
+		e.data = data
+	} else if raw, ok := fields["data"]; ok {
+		e.data = raw
+		// a string is the data itself unless the data is JSON
+		var s string
+		if !IsJSONContentType(e.contentType) && json.Unmarshal(raw, &s) == nil {
+			e.data = []byte(s)
+		}
+	}
+	return e, nil
+}
+
+// IsJSONContentType reports whether the data of a content type is JSON.
+func IsJSONContentType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
+}
+
+// apply sets the headers and the body of req to the event.
+func (e *binaryEvent) apply(req *fasthttp.Request) {
+	for name, v := range e.attrs {
+		req.Header.Set("ce-"+name, EncodeHeaderValue(v))
+	}
+	req.Header.SetContentType(e.contentType)
+	req.SetBody(e.data)
+}
+
+// EncodeHeaderValue returns an attribute value as the value of its ce- header,
+// with the space, '"', '%' and the bytes outside printable ASCII of its UTF-8
+// percent-encoded, as the CloudEvents HTTP binding requires.
+func EncodeHeaderValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// DecodeHeaderValue returns the attribute value of a ce- header, the value
+// as it is if it is not validly percent-encoded.
+func DecodeHeaderValue(v string) string {
+	if !strings.Contains(v, "%") {
+		return v
+	}
+	s, err := url.PathUnescape(v)
+	if err != nil {
+		return v
+	}
+	return s
+}
+
+// ContentMode is how the events of a run are sent: in binary or structured
+// content mode, with the Content-Type that fits each event, the one of
+// -content-type, or, with -content-type-mismatch, the one of the other
+// reading of the body, for consumers to reject: a structured cloud event as
+// application/json, any other JSON event or a binary one as
+// application/cloudevents+json.
+type ContentMode struct {
+	Binary bool
+	// ContentType is the Content-Type of every event, empty for the one of
+	// each event
+	ContentType string
+	Mismatch    bool
+}
+
+// StructuredType returns the Content-Type of an event, or a batch of them,
+// in structured content mode. A cloud event is told apart by its
+// specversion without parsing it, as templates are sent on every render.
+func (m ContentMode) StructuredType(event []byte) string {
+	if m.ContentType != "" {
+		return m.ContentType
+	}
+	body := bytes.TrimSpace(event)
+	if len(body) > 0 && body[0] == '[' {
+		if m.Mismatch {
+			return StructuredContentType
+		}
+		return BatchContentType
+	}
+	if bytes.Contains(body, []byte(`"specversion"`)) != m.Mismatch {
+		return StructuredContentType
+	}
+	return JSONContentType
+}
+
+// SetEvent sets the event sent by req in the content mode of the run.
+func SetEvent(req *fasthttp.Request, event []byte, mode ContentMode) error {
+	if !mode.Binary {
+		req.SetBody(event)
+		req.Header.SetContentType(mode.StructuredType(event))
+		return nil
+	}
+	e, err := toBinaryEvent(event)
+	if err != nil {
+		return err
+	}
+	switch {
+	case mode.ContentType != "":
+		e.contentType = mode.ContentType
+	case mode.Mismatch:
+		e.contentType = StructuredContentType
+	}
+	e.apply(req)
+	return nil
+}
+
+// CheckContentMode fails a run up front if its event cannot be sent in the
+// content mode of the run.
+func CheckContentMode(event []byte, binary bool) error {
+	if !binary {
+		return nil
+	}
+	if _, err := toBinaryEvent(event); err != nil {
+		return fmt.Errorf("event cannot be sent in binary content mode: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestEncodeHeaderValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/cloud-event-tester", "/cloud-event-tester"},
+		{"2024-01-02T03:04:05.6+01:00", "2024-01-02T03:04:05.6+01:00"},
+		{"a b", "a%20b"},
+		{`say "hi"`, "say%20%22hi%22"},
+		{"100%", "100%25"},
+		{"tab\there\n", "tab%09here%0A"},
+		{"café", "caf%C3%A9"},
+	}
+	for _, tt := range tests {
+		got := EncodeHeaderValue(tt.value)
+		if got != tt.want {
+			t.Errorf("EncodeHeaderValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if back := DecodeHeaderValue(got); back != tt.value {
+			t.Errorf("DecodeHeaderValue(%q) = %q, want %q", got, back, tt.value)
+		}
+	}
+	if got := DecodeHeaderValue("50%"); got != "50%" {
+		t.Errorf("DecodeHeaderValue of an invalid encoding = %q, want it as it is", got)
+	}
+}
+
+// TestBinaryRoundTrip converts structured cloud events to binary content mode
+// and back, and checks their attributes, data and extensions come back as
+// they were.
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+	}{
+		{"json data", `{"specversion":"1.0","id":"1","source":"/tester","type":"test","datacontenttype":"application/json","data":{"value":1}}`},
+		{"quotes and percent", `{"specversion":"1.0","id":"2","source":"/say \"hi\"","type":"100% test","subject":"a\tb\nc","datacontenttype":"application/json","data":{}}`},
+		{"non-ascii", `{"specversion":"1.0","id":"3","source":"/café","type":"test","myext":"日本","datacontenttype":"text/plain","data":"hello"}`},
+		{"base64 data", `{"specversion":"1.0","id":"4","source":"/tester","type":"test","datacontenttype":"application/octet-stream","data_base64":"AAEC/w=="}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			if err := SetEvent(req, []byte(tt.event), ContentMode{Binary: true}); err != nil {
+				t.Fatalf("SetEvent failed: %v", err)
+			}
+			req.Header.VisitAll(func(key, value []byte) {
+				for _, c := range value {
+					if c <= ' ' || c > '~' || c == '"' {
+						t.Errorf("header %s = %q is not percent-encoded", key, value)
+						return
+					}
+				}
+			})
+			out, err := StructuredEvents(req)
+			if err != nil {
+				t.Fatalf("StructuredEvents failed: %v", err)
+			}
+			if len(out) != 1 {
+				t.Fatalf("got %d events, want 1", len(out))
+			}
+			var got, want map[string]interface{}
+			if err := json.Unmarshal(out[0], &got); err != nil {
+				t.Fatalf("structured event %s: %v", out[0], err)
+			}
+			if err := json.Unmarshal([]byte(tt.event), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %s, want %s", out[0], tt.event)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package events
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// encodings of the event data selectable with -data-encoding, and the
+// datacontenttype of the events they encode
+const (
+	DataEncodingProtobuf = "protobuf"
+	DataEncodingAvro     = "avro"
+
+	protobufContentType = "application/protobuf"
+	avroContentType     = "application/avro"
+)
+
+// DataEncoder sends the data of every event of a run encoded in a binary
+// format, for consumers that only take binary payloads inside their cloud
+// events: the JSON data of an event, in the JSON mapping of the protobuf
+// message or the JSON encoding of the Avro schema, is encoded and sent as
+// the data_base64 of the event with the datacontenttype of the format, so a
+// binary mode event carries the encoded bytes as its body. Events that are
+// not cloud events are sent as the data of a new one, as in batches. Like
+// the renderers it wraps it is safe for the send shards to call
+// concurrently.
+type DataEncoder struct {
+	encoding    string
+	contentType string
+	encode      func(data []byte) ([]byte, error)
+	// events renders the events, nil to send body
+	events  Renderer
+	body    []byte
+	scratch sync.Pool
+}
+
+// NewDataEncoder returns the encoder of the events of events, or of body if
+// it is nil, to the encoding of the data schema, with the message of a
+// protobuf schema; a basic run, which encodes the events it sends, passes
+// neither. It returns nil if the run sends its data as JSON.
+func NewDataEncoder(encoding, schemaFile, message string, events Renderer, body []byte) (*DataEncoder, error) {
+	name := encoding
+	encoding = strings.ToLower(encoding)
+	if encoding == "" {
+		return nil, nil
+	}
+	e := &DataEncoder{
+		encoding: encoding,
+		events:   events,
+		scratch:  sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+	var err error
+	var schema string
+	switch encoding {
+	case DataEncodingProtobuf:
+		e.contentType = protobufContentType
+		e.encode, schema, err = protobufEncoder(schemaFile, message)
+	case DataEncodingAvro:
+		e.contentType = avroContentType
+		e.encode, schema, err = avroEncoder(schemaFile)
+	default:
+		return nil, fmt.Errorf("data encoding %q is not %s or %s", name, DataEncodingProtobuf, DataEncodingAvro)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if events == nil && body != nil {
+		var buf bytes.Buffer
+		if err := e.EncodeEvent(&buf, body); err != nil {
+			return nil, fmt.Errorf("event cannot be encoded: %w", err)
+		}
+		e.body = buf.Bytes()
+	}
+	log.Infof("Data Encoding: event data sent as %s %s (%s)", encoding, schema, e.contentType)
+	return e, nil
+}
+
+func (e *DataEncoder) Render(buf *bytes.Buffer) error {
+	if e.events == nil {
+		buf.Reset()
+		buf.Write(e.body)
+		return nil
+	}
+	scratch := e.scratch.Get().(*bytes.Buffer)
+	defer e.scratch.Put(scratch)
+	if err := e.events.Render(scratch); err != nil {
+		return err
+	}
+	return e.EncodeEvent(buf, scratch.Bytes())
+}
+
+// EncodeEvent writes event to buf with its data encoded.
+func (e *DataEncoder) EncodeEvent(buf *bytes.Buffer, event []byte) error {
+	structured, err := ToStructuredEvent(event)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(structured, &fields); err != nil {
+		return fmt.Errorf("event is not a JSON object: %w", err)
+	}
+	data, ok := fields["data"]
+	if !ok {
+		return fmt.Errorf("event has no JSON data to encode as %s", e.encoding)
+	}
+	encoded, err := e.encode(data)
+	if err != nil {
+		return fmt.Errorf("data cannot be encoded as %s: %w", e.encoding, err)
+	}
+	delete(fields, "data")
+	fields["data_base64"], _ = json.Marshal(base64.StdEncoding.EncodeToString(encoded))
+	fields["datacontenttype"], _ = json.Marshal(e.contentType)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	buf.Write(out)
+	return nil
+}
+
+// protobufEncoder returns the encoder of the data of the events as the
+// message named in the descriptor set file, as written by protoc
+// --include_imports --descriptor_set_out; the name can be left out if the
+// set has one message.
+func protobufEncoder(path, name string) (func([]byte) ([]byte, error), string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, "", fmt.Errorf("%s is not a protobuf descriptor set: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, "", fmt.Errorf("descriptor set %s: %w", path, err)
+	}
+	var msg protoreflect.MessageDescriptor
+	if name != "" {
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, "", fmt.Errorf("descriptor set %s has no message %s", path, name)
+		}
+		if msg, _ = d.(protoreflect.MessageDescriptor); msg == nil {
+			return nil, "", fmt.Errorf("%s of descriptor set %s is not a message", name, path)
+		}
+	} else {
+		var names []string
+		files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+			for i := 0; i < f.Messages().Len(); i++ {
+				msg = f.Messages().Get(i)
+				names = append(names, string(msg.FullName()))
+			}
+			return true
+		})
+		if len(names) != 1 {
+			return nil, "", fmt.Errorf("descriptor set %s has %d messages %v, -data-message names the one of the data", path, len(names), names)
+		}
+	}
+	encode := func(data []byte) ([]byte, error) {
+		m := dynamicpb.NewMessage(msg)
+		if err := protojson.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(m)
+	}
+	return encode, "message " + string(msg.FullName()), nil
+}
+
+// avroEncoder returns the encoder of the data of the events with the Avro
+// schema file, the data in the JSON encoding of Avro, unions wrapped in an
+// object naming their type.
+func avroEncoder(path string) (func([]byte) ([]byte, error), string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	codec, err := goavro.NewCodec(string(raw))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid Avro schema %s: %w", path, err)
+	}
+	encode := func(data []byte) ([]byte, error) {
+		native, _, err := codec.NativeFromTextual(data)
+		if err != nil {
+			return nil, err
+		}
+		return codec.BinaryFromNative(nil, native)
+	}
+	return encode, "schema " + path, nil
+}
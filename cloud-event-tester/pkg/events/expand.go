@@ -0,0 +1,169 @@
+package events
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// includeDirective starts an @include(file) directive of an event file.
+var includeDirective = []byte("@include(")
+
+// maxIncludeDepth is how deep included files may include others.
+const maxIncludeDepth = 16
+
+// ReadEventFile reads an event file, with its @include(file) directives
+// replaced by the content of the files they name and then its ${VAR}
+// references by the environment variables, so one fixture serves every
+// environment:
+//
+//	${NODE_NAME}          the value of NODE_NAME, an error if it is not set
+//	${CLUSTER:-lab}       the value of CLUSTER, lab if it is not set or empty
+//	$${NODE_NAME}         a literal ${NODE_NAME}
+//	@include(data.json)   the content of data.json, without its final newline
+//
+// Included files are found relative to the file including them and expanded
+// in turn. Values are inserted as they are, within quotes for JSON strings.
+// Unlike the placeholders of templates, the expansion is done when the file
+// is read, not for every send.
+func ReadEventFile(file string) ([]byte, error) {
+	event, err := IncludeFiles(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	return expandEnv(filepath.Base(file), event)
+}
+
+// IncludeFiles reads a file with its @include directives replaced; stack is
+// the chain of files including it.
+func IncludeFiles(file string, stack []string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil || !bytes.Contains(data, includeDirective) {
+		return data, err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range stack {
+		if f == abs {
+			var chain []string
+			for _, f := range append(stack[i:len(stack):len(stack)], abs) {
+				chain = append(chain, filepath.Base(f))
+			}
+			return nil, fmt.Errorf("include cycle %s", strings.Join(chain, " -> "))
+		}
+	}
+	if len(stack) == maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes are nested more than %d deep", filepath.Base(file), maxIncludeDepth)
+	}
+	stack = append(stack, abs)
+
+	var out bytes.Buffer
+	for {
+		i := bytes.Index(data, includeDirective)
+		if i < 0 {
+			out.Write(data)
+			return out.Bytes(), nil
+		}
+		out.Write(data[:i])
+		data = data[i+len(includeDirective):]
+		end := bytes.IndexByte(data, ')')
+		if end < 0 {
+			return nil, fmt.Errorf("%s: @include is missing its closing parenthesis", filepath.Base(file))
+		}
+		name := strings.Trim(strings.TrimSpace(string(data[:end])), `"'`)
+		data = data[end+1:]
+		if name == "" {
+			return nil, fmt.Errorf("%s: @include names no file", filepath.Base(file))
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(file), name)
+		}
+		included, err := IncludeFiles(name, stack)
+		if err != nil {
+			return nil, fmt.Errorf("%s: @include(%s): %w", filepath.Base(file), filepath.Base(name), err)
+		}
+		out.Write(bytes.TrimRight(included, "\r\n"))
+	}
+}
+
+// expandEnv replaces the ${VAR} references of an event with the environment
+// variables. A $ not followed by a variable name in braces is kept as is.
+// All unset variables without a default are reported at once.
+func expandEnv(name string, event []byte) ([]byte, error) {
+	if !bytes.Contains(event, []byte("${")) {
+		return event, nil
+	}
+	var out bytes.Buffer
+	var missing []string
+	for {
+		i := bytes.IndexByte(event, '$')
+		if i < 0 {
+			out.Write(event)
+			break
+		}
+		out.Write(event[:i])
+		event = event[i:]
+		if bytes.HasPrefix(event, []byte("$${")) {
+			out.WriteString("${")
+			event = event[3:]
+			continue
+		}
+		end := bytes.IndexByte(event, '}')
+		if !bytes.HasPrefix(event, []byte("${")) || end < 0 {
+			out.WriteByte('$')
+			event = event[1:]
+			continue
+		}
+		ref := string(event[2:end])
+		key, def, hasDefault := strings.Cut(ref, ":-")
+		if !isEnvName(key) {
+			out.WriteByte('$')
+			event = event[1:]
+			continue
+		}
+		event = event[end+1:]
+		switch value, ok := os.LookupEnv(key); {
+		case hasDefault && value == "":
+			out.WriteString(def)
+		case ok:
+			out.WriteString(value)
+		default:
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%s: environment variables not set: %s", name, strings.Join(dedupe(missing), ", "))
+	}
+	return out.Bytes(), nil
+}
+
+// isEnvName reports whether s is the name of an environment variable,
+// letters, digits and underscores not starting with a digit.
+func isEnvName(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// dedupe removes the repeated strings of a sorted slice.
+func dedupe(sorted []string) []string {
+	out := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// labelHeaderPrefix is the prefix of the HTTP headers carrying run labels.
+const labelHeaderPrefix = "X-Test-Label-"
+
+// labelNameRe matches valid CloudEvents extension attribute names, which
+// label names must be.
+var labelNameRe = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// LabelsFlag is a repeatable key=value flag.
+type LabelsFlag map[string]string
+
+func (l *LabelsFlag) String() string {
+	if l == nil || len(*l) == 0 {
+		return ""
+	}
+	return FormatLabels(*l)
+}
+
+func (l *LabelsFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("label %q is not key=value", value)
+	}
+	if *l == nil {
+		*l = LabelsFlag{}
+	}
+	(*l)[key] = val
+	return nil
+}
+
+// ParseLabels parses a comma separated list of key=value labels.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := LabelsFlag{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		if err := labels.Set(kv); err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
+
+// FormatLabels returns labels as sorted name=value pairs.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ValidateLabels checks the names of labels.
+func ValidateLabels(labels map[string]string) error {
+	for k := range labels {
+		if !labelNameRe.MatchString(k) {
+			return fmt.Errorf("label name %q must be 1-20 lowercase letters or digits", k)
+		}
+	}
+	return nil
+}
+
+// SetLabelHeaders adds the labels to a request as HTTP headers.
+func SetLabelHeaders(req *fasthttp.Request, labels map[string]string) {
+	for k, v := range labels {
+		req.Header.Set(labelHeaderPrefix+k, v)
+	}
+}
+
+// LabelEvent adds the labels as extension attributes to an event in
+// structured CloudEvents JSON format. Other payloads are returned unchanged;
+// they carry the labels in the HTTP headers only.
+func LabelEvent(event []byte, labels map[string]string) []byte {
+	if len(labels) == 0 {
+		return event
+	}
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(event, &attrs); err != nil {
+		return event
+	}
+	if _, ok := attrs["specversion"]; !ok {
+		return event
+	}
+	for k, v := range labels {
+		if _, ok := attrs[k]; ok {
+			// never overwrite an attribute of the event itself
+			continue
+		}
+		attrs[k], _ = json.Marshal(v)
+	}
+	labeled, err := json.Marshal(attrs)
+	if err != nil {
+		return event
+	}
+	return labeled
+}
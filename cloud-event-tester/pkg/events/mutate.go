@@ -0,0 +1,413 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// operations of mutation rules
+const (
+	mutationSet     = "set"
+	mutationDelete  = "delete"
+	mutationReplace = "replace"
+)
+
+// WithoutMessageRule removes the message of the records of a Redfish event,
+// for -with-msg NO.
+var WithoutMessageRule = MutationSpec{Op: mutationDelete, Path: "$.Events[*].Message"}
+
+// MutationSpec is a rule of a mutations file: set the value at a JSONPath,
+// delete it, or replace the matches of a regular expression in the string
+// at it. A set rule with values sets them in turn, the next one every time
+// it applies. A rule applies to every Every-th event of a run, to every
+// event if Every is 0 or 1.
+type MutationSpec struct {
+	Op      string        `yaml:"op" json:"op"`
+	Path    string        `yaml:"path" json:"path"`
+	Value   interface{}   `yaml:"value" json:"value,omitempty"`
+	Values  []interface{} `yaml:"values" json:"values,omitempty"`
+	Pattern string        `yaml:"pattern" json:"pattern,omitempty"`
+	With    string        `yaml:"with" json:"with,omitempty"`
+	Every   int           `yaml:"every" json:"every,omitempty"`
+}
+
+// MutationsFile is the file of -mutations.
+type MutationsFile struct {
+	Rules []MutationSpec `yaml:"rules"`
+}
+
+// PathStep is a step of a JSONPath: a member, an array index or all
+// members or elements.
+type PathStep struct {
+	key   string
+	index int
+	all   bool
+}
+
+// MutationRule is a compiled mutation rule.
+type MutationRule struct {
+	spec  MutationSpec
+	steps []PathStep
+	re    *regexp.Regexp
+	// values are those of a set rule as JSON, so the events of the
+	// shards never share them
+	values []json.RawMessage
+	// the events the rule changed, and the times it applied, for the
+	// values it sets in turn
+	applied, turns int64
+}
+
+// CompileMutationRule returns the rule of spec.
+func CompileMutationRule(spec MutationSpec) (*MutationRule, error) {
+	steps, err := ParseJSONPath(spec.Path)
+	if err != nil {
+		return nil, err
+	}
+	r := &MutationRule{spec: spec, steps: steps}
+	switch spec.Op {
+	case mutationSet:
+		if spec.Value == nil && len(spec.Values) == 0 {
+			return nil, fmt.Errorf("set %s needs a value or values", spec.Path)
+		}
+		if spec.Value != nil && len(spec.Values) > 0 {
+			return nil, fmt.Errorf("set %s has both a value and values", spec.Path)
+		}
+		values := spec.Values
+		if spec.Value != nil {
+			values = []interface{}{spec.Value}
+		}
+		for _, v := range values {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("set %s: value is not JSON: %w", spec.Path, err)
+			}
+			r.values = append(r.values, data)
+		}
+	case mutationDelete:
+	case mutationReplace:
+		if spec.Pattern == "" {
+			return nil, fmt.Errorf("replace %s needs a pattern", spec.Path)
+		}
+		if r.re, err = regexp.Compile(spec.Pattern); err != nil {
+			return nil, fmt.Errorf("replace %s: invalid pattern: %w", spec.Path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation %q, must be one of %s, %s or %s", spec.Op, mutationSet, mutationDelete, mutationReplace)
+	}
+	if spec.Every < 0 {
+		return nil, fmt.Errorf("every must not be negative, got %d", spec.Every)
+	}
+	return r, nil
+}
+
+// ParseJSONPath parses the subset of JSONPath the rules use: $ followed by
+// .name, ['name'], [index], .* and [*] steps.
+func ParseJSONPath(path string) ([]PathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q does not start with $", path)
+	}
+	var steps []PathStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			// a ] ends the name too, so the next step reports it
+			end := strings.IndexAny(rest, ".[]")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("path %q has an empty member name", path)
+			case "*":
+				steps = append(steps, PathStep{index: -1, all: true})
+			default:
+				steps = append(steps, PathStep{key: name, index: -1})
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", path)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, PathStep{index: -1, all: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, PathStep{key: inner[1 : len(inner)-1], index: -1})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("path %q has an invalid index [%s]", path, inner)
+				}
+				steps = append(steps, PathStep{index: i})
+			}
+		default:
+			return nil, fmt.Errorf("path %q has an unexpected %q", path, rest[:1])
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("path %q selects the whole event", path)
+	}
+	return steps, nil
+}
+
+// static reports whether a rule changes every event alike, so it can be
+// applied once to an event that is not rendered.
+func (r *MutationRule) static() bool {
+	return r.spec.Every <= 1 && len(r.spec.Values) == 0
+}
+
+// due reports whether the rule applies to the n-th event of a run.
+func (r *MutationRule) due(n int64) bool {
+	return r.spec.Every <= 1 || n%int64(r.spec.Every) == 0
+}
+
+func (r *MutationRule) String() string {
+	s := r.spec.Op + " " + r.spec.Path
+	if r.spec.Every > 1 {
+		s += fmt.Sprintf(" every %d", r.spec.Every)
+	}
+	return s
+}
+
+// apply applies the rule to a decoded event and returns it.
+func (r *MutationRule) apply(doc interface{}) interface{} {
+	var value json.RawMessage
+	switch {
+	case len(r.values) > 1:
+		turn := atomic.AddInt64(&r.turns, 1) - 1
+		value = r.values[turn%int64(len(r.values))]
+	case len(r.values) == 1:
+		value = r.values[0]
+	}
+	doc, n := MutatePath(doc, r.steps, func(v interface{}, ok bool) (interface{}, bool, bool) {
+		switch r.spec.Op {
+		case mutationSet:
+			return value, true, true
+		case mutationDelete:
+			return nil, false, ok
+		}
+		s, isString := v.(string)
+		if !isString || !r.re.MatchString(s) {
+			return v, true, false
+		}
+		return r.re.ReplaceAllString(s, r.spec.With), true, true
+	})
+	if n > 0 {
+		atomic.AddInt64(&r.applied, 1)
+	}
+	return doc
+}
+
+// MutatePath calls change with the values at steps below node, ok false for
+// a member that does not exist, and sets them to what it returns: keep false
+// removes the value, changed false leaves it as it was. It returns node and
+// the number of values changed; members are only created by the last step.
+func MutatePath(node interface{}, steps []PathStep, change func(v interface{}, ok bool) (nv interface{}, keep, changed bool)) (interface{}, int) {
+	step, last := steps[0], len(steps) == 1
+	count := 0
+	switch n := node.(type) {
+	case map[string]interface{}:
+		var keys []string
+		switch {
+		case step.all:
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+		case step.index >= 0:
+			return node, 0
+		default:
+			keys = []string{step.key}
+		}
+		for _, k := range keys {
+			v, ok := n[k]
+			if !last {
+				if ok {
+					var c int
+					n[k], c = MutatePath(v, steps[1:], change)
+					count += c
+				}
+				continue
+			}
+			nv, keep, changed := change(v, ok)
+			switch {
+			case !changed:
+			case keep:
+				n[k] = nv
+				count++
+			default:
+				delete(n, k)
+				count++
+			}
+		}
+		return n, count
+	case []interface{}:
+		if !step.all && step.index < 0 {
+			return node, 0
+		}
+		from, to := step.index, step.index+1
+		if step.all {
+			from, to = 0, len(n)
+		}
+		if to > len(n) {
+			return node, 0
+		}
+		kept := n[:0:0]
+		for i, v := range n {
+			if i < from || i >= to {
+				kept = append(kept, v)
+				continue
+			}
+			if !last {
+				nv, c := MutatePath(v, steps[1:], change)
+				kept = append(kept, nv)
+				count += c
+				continue
+			}
+			nv, keep, changed := change(v, true)
+			switch {
+			case !changed:
+				kept = append(kept, v)
+			case keep:
+				kept = append(kept, nv)
+				count++
+			default:
+				count++
+			}
+		}
+		return kept, count
+	}
+	return node, 0
+}
+
+// mutateEvent applies rules to an event.
+func mutateEvent(event []byte, rules []*MutationRule) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(event))
+	// numbers are kept as they are written
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("event is not JSON: %w", err)
+	}
+	for _, r := range rules {
+		doc = r.apply(doc)
+	}
+	return json.Marshal(doc)
+}
+
+// EventMutator applies the mutation rules of a run to its events as they are
+// sent: to the events of events, or to body if it is nil. The events of a
+// batch are mutated one by one. Like the stamper it is safe for the send
+// shards to call concurrently.
+type EventMutator struct {
+	rules   []*MutationRule
+	events  Renderer
+	body    []byte
+	sent    int64
+	scratch sync.Pool
+}
+
+// ApplyStaticMutations applies the rules to an event that is not rendered
+// once if they all change every event alike, and returns the mutated event
+// and no rules left to apply on every send.
+func ApplyStaticMutations(event []byte, rules []*MutationRule) ([]byte, []*MutationRule, error) {
+	if len(rules) == 0 {
+		return event, nil, nil
+	}
+	for _, r := range rules {
+		if !r.static() {
+			return event, rules, nil
+		}
+	}
+	mutated, err := mutateEvent(event, rules)
+	if err != nil {
+		return nil, nil, fmt.Errorf("event cannot be mutated: %w", err)
+	}
+	for _, r := range rules {
+		log.Infof("Mutation: %s, applied once", r)
+	}
+	return mutated, nil, nil
+}
+
+// NewEventMutator returns the mutator of the events of events, or of body if
+// it is nil, and nil if there are no rules.
+func NewEventMutator(rules []*MutationRule, events Renderer, body []byte) (*EventMutator, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if events == nil {
+		// fail before the run rather than on every send
+		if _, err := mutateEvent(body, nil); err != nil {
+			return nil, fmt.Errorf("event cannot be mutated: %w", err)
+		}
+	}
+	for _, r := range rules {
+		log.Infof("Mutation: %s, applied on every send", r)
+	}
+	return &EventMutator{
+		rules:   rules,
+		events:  events,
+		body:    body,
+		scratch: sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}, nil
+}
+
+func (m *EventMutator) Render(buf *bytes.Buffer) error {
+	n := atomic.AddInt64(&m.sent, 1)
+	event := m.body
+	if m.events != nil {
+		scratch := m.scratch.Get().(*bytes.Buffer)
+		defer m.scratch.Put(scratch)
+		if err := m.events.Render(scratch); err != nil {
+			return err
+		}
+		event = scratch.Bytes()
+	}
+	var due []*MutationRule
+	for _, r := range m.rules {
+		if r.due(n) {
+			due = append(due, r)
+		}
+	}
+	buf.Reset()
+	if len(due) == 0 {
+		buf.Write(event)
+		return nil
+	}
+	mutated, err := mutateEvent(event, due)
+	if err != nil {
+		return err
+	}
+	buf.Write(mutated)
+	return nil
+}
+
+// Report adds the events every rule changed to a run result and logs them.
+func (m *EventMutator) Report(result *report.Result) {
+	if m == nil {
+		return
+	}
+	sent := atomic.LoadInt64(&m.sent)
+	for _, r := range m.rules {
+		s := report.MutationStats{Rule: r.String(), Applied: int(atomic.LoadInt64(&r.applied))}
+		result.Mutations = append(result.Mutations, s)
+		log.Infof("Mutation: %s changed %d of %d events", s.Rule, s.Applied, sent)
+	}
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []PathStep
+	}{
+		{"$.id", []PathStep{{key: "id", index: -1}}},
+		{"$.data.value", []PathStep{{key: "data", index: -1}, {key: "value", index: -1}}},
+		{"$['data']", []PathStep{{key: "data", index: -1}}},
+		{`$["a.b"]`, []PathStep{{key: "a.b", index: -1}}},
+		{"$.Events[0]", []PathStep{{key: "Events", index: -1}, {index: 0}}},
+		{"$.Events[*].Message", []PathStep{{key: "Events", index: -1}, {index: -1, all: true}, {key: "Message", index: -1}}},
+		{"$.*", []PathStep{{index: -1, all: true}}},
+		{"$[12]", []PathStep{{index: 12}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseJSONPath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseJSONPathMalformed(t *testing.T) {
+	for _, path := range []string{
+		"",
+		"id",
+		".id",
+		"$",
+		"$.",
+		"$..id",
+		"$.data.",
+		"$id",
+		"$.Events[",
+		"$.Events[0",
+		"$.Events[]",
+		"$.Events[-1]",
+		"$.Events[x]",
+		"$.Events[1.5]",
+		"$['a]b']",
+		"$['data\"]",
+		"$.Events]",
+		"$.Events]x",
+	} {
+		if steps, err := ParseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) = %+v, want an error", path, steps)
+		}
+	}
+}
+
+func TestCompileMutationRuleInvalid(t *testing.T) {
+	for name, spec := range map[string]MutationSpec{
+		"unknown op":            {Op: "rename", Path: "$.id"},
+		"malformed path":        {Op: mutationDelete, Path: "$..id"},
+		"set without value":     {Op: mutationSet, Path: "$.id"},
+		"value and values":      {Op: mutationSet, Path: "$.id", Value: "a", Values: []interface{}{"b"}},
+		"value not JSON":        {Op: mutationSet, Path: "$.id", Value: math.NaN()},
+		"replace no pattern":    {Op: mutationReplace, Path: "$.id"},
+		"replace bad pattern":   {Op: mutationReplace, Path: "$.id", Pattern: "("},
+		"negative every":        {Op: mutationDelete, Path: "$.id", Every: -1},
+		"path without a dollar": {Op: mutationDelete, Path: "id"},
+	} {
+		if _, err := CompileMutationRule(spec); err == nil {
+			t.Errorf("%s: compileMutationRule(%+v) succeeded, want an error", name, spec)
+		}
+	}
+}
+
+func TestMutateEvent(t *testing.T) {
+	const event = `{"id":"1","data":{"value":"12.5","count":3},"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}]}`
+	tests := []struct {
+		name string
+		spec MutationSpec
+		want string
+	}{
+		{"set member", MutationSpec{Op: mutationSet, Path: "$.id", Value: "2"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"2"}`},
+		{"set new member", MutationSpec{Op: mutationSet, Path: "$.data.unit", Value: "C"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"unit":"C","value":"12.5"},"id":"1"}`},
+		{"set below a missing member", MutationSpec{Op: mutationSet, Path: "$.missing.value", Value: 1},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"set element", MutationSpec{Op: mutationSet, Path: "$.Events[1].Message", Value: "c"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"c","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"index out of range", MutationSpec{Op: mutationSet, Path: "$.Events[5].Message", Value: "c"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"index of an object", MutationSpec{Op: mutationSet, Path: "$.data[0]", Value: "c"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"member of an array", MutationSpec{Op: mutationDelete, Path: "$.Events.Message"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"delete all", MutationSpec{Op: mutationDelete, Path: "$.Events[*].Message"},
+			`{"Events":[{"MessageId":"x.1"},{"MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"delete element", MutationSpec{Op: mutationDelete, Path: "$.Events[0]"},
+			`{"Events":[{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"delete missing member", MutationSpec{Op: mutationDelete, Path: "$.data.unit"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"replace", MutationSpec{Op: mutationReplace, Path: "$.Events[*].MessageId", Pattern: `^x\.`, With: "y."},
+			`{"Events":[{"Message":"a","MessageId":"y.1"},{"Message":"b","MessageId":"y.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"replace a number", MutationSpec{Op: mutationReplace, Path: "$.data.count", Pattern: "3", With: "4"},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":3,"value":"12.5"},"id":"1"}`},
+		{"wildcard member", MutationSpec{Op: mutationSet, Path: "$.data.*", Value: 0},
+			`{"Events":[{"Message":"a","MessageId":"x.1"},{"Message":"b","MessageId":"x.2"}],"data":{"count":0,"value":0},"id":"1"}`},
+	}
+	for _, tt := range tests {
+		r, err := CompileMutationRule(tt.spec)
+		if err != nil {
+			t.Errorf("%s: compileMutationRule failed: %v", tt.name, err)
+			continue
+		}
+		got, err := mutateEvent([]byte(event), []*MutationRule{r})
+		if err != nil {
+			t.Errorf("%s: mutateEvent failed: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: mutateEvent = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMutateEventValuesInTurn(t *testing.T) {
+	r, err := CompileMutationRule(MutationSpec{Op: mutationSet, Path: "$.severity", Values: []interface{}{"OK", "Warning"}})
+	if err != nil {
+		t.Fatalf("compileMutationRule failed: %v", err)
+	}
+	for _, want := range []string{`{"severity":"OK"}`, `{"severity":"Warning"}`, `{"severity":"OK"}`} {
+		got, err := mutateEvent([]byte(`{"severity":"Critical"}`), []*MutationRule{r})
+		if err != nil {
+			t.Fatalf("mutateEvent failed: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("mutateEvent = %s, want %s", got, want)
+		}
+	}
+	if r.applied != 3 {
+		t.Errorf("rule applied to %d events, want 3", r.applied)
+	}
+}
+
+func TestMutateEventMalformed(t *testing.T) {
+	r, err := CompileMutationRule(MutationSpec{Op: mutationDelete, Path: "$.id"})
+	if err != nil {
+		t.Fatalf("compileMutationRule failed: %v", err)
+	}
+	for _, event := range []string{``, `{"id":`, `not json`, `{"id":"1"`} {
+		if got, err := mutateEvent([]byte(event), []*MutationRule{r}); err == nil {
+			t.Errorf("mutateEvent(%s) = %s, want an error", event, got)
+		}
+	}
+	// a scalar event has no members to change
+	got, err := mutateEvent([]byte(`42`), []*MutationRule{r})
+	if err != nil || string(got) != `42` {
+		t.Errorf("mutateEvent(42) = %s, %v, want it unchanged", got, err)
+	}
+}
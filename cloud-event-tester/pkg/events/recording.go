@@ -0,0 +1,276 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Timed events of a recording are stored in an envelope with the time they
+// were received, {"receivedAt":"<RFC 3339>","event":<event>}, so replay can
+// reproduce the gaps between them. The recorder writes exactly this form,
+// which lets the replay find the parts without decoding the events.
+const (
+	timedPrefix    = `{"receivedAt":"`
+	timedSeparator = `","event":`
+)
+
+// Recording is an NDJSON file of recorded events, one event per line, each
+// either an event or a timed event written by the receiver. The
+// file is memory-mapped and only the offsets of the events are kept, so
+// recordings larger than the memory of the test host can be replayed.
+type Recording struct {
+	data []byte
+	// starts are the offsets of the events in data
+	starts []int64
+	unmap  func() error
+}
+
+// OpenRecording maps the recording at path and indexes its events. Empty
+// lines are skipped.
+func OpenRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &Recording{}
+	if st.Size() > 0 {
+		if r.data, r.unmap, err = mapFile(f, st.Size()); err != nil {
+			return nil, fmt.Errorf("failed to map %s: %w", path, err)
+		}
+	}
+	for off := 0; off < len(r.data); {
+		end := bytes.IndexByte(r.data[off:], '\n')
+		if end < 0 {
+			end = len(r.data)
+		} else {
+			end += off
+		}
+		if len(bytes.TrimSpace(r.data[off:end])) > 0 {
+			r.starts = append(r.starts, int64(off))
+		}
+		off = end + 1
+	}
+	return r, nil
+}
+
+// Len returns the number of events in the recording.
+func (r *Recording) Len() int {
+	return len(r.starts)
+}
+
+// line returns line i of the recording.
+func (r *Recording) line(i int) []byte {
+	line := r.data[r.starts[i]:]
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	return bytes.TrimSpace(line)
+}
+
+// Event returns event i, without the envelope of a timed event. The slice
+// points into the mapping and is only valid until close.
+func (r *Recording) Event(i int) []byte {
+	line := r.line(i)
+	if _, event, ok := splitTimed(line); ok {
+		return event
+	}
+	return line
+}
+
+// ReceivedAt returns the time event i was received, if it is a timed event.
+func (r *Recording) ReceivedAt(i int) (time.Time, bool) {
+	at, _, ok := splitTimed(r.line(i))
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(at))
+	return t, err == nil
+}
+
+// splitTimed returns the time and the event of a timed event line.
+func splitTimed(line []byte) (at, event []byte, ok bool) {
+	if !bytes.HasPrefix(line, []byte(timedPrefix)) || !bytes.HasSuffix(line, []byte("}")) {
+		return nil, nil, false
+	}
+	rest := line[len(timedPrefix) : len(line)-1]
+	sep := bytes.Index(rest, []byte(timedSeparator))
+	if sep < 0 {
+		return nil, nil, false
+	}
+	return rest[:sep], bytes.TrimSpace(rest[sep+len(timedSeparator):]), true
+}
+
+func (r *Recording) Close() error {
+	if r.unmap == nil {
+		return nil
+	}
+	return r.unmap()
+}
+
+// recordFlushInterval is how often a recorder writes the events it buffered
+// to its file.
+const recordFlushInterval = time.Second
+
+// Recorder appends the events a receiver accepts to a recording, as
+// timed events. Binary cloud events are recorded in structured form, and
+// the events of a batch one by one, so the recording can be replayed in
+// either content mode.
+type Recorder struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	events int
+	err    error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewRecorder creates the recording at path, replacing an existing file.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recorder{f: f, w: bufio.NewWriter(f), stop: make(chan struct{}), done: make(chan struct{})}
+	go rec.flushLoop()
+	return rec, nil
+}
+
+func (rec *Recorder) flushLoop() {
+	defer close(rec.done)
+	ticker := time.NewTicker(recordFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rec.stop:
+			return
+		case <-ticker.C:
+		}
+		rec.mu.Lock()
+		if err := rec.w.Flush(); err != nil && rec.err == nil {
+			rec.err = err
+		}
+		rec.mu.Unlock()
+	}
+}
+
+// Record appends the events of a request received at the given time.
+func (rec *Recorder) Record(at time.Time, req *fasthttp.Request) error {
+	events, err := StructuredEvents(req)
+	if err != nil {
+		return err
+	}
+	return rec.RecordEvents(at, events)
+}
+
+// RecordEvents appends single-line JSON events received at the given time.
+func (rec *Recorder) RecordEvents(at time.Time, events [][]byte) error {
+	stamp := at.UTC().Format(time.RFC3339Nano)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return rec.err
+	}
+	for _, event := range events {
+		rec.w.WriteString(timedPrefix)    //nolint: errcheck
+		rec.w.WriteString(stamp)          //nolint: errcheck
+		rec.w.WriteString(timedSeparator) //nolint: errcheck
+		rec.w.Write(event)                //nolint: errcheck
+		if _, err := rec.w.WriteString("}\n"); err != nil {
+			rec.err = err
+			return err
+		}
+		rec.events++
+	}
+	return nil
+}
+
+// Close writes the buffered events and closes the recording. It returns the
+// number of events recorded.
+func (rec *Recorder) Close() (int, error) {
+	close(rec.stop)
+	<-rec.done
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	err := rec.err
+	if ferr := rec.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := rec.f.Close(); err == nil {
+		err = cerr
+	}
+	return rec.events, err
+}
+
+// StructuredEvents returns the events of a valid request as single-line
+// JSON. Batches are split into their events and binary cloud events turned
+// into structured ones, with the data as JSON, a string or data_base64 by
+// its content type.
+func StructuredEvents(req *fasthttp.Request) ([][]byte, error) {
+	if len(req.Header.Peek("Ce-Specversion")) == 0 {
+		body := bytes.TrimSpace(req.Body())
+		if body[0] != '[' {
+			return [][]byte{compactJSON(body)}, nil
+		}
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, err
+		}
+		events := make([][]byte, len(batch))
+		for i, event := range batch {
+			events[i] = compactJSON(event)
+		}
+		return events, nil
+	}
+
+	event := map[string]interface{}{}
+	req.Header.VisitAll(func(key, value []byte) {
+		if name := strings.ToLower(string(key)); strings.HasPrefix(name, "ce-") {
+			event[strings.TrimPrefix(name, "ce-")] = DecodeHeaderValue(string(value))
+		}
+	})
+	contentType := string(req.Header.ContentType())
+	if contentType != "" {
+		event["datacontenttype"] = contentType
+	}
+	data := bytes.TrimSpace(req.Body())
+	switch {
+	case len(data) == 0:
+	case (contentType == "" || IsJSONContentType(contentType)) && json.Valid(data):
+		event["data"] = json.RawMessage(data)
+	case utf8.Valid(data):
+		event["data"] = string(data)
+	default:
+		event["data_base64"] = base64.StdEncoding.EncodeToString(data)
+	}
+	out, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{out}, nil
+}
+
+// compactJSON returns a JSON value on a single line, as the lines of a
+// recording need.
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
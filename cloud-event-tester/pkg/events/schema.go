@@ -0,0 +1,489 @@
+package events
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSchemaErrors is the number of violations reported per event; the rest
+// are summarized.
+const maxSchemaErrors = 5
+
+// Schemas are the JSON Schemas the data of the events of a run is
+// validated with before it is sent, by event type. Events that are not cloud
+// events are the data of a cloud event of defaultEventType, as in binary
+// content mode.
+type Schemas struct {
+	byType map[string]*jsonSchema
+}
+
+// LoadSchemas loads the schemas of a run: every schema file of dir, for
+// the event type it is named after, and the schema files of files by type,
+// which take precedence. It returns nil if the run has no schemas.
+func LoadSchemas(dir string, files map[string]string) (*Schemas, error) {
+	if dir == "" && len(files) == 0 {
+		return nil, nil
+	}
+	s := &Schemas{byType: map[string]*jsonSchema{}}
+	if dir != "" {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no schema files found in %s", dir)
+		}
+		for _, file := range matches {
+			schema, err := loadJSONSchema(file)
+			if err != nil {
+				return nil, err
+			}
+			s.byType[strings.TrimSuffix(filepath.Base(file), ".json")] = schema
+		}
+	}
+	for eventType, file := range files {
+		schema, err := loadJSONSchema(file)
+		if err != nil {
+			return nil, err
+		}
+		s.byType[eventType] = schema
+	}
+	return s, nil
+}
+
+// validate checks the data of an event with the schema of its type. It
+// returns the type, the violations and whether there is a schema for the type
+// at all.
+func (s *Schemas) validate(event []byte) (eventType string, violations []string, checked bool) {
+	eventType, data, err := eventData(event)
+	if err != nil {
+		return eventType, []string{err.Error()}, true
+	}
+	schema := s.byType[eventType]
+	if schema == nil {
+		return eventType, nil, false
+	}
+	v := &schemaValidation{root: schema}
+	v.check(schema.node, data, "data")
+	if len(v.errors) > maxSchemaErrors {
+		v.errors = append(v.errors[:maxSchemaErrors], fmt.Sprintf("and %d more", len(v.errors)-maxSchemaErrors))
+	}
+	return eventType, v.errors, true
+}
+
+// Check validates the data of an event of the given event file. It returns an
+// error listing the violations if there are any; a nil set of schemas passes
+// every event.
+func (s *Schemas) Check(file string, event []byte) error {
+	if s == nil {
+		return nil
+	}
+	eventType, violations, checked := s.validate(event)
+	if !checked || len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("event of %s violates the schema of %s: %s", file, eventType, strings.Join(violations, "; "))
+}
+
+// eventData returns the type and the decoded data of an event.
+func eventData(event []byte) (string, interface{}, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return "", nil, fmt.Errorf("event is not a JSON object: %w", err)
+	}
+	if _, ok := fields["specversion"]; !ok {
+		data, err := decodeJSON(event)
+		return defaultEventType, data, err
+	}
+	var eventType, contentType string
+	json.Unmarshal(fields["type"], &eventType)              //nolint: errcheck
+	json.Unmarshal(fields["datacontenttype"], &contentType) //nolint: errcheck
+	if raw, ok := fields["data_base64"]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return eventType, nil, fmt.Errorf("data_base64 is not a string: %w", err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return eventType, nil, fmt.Errorf("data_base64: %w", err)
+		}
+		if contentType != "" && !IsJSONContentType(contentType) {
+			return eventType, string(raw), nil
+		}
+		data, err := decodeJSON(raw)
+		return eventType, data, err
+	}
+	raw, ok := fields["data"]
+	if !ok {
+		return eventType, nil, nil
+	}
+	data, err := decodeJSON(raw)
+	return eventType, data, err
+}
+
+func decodeJSON(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("data is not JSON: %w", err)
+	}
+	return v, nil
+}
+
+// jsonSchema is a parsed JSON Schema. Of the keywords of draft 2020-12 and
+// its predecessors it supports those that constrain the structure and values
+// of events: type, enum, const, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, format
+// (date-time, uri, uuid), minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, allOf, anyOf, oneOf, not and $ref within the schema.
+type jsonSchema struct {
+	file string
+	node interface{}
+	// the patterns of the schema, compiled when it is loaded
+	patterns map[string]*regexp.Regexp
+}
+
+func loadJSONSchema(file string) (*jsonSchema, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	node, err := decodeJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s is not JSON: %w", file, err)
+	}
+	s := &jsonSchema{file: file, node: node, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compilePatterns(node); err != nil {
+		return nil, fmt.Errorf("schema %s: %w", file, err)
+	}
+	return s, nil
+}
+
+func (s *jsonSchema) compilePatterns(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, v := range n {
+			if p, ok := v.(string); ok && key == "pattern" {
+				re, err := regexp.Compile(p)
+				if err != nil {
+					return fmt.Errorf("invalid pattern %q: %w", p, err)
+				}
+				s.patterns[p] = re
+			} else if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range n {
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the node a local reference like #/$defs/state points to.
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("reference %s is not within the schema", ref)
+	}
+	node := s.node
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[token]
+		default:
+			node = nil
+		}
+		if node == nil {
+			return nil, fmt.Errorf("reference %s not found in %s", ref, s.file)
+		}
+	}
+	return node, nil
+}
+
+// schemaValidation collects the violations of a value.
+type schemaValidation struct {
+	root   *jsonSchema
+	errors []string
+	// depth guards against cyclic references
+	depth int
+}
+
+func (v *schemaValidation) errorf(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+// valid reports whether value passes schema, without recording violations.
+func (v *schemaValidation) valid(schema, value interface{}, path string) bool {
+	sub := &schemaValidation{root: v.root, depth: v.depth}
+	sub.check(schema, value, path)
+	return len(sub.errors) == 0
+}
+
+func (v *schemaValidation) check(schema, value interface{}, path string) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.errorf(path, "not allowed")
+		}
+		return
+	case map[string]interface{}:
+		v.checkObject(s, value, path)
+	}
+}
+
+func (v *schemaValidation) checkObject(s map[string]interface{}, value interface{}, path string) {
+	if ref, ok := s["$ref"].(string); ok {
+		if v.depth > 64 {
+			v.errorf(path, "reference %s nested too deep", ref)
+			return
+		}
+		node, err := v.root.resolve(ref)
+		if err != nil {
+			v.errorf(path, "%v", err)
+			return
+		}
+		v.depth++
+		v.check(node, value, path)
+		v.depth--
+	}
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		v.errorf(path, "is %s, expected %s", jsonType(value), formatTypes(t))
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.errorf(path, "%s is not one of %s", formatValue(value), formatValue(enum))
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(c, value) {
+		v.errorf(path, "%s is not %s", formatValue(value), formatValue(c))
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := s[key].([]interface{})
+		if !ok {
+			continue
+		}
+		passed := 0
+		for _, sub := range subs {
+			if key == "allOf" {
+				v.check(sub, value, path)
+			} else if v.valid(sub, value, path) {
+				passed++
+			}
+		}
+		if key == "anyOf" && passed == 0 {
+			v.errorf(path, "matches none of anyOf")
+		} else if key == "oneOf" && passed != 1 {
+			v.errorf(path, "matches %d of oneOf, expected exactly 1", passed)
+		}
+	}
+	if not, ok := s["not"]; ok && v.valid(not, value, path) {
+		v.errorf(path, "must not match the not schema")
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.checkProperties(s, val, path)
+	case []interface{}:
+		if n, ok := schemaInt(s["minItems"]); ok && len(val) < n {
+			v.errorf(path, "has %d items, at least %d needed", len(val), n)
+		}
+		if n, ok := schemaInt(s["maxItems"]); ok && len(val) > n {
+			v.errorf(path, "has %d items, at most %d allowed", len(val), n)
+		}
+		if items, ok := s["items"]; ok {
+			if _, tuple := items.([]interface{}); !tuple {
+				for i, item := range val {
+					v.check(items, item, fmt.Sprintf("%s[%d]", path, i))
+				}
+			}
+		}
+	case string:
+		length := len([]rune(val))
+		if n, ok := schemaInt(s["minLength"]); ok && length < n {
+			v.errorf(path, "is %d characters long, at least %d needed", length, n)
+		}
+		if n, ok := schemaInt(s["maxLength"]); ok && length > n {
+			v.errorf(path, "is %d characters long, at most %d allowed", length, n)
+		}
+		if p, ok := s["pattern"].(string); ok && !v.root.patterns[p].MatchString(val) {
+			v.errorf(path, "%q does not match %s", val, p)
+		}
+		if f, ok := s["format"].(string); ok && !matchesFormat(f, val) {
+			v.errorf(path, "%q is not a %s", val, f)
+		}
+	case json.Number:
+		v.checkNumber(s, val, path)
+	}
+}
+
+func (v *schemaValidation) checkProperties(s map[string]interface{}, obj map[string]interface{}, path string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					v.errorf(path, "required property %s is missing", name)
+				}
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	additional, restricted := s["additionalProperties"]
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := props[name]; ok {
+			v.check(prop, obj[name], path+"."+name)
+		} else if restricted {
+			if b, ok := additional.(bool); ok && !b {
+				v.errorf(path, "property %s is not allowed", name)
+			} else {
+				v.check(additional, obj[name], path+"."+name)
+			}
+		}
+	}
+}
+
+func (v *schemaValidation) checkNumber(s map[string]interface{}, n json.Number, path string) {
+	value, ok := new(big.Float).SetString(n.String())
+	if !ok {
+		return
+	}
+	bound := func(key string) (*big.Float, bool) {
+		b, ok := s[key].(json.Number)
+		if !ok {
+			return nil, false
+		}
+		f, ok := new(big.Float).SetString(b.String())
+		return f, ok
+	}
+	if b, ok := bound("minimum"); ok && value.Cmp(b) < 0 {
+		v.errorf(path, "%s is less than the minimum %s", n, s["minimum"])
+	}
+	if b, ok := bound("maximum"); ok && value.Cmp(b) > 0 {
+		v.errorf(path, "%s is greater than the maximum %s", n, s["maximum"])
+	}
+	if b, ok := bound("exclusiveMinimum"); ok && value.Cmp(b) <= 0 {
+		v.errorf(path, "%s is not greater than %s", n, s["exclusiveMinimum"])
+	}
+	if b, ok := bound("exclusiveMaximum"); ok && value.Cmp(b) >= 0 {
+		v.errorf(path, "%s is not less than %s", n, s["exclusiveMaximum"])
+	}
+}
+
+// jsonType returns the JSON Schema type of a decoded value.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// matchesType reports whether value has the type, or one of the types, of a
+// type keyword. Integers are numbers as well.
+func matchesType(t interface{}, value interface{}) bool {
+	types, ok := t.([]interface{})
+	if !ok {
+		types = []interface{}{t}
+	}
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTypes(t interface{}) string {
+	if types, ok := t.([]interface{}); ok {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = fmt.Sprint(t)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func formatValue(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// jsonEqual compares decoded values; numbers compare by value.
+func jsonEqual(a, b interface{}) bool {
+	if na, ok := a.(json.Number); ok {
+		nb, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fa, okA := new(big.Float).SetString(na.String())
+		fb, okB := new(big.Float).SetString(nb.String())
+		return okA && okB && fa.Cmp(fb) == 0
+	}
+	return formatValue(a) == formatValue(b)
+}
+
+func schemaInt(v interface{}) (int, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return int(i), err == nil
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// matchesFormat checks the formats events use; others are not checked.
+func matchesFormat(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.IsAbs()
+	case "uuid":
+		return uuidPattern.MatchString(s)
+	}
+	return true
+}
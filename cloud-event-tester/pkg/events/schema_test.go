@@ -0,0 +1,204 @@
+package events
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSchema writes a schema file into a temporary directory and returns
+// its path.
+func writeSchema(t *testing.T, name, schema string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testStateSchema = `{
+  "type": "object",
+  "required": ["state", "value"],
+  "additionalProperties": false,
+  "properties": {
+    "state": {"$ref": "#/$defs/state"},
+    "value": {"type": "number", "minimum": 0, "exclusiveMaximum": 100},
+    "count": {"type": "integer", "maximum": 10},
+    "id": {"type": "string", "format": "uuid"},
+    "time": {"type": "string", "format": "date-time"},
+    "source": {"type": "string", "format": "uri"},
+    "name": {"type": "string", "minLength": 2, "maxLength": 4, "pattern": "^[a-z]+$"},
+    "tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}},
+    "kind": {"const": "clock"},
+    "level": {"anyOf": [{"type": "integer"}, {"enum": ["low", "high"]}]},
+    "mode": {"oneOf": [{"type": "string"}, {"type": "string", "maxLength": 1}]},
+    "note": {"not": {"type": "null"}},
+    "extra": {"allOf": [{"type": "object"}, {"required": ["a"]}]}
+  },
+  "$defs": {
+    "state": {"enum": ["Locked", "Freerun", "Holdover"]}
+  }
+}`
+
+func TestSchemaValidation(t *testing.T) {
+	schema, err := loadJSONSchema(writeSchema(t, "state.json", testStateSchema))
+	if err != nil {
+		t.Fatalf("loadJSONSchema failed: %v", err)
+	}
+	s := &Schemas{byType: map[string]*jsonSchema{"clock": schema}}
+	tests := []struct {
+		data string
+		// the violations, an empty list for valid data
+		want []string
+	}{
+		{`{"state": "Locked", "value": 12.5}`, nil},
+		{`{"state": "Locked", "value": 0, "count": 10, "id": "4b1f4e2c-9c1e-4b8e-8f5e-2d0c9f1e7a61",
+		   "time": "2026-10-14T10:00:00.5Z", "source": "https://node/clock", "name": "abc", "tags": ["a"],
+		   "kind": "clock", "level": "low", "mode": "ab", "note": 1, "extra": {"a": 1}}`, nil},
+		{`{"value": 1}`, []string{"data: required property state is missing"}},
+		{`{"state": "Lost", "value": 1}`, []string{`data.state: "Lost" is not one of ["Locked","Freerun","Holdover"]`}},
+		{`{"state": "Locked", "value": "1"}`, []string{"data.value: is string, expected number"}},
+		{`{"state": "Locked", "value": -1}`, []string{"data.value: -1 is less than the minimum 0"}},
+		{`{"state": "Locked", "value": 100}`, []string{"data.value: 100 is not less than 100"}},
+		{`{"state": "Locked", "value": 1, "count": 1.5}`, []string{"data.count: is number, expected integer"}},
+		{`{"state": "Locked", "value": 1, "count": 11}`, []string{"data.count: 11 is greater than the maximum 10"}},
+		{`{"state": "Locked", "value": 1, "other": true}`, []string{"data: property other is not allowed"}},
+		{`{"state": "Locked", "value": 1, "id": "not-a-uuid"}`, []string{`data.id: "not-a-uuid" is not a uuid`}},
+		{`{"state": "Locked", "value": 1, "time": "yesterday"}`, []string{`data.time: "yesterday" is not a date-time`}},
+		{`{"state": "Locked", "value": 1, "source": "node/clock"}`, []string{`data.source: "node/clock" is not a uri`}},
+		{`{"state": "Locked", "value": 1, "name": "a"}`, []string{"data.name: is 1 characters long, at least 2 needed"}},
+		{`{"state": "Locked", "value": 1, "name": "abcde"}`, []string{"data.name: is 5 characters long, at most 4 allowed"}},
+		{`{"state": "Locked", "value": 1, "name": "AB"}`, []string{`data.name: "AB" does not match ^[a-z]+$`}},
+		{`{"state": "Locked", "value": 1, "tags": []}`, []string{"data.tags: has 0 items, at least 1 needed"}},
+		{`{"state": "Locked", "value": 1, "tags": ["a", "b", "c"]}`, []string{"data.tags: has 3 items, at most 2 allowed"}},
+		{`{"state": "Locked", "value": 1, "tags": [1]}`, []string{"data.tags[0]: is integer, expected string"}},
+		{`{"state": "Locked", "value": 1, "kind": "timer"}`, []string{`data.kind: "timer" is not "clock"`}},
+		{`{"state": "Locked", "value": 1, "level": "mid"}`, []string{"data.level: matches none of anyOf"}},
+		{`{"state": "Locked", "value": 1, "mode": "a"}`, []string{"data.mode: matches 2 of oneOf, expected exactly 1"}},
+		{`{"state": "Locked", "value": 1, "note": null}`, []string{"data.note: must not match the not schema"}},
+		{`{"state": "Locked", "value": 1, "extra": {}}`, []string{"data.extra: required property a is missing"}},
+		{`[]`, []string{"data: is array, expected object"}},
+		{`null`, []string{"data: is null, expected object"}},
+	}
+	for _, tt := range tests {
+		event := `{"specversion": "1.0", "id": "1", "source": "test", "type": "clock", "data": ` + tt.data + `}`
+		eventType, violations, checked := s.validate([]byte(event))
+		if eventType != "clock" || !checked {
+			t.Errorf("validate(%s) checked %v the type %q, want the schema of clock", tt.data, checked, eventType)
+			continue
+		}
+		if strings.Join(violations, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("validate(%s) = %q, want %q", tt.data, violations, tt.want)
+		}
+	}
+}
+
+func TestSchemaValidationEvents(t *testing.T) {
+	schema, err := loadJSONSchema(writeSchema(t, "state.json", `{"type": "object", "required": ["state"]}`))
+	if err != nil {
+		t.Fatalf("loadJSONSchema failed: %v", err)
+	}
+	s := &Schemas{byType: map[string]*jsonSchema{"clock": schema, defaultEventType: schema}}
+	encoded := base64.StdEncoding.EncodeToString([]byte(`{"value": 1}`))
+	tests := []struct {
+		name       string
+		event      string
+		violations int
+		checked    bool
+	}{
+		{"valid", `{"specversion": "1.0", "type": "clock", "data": {"state": "Locked"}}`, 0, true},
+		{"mismatch", `{"specversion": "1.0", "type": "clock", "data": {"value": 1}}`, 1, true},
+		{"no schema for the type", `{"specversion": "1.0", "type": "timer", "data": {}}`, 0, false},
+		{"no data", `{"specversion": "1.0", "type": "clock"}`, 1, true},
+		{"base64 data", `{"specversion": "1.0", "type": "clock", "data_base64": "` + encoded + `"}`, 1, true},
+		{"base64 text", `{"specversion": "1.0", "type": "clock", "datacontenttype": "text/plain", "data_base64": "` + encoded + `"}`, 1, true},
+		{"invalid base64", `{"specversion": "1.0", "type": "clock", "data_base64": "%%%"}`, 1, true},
+		{"base64 not a string", `{"specversion": "1.0", "type": "clock", "data_base64": 1}`, 1, true},
+		{"not a cloud event", `{"state": "Locked"}`, 0, true},
+		{"not a cloud event, mismatch", `{"value": 1}`, 1, true},
+		{"not an object", `["state"]`, 1, true},
+		{"truncated", `{"specversion": "1.0", "type": "clock", "data": {"state"`, 1, true},
+	}
+	for _, tt := range tests {
+		_, violations, checked := s.validate([]byte(tt.event))
+		if len(violations) != tt.violations || checked != tt.checked {
+			t.Errorf("%s: validate = %q, checked %v, want %d violations, checked %v", tt.name, violations, checked, tt.violations, tt.checked)
+		}
+	}
+	if err := s.Check("event.json", []byte(`{"specversion": "1.0", "type": "clock", "data": {}}`)); err == nil ||
+		!strings.Contains(err.Error(), "event of event.json violates the schema of clock") {
+		t.Errorf("check = %v, want the violation of event.json", err)
+	}
+	var none *Schemas
+	if err := none.Check("event.json", []byte(`not json`)); err != nil {
+		t.Errorf("nil schemas check = %v, want every event to pass", err)
+	}
+}
+
+func TestSchemaValidationLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		want   string
+	}{
+		{"cyclic reference", `{"$ref": "#"}`, `{}`, "nested too deep"},
+		{"missing reference", `{"$ref": "#/$defs/none"}`, `{}`, "reference #/$defs/none not found"},
+		{"remote reference", `{"$ref": "https://example.com/schema.json"}`, `{}`, "is not within the schema"},
+		{"false schema", `{"properties": {"a": false}}`, `{"a": 1}`, "data.a: not allowed"},
+		{"many violations", `{"items": {"type": "string"}}`, `[1, 2, 3, 4, 5, 6, 7]`, "and 2 more"},
+	}
+	for _, tt := range tests {
+		schema, err := loadJSONSchema(writeSchema(t, "schema.json", tt.schema))
+		if err != nil {
+			t.Errorf("%s: loadJSONSchema failed: %v", tt.name, err)
+			continue
+		}
+		s := &Schemas{byType: map[string]*jsonSchema{"clock": schema}}
+		_, violations, _ := s.validate([]byte(`{"specversion": "1.0", "type": "clock", "data": ` + tt.data + `}`))
+		if !strings.Contains(strings.Join(violations, "; "), tt.want) {
+			t.Errorf("%s: validate = %q, want a violation with %q", tt.name, violations, tt.want)
+		}
+	}
+}
+
+func TestLoadJSONSchemaMalformed(t *testing.T) {
+	for name, schema := range map[string]string{
+		"not JSON":        `{"type": "object",`,
+		"empty":           ``,
+		"invalid pattern": `{"properties": {"name": {"pattern": "(["}}}`,
+		"nested pattern":  `{"anyOf": [{"items": {"pattern": "*"}}]}`,
+	} {
+		if _, err := loadJSONSchema(writeSchema(t, "schema.json", schema)); err == nil {
+			t.Errorf("%s: loadJSONSchema succeeded, want an error", name)
+		}
+	}
+	if _, err := loadJSONSchema(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("loadJSONSchema of a missing file succeeded, want an error")
+	}
+}
+
+func TestLoadEventSchemas(t *testing.T) {
+	dir := filepath.Dir(writeSchema(t, "clock.json", `{"type": "object"}`))
+	if err := os.WriteFile(filepath.Join(dir, "timer.json"), []byte(`{"type": "array"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	override := writeSchema(t, "other.json", `{"type": "string"}`)
+	s, err := LoadSchemas(dir, map[string]string{"timer": override})
+	if err != nil {
+		t.Fatalf("loadEventSchemas failed: %v", err)
+	}
+	if len(s.byType) != 2 || s.byType["clock"] == nil || s.byType["timer"].file != override {
+		t.Errorf("loadEventSchemas has the schemas %v, want clock of the directory and timer of its own", s.byType)
+	}
+
+	if s, err := LoadSchemas("", nil); s != nil || err != nil {
+		t.Errorf("loadEventSchemas without schemas = %v, %v, want none", s, err)
+	}
+	if _, err := LoadSchemas(t.TempDir(), nil); err == nil {
+		t.Errorf("loadEventSchemas of an empty directory succeeded, want an error")
+	}
+}
@@ -0,0 +1,9 @@
+package events
+
+// extension attributes of stamped events: the run that sent an event, its
+// number in the run, counting from 1, and the time it was sent
+const (
+	SequenceRunAttr = "cetrunid"
+	SequenceSeqAttr = "cetseq"
+	SendTimeAttr    = "cetsenttime"
+)
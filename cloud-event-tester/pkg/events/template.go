@@ -0,0 +1,90 @@
+// Package events builds the cloud events the tester sends: the templates and
+// generated sequences of events, their content modes, batches, labels,
+// mutations and data encodings, the schemas they are checked against and
+// the recordings they are replayed from.
+package events
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Template is an event file with Go template placeholders, rendered for
+// every send so consumers do not deduplicate the events of a run away:
+//
+//	{{uuid}}          a random UUID
+//	{{now}}           the time of the send, RFC 3339 with nanoseconds
+//	{{seq}}           the number of the send in the run, from 1
+//	{{randInt 1 100}} a random integer between the bounds, both included
+type Template struct {
+	tmpl *template.Template
+	// seq is shared by all templates of a run
+	seq *int64
+}
+
+// Renderer renders the event of every send of a performance run; it is
+// an event template or a generator.
+type Renderer interface {
+	Render(buf *bytes.Buffer) error
+}
+
+// ParseTemplate returns the template of an event, or nil if the event
+// has no placeholders and can be sent as is.
+func ParseTemplate(name string, event []byte, seq *int64) (*Template, error) {
+	if !bytes.Contains(event, []byte("{{")) {
+		return nil, nil
+	}
+	t := &Template{seq: seq}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"uuid":    NewUUID,
+		"now":     func() string { return time.Now().Format(time.RFC3339Nano) },
+		"seq":     func() int64 { return atomic.AddInt64(t.seq, 1) },
+		"randInt": randInt,
+	}).Parse(string(event))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse event template %s: %w", name, err)
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// Render writes the next event to buf, replacing its content.
+func (t *Template) Render(buf *bytes.Buffer) error {
+	buf.Reset()
+	return t.tmpl.Execute(buf, nil)
+}
+
+// RenderEvent renders an event once if it has placeholders, for the modes
+// that read an event file for every send.
+func RenderEvent(name string, event []byte, seq *int64) ([]byte, error) {
+	t, err := ParseTemplate(name, event, seq)
+	if t == nil || err != nil {
+		return event, err
+	}
+	var buf bytes.Buffer
+	if err := t.Render(&buf); err != nil {
+		return nil, fmt.Errorf("failed to render event template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// NewUUID returns a random (version 4) UUID.
+func NewUUID() string {
+	var u [16]byte
+	rand.Read(u[:]) //nolint: errcheck
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt: max %d is below min %d", max, min)
+	}
+	return min + mrand.Intn(max-min+1), nil
+}
@@ -0,0 +1,206 @@
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// adaptiveDecrease is the factor the adaptive rate is cut by in a second with
+// throttled responses.
+const adaptiveDecrease = 0.5
+
+// rateController adapts the rate of a performance run to a target that
+// pushes back, additive increase and multiplicative decrease: every second
+// with 429 or 503 responses it halves the rate, down to a floor, and every
+// second without any it raises the rate by a step, up to the requested rate.
+// A Retry-After header holds all sends until it expires. The send loops keep
+// their pacing and let the share of the sends through that the rate allows,
+// as faultInjector picks its share of broken events. A nil controller lets
+// every send through.
+type rateController struct {
+	requested, min, step float64
+	// rate is only touched by the ticker, share is the rate as a share of
+	// the requested rate, as the bits of a float64
+	rate  float64
+	share uint64
+	// throttled responses of the current second and of the run, and the
+	// end of the latest Retry-After in Unix nanoseconds
+	second, throttled int64
+	retryUntil        int64
+	retryAfter, held  int64
+	decreases         int
+	lowest            float64
+}
+
+func newRateController(cfg *Config) *rateController {
+	if !cfg.AdaptiveRate {
+		return nil
+	}
+	c := &rateController{
+		requested: float64(cfg.Rate),
+		min:       float64(cfg.AdaptiveMinRate),
+		step:      float64(cfg.AdaptiveStep),
+		rate:      float64(cfg.Rate),
+		lowest:    float64(cfg.Rate),
+	}
+	if c.step == 0 {
+		c.step = math.Ceil(c.requested / 10)
+	}
+	c.setShare(1)
+	log.Infof("Adaptive Rate: halved on 429/503 responses down to %d msg/s, raised by %g msg/s every second without", cfg.AdaptiveMinRate, c.step)
+	return c
+}
+
+// validateAdaptiveRate checks the adaptive rate settings. The target pushes
+// back with HTTP status codes, so the run sends over HTTP.
+func (c *Config) validateAdaptiveRate() error {
+	if !c.AdaptiveRate {
+		return nil
+	}
+	switch {
+	case !c.IsPerf():
+		return fmt.Errorf("adaptive rate control paces performance runs only")
+	case strings.ToLower(c.Transport) != sender.TransportHTTP:
+		return fmt.Errorf("adaptive rate control reads HTTP responses, it cannot be combined with the %s transport", c.Transport)
+	case c.AdaptiveMinRate < 1 || c.AdaptiveMinRate > c.Rate:
+		return fmt.Errorf("adaptive min rate must be between 1 and the rate %d, got %d", c.Rate, c.AdaptiveMinRate)
+	case c.AdaptiveStep < 0:
+		return fmt.Errorf("adaptive step must not be negative, got %d", c.AdaptiveStep)
+	}
+	return nil
+}
+
+func (c *rateController) setShare(share float64) {
+	atomic.StoreUint64(&c.share, math.Float64bits(share))
+}
+
+// observe counts a throttled response and honors its Retry-After header.
+func (c *rateController) observe(res *fasthttp.Response) {
+	if c == nil {
+		return
+	}
+	if code := res.StatusCode(); code == fasthttp.StatusTooManyRequests || code == fasthttp.StatusServiceUnavailable {
+		atomic.AddInt64(&c.second, 1)
+		atomic.AddInt64(&c.throttled, 1)
+	}
+	now := time.Now()
+	wait, ok := parseRetryAfter(string(res.Header.Peek(fasthttp.HeaderRetryAfter)), now)
+	if !ok {
+		return
+	}
+	until := now.Add(wait).UnixNano()
+	for {
+		cur := atomic.LoadInt64(&c.retryUntil)
+		if cur >= until {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&c.retryUntil, cur, until) {
+			// a Retry-After that extends a hold is not a new one
+			if cur < now.UnixNano() {
+				atomic.AddInt64(&c.retryAfter, 1)
+				log.Warnf("Adaptive Rate: the target asked to retry after %v, holding the sends", wait)
+			}
+			return
+		}
+	}
+}
+
+// admit tells whether a send paced by a loop goes ahead, with credit the
+// share of the sends of the loop due, and counts the sends it holds.
+func (c *rateController) admit(credit *float64) bool {
+	if c == nil {
+		return true
+	}
+	if time.Now().UnixNano() < atomic.LoadInt64(&c.retryUntil) {
+		atomic.AddInt64(&c.held, 1)
+		return false
+	}
+	*credit += math.Float64frombits(atomic.LoadUint64(&c.share))
+	if *credit < 1 {
+		atomic.AddInt64(&c.held, 1)
+		return false
+	}
+	*credit--
+	return true
+}
+
+// tick returns the rate of the second that ended, 0 for a nil controller,
+// and adapts it for the next one. A second in a Retry-After keeps the rate.
+func (c *rateController) tick() int {
+	if c == nil {
+		return 0
+	}
+	rate := c.rate
+	throttled := atomic.SwapInt64(&c.second, 0)
+	switch {
+	case throttled > 0:
+		if c.rate = c.rate * adaptiveDecrease; c.rate < c.min {
+			c.rate = c.min
+		}
+		if c.rate < c.lowest {
+			c.lowest = c.rate
+		}
+		if c.rate < rate {
+			c.decreases++
+			log.Warnf("Adaptive Rate: %d responses throttled, rate lowered to %d msg/s", throttled, int(math.Round(c.rate)))
+		}
+	case time.Now().UnixNano() < atomic.LoadInt64(&c.retryUntil):
+	case c.rate < c.requested:
+		if c.rate += c.step; c.rate >= c.requested {
+			c.rate = c.requested
+			log.Infof("Adaptive Rate: back at the requested %d msg/s", int(c.requested))
+		}
+	}
+	c.setShare(c.rate / c.requested)
+	return int(math.Round(rate))
+}
+
+// report adds the adaptive rate stats to a run result. It must only be
+// called after the ticker stopped.
+func (c *rateController) report(result *report.Result) {
+	if c == nil {
+		return
+	}
+	result.Adaptive = &report.AdaptiveStats{
+		Throttled:  int(atomic.LoadInt64(&c.throttled)),
+		RetryAfter: int(atomic.LoadInt64(&c.retryAfter)),
+		Held:       int(atomic.LoadInt64(&c.held)),
+		Decreases:  c.decreases,
+		LowestRate: int(math.Round(c.lowest)),
+		FinalRate:  int(math.Round(c.rate)),
+	}
+	a := result.Adaptive
+	log.Infof("Adaptive Rate: %d throttled responses, %d Retry-After, %d rate decreases, lowest %d msg/s, final %d msg/s, %d sends held",
+		a.Throttled, a.RetryAfter, a.Decreases, a.LowestRate, a.FinalRate, a.Held)
+}
+
+// parseRetryAfter parses a Retry-After header, delay seconds or an HTTP
+// date, into the time to wait from now.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(v)
+	if err != nil || !at.After(now) {
+		return 0, false
+	}
+	return at.Sub(now), true
+}
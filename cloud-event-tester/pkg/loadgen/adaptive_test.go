@@ -0,0 +1,118 @@
+package loadgen
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// TestRateController throttles the sends of a run for a few seconds and
+// checks the rate halves down to its floor, then climbs back by its step to
+// the requested rate, with the sends admitted at the share of the rate.
+func TestRateController(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Perf = "YES"
+	cfg.Rate = 100
+	cfg.AdaptiveRate = true
+	cfg.AdaptiveMinRate = 10
+	c := newRateController(&cfg)
+	throttled := new(fasthttp.Response)
+	throttled.SetStatusCode(fasthttp.StatusTooManyRequests)
+
+	// the rate the next second is sent at, after every tick
+	want := []int{50, 25, 13, 10, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 100}
+	for i, rate := range want {
+		if i < 5 {
+			c.observe(throttled)
+		}
+		c.tick()
+		if got := int(c.rate + 0.5); got != rate {
+			t.Fatalf("rate after second %d = %d, want %d", i+1, got, rate)
+		}
+		// the loop paces the requested rate and the controller admits
+		// the share of the adapted one
+		admitted := 0
+		var credit float64
+		for n := 0; n < cfg.Rate; n++ {
+			if c.admit(&credit) {
+				admitted++
+			}
+		}
+		if admitted < int(c.rate)-1 || admitted > int(c.rate+0.5)+1 {
+			t.Errorf("second %d admitted %d of %d sends at %g msg/s", i+2, admitted, cfg.Rate, c.rate)
+		}
+	}
+
+	var result report.Result
+	c.report(&result)
+	a := result.Adaptive
+	if a.Throttled != 5 || a.Decreases != 4 || a.LowestRate != 10 || a.FinalRate != 100 {
+		t.Errorf("adaptive stats %+v, want 5 throttled, 4 decreases, lowest 10 and final 100", *a)
+	}
+}
+
+// TestRateControllerRetryAfter checks a Retry-After holds every send and
+// keeps the rate until it expires.
+func TestRateControllerRetryAfter(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Perf = "YES"
+	cfg.Rate = 100
+	cfg.AdaptiveRate = true
+	cfg.AdaptiveMinRate = 10
+	c := newRateController(&cfg)
+	c.rate = 50
+	res := new(fasthttp.Response)
+	res.SetStatusCode(fasthttp.StatusOK)
+	res.Header.Set(fasthttp.HeaderRetryAfter, "30")
+	c.observe(res)
+	var credit float64
+	if c.admit(&credit) {
+		t.Errorf("a send was admitted during a Retry-After")
+	}
+	if c.tick(); c.rate != 50 {
+		t.Errorf("rate changed to %g during a Retry-After, want 50", c.rate)
+	}
+	// the hold is over
+	atomic.StoreInt64(&c.retryUntil, 0)
+	if c.tick(); c.rate != 60 {
+		t.Errorf("rate %g after a Retry-After, want 60", c.rate)
+	}
+	// at a share of 0.6 one of two sends goes ahead
+	if c.admit(&credit) == c.admit(&credit) {
+		t.Errorf("not one of two sends admitted after the Retry-After")
+	}
+	var result report.Result
+	c.report(&result)
+	if result.Adaptive.RetryAfter != 1 || result.Adaptive.Held != 2 {
+		t.Errorf("adaptive stats %+v, want 1 Retry-After and 2 sends held", *result.Adaptive)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"5", 5 * time.Second, true},
+		{" 120 ", 2 * time.Minute, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Second).Format(http.TimeFormat), 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package loadgen
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// kinds of assertion failures in the breakdown of a run; header failures are
+// counted by header, like "header ce-id"
+const (
+	assertStatus = "status"
+	assertBody   = "body"
+	assertHeader = "header"
+)
+
+// defaultExpectStatus is the status assertion of runs that set none.
+const defaultExpectStatus = "2xx"
+
+// AssertionSpec holds the assertions on the responses to the events of a run
+// or of one event file, as given in the settings.
+type AssertionSpec struct {
+	// Status lists the expected status codes, like 200,202, 2xx or 200-299
+	Status string `yaml:"status" json:"status,omitempty"`
+	// Body is a regular expression the response body must match
+	Body string `yaml:"body" json:"body,omitempty"`
+	// Headers the response must have; a non-empty value is a regular
+	// expression the header must match
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+}
+
+// statusRange is a range of expected status codes.
+type statusRange struct {
+	from, to int
+}
+
+// responseAssertion checks the responses to the events of a run. A response
+// that fails it was received, so it is counted apart from the transport
+// errors of the sends.
+type responseAssertion struct {
+	status  []statusRange
+	spec    string
+	body    *regexp.Regexp
+	headers map[string]*regexp.Regexp
+}
+
+// newResponseAssertion compiles spec; the status defaults to 2xx.
+func newResponseAssertion(spec AssertionSpec) (*responseAssertion, error) {
+	if spec.Status == "" {
+		spec.Status = defaultExpectStatus
+	}
+	a := &responseAssertion{spec: spec.Status}
+	for _, item := range sender.SplitList(spec.Status) {
+		r, err := parseStatusRange(item)
+		if err != nil {
+			return nil, err
+		}
+		a.status = append(a.status, r)
+	}
+	if spec.Body != "" {
+		re, err := regexp.Compile(spec.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body assertion: %w", err)
+		}
+		a.body = re
+	}
+	for name, value := range spec.Headers {
+		if name == "" {
+			return nil, fmt.Errorf("header assertion without a name")
+		}
+		if a.headers == nil {
+			a.headers = map[string]*regexp.Regexp{}
+		}
+		var re *regexp.Regexp
+		if value != "" {
+			var err error
+			if re, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("invalid assertion of header %s: %w", name, err)
+			}
+		}
+		a.headers[name] = re
+	}
+	return a, nil
+}
+
+// parseStatusRange parses a status code, a class like 2xx or a range like
+// 200-299.
+func parseStatusRange(s string) (statusRange, error) {
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+		from := int(s[0]-'0') * 100
+		return statusRange{from, from + 99}, nil
+	}
+	from, to, isRange := strings.Cut(s, "-")
+	lo, err := strconv.Atoi(strings.TrimSpace(from))
+	hi := lo
+	if err == nil && isRange {
+		hi, err = strconv.Atoi(strings.TrimSpace(to))
+	}
+	if err != nil || lo < 100 || hi > 599 || hi < lo {
+		return statusRange{}, fmt.Errorf("expected status %q is not a code, class like 2xx or range like 200-299", s)
+	}
+	return statusRange{lo, hi}, nil
+}
+
+// check returns the kind of the first assertion res fails and why, or an
+// empty kind if it passes.
+func (a *responseAssertion) check(res *fasthttp.Response) (kind, reason string) {
+	code := res.StatusCode()
+	if !a.expectedStatus(code) {
+		return assertStatus, fmt.Sprintf("response status %d, expected %s", code, a.spec)
+	}
+	for name, re := range a.headers {
+		value := res.Header.Peek(name)
+		if value == nil {
+			return assertHeader + " " + name, fmt.Sprintf("response without header %s", name)
+		}
+		if re != nil && !re.Match(value) {
+			return assertHeader + " " + name, fmt.Sprintf("response header %s %q does not match %s", name, value, re)
+		}
+	}
+	if a.body != nil && !a.body.Match(res.Body()) {
+		return assertBody, fmt.Sprintf("response body does not match %s", a.body)
+	}
+	return "", ""
+}
+
+// expectedStatus reports whether code counts as success.
+func (a *responseAssertion) expectedStatus(code int) bool {
+	for _, r := range a.status {
+		if code >= r.from && code <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// assertions returns the global assertion of the run and those of the event
+// files that have their own, by file name. The settings a file assertion
+// leaves empty are taken from the global one.
+func (c *Config) assertions() (*responseAssertion, map[string]*responseAssertion, error) {
+	global := c.AssertionSpec()
+	all, err := newResponseAssertion(global)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string]*responseAssertion, len(c.FileAssertions))
+	for file, spec := range c.FileAssertions {
+		if files[file], err = newResponseAssertion(spec.withDefaults(global)); err != nil {
+			return nil, nil, fmt.Errorf("event file %s: %w", file, err)
+		}
+	}
+	return all, files, nil
+}
+
+// AssertionSpec returns the global assertions of the run.
+func (c *Config) AssertionSpec() AssertionSpec {
+	return AssertionSpec{Status: c.ExpectStatus, Body: c.ExpectBody, Headers: c.ExpectHeaders}
+}
+
+// withDefaults returns s with the settings it leaves empty taken from
+// global.
+func (s AssertionSpec) withDefaults(global AssertionSpec) AssertionSpec {
+	if s.Status == "" {
+		s.Status = global.Status
+	}
+	if s.Body == "" {
+		s.Body = global.Body
+	}
+	if s.Headers == nil {
+		s.Headers = global.Headers
+	}
+	return s
+}
+
+// assertionFor returns the assertion of the event file with the given name.
+func assertionFor(all *responseAssertion, files map[string]*responseAssertion, file string) *responseAssertion {
+	if a := files[file]; a != nil {
+		return a
+	}
+	return all
+}
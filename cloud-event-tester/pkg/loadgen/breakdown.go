@@ -0,0 +1,208 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// latencyBreakdown sends every -latency-breakdown-th request of a run with a
+// traced net/http client on a new connection, whatever the stack of the
+// run, and times its phases, to tell whether the latency of a consumer lives
+// in the network path or in its handler. The other requests are sent as
+// usual, on the connections they keep alive; a sampled one pays for its
+// connection, so its total is higher than that of the others. Failed
+// samples are counted but not timed. It is safe for concurrent use; a nil
+// breakdown samples nothing.
+type latencyBreakdown struct {
+	every  int64
+	n      int64
+	client *sender.NetHTTPClient
+
+	mu      sync.Mutex
+	samples int64
+	failed  int64
+	dns     *hdrhistogram.Histogram
+	connect *hdrhistogram.Histogram
+	tls     *hdrhistogram.Histogram
+	ttfb    *hdrhistogram.Histogram
+	total   *hdrhistogram.Histogram
+}
+
+func newLatencyBreakdown(cfg *Config) *latencyBreakdown {
+	if cfg.LatencyBreakdown == 0 {
+		return nil
+	}
+	fresh := cfg.Options
+	fresh.NoKeepAlive = true
+	tlsConfig, _ := cfg.TLSConfig()
+	log.Infof("Latency Breakdown: every %s request on a new connection", ordinal(cfg.LatencyBreakdown))
+	return &latencyBreakdown{
+		every:   int64(cfg.LatencyBreakdown),
+		client:  sender.NewNetHTTPClient(&fresh, tlsConfig, nil),
+		dns:     report.NewLatencyHistogram(),
+		connect: report.NewLatencyHistogram(),
+		tls:     report.NewLatencyHistogram(),
+		ttfb:    report.NewLatencyHistogram(),
+		total:   report.NewLatencyHistogram(),
+	}
+}
+
+// ordinal returns n as an ordinal for the log, like 10th.
+func ordinal(n int) string {
+	if n == 1 {
+		return "single"
+	}
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// validateLatencyBreakdown checks the latency breakdown settings.
+func (c *Config) validateLatencyBreakdown() error {
+	switch {
+	case c.LatencyBreakdown < 0:
+		return fmt.Errorf("latency-breakdown must not be negative, got %d", c.LatencyBreakdown)
+	case c.LatencyBreakdown > 0 && c.Transport != "" && strings.ToLower(c.Transport) != sender.TransportHTTP:
+		return fmt.Errorf("the latency breakdown times HTTP requests, not the %s transport", c.Transport)
+	}
+	return nil
+}
+
+// sample returns client sending the sampled requests through the breakdown.
+func (b *latencyBreakdown) sample(client sender.Doer) sender.Doer {
+	if b == nil {
+		return client
+	}
+	return breakdownClient{Doer: client, b: b}
+}
+
+// breakdownClient sends the requests of a client, the sampled ones through
+// its latency breakdown.
+type breakdownClient struct {
+	sender.Doer
+	b *latencyBreakdown
+}
+
+func (c breakdownClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	if atomic.AddInt64(&c.b.n, 1)%c.b.every != 0 {
+		return c.Doer.Do(req, res)
+	}
+	return c.b.do(req, res)
+}
+
+func (c breakdownClient) Close() error {
+	sender.CloseClient(c.Doer)
+	return nil
+}
+
+// phaseTimes are the times a traced request went through its phases. The
+// trace hooks of concurrent dials, as for both address families, may run on
+// other goroutines.
+type phaseTimes struct {
+	mu                       sync.Mutex
+	dnsStart, dnsDone        time.Time
+	connectStart, connectEnd time.Time
+	tlsStart, tlsDone        time.Time
+	wrote, firstByte         time.Time
+}
+
+func (t *phaseTimes) set(at *time.Time, first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if first && !at.IsZero() {
+		return
+	}
+	*at = time.Now()
+}
+
+func (t *phaseTimes) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.set(&t.dnsStart, true) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone, false) },
+		ConnectStart:         func(string, string) { t.set(&t.connectStart, true) },
+		ConnectDone:          func(string, string, error) { t.set(&t.connectEnd, false) },
+		TLSHandshakeStart:    func() { t.set(&t.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.set(&t.tlsDone, false) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wrote, false) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte, true) },
+	}
+}
+
+// do sends a sampled request and records its phases.
+func (b *latencyBreakdown) do(req *fasthttp.Request, res *fasthttp.Response) error {
+	var t phaseTimes
+	ctx := httptrace.WithClientTrace(context.Background(), t.trace())
+	start := time.Now()
+	err := b.client.DoContext(ctx, req, res)
+	total := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.samples++
+	if err != nil {
+		b.failed++
+		return err
+	}
+	phase := func(h *hdrhistogram.Histogram, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			report.RecordLatency(h, to.Sub(from))
+		}
+	}
+	phase(b.dns, t.dnsStart, t.dnsDone)
+	phase(b.connect, t.connectStart, t.connectEnd)
+	phase(b.tls, t.tlsStart, t.tlsDone)
+	phase(b.ttfb, t.wrote, t.firstByte)
+	report.RecordLatency(b.total, total)
+	return nil
+}
+
+// report adds the breakdown to a run result and logs it.
+func (b *latencyBreakdown) report(result *report.Result) {
+	if b == nil || result == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := &report.BreakdownStats{
+		Every:   int(b.every),
+		Samples: b.samples,
+		Failed:  b.failed,
+		DNS:     report.SummarizeLatency(b.dns),
+		Connect: report.SummarizeLatency(b.connect),
+		TLS:     report.SummarizeLatency(b.tls),
+		TTFB:    report.SummarizeLatency(b.ttfb),
+		Total:   report.SummarizeLatency(b.total),
+	}
+	result.LatencyBreakdown = stats
+	if stats.Total == nil {
+		log.Infof("Latency Breakdown: no successful samples of %d", stats.Samples)
+		return
+	}
+	var phases []string
+	for _, p := range stats.Phases() {
+		phases = append(phases, fmt.Sprintf("%s %.3f/%.3f", p.Name, p.Stats.P50, p.Stats.P99))
+	}
+	log.Infof("Latency Breakdown (ms, p50/p99) of %d sampled sends: %s", stats.Total.Count, strings.Join(phases, ", "))
+}
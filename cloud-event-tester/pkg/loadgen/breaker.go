@@ -0,0 +1,186 @@
+package loadgen
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// states of the circuit breaker of a run
+const (
+	breakerClosed = "closed"
+	breakerOpen   = "open"
+	// a single probe is in flight
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops a performance run from hammering a dead target: after
+// threshold failed sends in a row it opens and holds all sends for the
+// cool-down, then lets a single probe through. A probe that succeeds closes
+// it, one that fails opens it for another cool-down. A failed send is one
+// that failed or whose response failed an assertion, as the run counts them.
+// Sends already in flight when it opens do not count, and the result that
+// arrives first after a probe was let through decides it. A nil breaker lets
+// every send through.
+type circuitBreaker struct {
+	threshold int64
+	cooldown  time.Duration
+	start     time.Time
+	// failures in a row while closed, and the sends held
+	failures, held int64
+
+	mu    sync.Mutex
+	state string
+	// until is the end of the cool-down, opened when the breaker last
+	// opened
+	until, opened time.Time
+	stats         report.BreakerStats
+	// open tells the send loops the breaker is not closed without taking
+	// the lock
+	open int32
+}
+
+func newCircuitBreaker(cfg *Config) *circuitBreaker {
+	if cfg.BreakerFailures == 0 {
+		return nil
+	}
+	log.Infof("Circuit Breaker: open after %d failed sends in a row, probing every %v", cfg.BreakerFailures, cfg.BreakerCooldown)
+	return &circuitBreaker{
+		threshold: int64(cfg.BreakerFailures),
+		cooldown:  cfg.BreakerCooldown,
+		start:     time.Now(),
+		state:     breakerClosed,
+	}
+}
+
+// validateBreaker checks the circuit breaker settings.
+func (c *Config) validateBreaker() error {
+	switch {
+	case c.BreakerFailures < 0:
+		return fmt.Errorf("breaker failures must not be negative, got %d", c.BreakerFailures)
+	case c.BreakerFailures == 0:
+		return nil
+	case !c.IsPerf():
+		return fmt.Errorf("the circuit breaker holds the sends of performance runs only")
+	case c.BreakerCooldown <= 0:
+		return fmt.Errorf("breaker cool-down must be positive, got %v", c.BreakerCooldown)
+	}
+	return nil
+}
+
+// allow tells whether a send goes ahead: always while the breaker is closed,
+// as a probe once the cool-down is over, and never while a probe is in
+// flight.
+func (b *circuitBreaker) allow() bool {
+	if b == nil || atomic.LoadInt32(&b.open) == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == breakerClosed:
+		return true
+	case b.state == breakerOpen && !time.Now().Before(b.until):
+		b.stats.Probes++
+		b.transition(breakerHalfOpen, "cool-down of %v over, probing", b.cooldown)
+		return true
+	}
+	b.held++
+	return false
+}
+
+// record accounts the result of a send.
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	if atomic.LoadInt32(&b.open) == 0 {
+		if !failed {
+			atomic.StoreInt64(&b.failures, 0)
+			return
+		}
+		if atomic.AddInt64(&b.failures, 1) != b.threshold {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.state == breakerClosed {
+			b.trip("%d failed sends in a row", b.threshold)
+		}
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerHalfOpen {
+		return
+	}
+	if failed {
+		b.trip("probe failed")
+		return
+	}
+	b.stats.OpenSeconds += time.Since(b.opened).Seconds()
+	atomic.StoreInt64(&b.failures, 0)
+	atomic.StoreInt32(&b.open, 0)
+	b.transition(breakerClosed, "probe succeeded")
+}
+
+// trip opens the breaker for a cool-down. The lock must be held.
+func (b *circuitBreaker) trip(format string, args ...interface{}) {
+	now := time.Now()
+	if b.state == breakerClosed {
+		b.stats.Opens++
+		b.opened = now
+	}
+	b.until = now.Add(b.cooldown)
+	atomic.StoreInt32(&b.open, 1)
+	b.transition(breakerOpen, format, args...)
+}
+
+// transition records and logs a change of state. The lock must be held.
+func (b *circuitBreaker) transition(state, format string, args ...interface{}) {
+	b.state = state
+	t := report.BreakerTransition{
+		At:     time.Since(b.start).Seconds(),
+		State:  state,
+		Reason: fmt.Sprintf(format, args...),
+	}
+	b.stats.Transitions = append(b.stats.Transitions, t)
+	if state == breakerOpen {
+		log.Warnf("Circuit Breaker: open, %s, holding the sends for %v", t.Reason, b.cooldown)
+	} else {
+		log.Infof("Circuit Breaker: %s, %s", state, t.Reason)
+	}
+}
+
+// report adds the breaker stats to a run result.
+func (b *circuitBreaker) report(result *report.Result) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	if b.state != breakerClosed {
+		stats.OpenSeconds += time.Since(b.opened).Seconds()
+	}
+	stats.Held = int(b.held)
+	result.Breaker = &stats
+	log.Infof("Circuit Breaker: opened %d times, open for %.1f seconds, %d probes, %d sends held, %s at the end",
+		stats.Opens, stats.OpenSeconds, stats.Probes, stats.Held, b.state)
+}
+
+// breakerChecks returns the check of the circuit breaker of a run, which
+// fails if it opened.
+func breakerChecks(result *report.Result) []report.CheckResult {
+	if result.Breaker == nil {
+		return nil
+	}
+	b := result.Breaker
+	return []report.CheckResult{report.CheckResult{Name: "circuit breaker"}.PassIf(b.Opens == 0,
+		"opened %d times, open for %.1f seconds, %d sends held", b.Opens, b.OpenSeconds, b.Held)}
+}
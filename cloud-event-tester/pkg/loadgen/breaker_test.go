@@ -0,0 +1,99 @@
+package loadgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// TestCircuitBreaker opens the breaker with failed sends in a row, fails a
+// probe after the cool-down, then closes it with one that succeeds.
+func TestCircuitBreaker(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BreakerFailures = 3
+	cfg.BreakerCooldown = 50 * time.Millisecond
+	b := newCircuitBreaker(&cfg)
+
+	// a success resets the failures in a row
+	for _, failed := range []bool{true, true, false, true, true} {
+		if !b.allow() {
+			t.Fatalf("closed breaker held a send")
+		}
+		b.record(failed)
+	}
+	if b.state != breakerClosed {
+		t.Fatalf("breaker %s after 2 failed sends in a row, want closed", b.state)
+	}
+	b.record(true)
+	if b.state != breakerOpen {
+		t.Fatalf("breaker %s after 3 failed sends in a row, want open", b.state)
+	}
+	if b.allow() || b.allow() {
+		t.Errorf("open breaker let a send through in the cool-down")
+	}
+	// sends in flight when it opened do not count
+	b.record(false)
+	if b.state != breakerOpen {
+		t.Errorf("breaker %s after a send in flight succeeded, want open", b.state)
+	}
+
+	time.Sleep(cfg.BreakerCooldown)
+	if !b.allow() {
+		t.Fatalf("breaker held the probe after the cool-down")
+	}
+	if b.state != breakerHalfOpen {
+		t.Fatalf("breaker %s with a probe in flight, want half-open", b.state)
+	}
+	if b.allow() {
+		t.Errorf("half-open breaker let a second send through")
+	}
+	b.record(true)
+	if b.state != breakerOpen || b.allow() {
+		t.Fatalf("breaker %s after the probe failed, want open for another cool-down", b.state)
+	}
+
+	time.Sleep(cfg.BreakerCooldown)
+	if !b.allow() {
+		t.Fatalf("breaker held the second probe")
+	}
+	b.record(false)
+	if b.state != breakerClosed || !b.allow() {
+		t.Fatalf("breaker %s after the probe succeeded, want closed", b.state)
+	}
+
+	var result report.Result
+	b.report(&result)
+	s := result.Breaker
+	if s.Opens != 1 || s.Probes != 2 || s.Held != 4 {
+		t.Errorf("breaker opened %d times, %d probes, %d held, want 1, 2 and 4", s.Opens, s.Probes, s.Held)
+	}
+	var states []string
+	for _, tr := range s.Transitions {
+		states = append(states, tr.State)
+	}
+	want := []string{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}
+	if len(states) != len(want) {
+		t.Fatalf("transitions %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("transitions %v, want %v", states, want)
+			break
+		}
+	}
+	if s.OpenSeconds < 2*cfg.BreakerCooldown.Seconds() {
+		t.Errorf("breaker open for %.3f s, want at least two cool-downs", s.OpenSeconds)
+	}
+}
+
+func TestCircuitBreakerNil(t *testing.T) {
+	cfg := DefaultConfig()
+	b := newCircuitBreaker(&cfg)
+	for i := 0; i < 100; i++ {
+		b.record(true)
+	}
+	if !b.allow() {
+		t.Errorf("a nil breaker held a send")
+	}
+}
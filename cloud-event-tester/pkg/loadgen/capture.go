@@ -0,0 +1,126 @@
+package loadgen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// responseCapture writes the status, headers and body of the failed
+// responses of a run to a directory of its own below the capture directory,
+// a file per response, so a consumer that rejects events can be diagnosed
+// from what it answered. A failed response is one that failed its
+// assertion, or in performance runs that do not check the responses, one
+// with a status other than 2xx. At most max responses are captured, their
+// bodies cut after maxBody bytes; the directory is only created with the
+// first one. It is safe for concurrent use; a nil capture writes nothing.
+type responseCapture struct {
+	parent  string
+	start   time.Time
+	max     int64
+	maxBody int
+
+	// n counts the failed responses
+	n    int64
+	once sync.Once
+	dir  string
+	err  error
+}
+
+func newResponseCapture(cfg *Config) *responseCapture {
+	if cfg.CaptureDir == "" {
+		return nil
+	}
+	log.Infof("Response Capture: up to %d failed responses to %s", cfg.CaptureMax, cfg.CaptureDir)
+	return &responseCapture{
+		parent:  cfg.CaptureDir,
+		start:   time.Now(),
+		max:     int64(cfg.CaptureMax),
+		maxBody: cfg.CaptureMaxBody,
+	}
+}
+
+// validateCapture checks the response capture settings.
+func (c *Config) validateCapture() error {
+	switch {
+	case c.CaptureDir == "":
+		return nil
+	case c.CaptureMax <= 0:
+		return fmt.Errorf("capture max must be positive, got %d", c.CaptureMax)
+	case c.CaptureMaxBody < 0:
+		return fmt.Errorf("capture max body must not be negative, got %d", c.CaptureMaxBody)
+	}
+	return nil
+}
+
+// record captures the failed response res to the request req, sent to
+// target at sent, with the reason it failed.
+func (c *responseCapture) record(sent time.Time, req *fasthttp.Request, res *fasthttp.Response, target, reason string) {
+	if c == nil {
+		return
+	}
+	n := atomic.AddInt64(&c.n, 1)
+	if n > c.max {
+		return
+	}
+	c.once.Do(func() {
+		// the runs sharing the capture directory each get their own
+		if c.err = os.MkdirAll(c.parent, 0o755); c.err == nil {
+			c.dir, c.err = os.MkdirTemp(c.parent, "run-"+c.start.UTC().Format("20060102T150405Z")+"-")
+		}
+		if c.err != nil {
+			log.Errorf("Failed to create the capture directory in %s, not capturing: %v", c.parent, c.err)
+		}
+	})
+	if c.err != nil {
+		return
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s %s %s\n", sent.UTC().Format(time.RFC3339Nano), req.Header.Method(), target)
+	if id := requestEventID(req); id != "" {
+		fmt.Fprintf(&b, "# event %s\n", id)
+	}
+	fmt.Fprintf(&b, "# %s\n", reason)
+	b.Write(res.Header.Header())
+	body := res.Body()
+	if len(body) > c.maxBody {
+		b.Write(body[:c.maxBody])
+		fmt.Fprintf(&b, "\n# body cut after %d of %d bytes\n", c.maxBody, len(body))
+	} else {
+		b.Write(body)
+	}
+	name := filepath.Join(c.dir, fmt.Sprintf("%06d-%d.txt", n, res.StatusCode()))
+	if err := os.WriteFile(name, b.Bytes(), 0o644); err != nil {
+		log.Errorf("Failed to capture response to %s: %v", name, err)
+	}
+}
+
+// report adds the captured responses to a run result and logs them.
+func (c *responseCapture) report(result *report.Result) {
+	if c == nil {
+		return
+	}
+	n := atomic.LoadInt64(&c.n)
+	stats := &report.CaptureStats{Dir: c.dir, Captured: int(n)}
+	if n > c.max {
+		stats.Captured, stats.Skipped = int(c.max), int(n-c.max)
+	}
+	if c.err != nil {
+		stats.Captured = 0
+	}
+	result.Captures = stats
+	if stats.Dir == "" {
+		log.Infof("Response Capture: no failed responses")
+		return
+	}
+	log.Infof("Response Capture: %d failed responses in %s, %d more not captured", stats.Captured, stats.Dir, stats.Skipped)
+}
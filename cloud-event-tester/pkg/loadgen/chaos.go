@@ -0,0 +1,277 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// kinds of network chaos, the values of the chaos field of their log lines
+const (
+	chaosDelay    = "delay"
+	chaosAbort    = "abort"
+	chaosTruncate = "truncate"
+	chaosPause    = "pause"
+)
+
+// chaosMonkey injects network faults on the client side into the sends of a
+// performance run, to see a consumer through them: it delays sends at
+// random, aborts the connection of a send in the middle of its body, sends
+// events cut short and pauses all traffic in windows. Each fault is logged
+// and counted; a nil monkey injects none.
+type chaosMonkey struct {
+	// shares of the sends delayed, aborted and truncated
+	delayShare, abortShare, truncateShare float64
+	maxDelay                              time.Duration
+	// pause windows: pause long, every every from the start of the run,
+	// starting with traffic
+	every, pause time.Duration
+	start        time.Time
+	// the aborted sends open connections of their own, through the proxy
+	// of the run if it has one, else to the address of -resolve
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+	dial        fasthttp.DialFunc
+	resolve     sender.Resolver
+
+	delayed, aborted, truncated, paused int64
+	// pauses counts the pause windows entered, window is the next one
+	pauses, window int64
+}
+
+// newChaosMonkey returns the monkey of the chaos settings of a run, nil if
+// they inject nothing.
+func newChaosMonkey(cfg *Config) (*chaosMonkey, error) {
+	if !cfg.hasChaos() {
+		return nil, nil
+	}
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &chaosMonkey{
+		delayShare:    cfg.ChaosDelayRate / 100,
+		abortShare:    cfg.ChaosAbortRate / 100,
+		truncateShare: cfg.ChaosTruncateRate / 100,
+		maxDelay:      cfg.ChaosDelay,
+		every:         cfg.ChaosPauseEvery,
+		pause:         cfg.ChaosPause,
+		start:         time.Now(),
+		tlsConfig:     tlsConfig,
+		dialTimeout:   cfg.RequestTimeout,
+		dial:          sender.NewProxyDialer(&cfg.Options),
+		resolve:       sender.NewResolver(&cfg.Options),
+	}, nil
+}
+
+func (c *Config) hasChaos() bool {
+	return c.ChaosDelayRate > 0 || c.ChaosAbortRate > 0 || c.ChaosTruncateRate > 0 || c.ChaosPause > 0
+}
+
+// validateChaos checks the chaos settings, and that the run sends from its
+// loop over HTTP.
+func (c *Config) validateChaos() error {
+	for _, rate := range []float64{c.ChaosDelayRate, c.ChaosAbortRate, c.ChaosTruncateRate} {
+		if rate < 0 || rate > 100 {
+			return fmt.Errorf("chaos rates must be percentages between 0 and 100, got %g", rate)
+		}
+	}
+	switch {
+	case c.ChaosAbortRate+c.ChaosTruncateRate > 100:
+		return fmt.Errorf("at most all sends can be aborted or truncated, got %g%% and %g%%", c.ChaosAbortRate, c.ChaosTruncateRate)
+	case c.ChaosDelay < 0 || c.ChaosPause < 0 || c.ChaosPauseEvery < 0:
+		return fmt.Errorf("chaos delays and pauses must not be negative")
+	case (c.ChaosDelayRate > 0) != (c.ChaosDelay > 0):
+		return fmt.Errorf("delayed sends need both a chaos delay rate and a chaos delay")
+	case c.ChaosPause > 0 && c.ChaosPauseEvery <= c.ChaosPause:
+		return fmt.Errorf("pauses need a duration shorter than the time between them, got %v every %v", c.ChaosPause, c.ChaosPauseEvery)
+	case c.ChaosPause == 0 && c.ChaosPauseEvery > 0:
+		return fmt.Errorf("chaos pauses need a duration")
+	case !c.hasChaos():
+		return nil
+	case !c.IsPerf():
+		return fmt.Errorf("network chaos is only injected into performance runs")
+	case strings.ToLower(c.Transport) != sender.TransportHTTP:
+		return fmt.Errorf("network chaos is injected over HTTP, not %s", c.Transport)
+	case strings.ToUpper(c.CheckResp) == "MULTI_THREAD":
+		return fmt.Errorf("network chaos is injected with CHECK_RESP YES or NO, not MULTI_THREAD")
+	}
+	return nil
+}
+
+// log logs the chaos settings of a run.
+func (m *chaosMonkey) log() {
+	if m == nil {
+		return
+	}
+	if m.delayShare > 0 {
+		log.Infof("Chaos: %g%% of sends delayed by up to %v", 100*m.delayShare, m.maxDelay)
+	}
+	if m.abortShare > 0 {
+		log.Infof("Chaos: %g%% of sends aborted in the middle of the body", 100*m.abortShare)
+	}
+	if m.truncateShare > 0 {
+		log.Infof("Chaos: %g%% of sends truncated", 100*m.truncateShare)
+	}
+	if m.pause > 0 {
+		log.Infof("Chaos: traffic paused for %v every %v", m.pause, m.every)
+	}
+}
+
+// pausing tells whether the run is in a pause window, counting the send it
+// does not send. The first send of a window logs it.
+func (m *chaosMonkey) pausing() bool {
+	if m == nil || m.pause == 0 {
+		return false
+	}
+	elapsed := time.Since(m.start)
+	if elapsed%m.every < m.every-m.pause {
+		return false
+	}
+	atomic.AddInt64(&m.paused, 1)
+	window := int64(elapsed / m.every)
+	if next := atomic.LoadInt64(&m.window); next <= window && atomic.CompareAndSwapInt64(&m.window, next, window+1) {
+		atomic.AddInt64(&m.pauses, 1)
+		log.WithField("chaos", chaosPause).Infof("Chaos: pausing traffic for %v", m.pause)
+	}
+	return true
+}
+
+// delay holds up a send by a random delay up to the chaos delay, with the
+// chaos delay rate. It returns early if stop is closed.
+func (m *chaosMonkey) delay(rng *rand.Rand, target string, stop <-chan struct{}) {
+	if m == nil || m.delayShare == 0 || rng.Float64() >= m.delayShare {
+		return
+	}
+	d := time.Duration(rng.Int63n(int64(m.maxDelay)) + 1)
+	atomic.AddInt64(&m.delayed, 1)
+	log.WithFields(log.Fields{"chaos": chaosDelay, "target": target}).Infof("Chaos: delaying a send by %v", d)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-stop:
+	}
+}
+
+// pick returns the fault of the next send, abort or truncate, or "" to send
+// it as is.
+func (m *chaosMonkey) pick(rng *rand.Rand) string {
+	if m == nil || m.abortShare+m.truncateShare == 0 {
+		return ""
+	}
+	switch r := rng.Float64(); {
+	case r < m.abortShare:
+		return chaosAbort
+	case r < m.abortShare+m.truncateShare:
+		return chaosTruncate
+	}
+	return ""
+}
+
+// abort writes the request of req on a connection of its own to target, up
+// to the middle of its body, and closes the connection.
+func (m *chaosMonkey) abort(req *fasthttp.Request, target string) {
+	atomic.AddInt64(&m.aborted, 1)
+	err := m.writeHalf(req, target)
+	fields := log.Fields{"chaos": chaosAbort, "target": target}
+	if err != nil {
+		log.WithFields(fields).Infof("Chaos: aborting a send failed to connect: %v", err)
+		return
+	}
+	log.WithFields(fields).Infof("Chaos: aborted a send in the middle of its body")
+}
+
+func (m *chaosMonkey) writeHalf(req *fasthttp.Request, target string) error {
+	u, err := url.Parse(sender.TargetURI(target))
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	var conn net.Conn
+	if socket, ok := sender.UnixSocket(host); ok {
+		conn, err = (&net.Dialer{Timeout: m.dialTimeout}).Dial("unix", socket)
+	} else if m.dial != nil {
+		conn, err = m.dial(host)
+	} else {
+		conn, err = (&net.Dialer{Timeout: m.dialTimeout}).Dial("tcp", m.resolve.Addr(host))
+	}
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "https" {
+		config := m.tlsConfig.Clone()
+		if config == nil {
+			config = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, config)
+	}
+	defer conn.Close()
+	var raw bytes.Buffer
+	w := bufio.NewWriter(&raw)
+	if err := req.Write(w); err != nil {
+		return err
+	}
+	w.Flush() //nolint: errcheck
+	data := raw.Bytes()
+	end := bytes.Index(data, []byte("\r\n\r\n")) + 4
+	half := end + (len(data)-end)/2
+	if m.dialTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(m.dialTimeout)) //nolint: errcheck
+	}
+	_, err = conn.Write(data[:half])
+	return err
+}
+
+// truncate cuts the body of req, a copy of the request of an event, to a
+// random length short of the whole event, and counts it.
+func (m *chaosMonkey) truncate(rng *rand.Rand, req *fasthttp.Request, target string) {
+	atomic.AddInt64(&m.truncated, 1)
+	body := req.Body()
+	n := 0
+	if len(body) > 1 {
+		n = 1 + rng.Intn(len(body)-1)
+	}
+	req.SetBody(bytes.Clone(body[:n]))
+	log.WithFields(log.Fields{"chaos": chaosTruncate, "target": target}).Infof("Chaos: sending %d of %d bytes of an event", n, len(body))
+}
+
+// report adds the faults injected to a run result and logs them.
+func (m *chaosMonkey) report(result *report.Result) {
+	if m == nil {
+		return
+	}
+	result.Chaos = &report.ChaosStats{
+		Delayed:   int(atomic.LoadInt64(&m.delayed)),
+		Aborted:   int(atomic.LoadInt64(&m.aborted)),
+		Truncated: int(atomic.LoadInt64(&m.truncated)),
+		Pauses:    int(atomic.LoadInt64(&m.pauses)),
+		Paused:    int(atomic.LoadInt64(&m.paused)),
+	}
+	c := result.Chaos
+	log.Infof("Chaos: %d sends delayed, %d aborted, %d truncated, %d pauses with %d sends not sent",
+		c.Delayed, c.Aborted, c.Truncated, c.Pauses, c.Paused)
+}
@@ -0,0 +1,114 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+const checkpointVersion = 1
+
+// checkpoint is the persisted state of a performance run, written
+// periodically so an interrupted run can be resumed with -resume.
+type checkpoint struct {
+	Version        int       `json:"version"`
+	Config         Config    `json:"config"`
+	StartTime      time.Time `json:"startTime"`
+	Updated        time.Time `json:"updated"`
+	ElapsedSeconds float64   `json:"elapsedSeconds"`
+	TotalMsg       int       `json:"totalMsg"`
+	Segments       int       `json:"segments"`
+	Completed      bool      `json:"completed"`
+	// the counters and latencies of the segments so far, which the next
+	// segment of a resumed run continues from
+	Connections       int                    `json:"connections,omitempty"`
+	Errors            map[string]int         `json:"errors,omitempty"`
+	AssertionFailures map[string]int         `json:"assertionFailures,omitempty"`
+	StatusCodes       map[string]int         `json:"statusCodes,omitempty"`
+	Latency           *hdrhistogram.Snapshot `json:"latency,omitempty"`
+	// the run ID and the last sequence number of numbered events, which
+	// the events of the next segment continue from
+	RunID        string `json:"runId,omitempty"`
+	LastSequence int64  `json:"lastSequence,omitempty"`
+}
+
+// saveCheckpoint writes the checkpoint atomically, so a crash while writing
+// never leaves a truncated file behind.
+func saveCheckpoint(path string, cp *checkpoint) error {
+	cp.Version = checkpointVersion
+	cp.Updated = time.Now()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCheckpoint reads a checkpoint, returning nil without error if the file
+// does not exist so the first run and resumed runs can share a command line.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d in %s", cp.Version, path)
+	}
+	return cp, nil
+}
+
+// compatible reports whether a checkpoint was written by a run with the same
+// target and load settings as cfg.
+func (cp *checkpoint) compatible(cfg *Config) error {
+	c := cp.Config
+	if c.URL != cfg.URL || c.Rate != cfg.Rate || c.Period() != cfg.Period() || c.Duration != cfg.Duration || c.EventFile != cfg.EventFile {
+		return fmt.Errorf("checkpoint was written for a different run (url=%s rate=%s duration=%g event-file=%s)",
+			c.URL, FormatRate(c.Rate, c.RatePeriod), c.Duration, c.EventFile)
+	}
+	return nil
+}
+
+// newCheckpointLatency returns the histogram of the latencies of a run with
+// a checkpoint file, starting with those of the earlier segments if cp is
+// the checkpoint it resumes; nil without a checkpoint file. Unlike those of
+// the shards it can be saved while they record.
+func newCheckpointLatency(cfg *Config, cp *checkpoint) *report.SharedHistogram {
+	if cfg.CheckpointFile == "" {
+		return nil
+	}
+	h := report.NewLatencyHistogram()
+	if cp != nil && cp.Latency != nil {
+		h.Merge(hdrhistogram.Import(cp.Latency))
+	}
+	return report.NewSharedHistogram(h)
+}
@@ -0,0 +1,55 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+func TestCheckpointSequence(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Sequence = true
+	event := []byte(`{"specversion":"1.0","id":"1","source":"test","type":"test"}`)
+	stamper, err := newEventStamper(&cfg, nil, nil, event, nil)
+	if err != nil {
+		t.Fatalf("newEventStamper failed: %v", err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if err := stamper.Render(&buf); err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	cp := &checkpoint{Config: cfg}
+	stamper.save(cp)
+	if err := saveCheckpoint(path, cp); err != nil {
+		t.Fatalf("saveCheckpoint failed: %v", err)
+	}
+	cp, err = loadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint failed: %v", err)
+	}
+	if cp.RunID != stamper.runID || cp.LastSequence != 3 {
+		t.Fatalf("checkpoint has run %q up to %d, want run %q up to 3", cp.RunID, cp.LastSequence, stamper.runID)
+	}
+
+	// the resumed run continues the numbers of the saved one
+	resumed, err := newEventStamper(&cfg, nil, nil, event, cp)
+	if err != nil {
+		t.Fatalf("newEventStamper failed: %v", err)
+	}
+	if err := resumed.Render(&buf); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	var stamped map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &stamped); err != nil {
+		t.Fatalf("stamped event is not JSON: %v", err)
+	}
+	if stamped[events.SequenceRunAttr] != stamper.runID || stamped[events.SequenceSeqAttr] != float64(4) {
+		t.Errorf("resumed event is %s, want run %s and number 4", buf.Bytes(), stamper.runID)
+	}
+}
@@ -0,0 +1,196 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// clockProbes is the number of round trips of a clock sync; the offset is
+// taken from the fastest.
+const clockProbes = 8
+
+// ClockTime is the answer of a receiver to GET /clock.
+type ClockTime struct {
+	UnixNano int64 `json:"unixNano"`
+}
+
+// clockSync estimates the offset of the clock of the receiver of a run with
+// -send-time from the clock of the sender, so the send times stamped on the
+// events are on the clock of the receiver and the one-way delivery latency
+// it measures is not skewed by the clocks of two hosts. Like NTP it asks
+// the receiver for its time and takes the offset from the fastest of a few
+// round trips, assuming the answer was sent halfway; every
+// -clock-sync-interval it estimates again to follow the drift of the
+// clocks. A nil sync has no offset.
+type clockSync struct {
+	url    string
+	client *http.Client
+	// offset and bound are nanoseconds
+	offset, bound int64
+
+	mu     sync.Mutex
+	syncs  int64
+	failed int64
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newClockSync estimates the clock offset of the receiver at -clock-sync-url
+// before a run and keeps it up to date while it runs, if configured. It
+// fails if the receiver cannot be asked for its time.
+func newClockSync(ctx context.Context, cfg *Config) (*clockSync, error) {
+	if cfg.ClockSyncURL == "" {
+		return nil, nil
+	}
+	c := &clockSync{
+		url:    cfg.ClockSyncURL,
+		client: &http.Client{Timeout: 2 * time.Second},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := c.sync(ctx); err != nil {
+		return nil, fmt.Errorf("failed to sync the clock with %s: %w", c.url, err)
+	}
+	log.Infof("Clock Sync: the clock of %s is %s from ours, within %s", c.url, c.offsetValue(), time.Duration(atomic.LoadInt64(&c.bound)))
+	go c.run(cfg.ClockSyncInterval)
+	return c, nil
+}
+
+// validateClockSync checks the clock sync settings.
+func (c *Config) validateClockSync() error {
+	switch {
+	case c.ClockSyncInterval < 0:
+		return fmt.Errorf("clock-sync-interval must not be negative, got %v", c.ClockSyncInterval)
+	case c.ClockSyncURL == "":
+		return nil
+	case !c.SendTime:
+		return fmt.Errorf("clock-sync-url corrects the send times of -send-time, which is not set")
+	case !strings.HasPrefix(c.ClockSyncURL, "http://") && !strings.HasPrefix(c.ClockSyncURL, "https://"):
+		return fmt.Errorf("clock-sync-url must be an http:// or https:// URL, got %q", c.ClockSyncURL)
+	}
+	return nil
+}
+
+// run estimates the offset again every interval until the sync is closed.
+func (c *clockSync) run(interval time.Duration) {
+	defer close(c.done)
+	if interval == 0 {
+		<-c.stop
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := c.sync(context.Background()); err != nil {
+			log.Warnf("Clock Sync: failed to sync with %s, keeping an offset of %s: %v", c.url, c.offsetValue(), err)
+			continue
+		}
+		log.Debugf("Clock Sync: offset %s, within %s", c.offsetValue(), time.Duration(atomic.LoadInt64(&c.bound)))
+	}
+}
+
+// sync estimates the offset from the fastest of a few round trips.
+func (c *clockSync) sync(ctx context.Context) error {
+	best := time.Duration(-1)
+	var offset time.Duration
+	var lastErr error
+	for i := 0; i < clockProbes; i++ {
+		o, rtt, err := c.probe(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if best < 0 || rtt < best {
+			best, offset = rtt, o
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if best < 0 {
+		c.failed++
+		return lastErr
+	}
+	c.syncs++
+	atomic.StoreInt64(&c.offset, int64(offset))
+	atomic.StoreInt64(&c.bound, int64(best/2))
+	return nil
+}
+
+// probe asks the receiver for its time and returns the offset of its clock,
+// assuming it answered halfway through the round trip, and the round trip.
+func (c *clockSync) probe(ctx context.Context) (time.Duration, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer sender.DrainBody(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	rtt := time.Since(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%s", resp.Status)
+	}
+	var t ClockTime
+	if err := json.Unmarshal(body, &t); err != nil || t.UnixNano == 0 {
+		return 0, 0, fmt.Errorf("no time in the response: %s", body)
+	}
+	return time.Unix(0, t.UnixNano).Sub(start.Add(rtt / 2)), rtt, nil
+}
+
+// offsetValue returns the offset of the clock of the receiver, 0 for a nil
+// sync.
+func (c *clockSync) offsetValue() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&c.offset))
+}
+
+// close stops estimating the offset.
+func (c *clockSync) close() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+}
+
+// report adds the clock sync to a run result.
+func (c *clockSync) report(result *report.Result) {
+	if c == nil || result == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result.ClockSync = &report.ClockSyncStats{
+		URL:      c.url,
+		OffsetMs: float64(atomic.LoadInt64(&c.offset)) / float64(time.Millisecond),
+		ErrorMs:  float64(atomic.LoadInt64(&c.bound)) / float64(time.Millisecond),
+		Syncs:    c.syncs,
+		Failed:   c.failed,
+	}
+}
@@ -0,0 +1,36 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func TestRedactedConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Headers = map[string]string{
+		"Authorization": "Bearer s3cret",
+		"X-Api-Key":     "s3cret",
+		"X-Tenant":      "blue",
+	}
+	data, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("redacted settings %s carry a credential", data)
+	}
+	var decoded Config
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if decoded.Headers["X-Tenant"] != "blue" || decoded.Headers["Authorization"] != sender.RedactedValue {
+		t.Errorf("redacted headers are %v", decoded.Headers)
+	}
+	// the run itself still sends the credentials
+	if cfg.Headers["Authorization"] != "Bearer s3cret" {
+		t.Errorf("redacted changed the headers of the run to %v", cfg.Headers)
+	}
+}
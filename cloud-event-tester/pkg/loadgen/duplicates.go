@@ -0,0 +1,306 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// duplicateCheckRunID in -duplicate-check-url is replaced by the run ID of
+// the -sequence numbers of the run.
+const duplicateCheckRunID = "{runId}"
+
+// interleaveWindow is the number of events whose copies an interleaving
+// duplicator holds back to send between the following events.
+const interleaveWindow = 8
+
+// duplicator sends every event of a run -duplicates more times, as a
+// producer with at-least-once delivery does when it redelivers an event it
+// does not know was received, to test the idempotency of the consumer. The
+// copies are exact copies of the request of the event, with its ID and
+// -sequence number, sent on their own after the event, each after a random
+// delay up to -duplicate-jitter, and with -duplicate-interleave between the
+// following events in random order. Their responses are counted apart and
+// do not count as sends of the run. After the run -duplicate-check-url, the
+// query endpoint of the consumer or of a receiver behind it, is asked how
+// many events were processed. It is safe for concurrent use; a nil
+// duplicator sends no copies.
+type duplicator struct {
+	copies     int
+	jitter     time.Duration
+	interleave bool
+	checkURL   string
+	checkPath  []events.PathStep
+	checkWait  time.Duration
+
+	events, unique, sent, accepted, rejected, failed int64
+}
+
+func newDuplicator(cfg *Config) *duplicator {
+	if cfg.Duplicates == 0 {
+		return nil
+	}
+	d := &duplicator{
+		copies:     cfg.Duplicates,
+		jitter:     cfg.DuplicateJitter,
+		interleave: cfg.DuplicateInterleave,
+		checkURL:   cfg.DuplicateCheckURL,
+		checkWait:  cfg.DuplicateCheckWait,
+	}
+	// checked by validateDuplicates
+	d.checkPath, _ = events.ParseJSONPath(cfg.DuplicateCheckPath)
+	how := "right after it"
+	if d.interleave {
+		how = "between the following events"
+	}
+	if d.jitter > 0 {
+		how += fmt.Sprintf(", up to %s later", d.jitter)
+	}
+	log.Infof("Duplicates: %d copies of every event, sent %s", d.copies, how)
+	return d
+}
+
+// validateDuplicates checks the duplicate delivery settings.
+func (c *Config) validateDuplicates() error {
+	switch {
+	case c.Duplicates < 0:
+		return fmt.Errorf("duplicates must not be negative, got %d", c.Duplicates)
+	case c.DuplicateJitter < 0 || c.DuplicateCheckWait < 0:
+		return fmt.Errorf("duplicate-jitter and duplicate-check-wait must not be negative")
+	case c.Duplicates == 0:
+		if c.DuplicateJitter > 0 || c.DuplicateInterleave || c.DuplicateCheckURL != "" {
+			return fmt.Errorf("the duplicate delivery options need -duplicates")
+		}
+		return nil
+	case c.Transport != "" && strings.ToLower(c.Transport) != sender.TransportHTTP:
+		return fmt.Errorf("duplicates are sent over HTTP, not the %s transport", c.Transport)
+	case c.StreamBodies:
+		return fmt.Errorf("duplicates cannot copy the streamed bodies of -stream-bodies")
+	}
+	if c.DuplicateCheckURL == "" {
+		return nil
+	}
+	if strings.Contains(c.DuplicateCheckURL, duplicateCheckRunID) && (!c.Sequence || c.Publishers > 0) {
+		return fmt.Errorf("%s in duplicate-check-url needs the run ID of -sequence, which publishers do not share", duplicateCheckRunID)
+	}
+	if _, err := events.ParseJSONPath(c.DuplicateCheckPath); err != nil {
+		return fmt.Errorf("invalid duplicate-check-path: %w", err)
+	}
+	return nil
+}
+
+// resend returns client sending the copies of the requests it sends.
+func (d *duplicator) resend(client sender.Doer) sender.Doer {
+	if d == nil {
+		return client
+	}
+	return &duplicatingClient{Doer: client, d: d}
+}
+
+// duplicateGroup is an event and its copies, the event is unique once one
+// of them was accepted.
+type duplicateGroup struct {
+	accepted int32
+}
+
+// pendingCopy is a copy an interleaving client holds back.
+type pendingCopy struct {
+	req   *fasthttp.Request
+	group *duplicateGroup
+}
+
+// duplicatingClient sends the copies of the requests of a client. Close
+// sends the copies it holds back and waits for all of them.
+type duplicatingClient struct {
+	sender.Doer
+	d *duplicator
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	pending []pendingCopy
+}
+
+func (c *duplicatingClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	err := c.Doer.Do(req, res)
+	g := &duplicateGroup{}
+	atomic.AddInt64(&c.d.events, 1)
+	c.d.settle(g, err, res, false)
+	copies := make([]pendingCopy, c.d.copies)
+	for i := range copies {
+		copies[i] = pendingCopy{req: fasthttp.AcquireRequest(), group: g}
+		req.CopyTo(copies[i].req)
+	}
+	if c.d.interleave {
+		c.mu.Lock()
+		c.pending = append(c.pending, copies...)
+		copies = copies[:0]
+		for len(c.pending) > c.d.copies*interleaveWindow {
+			copies = append(copies, c.take(rand.Intn(len(c.pending))))
+		}
+		c.mu.Unlock()
+	}
+	for _, p := range copies {
+		c.send(p)
+	}
+	return err
+}
+
+// take removes the i-th pending copy; the caller holds c.mu.
+func (c *duplicatingClient) take(i int) pendingCopy {
+	p := c.pending[i]
+	last := len(c.pending) - 1
+	c.pending[i] = c.pending[last]
+	c.pending = c.pending[:last]
+	return p
+}
+
+// send sends a copy on its own, after the jitter.
+func (c *duplicatingClient) send(p pendingCopy) {
+	c.wg.Add(1)
+	var delay time.Duration
+	if c.d.jitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(c.d.jitter)))
+	}
+	time.AfterFunc(delay, func() {
+		defer c.wg.Done()
+		res := fasthttp.AcquireResponse()
+		err := c.Doer.Do(p.req, res)
+		atomic.AddInt64(&c.d.sent, 1)
+		c.d.settle(p.group, err, res, true)
+		fasthttp.ReleaseRequest(p.req)
+		fasthttp.ReleaseResponse(res)
+	})
+}
+
+func (c *duplicatingClient) Close() error {
+	c.mu.Lock()
+	for len(c.pending) > 0 {
+		c.send(c.take(rand.Intn(len(c.pending))))
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	sender.CloseClient(c.Doer)
+	return nil
+}
+
+// settle counts the response to the event or a copy of group.
+func (d *duplicator) settle(g *duplicateGroup, err error, res *fasthttp.Response, isCopy bool) {
+	ok := err == nil && res.StatusCode() >= 200 && res.StatusCode() < 300
+	if ok && atomic.CompareAndSwapInt32(&g.accepted, 0, 1) {
+		atomic.AddInt64(&d.unique, 1)
+	}
+	if !isCopy {
+		return
+	}
+	switch {
+	case err != nil:
+		atomic.AddInt64(&d.failed, 1)
+	case ok:
+		atomic.AddInt64(&d.accepted, 1)
+	default:
+		atomic.AddInt64(&d.rejected, 1)
+	}
+}
+
+// report adds the copies of the run to a run result and logs them. With a
+// check URL it waits for the consumer to settle, asks it how many events it
+// processed and adds a check that it processed every unique event once.
+func (d *duplicator) report(result *report.Result) {
+	if d == nil || result == nil {
+		return
+	}
+	stats := &report.DuplicateStats{
+		Copies:   d.copies,
+		Events:   atomic.LoadInt64(&d.events),
+		Unique:   atomic.LoadInt64(&d.unique),
+		Sent:     atomic.LoadInt64(&d.sent),
+		Accepted: atomic.LoadInt64(&d.accepted),
+		Rejected: atomic.LoadInt64(&d.rejected),
+		Failed:   atomic.LoadInt64(&d.failed),
+	}
+	result.Duplicates = stats
+	log.Infof("Duplicates: %d events, %d accepted at least once, %d copies sent: %d accepted, %d rejected, %d failed",
+		stats.Events, stats.Unique, stats.Sent, stats.Accepted, stats.Rejected, stats.Failed)
+	if d.checkURL == "" {
+		return
+	}
+	check := report.CheckResult{Name: "duplicates collapsed"}
+	url := d.checkURL
+	if strings.Contains(url, duplicateCheckRunID) {
+		if result.Sequence == nil {
+			result.Checks = append(result.Checks, check.Fail("the run has no sequence run ID to query"))
+			return
+		}
+		url = strings.ReplaceAll(url, duplicateCheckRunID, result.Sequence.RunID)
+	}
+	time.Sleep(d.checkWait)
+	processed, err := d.query(url)
+	if err != nil {
+		log.Errorf("Duplicates: failed to query %s: %v", url, err)
+		result.Checks = append(result.Checks, check.Fail("failed to query %s: %v", url, err))
+		return
+	}
+	stats.Processed = &processed
+	check = check.PassIf(processed == stats.Unique, "%d events processed of %d accepted, sent %d times each",
+		processed, stats.Unique, d.copies+1)
+	switch {
+	case processed > stats.Unique:
+		log.Errorf("Duplicates: the consumer processed %d events of %d, %d duplicates were not collapsed", processed, stats.Unique, processed-stats.Unique)
+	case processed < stats.Unique:
+		log.Errorf("Duplicates: the consumer processed %d events of %d, %d are missing", processed, stats.Unique, stats.Unique-processed)
+	default:
+		log.Infof("Duplicates: the consumer processed all %d events once, the duplicates were collapsed", processed)
+	}
+	result.Checks = append(result.Checks, check)
+}
+
+// query gets the count of processed events at the check path of the JSON
+// document at url.
+func (d *duplicator) query(url string) (int64, error) {
+	hc := http.Client{Timeout: 10 * time.Second}
+	resp, err := hc.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer sender.DrainBody(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return 0, fmt.Errorf("malformed response: %w", err)
+	}
+	var value interface{}
+	var found bool
+	events.MutatePath(doc, d.checkPath, func(v interface{}, ok bool) (interface{}, bool, bool) {
+		if ok && !found {
+			value, found = v, true
+		}
+		return v, true, false
+	})
+	n, isNumber := value.(json.Number)
+	if !isNumber {
+		return 0, fmt.Errorf("no count of processed events at the check path")
+	}
+	return n.Int64()
+}
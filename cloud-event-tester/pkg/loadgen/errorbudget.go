@@ -0,0 +1,162 @@
+package loadgen
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// errorBudget stops a performance run early once its failed sends exceed
+// -max-errors in total or -max-consecutive-errors in a row, instead of
+// spending the rest of the duration on a broken target. A failed send is
+// one that failed or whose response failed an assertion, as the run and the
+// circuit breaker count them; the sends held by an open breaker are not
+// sent and so do not count. A resumed run starts with the failures of its
+// checkpoint. A nil budget never stops the run.
+type errorBudget struct {
+	maxErrors, maxStreak int64
+	start                time.Time
+	// stop ends the run, once
+	stop     func()
+	stopOnce sync.Once
+	// failed in total and in a row
+	failed, streak int64
+
+	mu    sync.Mutex
+	stats report.ErrorBudgetStats
+}
+
+func newErrorBudget(cfg *Config, cp *checkpoint, stop func()) *errorBudget {
+	if cfg.MaxErrors == 0 && cfg.MaxConsecutiveErrors == 0 {
+		return nil
+	}
+	b := &errorBudget{
+		maxErrors: int64(cfg.MaxErrors),
+		maxStreak: int64(cfg.MaxConsecutiveErrors),
+		start:     time.Now(),
+		stop:      stop,
+		stats:     report.ErrorBudgetStats{MaxErrors: cfg.MaxErrors, MaxConsecutiveErrors: cfg.MaxConsecutiveErrors},
+	}
+	if cp != nil {
+		for _, n := range cp.Errors {
+			b.failed += int64(n)
+		}
+		for _, n := range cp.AssertionFailures {
+			b.failed += int64(n)
+		}
+	}
+	log.Infof("Error Budget: %s", b.text())
+	return b
+}
+
+// text describes the thresholds of the budget.
+func (b *errorBudget) text() string {
+	switch {
+	case b.maxErrors > 0 && b.maxStreak > 0:
+		return fmt.Sprintf("stop after more than %d failed sends, or %d in a row", b.maxErrors, b.maxStreak)
+	case b.maxErrors > 0:
+		return fmt.Sprintf("stop after more than %d failed sends", b.maxErrors)
+	}
+	return fmt.Sprintf("stop after more than %d failed sends in a row", b.maxStreak)
+}
+
+// validateErrorBudget checks the error budget settings.
+func (c *Config) validateErrorBudget() error {
+	switch {
+	case c.MaxErrors < 0 || c.MaxConsecutiveErrors < 0:
+		return fmt.Errorf("max-errors and max-consecutive-errors must not be negative")
+	case c.MaxErrors == 0 && c.MaxConsecutiveErrors == 0:
+		return nil
+	case !c.IsPerf():
+		return fmt.Errorf("the error budget stops performance runs only")
+	}
+	return nil
+}
+
+// record accounts the result of a send; reason describes a failure.
+func (b *errorBudget) record(failed bool, reason string) {
+	if b == nil {
+		return
+	}
+	if !failed {
+		atomic.StoreInt64(&b.streak, 0)
+		return
+	}
+	n, streak := atomic.AddInt64(&b.failed, 1), atomic.AddInt64(&b.streak, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if int(streak) > b.stats.LongestStreak {
+		b.stats.LongestStreak = int(streak)
+	}
+	if b.stats.Reason != "" {
+		return
+	}
+	switch {
+	case b.maxErrors > 0 && n > b.maxErrors:
+		b.exhaust(reason, "%d failed sends, more than the %d allowed", n, b.maxErrors)
+	case b.maxStreak > 0 && streak > b.maxStreak:
+		b.exhaust(reason, "%d failed sends in a row, more than the %d allowed", streak, b.maxStreak)
+	}
+}
+
+// exhaust stops the run. The lock must be held.
+func (b *errorBudget) exhaust(last, format string, args ...interface{}) {
+	b.stats.Reason = fmt.Sprintf(format, args...)
+	b.stats.At = time.Since(b.start).Seconds()
+	b.stats.LastFailure = last
+	log.Errorf("Error budget exhausted %.1f seconds into the run: %s, the last failure: %s. Stopping the run",
+		b.stats.At, b.stats.Reason, last)
+	b.stopOnce.Do(b.stop)
+}
+
+// exhausted reports whether the budget stopped the run.
+func (b *errorBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats.Reason != ""
+}
+
+// report adds the budget stats to a run result.
+func (b *errorBudget) report(result *report.Result) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Failed = int(atomic.LoadInt64(&b.failed))
+	result.ErrorBudget = &stats
+	if stats.Reason != "" {
+		log.Errorf("Error Budget: stopped the run at %.1f seconds, %d failed sends, at most %d in a row",
+			stats.At, stats.Failed, stats.LongestStreak)
+	} else {
+		log.Infof("Error Budget: %d failed sends, at most %d in a row", stats.Failed, stats.LongestStreak)
+	}
+}
+
+// errorBudgetChecks returns the SLA checks of the error budget of a run,
+// the run fails them when the budget stopped it.
+func errorBudgetChecks(result *report.Result) []report.CheckResult {
+	b := result.ErrorBudget
+	if b == nil {
+		return nil
+	}
+	var checks []report.CheckResult
+	if b.MaxErrors > 0 {
+		checks = append(checks, report.CheckResult{Name: "max-errors", SLA: true}.PassIf(b.Failed <= b.MaxErrors,
+			"%d failed sends, at most %d allowed", b.Failed, b.MaxErrors))
+	}
+	if b.MaxConsecutiveErrors > 0 {
+		checks = append(checks, report.CheckResult{Name: "max-consecutive-errors", SLA: true}.PassIf(b.LongestStreak <= b.MaxConsecutiveErrors,
+			"%d failed sends in a row, at most %d allowed", b.LongestStreak, b.MaxConsecutiveErrors))
+	}
+	return checks
+}
@@ -0,0 +1,87 @@
+package loadgen
+
+import (
+	"testing"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+func TestErrorBudget(t *testing.T) {
+	tests := []struct {
+		name              string
+		maxErrors, streak int
+		// sends are the results of the sends, true for a failed one
+		sends []bool
+		// stopAt is the send the budget stops the run at, 0 if it does not
+		stopAt int
+	}{
+		{name: "within budget", maxErrors: 2, sends: []bool{true, false, true, false}},
+		{name: "total exhausted", maxErrors: 2, sends: []bool{true, false, true, false, true, true}, stopAt: 5},
+		{name: "streak reset", streak: 2, sends: []bool{true, true, false, true, true, false}},
+		{name: "streak exhausted", streak: 2, sends: []bool{true, false, true, true, true, true}, stopAt: 5},
+		{name: "first threshold", maxErrors: 3, streak: 2, sends: []bool{true, true, true}, stopAt: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxErrors, cfg.MaxConsecutiveErrors = tt.maxErrors, tt.streak
+			stops := 0
+			b := newErrorBudget(&cfg, nil, func() { stops++ })
+			stoppedAt := 0
+			for i, failed := range tt.sends {
+				b.record(failed, "status 500")
+				if stoppedAt == 0 && b.exhausted() {
+					stoppedAt = i + 1
+				}
+			}
+			if stoppedAt != tt.stopAt {
+				t.Errorf("budget stopped the run at send %d, want %d", stoppedAt, tt.stopAt)
+			}
+			want := 0
+			if tt.stopAt > 0 {
+				want = 1
+			}
+			if stops != want {
+				t.Errorf("run stopped %d times, want %d", stops, want)
+			}
+			var result report.Result
+			b.report(&result)
+			failed := 0
+			for _, f := range tt.sends {
+				if f {
+					failed++
+				}
+			}
+			if result.ErrorBudget.Failed != failed {
+				t.Errorf("budget counted %d failed sends, want %d", result.ErrorBudget.Failed, failed)
+			}
+			if tt.stopAt > 0 && result.ErrorBudget.LastFailure != "status 500" {
+				t.Errorf("last failure = %q, want the one that exhausted the budget", result.ErrorBudget.LastFailure)
+			}
+		})
+	}
+}
+
+// TestErrorBudgetResumed checks a resumed run starts with the failures of
+// its checkpoint.
+func TestErrorBudgetResumed(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxErrors = 3
+	stopped := false
+	b := newErrorBudget(&cfg, &checkpoint{Errors: map[string]int{"timeout": 2}, AssertionFailures: map[string]int{"status": 1}}, func() { stopped = true })
+	b.record(true, "timeout")
+	if !stopped {
+		t.Errorf("the fourth failure of a resumed run did not exhaust a budget of 3")
+	}
+}
+
+func TestErrorBudgetNil(t *testing.T) {
+	cfg := DefaultConfig()
+	b := newErrorBudget(&cfg, nil, func() { t.Errorf("a run without a budget was stopped") })
+	for i := 0; i < 100; i++ {
+		b.record(true, "timeout")
+	}
+	if b.exhausted() {
+		t.Errorf("a nil budget is exhausted")
+	}
+}
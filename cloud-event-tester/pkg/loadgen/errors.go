@@ -0,0 +1,212 @@
+package loadgen
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// kinds of send errors in the error breakdown of a run
+const (
+	errTimeout     = "timeout"
+	errRefused     = "connection refused"
+	errReset       = "connection reset"
+	errNoFreeConns = "no free connections"
+	errDNS         = "dns"
+	errTLS         = "tls"
+	errOther       = "other"
+)
+
+// sendErrors counts the failed sends of a performance run by kind, and apart
+// from them the responses that failed an assertion. The shards and workers
+// record concurrently; the count of the current second is kept apart for the
+// timeline. It also counts every response by status code, for the breakdown
+// of statusCounts.
+type sendErrors struct {
+	mu         sync.Mutex
+	counts     map[string]int
+	assertions map[string]int
+	tick       int64
+	// statuses are counted without the lock, as every response is
+	statuses [600]int64
+	// assert tells the status codes that count as success in the breakdown
+	assert *responseAssertion
+}
+
+func newSendErrors(assert *responseAssertion) *sendErrors {
+	return &sendErrors{counts: map[string]int{}, assertions: map[string]int{}, assert: assert}
+}
+
+// record counts a failed send.
+func (e *sendErrors) record(err error) {
+	if e == nil {
+		return
+	}
+	kind := errorKind(err)
+	atomic.AddInt64(&e.tick, 1)
+	e.mu.Lock()
+	e.counts[kind]++
+	e.mu.Unlock()
+}
+
+// recordAssertion counts a response that failed an assertion. The first
+// failure of each kind is logged with its reason.
+func (e *sendErrors) recordAssertion(kind, reason string) {
+	if e == nil {
+		return
+	}
+	atomic.AddInt64(&e.tick, 1)
+	e.mu.Lock()
+	e.assertions[kind]++
+	first := e.assertions[kind] == 1
+	e.mu.Unlock()
+	if first {
+		log.Warnf("Response assertion failed: %s", reason)
+	} else {
+		log.Debugf("Response assertion failed: %s", reason)
+	}
+}
+
+// recordStatus counts a response with status code.
+func (e *sendErrors) recordStatus(code int) {
+	if e == nil || code < 0 || code >= len(e.statuses) {
+		return
+	}
+	atomic.AddInt64(&e.statuses[code], 1)
+}
+
+// second returns the errors and assertion failures since the previous call.
+func (e *sendErrors) second() int {
+	if e == nil {
+		return 0
+	}
+	return int(atomic.SwapInt64(&e.tick, 0))
+}
+
+// report adds the breakdown to a run result and logs it.
+func (e *sendErrors) report(result *report.Result) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result.Errors = logCounts("Send errors", e.counts)
+	result.AssertionFailures = logCounts("Assertion failures", e.assertions)
+	for code := range e.statuses {
+		if n := atomic.LoadInt64(&e.statuses[code]); n > 0 {
+			result.CountStatus(code, int(n))
+		}
+	}
+	logStatusCodes(result.StatusCodes, e.assert)
+}
+
+// save copies the counts to a checkpoint.
+func (e *sendErrors) save(cp *checkpoint) {
+	e.mu.Lock()
+	cp.Errors = copyCounts(e.counts)
+	cp.AssertionFailures = copyCounts(e.assertions)
+	e.mu.Unlock()
+	var statuses report.Result
+	for code := range e.statuses {
+		if n := atomic.LoadInt64(&e.statuses[code]); n > 0 {
+			statuses.CountStatus(code, int(n))
+		}
+	}
+	cp.StatusCodes = statuses.StatusCodes
+}
+
+// restore adds the counts of the earlier segments of a resumed run, saved in
+// its checkpoint, before the sends start.
+func (e *sendErrors) restore(cp *checkpoint) {
+	for kind, n := range cp.Errors {
+		e.counts[kind] += n
+	}
+	for kind, n := range cp.AssertionFailures {
+		e.assertions[kind] += n
+	}
+	for code, n := range cp.StatusCodes {
+		if c, err := strconv.Atoi(code); err == nil && c >= 0 && c < len(e.statuses) {
+			e.statuses[c] += int64(n)
+		}
+	}
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	if len(counts) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(counts))
+	for kind, n := range counts {
+		out[kind] = n
+	}
+	return out
+}
+
+// logStatusCodes logs the responses by status code, marking the codes that
+// do not count as success with a.
+func logStatusCodes(counts map[string]int, a *responseAssertion) {
+	codes := make([]string, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	// the codes have three digits, so they sort as numbers
+	sort.Strings(codes)
+	for _, code := range codes {
+		n, _ := strconv.Atoi(code)
+		if a != nil && !a.expectedStatus(n) {
+			log.Infof("Responses with status %s: %d, not a success (expected %s)", code, counts[code], a.spec)
+		} else {
+			log.Infof("Responses with status %s: %d", code, counts[code])
+		}
+	}
+}
+
+// logCounts logs counts by kind and returns a copy, nil if there are none.
+func logCounts(what string, counts map[string]int) map[string]int {
+	if len(counts) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(counts))
+	kinds := make([]string, 0, len(counts))
+	for kind, n := range counts {
+		out[kind] = n
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		log.Infof("%s (%s): %d", what, kind, counts[kind])
+	}
+	return out
+}
+
+// errorKind classifies a send error.
+func errorKind(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, fasthttp.ErrNoFreeConns):
+		return errNoFreeConns
+	case errors.Is(err, fasthttp.ErrTimeout), errors.Is(err, fasthttp.ErrDialTimeout),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, fasthttp.ErrConnectionClosed):
+		return errReset
+	case errors.As(err, &dnsErr):
+		return errDNS
+	case strings.Contains(err.Error(), "tls:") || strings.Contains(err.Error(), "x509:"):
+		return errTLS
+	}
+	return errOther
+}
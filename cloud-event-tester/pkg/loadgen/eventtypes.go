@@ -0,0 +1,135 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// event types of the sends whose events have neither a CloudEvents type nor
+// a Redfish message ID, and of batches of events of several types
+const (
+	untypedEvents = "untyped"
+	mixedEvents   = "mixed"
+)
+
+// typedEvent holds the fields an event is typed by: the type of a cloud
+// event, or else the message ID of the first record of a Redfish event.
+type typedEvent struct {
+	Type   string `json:"type"`
+	Events []struct {
+		MessageID string `json:"MessageId"`
+	} `json:"Events"`
+}
+
+func (e *typedEvent) typ() string {
+	switch {
+	case e.Type != "":
+		return e.Type
+	case len(e.Events) > 0 && e.Events[0].MessageID != "":
+		return e.Events[0].MessageID
+	}
+	return untypedEvents
+}
+
+// eventType returns the type of an event, that of all the events of a
+// batch, or mixedEvents if they differ.
+func eventType(event []byte) string {
+	event = bytes.TrimSpace(event)
+	if len(event) > 0 && event[0] == '[' {
+		var batch []typedEvent
+		if json.Unmarshal(event, &batch) != nil || len(batch) == 0 {
+			return untypedEvents
+		}
+		typ := batch[0].typ()
+		for i := range batch[1:] {
+			if batch[i+1].typ() != typ {
+				return mixedEvents
+			}
+		}
+		return typ
+	}
+	var e typedEvent
+	if json.Unmarshal(event, &e) != nil {
+		return untypedEvents
+	}
+	return e.typ()
+}
+
+// eventTypeRecorder counts the sends of every event type of a run, and
+// records the latencies of their successful sends, so a type that is slow to
+// handle stands out of the events mixed with it. It is safe for concurrent
+// use.
+type eventTypeRecorder struct {
+	mu    sync.Mutex
+	stats map[string]*typeCounts
+}
+
+type typeCounts struct {
+	stats   report.EventTypeStats
+	latency *hdrhistogram.Histogram
+}
+
+func newEventTypeRecorder() *eventTypeRecorder {
+	return &eventTypeRecorder{stats: map[string]*typeCounts{}}
+}
+
+// record accounts a send of an event of type typ that failed, or succeeded
+// after latency. A nil recorder records nothing.
+func (r *eventTypeRecorder) record(typ string, latency time.Duration, failed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.stats[typ]
+	if !ok {
+		c = &typeCounts{stats: report.EventTypeStats{Type: typ}, latency: report.NewLatencyHistogram()}
+		r.stats[typ] = c
+	}
+	c.stats.Sent++
+	if failed {
+		c.stats.Errors++
+		return
+	}
+	report.RecordLatency(c.latency, latency)
+}
+
+// report adds the stats of every event type to a run result, by type, if
+// the run sent more than one type; those of a single type are the stats of
+// the run.
+func (r *eventTypeRecorder) report(result *report.Result) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.stats) < 2 {
+		return
+	}
+	types := make([]string, 0, len(r.stats))
+	for typ := range r.stats {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	result.EventTypes = make([]report.EventTypeStats, 0, len(types))
+	for _, typ := range types {
+		c := r.stats[typ]
+		st := c.stats
+		st.Latency = report.SummarizeLatency(c.latency)
+		if st.Latency != nil {
+			log.Infof("Event type %s: %d sent, %d errors, latency (ms) p50 %.3f p90 %.3f p99 %.3f max %.3f",
+				st.Type, st.Sent, st.Errors, st.Latency.P50, st.Latency.P90, st.Latency.P99, st.Latency.Max)
+		} else {
+			log.Infof("Event type %s: %d sent, %d errors", st.Type, st.Sent, st.Errors)
+		}
+		result.EventTypes = append(result.EventTypes, st)
+	}
+}
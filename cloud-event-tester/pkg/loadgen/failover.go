@@ -0,0 +1,102 @@
+package loadgen
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// failover switches between the primary targets and a backup URL when the
+// active side has been unreachable for a while, like a client of an HA
+// consumer pair. It is safe for concurrent use.
+type failover struct {
+	after time.Duration
+
+	mu        sync.Mutex
+	backup    bool
+	downSince time.Time
+	events    []report.FailoverEvent
+	stats     map[string]*report.TargetStats
+	order     []string
+}
+
+// newFailover returns the failover of a run, or nil if no backup URL is
+// configured.
+func newFailover(cfg *Config) *failover {
+	if cfg.BackupURL == "" {
+		return nil
+	}
+	return &failover{after: time.Duration(cfg.FailoverAfter) * time.Second, stats: map[string]*report.TargetStats{}}
+}
+
+// onBackup reports whether requests should go to the backup URL. A nil
+// failover never does.
+func (f *failover) onBackup() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.backup
+}
+
+// record accounts a request sent to target and switches sides once the
+// active side has failed continuously for the failover period. peer is the
+// target of the other side, for the event log.
+func (f *failover) record(target, peer string, err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st, ok := f.stats[target]
+	if !ok {
+		st = &report.TargetStats{URL: target}
+		f.stats[target] = st
+		f.order = append(f.order, target)
+	}
+	st.Sent++
+	if err == nil {
+		f.downSince = time.Time{}
+		return
+	}
+	st.Errors++
+	now := time.Now()
+	if f.downSince.IsZero() {
+		f.downSince = now
+		return
+	}
+	if now.Sub(f.downSince) < f.after {
+		return
+	}
+	log.Warnf("%s unreachable for %v, failing over to %s", target, now.Sub(f.downSince).Round(time.Second), peer)
+	f.backup = !f.backup
+	f.downSince = time.Time{}
+	f.events = append(f.events, report.FailoverEvent{Time: now, From: target, To: peer})
+}
+
+// report adds the failover events and per-target stats to a run result,
+// unless the targets of a run with several reported theirs.
+func (f *failover) report(result *report.Result) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result.Failovers = append([]report.FailoverEvent(nil), f.events...)
+	if len(f.events) > 0 {
+		log.Infof("Failovers: %d", len(f.events))
+	}
+	if result.Targets != nil {
+		return
+	}
+	result.Targets = make([]report.TargetStats, 0, len(f.order))
+	for _, target := range f.order {
+		st := *f.stats[target]
+		log.Infof("Target %s: %d sent, %d errors", st.URL, st.Sent, st.Errors)
+		result.Targets = append(result.Targets, st)
+	}
+}
@@ -0,0 +1,277 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// fault classes of -fault-classes
+const (
+	faultMissingAttributes = "missing-attributes"
+	faultInvalidJSON       = "invalid-json"
+	faultContentType       = "wrong-content-type"
+	faultOversized         = "oversized"
+)
+
+// faultClasses are the fault classes, in the order the faults are sent.
+var faultClasses = []string{faultMissingAttributes, faultInvalidJSON, faultContentType, faultOversized}
+
+// oversizedFaultBytes is the padding of the oversized events, far beyond the
+// size of any event a consumer needs to accept.
+const oversizedFaultBytes = 4 << 20
+
+// faultContentTypeValue is the content type of the wrong-content-type fault.
+const faultContentTypeValue = "text/plain"
+
+// requiredAttributes are removed by the missing-attributes fault: the
+// required attributes of a cloud event and the Id, type and events of a
+// Redfish event. In binary content mode the attributes are headers.
+var (
+	requiredAttributes       = []string{"specversion", "id", "source", "type", "Id", "@odata.type", "Events"}
+	requiredAttributeHeaders = []string{"ce-specversion", "ce-id", "ce-source", "ce-type"}
+)
+
+// faultInjector replaces a share of the sends of a performance run with
+// broken events, evenly spread over the run and the fault classes. A nil
+// injector injects nothing.
+type faultInjector struct {
+	// share is the fraction of the sends
+	share  float64
+	faults []*fault
+	next   uint64
+}
+
+// fault is a fault class and how it breaks a request, counted by the
+// response of the consumer. The counters are updated atomically by the
+// shards.
+type fault struct {
+	class string
+	// body replaces the body of the request, if not nil
+	body        []byte
+	contentType string
+	// headers are removed from the request
+	headers []string
+
+	sent, rejected, accepted, serverErrors, failed int64
+}
+
+// newFaultInjector returns the injector of -fault-rate for the event body of
+// a run, nil if no faults are injected.
+func newFaultInjector(cfg *Config, body []byte) *faultInjector {
+	if cfg.FaultRate == 0 {
+		return nil
+	}
+	binary := cfg.isBinary()
+	f := &faultInjector{share: cfg.FaultRate / 100}
+	for _, class := range cfg.faultClasses() {
+		ft := &fault{class: class}
+		switch class {
+		case faultMissingAttributes:
+			if binary {
+				ft.headers = requiredAttributeHeaders
+			} else {
+				ft.body = withoutAttributes(body)
+			}
+		case faultInvalidJSON:
+			// an event cut in half is never valid JSON
+			ft.body = bytes.Clone(body[:len(body)/2])
+		case faultContentType:
+			ft.contentType = faultContentTypeValue
+		case faultOversized:
+			ft.body = padEvent(body, "faultpadding", oversizedFaultBytes)
+		}
+		f.faults = append(f.faults, ft)
+	}
+	return f
+}
+
+// faultClasses returns the fault classes of -fault-classes, all if it is
+// empty.
+func (c *Config) faultClasses() []string {
+	if c.FaultClasses == "" {
+		return faultClasses
+	}
+	var classes []string
+	for _, class := range strings.Split(c.FaultClasses, ",") {
+		classes = append(classes, strings.ToLower(strings.TrimSpace(class)))
+	}
+	return classes
+}
+
+// validateFaults checks the fault rate and classes, and that the run sends
+// from its loop over HTTP, where the responses to the faults are seen.
+func (c *Config) validateFaults() error {
+	for _, class := range c.faultClasses() {
+		if !containsString(faultClasses, class) {
+			return fmt.Errorf("unknown fault class %q, must be one of %s", class, strings.Join(faultClasses, ", "))
+		}
+	}
+	switch {
+	case c.FaultRate < 0 || c.FaultRate > 100:
+		return fmt.Errorf("fault rate must be a percentage between 0 and 100, got %g", c.FaultRate)
+	case c.FaultRate == 0:
+		return nil
+	case !c.IsPerf():
+		return fmt.Errorf("faults are only injected by performance runs")
+	case strings.ToLower(c.Transport) != sender.TransportHTTP:
+		return fmt.Errorf("faults are injected over HTTP, not %s", c.Transport)
+	case strings.ToUpper(c.CheckResp) == "MULTI_THREAD":
+		return fmt.Errorf("faults are injected with CHECK_RESP YES or NO, not MULTI_THREAD")
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// due returns the fault to send instead of the next event of a shard, nil to
+// send the event. credit is the share of faults the shard has accumulated.
+func (f *faultInjector) due(credit *float64) *fault {
+	if f == nil {
+		return nil
+	}
+	*credit += f.share
+	if *credit < 1 {
+		return nil
+	}
+	*credit--
+	i := atomic.AddUint64(&f.next, 1) - 1
+	return f.faults[i%uint64(len(f.faults))]
+}
+
+// apply breaks req, a copy of the request of the event.
+func (ft *fault) apply(req *fasthttp.Request) {
+	if ft.body != nil {
+		req.SetBody(ft.body)
+	}
+	if ft.contentType != "" {
+		req.Header.SetContentType(ft.contentType)
+	}
+	for _, h := range ft.headers {
+		req.Header.Del(h)
+	}
+}
+
+// record counts the response to a fault, or the failed send.
+func (ft *fault) record(status int, err error) {
+	atomic.AddInt64(&ft.sent, 1)
+	switch {
+	case err != nil:
+		atomic.AddInt64(&ft.failed, 1)
+		log.Debugf("Fault %s: send failed: %v", ft.class, err)
+	case status >= 500:
+		atomic.AddInt64(&ft.serverErrors, 1)
+	case status >= 400:
+		atomic.AddInt64(&ft.rejected, 1)
+	default:
+		atomic.AddInt64(&ft.accepted, 1)
+	}
+}
+
+// report adds the responses to the faults to a run result and logs them.
+func (f *faultInjector) report(result *report.Result) {
+	if f == nil {
+		return
+	}
+	for _, ft := range f.faults {
+		s := report.FaultStats{
+			Class:        ft.class,
+			Sent:         int(atomic.LoadInt64(&ft.sent)),
+			Rejected:     int(atomic.LoadInt64(&ft.rejected)),
+			Accepted:     int(atomic.LoadInt64(&ft.accepted)),
+			ServerErrors: int(atomic.LoadInt64(&ft.serverErrors)),
+			Failed:       int(atomic.LoadInt64(&ft.failed)),
+		}
+		result.Faults = append(result.Faults, s)
+		log.Infof("Fault %s: %d sent, %d rejected, %d accepted, %d server errors, %d failed",
+			s.Class, s.Sent, s.Rejected, s.Accepted, s.ServerErrors, s.Failed)
+	}
+}
+
+// AddFaultStats adds the stats of a fault class to those of a distributed
+// run.
+func AddFaultStats(all []report.FaultStats, s report.FaultStats) []report.FaultStats {
+	for i := range all {
+		if all[i].Class == s.Class {
+			all[i].Sent += s.Sent
+			all[i].Rejected += s.Rejected
+			all[i].Accepted += s.Accepted
+			all[i].ServerErrors += s.ServerErrors
+			all[i].Failed += s.Failed
+			return all
+		}
+	}
+	return append(all, s)
+}
+
+// faultChecks check that the consumer accepted none of the faults of a run
+// and answered none with a server error.
+func faultChecks(result *report.Result) []report.CheckResult {
+	var checks []report.CheckResult
+	for _, s := range result.Faults {
+		checks = append(checks, report.CheckResult{Name: "fault " + s.Class}.PassIf(s.Accepted == 0 && s.ServerErrors == 0,
+			"%d of %d rejected, %d accepted, %d server errors, %d failed", s.Rejected, s.Sent, s.Accepted, s.ServerErrors, s.Failed))
+	}
+	return checks
+}
+
+// withoutAttributes returns an event, or the events of a batch, without the
+// required attributes.
+func withoutAttributes(body []byte) []byte {
+	var event interface{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return body
+	}
+	events := []interface{}{event}
+	if batch, ok := event.([]interface{}); ok {
+		events = batch
+	}
+	for _, e := range events {
+		if attrs, ok := e.(map[string]interface{}); ok {
+			for _, name := range requiredAttributes {
+				delete(attrs, name)
+			}
+		}
+	}
+	broken, err := json.Marshal(event)
+	if err != nil {
+		return body
+	}
+	return broken
+}
+
+// padEvent returns an event with an attribute attr of size bytes, still
+// valid JSON, or the event followed by as much whitespace if it is not an
+// object.
+func padEvent(body []byte, attr string, size int) []byte {
+	i := bytes.IndexByte(body, '{')
+	if i < 0 {
+		return append(bytes.Clone(body), bytes.Repeat([]byte{' '}, size)...)
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(body) + size + 32)
+	buf.Write(body[:i+1])
+	buf.WriteString(`"` + attr + `":"`)
+	buf.Write(bytes.Repeat([]byte{'x'}, size))
+	buf.WriteByte('"')
+	if rest := bytes.TrimSpace(body[i+1:]); len(rest) > 0 && rest[0] != '}' {
+		buf.WriteByte(',')
+	}
+	buf.Write(body[i+1:])
+	return buf.Bytes()
+}
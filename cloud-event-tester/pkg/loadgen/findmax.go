@@ -0,0 +1,190 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// search strategies of -find-max
+const (
+	findMaxStep   = "step"
+	findMaxBinary = "binary"
+)
+
+// findMaxRange returns the lowest rate and the step of the throughput search
+// of a run, with their defaults: a tenth of the rate for the step, and the
+// step for the lowest rate.
+func (c *Config) findMaxRange() (lowest, step int) {
+	step = c.FindMaxStep
+	if step == 0 {
+		if step = c.Rate / 10; step == 0 {
+			step = 1
+		}
+	}
+	if lowest = c.FindMaxMin; lowest == 0 {
+		lowest = step
+	}
+	return lowest, step
+}
+
+// validateFindMax checks the throughput search settings of a performance
+// run.
+func (c *Config) validateFindMax() error {
+	if c.FindMax == "" {
+		return nil
+	}
+	switch strings.ToLower(c.FindMax) {
+	case findMaxStep, findMaxBinary:
+	default:
+		return fmt.Errorf("find-max %q is not step or binary", c.FindMax)
+	}
+	lowest, step := c.findMaxRange()
+	switch {
+	case !c.hasSLA():
+		return fmt.Errorf("find-max probes the rates against the SLA thresholds, set -max-error-rate, -max-p99-ms or -min-achieved-rate")
+	case c.Continuous():
+		return fmt.Errorf("the probes of find-max need a duration")
+	case c.FindMaxStep < 0 || c.FindMaxMin < 0:
+		return fmt.Errorf("find-max step and lowest rate must not be negative, got %d and %d", c.FindMaxStep, c.FindMaxMin)
+	case lowest > c.Rate:
+		return fmt.Errorf("find-max searches up to the rate, got a lowest rate of %d for a rate of %d", lowest, c.Rate)
+	case lowest < c.Shards:
+		return fmt.Errorf("find-max lowest rate must be at least the shards, got %d for %d shards", lowest, c.Shards)
+	case step > c.Rate:
+		return fmt.Errorf("find-max step must not exceed the rate, got %d for a rate of %d", step, c.Rate)
+	case c.AdaptiveRate:
+		return fmt.Errorf("find-max sets the rate of every probe, adaptive rate control is not supported")
+	case c.BurstSize > 0:
+		return fmt.Errorf("find-max sets the rate of every probe, bursts are not supported")
+	}
+	return nil
+}
+
+// findMaxRate searches for the highest rate, up to the rate of cfg, at which
+// a run still meets its SLA thresholds. Every probe is a run of the duration
+// of cfg at one rate; its report, JUnit and trace files get the rate as
+// suffix. The step strategy raises the rate by the step from the lowest
+// rate until a probe fails; the binary strategy probes the lowest and the
+// highest rate and then halves the range between the highest rate that
+// passed and the lowest that failed until it is no wider than the step.
+// It returns the result of the probe at the capacity found, with the search
+// added, or of the lowest rate if that failed already, so its SLA
+// violations fail the run; it is also written to the report file of cfg.
+func findMaxRate(ctx context.Context, cfg *Config, onTick StatsListener) (*report.Result, error) {
+	strategy := strings.ToLower(cfg.FindMax)
+	lowest, step := cfg.findMaxRange()
+	stats := &report.FindMaxStats{Strategy: strategy}
+	results := map[int]*report.Result{}
+	probe := func(rate int) (bool, error) {
+		p := cfg.Clone()
+		p.FindMax = ""
+		p.Rate = rate
+		suffix := strconv.Itoa(rate) + "mps"
+		p.ReportFile = SuffixPath(cfg.ReportFile, suffix)
+		p.TimeseriesFile = SuffixPath(cfg.TimeseriesFile, suffix)
+		p.JUnitFile = SuffixPath(cfg.JUnitFile, suffix)
+		p.TraceFile = SuffixPath(cfg.TraceFile, suffix)
+		log.Infof("=== Find Max: probe %d at %d msg/s ===", len(stats.Probes)+1, rate)
+		result, err := Run(ctx, &p, onTick)
+		if err != nil {
+			return false, err
+		}
+		var failed SLAViolation
+		failed.AddViolations(result)
+		passed := failed.Err() == nil && !result.Interrupted
+		stats.Probes = append(stats.Probes, report.FindMaxProbe{
+			Rate:      rate,
+			TotalMsg:  result.TotalMsg,
+			AvgRate:   result.AvgRate,
+			ErrorRate: result.ErrorRate,
+			Latency:   result.Latency,
+			Passed:    passed,
+		})
+		results[rate] = result
+		if passed {
+			log.Infof("Find Max: %d msg/s met the thresholds", rate)
+		} else {
+			log.Warnf("Find Max: %d msg/s failed the thresholds", rate)
+		}
+		return passed, nil
+	}
+
+	log.Infof("Find Max: %s search from %d up to %d msg/s, step %d", strategy, lowest, cfg.Rate, step)
+	passed, err := probe(lowest)
+	if err != nil {
+		return nil, err
+	}
+	if passed {
+		stats.Capacity = lowest
+		switch strategy {
+		case findMaxStep:
+			for rate := lowest + step; rate <= cfg.Rate && ctx.Err() == nil; rate += step {
+				if passed, err = probe(rate); err != nil {
+					return nil, err
+				}
+				if !passed {
+					break
+				}
+				stats.Capacity = rate
+			}
+		case findMaxBinary:
+			failed := cfg.Rate + 1
+			if cfg.Rate > lowest && ctx.Err() == nil {
+				if passed, err = probe(cfg.Rate); err != nil {
+					return nil, err
+				}
+				if passed {
+					stats.Capacity = cfg.Rate
+				} else {
+					failed = cfg.Rate
+				}
+			}
+			for failed-stats.Capacity > step && ctx.Err() == nil {
+				rate := stats.Capacity + (failed-stats.Capacity)/2
+				if passed, err = probe(rate); err != nil {
+					return nil, err
+				}
+				if passed {
+					stats.Capacity = rate
+				} else {
+					failed = rate
+				}
+			}
+		}
+	}
+
+	printFindMax(stats)
+	result := results[lowest]
+	if stats.Capacity > 0 {
+		result = results[stats.Capacity]
+		log.Infof("Find Max: maximum sustainable rate %d msg/s, %d probes", stats.Capacity, len(stats.Probes))
+	} else {
+		log.Errorf("Find Max: the lowest rate of %d msg/s failed the thresholds already", lowest)
+	}
+	result.FindMax = stats
+	// the report file of the search has the probe at the capacity and the
+	// probes
+	WriteReportFile(cfg, result)
+	return result, nil
+}
+
+// printFindMax logs the probes of a throughput search as a table.
+func printFindMax(stats *report.FindMaxStats) {
+	log.Infof("%10s %10s %12s %9s %10s %8s", "RATE", "SENT", "AVG(MSG/S)", "ERRORS", "P99(MS)", "RESULT")
+	for _, p := range stats.Probes {
+		p99, outcome := "-", "failed"
+		if p.Latency != nil {
+			p99 = fmt.Sprintf("%.3f", p.Latency.P99)
+		}
+		if p.Passed {
+			outcome = "passed"
+		}
+		log.Infof("%10d %10d %12.1f %8.2f%% %10s %8s", p.Rate, p.TotalMsg, p.AvgRate, p.ErrorRate, p99, outcome)
+	}
+}
@@ -0,0 +1,292 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// generatorState is a state of a generated PTP event: the value of its
+// notification, if it has one, the range of its metric and how likely the
+// next event is still in this state.
+type generatorState struct {
+	value string
+	// min and max bound the metric, for offsets in ns in either direction
+	min, max int
+	// signed metrics, like offsets, are negative half of the time
+	signed bool
+	// stay is the probability that the next event is in the same state
+	stay float64
+}
+
+// generatorSpec describes the events of a generator. Its states are in the
+// order the clock goes through them, like losing lock to holdover, holdover
+// running out to free-run and free-run locking again, so every transition
+// of a generator is to the next state.
+type generatorSpec struct {
+	eventType string
+	// resource is the path of the resource below the node
+	resource string
+	// notification is false for events that only have a metric
+	notification bool
+	states       []generatorState
+}
+
+// Generator generates the events of a run instead of the event files. A
+// performance run sends the next event of its generator with every send; a
+// basic run sends the events of its sequence, in order.
+type Generator interface {
+	// Next writes the next event to buf, replacing its content. It is called
+	// concurrently by the send shards.
+	Next(buf *bytes.Buffer) error
+	// Sequence returns the events of a basic run.
+	Sequence() ([][]byte, error)
+}
+
+// GeneratorFactory returns a new generator for a run with the given
+// settings. A generator that is an io.Closer is closed after the run.
+type GeneratorFactory func(cfg *Config) (Generator, error)
+
+// generators are the registered generators, by their -generator name.
+var generators = map[string]GeneratorFactory{}
+
+// RegisterGenerator makes a generator available to runs by name, replacing
+// the generator of that name, if any. It is meant to be called from an init
+// function, like the built-in generators are registered, and is not safe to
+// call concurrently with runs.
+func RegisterGenerator(name string, factory GeneratorFactory) {
+	generators[name] = factory
+}
+
+func init() {
+	for name, spec := range ptpGenerators {
+		spec := spec
+		RegisterGenerator(name, func(*Config) (Generator, error) {
+			return newPTPGenerator(spec), nil
+		})
+	}
+	RegisterGenerator(execGeneratorName, newExecGenerator)
+}
+
+// ptpGenerators are the built-in PTP event generators. The events have the
+// format of cloud-event-proxy with the O-RAN REST API: a notification with
+// the new state and a metric, both for the resource address of the node.
+var ptpGenerators = map[string]*generatorSpec{
+	"ptp-lock-state": {
+		eventType:    "event.sync.ptp-status.ptp-state-change",
+		resource:     "/sync/ptp-status/lock-state",
+		notification: true,
+		states: []generatorState{
+			{value: "LOCKED", min: 0, max: 10, signed: true, stay: 0.98},
+			{value: "HOLDOVER", min: 10, max: 1500, signed: true, stay: 0.9},
+			{value: "FREERUN", min: 1500, max: 1000000, signed: true, stay: 0.8},
+		},
+	},
+	"ptp-os-clock-sync-state": {
+		eventType:    "event.sync.sync-status.os-clock-sync-state-change",
+		resource:     "/sync/sync-status/os-clock-sync-state",
+		notification: true,
+		states: []generatorState{
+			{value: "LOCKED", min: 0, max: 20, signed: true, stay: 0.98},
+			{value: "FREERUN", min: 100, max: 1000000, signed: true, stay: 0.8},
+		},
+	},
+	"ptp-gnss-sync-status": {
+		eventType:    "event.sync.gnss-status.gnss-state-change",
+		resource:     "/sync/gnss-status/gnss-sync-status",
+		notification: true,
+		states: []generatorState{
+			{value: "SYNCHRONIZED", min: 0, max: 5, signed: true, stay: 0.98},
+			{value: "ANTENNA-DISCONNECTED", min: 100, max: 10000, signed: true, stay: 0.85},
+			{value: "ACQUIRING-SYNC", min: 5, max: 100, signed: true, stay: 0.85},
+		},
+	},
+	"ptp-clock-class": {
+		eventType: "event.sync.ptp-status.ptp-clock-class-change",
+		resource:  "/sync/ptp-status/clock-class",
+		// the clock classes of ITU-T G.8275.1: locked to a PRTC, in holdover
+		// within and out of its specification, and free-running
+		states: []generatorState{
+			{min: 6, max: 6, stay: 0.98},
+			{min: 7, max: 7, stay: 0.9},
+			{min: 140, max: 140, stay: 0.9},
+			{min: 248, max: 248, stay: 0.8},
+		},
+	},
+}
+
+// generatorNames returns the names of the generators, sorted.
+func generatorNames() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runGenerator is the generator of a run. It labels the events like those of
+// the event files and is the events.Renderer of performance runs.
+type runGenerator struct {
+	gen    Generator
+	labels map[string]string
+}
+
+// newGenerator returns the generator of a run, see -generator.
+func newGenerator(cfg *Config) (*runGenerator, error) {
+	factory, ok := generators[cfg.Generator]
+	if !ok {
+		return nil, fmt.Errorf("unknown generator %q, must be one of %s", cfg.Generator, strings.Join(generatorNames(), ", "))
+	}
+	gen, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("generator %s: %w", cfg.Generator, err)
+	}
+	return &runGenerator{gen: gen, labels: cfg.Labels}, nil
+}
+
+func (g *runGenerator) Render(buf *bytes.Buffer) error {
+	if err := g.gen.Next(buf); err != nil {
+		return err
+	}
+	if len(g.labels) > 0 {
+		event := events.LabelEvent(buf.Bytes(), g.labels)
+		buf.Reset()
+		buf.Write(event)
+	}
+	return nil
+}
+
+func (g *runGenerator) sequence() ([][]byte, error) {
+	seq, err := g.gen.Sequence()
+	if err != nil {
+		return nil, err
+	}
+	for i, event := range seq {
+		seq[i] = events.LabelEvent(event, g.labels)
+	}
+	return seq, nil
+}
+
+// close closes the generator if it is an io.Closer.
+func (g *runGenerator) close() {
+	if c, ok := g.gen.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Warnf("Failed to close generator: %v", err)
+		}
+	}
+}
+
+// eventGenerator is a built-in PTP event generator. Its events walk through
+// the states of its spec.
+type eventGenerator struct {
+	spec *generatorSpec
+	node string
+
+	mu    sync.Mutex
+	state int
+	rng   *rand.Rand
+}
+
+// newPTPGenerator returns a generator of the events of spec. The resource
+// addresses are of the node in NODE_NAME, as Kubernetes sets it through the
+// downward API, or of the host.
+func newPTPGenerator(spec *generatorSpec) *eventGenerator {
+	node := os.Getenv("NODE_NAME")
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	return &eventGenerator{
+		spec: spec,
+		node: node,
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next moves to the next state with the probability of leaving the current
+// one and generates an event in it.
+func (g *eventGenerator) Next(buf *bytes.Buffer) error {
+	g.mu.Lock()
+	if g.rng.Float64() >= g.spec.states[g.state].stay {
+		g.state = (g.state + 1) % len(g.spec.states)
+	}
+	event := g.event(g.spec.states[g.state])
+	g.mu.Unlock()
+	buf.Reset()
+	buf.Write(event)
+	return nil
+}
+
+// Sequence returns an event for every state, in the order of the
+// transitions.
+func (g *eventGenerator) Sequence() ([][]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	events := make([][]byte, len(g.spec.states))
+	for i, st := range g.spec.states {
+		events[i] = g.event(st)
+	}
+	return events, nil
+}
+
+// generatedValue is a value of the data of a generated event.
+type generatedValue struct {
+	ResourceAddress string `json:"ResourceAddress"`
+	DataType        string `json:"data_type"`
+	ValueType       string `json:"value_type"`
+	Value           string `json:"value"`
+}
+
+// generatedEvent is a generated event in structured CloudEvents JSON format.
+type generatedEvent struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Time            string `json:"time"`
+	DataContentType string `json:"datacontenttype"`
+	Data            struct {
+		Version string           `json:"version"`
+		Values  []generatedValue `json:"values"`
+	} `json:"data"`
+}
+
+// event builds an event in state st; the caller holds g.mu for the random
+// metric.
+func (g *eventGenerator) event(st generatorState) []byte {
+	address := "/cluster/node/" + g.node + g.spec.resource
+	metric := st.min
+	if st.max > st.min {
+		metric += g.rng.Intn(st.max - st.min + 1)
+	}
+	if st.signed && g.rng.Intn(2) == 0 {
+		metric = -metric
+	}
+	e := generatedEvent{
+		SpecVersion:     "1.0",
+		ID:              events.NewUUID(),
+		Source:          g.spec.resource,
+		Type:            g.spec.eventType,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+	}
+	e.Data.Version = "1.0"
+	if g.spec.notification {
+		e.Data.Values = append(e.Data.Values, generatedValue{ResourceAddress: address, DataType: "notification", ValueType: "enumeration", Value: st.value})
+	}
+	e.Data.Values = append(e.Data.Values, generatedValue{ResourceAddress: address, DataType: "metric", ValueType: "decimal64.3", Value: strconv.Itoa(metric)})
+	event, _ := json.Marshal(&e)
+	return event
+}
@@ -0,0 +1,123 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenBucketScript refills a token bucket shared by all testers using the
+// clock of the Redis server, so the clocks of the testers do not matter, and
+// grants up to the requested number of tokens.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local granted = math.min(requested, math.floor(tokens))
+tokens = tokens - granted
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], 60000)
+return granted
+`)
+
+// globalLimiter caps the combined rate of independent testers with a token
+// bucket in Redis. Tokens are taken in small batches to keep the number of
+// Redis round trips low. The send shards of a run share it, taking their
+// tokens under mu.
+type globalLimiter struct {
+	client *redis.Client
+	key    string
+	rate   int
+	burst  int
+	batch  int
+	tokens int
+
+	// mu serializes the send shards taking tokens
+	mu sync.Mutex
+
+	// failOpenUntil lets requests through without tokens for a while after
+	// Redis failed, so an outage does not stall the run.
+	failOpenUntil time.Time
+}
+
+// newGlobalLimiter returns the limiter of a run, or nil if no global rate is
+// configured.
+func newGlobalLimiter(ctx context.Context, cfg *Config) (*globalLimiter, error) {
+	if cfg.RedisURL == "" {
+		return nil, nil
+	}
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	l := &globalLimiter{
+		client: redis.NewClient(opts),
+		key:    cfg.RateKey,
+		rate:   cfg.GlobalRate,
+		// 10ms worth of tokens per round trip, at most 100ms of burst
+		batch: cfg.GlobalRate / 100,
+		burst: cfg.GlobalRate / 10,
+	}
+	if l.batch < 1 {
+		l.batch = 1
+	}
+	if l.burst < l.batch {
+		l.burst = l.batch
+	}
+	if err := l.client.Ping(ctx).Err(); err != nil {
+		l.client.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", opts.Addr, err)
+	}
+	log.Infof("Global rate: %d msg/sec shared through redis %s key %s", l.rate, opts.Addr, l.key)
+	return l, nil
+}
+
+// wait blocks until a token of the global rate is available or ctx is done.
+func (l *globalLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.tokens == 0 {
+		if time.Now().Before(l.failOpenUntil) {
+			return nil
+		}
+		granted, err := tokenBucketScript.Run(ctx, l.client, []string{l.key}, l.rate, l.burst, l.batch).Int()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Errorf("Global rate limiter failed, sending without it for 1s: %v", err)
+			l.failOpenUntil = time.Now().Add(time.Second)
+			return nil
+		}
+		if granted > 0 {
+			l.tokens = granted
+			break
+		}
+		// the bucket is empty, wait until a batch has been refilled
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(l.batch) * time.Second / time.Duration(l.rate)):
+		}
+	}
+	l.tokens--
+	return nil
+}
+
+func (l *globalLimiter) close() {
+	l.client.Close()
+}
@@ -0,0 +1,170 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// livenessTimeout is how long the send loop may go without an iteration
+// before the tester reports itself unhealthy.
+const livenessTimeout = 30 * time.Second
+
+// RunHealth is the health of one run: ready once it is configured and
+// warmed up, healthy as long as its running send loop keeps making progress.
+// A nil state, of a run without health probes, records nothing.
+type RunHealth struct {
+	probes  *HealthProbes
+	mu      sync.Mutex
+	ready   bool
+	sending bool
+	// lastBeat is the time of the last beat in Unix nanoseconds. It is
+	// updated on every send, so it is atomic instead of under mu.
+	lastBeat int64
+}
+
+// healthProbesKey is the context key of the health probes of the runs of a
+// context, see WithHealthProbes.
+type healthProbesKey struct{}
+
+// WithHealthProbes returns a context whose runs report their health to p.
+func WithHealthProbes(ctx context.Context, p *HealthProbes) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, healthProbesKey{}, p)
+}
+
+// NewRunHealth returns the health of a run of ctx, registered with the
+// health probes of ctx until it is closed, or nil if ctx has none.
+func NewRunHealth(ctx context.Context) *RunHealth {
+	p, _ := ctx.Value(healthProbesKey{}).(*HealthProbes)
+	if p == nil {
+		return nil
+	}
+	h := &RunHealth{probes: p}
+	p.mu.Lock()
+	p.runs[h] = struct{}{}
+	p.mu.Unlock()
+	return h
+}
+
+// close unregisters the run from the health probes.
+func (h *RunHealth) close() {
+	if h == nil {
+		return
+	}
+	h.probes.mu.Lock()
+	delete(h.probes.runs, h)
+	h.probes.mu.Unlock()
+}
+
+func (h *RunHealth) setReady(ready bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+}
+
+// loopStarted marks the send loop as running.
+func (h *RunHealth) loopStarted() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sending = true
+	atomic.StoreInt64(&h.lastBeat, time.Now().UnixNano())
+}
+
+// Beat records progress of the send loop.
+func (h *RunHealth) Beat() {
+	if h == nil {
+		return
+	}
+	atomic.StoreInt64(&h.lastBeat, time.Now().UnixNano())
+}
+
+// loopStopped marks the send loop as finished.
+func (h *RunHealth) loopStopped() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sending = false
+}
+
+func (h *RunHealth) check() (healthy bool, ready bool, detail string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sending {
+		if stalled := time.Since(time.Unix(0, atomic.LoadInt64(&h.lastBeat))); stalled > livenessTimeout {
+			return false, h.ready, fmt.Sprintf("send loop stalled for %v", stalled.Round(time.Second))
+		}
+	}
+	return true, h.ready, ""
+}
+
+// HealthProbes are what the /healthz and /readyz probes report on: the
+// runs in progress, healthy unless the send loop of one stalled, and ready
+// once one is, or once the tester marked itself ready to accept runs.
+type HealthProbes struct {
+	mu    sync.Mutex
+	ready bool
+	runs  map[*RunHealth]struct{}
+}
+
+// NewHealthProbes returns the probes of a tester without runs.
+func NewHealthProbes() *HealthProbes {
+	return &HealthProbes{runs: map[*RunHealth]struct{}{}}
+}
+
+// SetReady marks the tester ready without a run, as an idle daemon is.
+func (p *HealthProbes) SetReady(ready bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ready = ready
+}
+
+func (p *HealthProbes) check() (healthy bool, ready bool, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	healthy, ready = true, p.ready
+	for h := range p.runs {
+		runHealthy, runReady, runDetail := h.check()
+		if !runHealthy {
+			healthy, detail = false, runDetail
+		}
+		ready = ready || runReady
+	}
+	return healthy, ready, detail
+}
+
+// HandleHealthz answers the liveness probe.
+func (p *HealthProbes) HandleHealthz(w http.ResponseWriter, _ *http.Request) {
+	healthy, _, detail := p.check()
+	if !healthy {
+		http.Error(w, detail, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// HandleReadyz answers the readiness probe.
+func (p *HealthProbes) HandleReadyz(w http.ResponseWriter, _ *http.Request) {
+	_, ready, _ := p.check()
+	if !ready {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
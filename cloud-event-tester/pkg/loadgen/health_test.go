@@ -0,0 +1,53 @@
+package loadgen
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthProbesRuns(t *testing.T) {
+	probes := NewHealthProbes()
+	ctx := WithHealthProbes(context.Background(), probes)
+	if healthy, ready, _ := probes.check(); !healthy || ready {
+		t.Errorf("probes without runs are healthy %v, ready %v, want healthy and not ready", healthy, ready)
+	}
+
+	a, b := NewRunHealth(ctx), NewRunHealth(ctx)
+	a.setReady(true)
+	a.loopStarted()
+	b.loopStarted()
+	if healthy, ready, _ := probes.check(); !healthy || !ready {
+		t.Errorf("probes of two running runs are healthy %v, ready %v, want both", healthy, ready)
+	}
+	// a run that stops does not stop the liveness check of the other
+	a.loopStopped()
+	a.close()
+	atomic.StoreInt64(&b.lastBeat, time.Now().Add(-2*livenessTimeout).UnixNano())
+	if healthy, ready, detail := probes.check(); healthy || ready || detail == "" {
+		t.Errorf("probes of a stalled run are healthy %v, ready %v (%q), want unhealthy and not ready", healthy, ready, detail)
+	}
+	b.Beat()
+	if healthy, _, _ := probes.check(); !healthy {
+		t.Errorf("probes of a run that beat again are unhealthy")
+	}
+	b.close()
+	probes.SetReady(true)
+	if healthy, ready, _ := probes.check(); !healthy || !ready {
+		t.Errorf("probes of an idle ready tester are healthy %v, ready %v, want both", healthy, ready)
+	}
+}
+
+func TestRunHealthWithoutProbes(t *testing.T) {
+	h := NewRunHealth(context.Background())
+	if h != nil {
+		t.Fatalf("NewRunHealth without probes = %v, want nil", h)
+	}
+	// a run without probes records nothing
+	h.setReady(true)
+	h.loopStarted()
+	h.Beat()
+	h.loopStopped()
+	h.close()
+}
@@ -0,0 +1,273 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// reportHTML is the format of the self-contained HTML report, see
+// WriteReportHTML.
+const reportHTML = "html"
+
+// newTickLatency returns the histogram of the latencies of the current
+// second of a performance run, for the latency percentiles of its timeline,
+// or nil if nothing reads them: the report file, the time series file and
+// the report pages of a results server.
+func newTickLatency(cfg *Config) *report.SharedHistogram {
+	if cfg.ReportFile == "" && cfg.TimeseriesFile == "" && cfg.ResultsServer == "" {
+		return nil
+	}
+	return report.NewSharedHistogram(nil)
+}
+
+// tickPercentiles adds the latency percentiles of the second that ended to
+// its stats and starts the next second.
+func tickPercentiles(h *report.SharedHistogram, tick *report.TickStats) {
+	if h == nil {
+		return
+	}
+	if l := h.Drain(); l != nil {
+		tick.P50, tick.P90, tick.P95, tick.P99, tick.Max = l.P50, l.P90, l.P95, l.P99, l.Max
+	}
+}
+
+// htmlCount is one row of the error breakdown of the HTML report, Share its
+// percentage of the largest count, for the width of its bar.
+type htmlCount struct {
+	Name  string
+	Count int
+	Share float64
+}
+
+// htmlReport is what the HTML report template is executed with.
+type htmlReport struct {
+	Report   *RunReport
+	Title    string
+	Duration string
+	Errors   []htmlCount
+	Config   string
+	// Chart is the timeline and the requested rate as the script of the
+	// charts reads them
+	Chart htmlChart
+}
+
+// htmlChart is the data of the charts of the HTML report.
+type htmlChart struct {
+	Requested float64            `json:"requested"`
+	Timeline  []report.TickStats `json:"timeline"`
+}
+
+// htmlCounts returns the error breakdown of a run: the failed sends by
+// kind and the responses that failed an assertion, the largest first.
+func htmlCounts(r *report.Result) []htmlCount {
+	var counts []htmlCount
+	for kind, n := range r.Errors {
+		counts = append(counts, htmlCount{Name: kind, Count: n})
+	}
+	for kind, n := range r.AssertionFailures {
+		counts = append(counts, htmlCount{Name: "assertion: " + kind, Count: n})
+	}
+	if r.SchemaViolations > 0 {
+		counts = append(counts, htmlCount{Name: "schema violation", Count: r.SchemaViolations})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	for i := range counts {
+		counts[i].Share = 100 * float64(counts[i].Count) / float64(counts[0].Count)
+	}
+	return counts
+}
+
+// WriteReportHTML writes a report as a single HTML page for the people who
+// will not read the log or the JSON: the totals, charts of the rate, errors
+// and latency percentiles of every second, the error breakdown, the checks
+// and the configuration. The charts are drawn by a script in the page, so it
+// can be shared as one file and opened without a network.
+func WriteReportHTML(w io.Writer, rep *RunReport) error {
+	r := rep.Result
+	config, err := json.MarshalIndent(rep.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	data := htmlReport{
+		Report:   rep,
+		Title:    "Cloud Event Tester Report",
+		Duration: (time.Duration(r.TotalSeconds * float64(time.Second))).Round(time.Millisecond).String(),
+		Errors:   htmlCounts(r),
+		Config:   string(config),
+		Chart:    htmlChart{Requested: r.RequestedRate, Timeline: r.Timeline},
+	}
+	return htmlReportTemplate.Execute(w, data)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"labels": events.FormatLabels,
+	"ms": func(v float64) string {
+		return fmt.Sprintf("%.3f ms", v)
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; max-width: 1000px; }
+h2 { margin-top: 32px; border-bottom: 1px solid #ddd; padding-bottom: 4px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.num { text-align: right; }
+.cards { display: flex; flex-wrap: wrap; gap: 12px; }
+.card { border: 1px solid #ddd; border-radius: 4px; padding: 8px 16px; min-width: 120px; }
+.card b { display: block; font-size: 1.4em; }
+.passed { color: #2a7d2a; }
+.failed { color: #c0392b; }
+.bar { background: #c0392b; height: 12px; }
+.chart { position: relative; }
+.chart svg { font-size: 11px; }
+.tip { position: absolute; pointer-events: none; background: #fff; border: 1px solid #999; padding: 4px; font-size: 12px; display: none; white-space: nowrap; }
+pre { background: #f6f6f6; padding: 8px; overflow-x: auto; }
+</style>
+</head>
+<body>
+{{with .Report}}<h1>{{$.Title}}</h1>
+<p>{{.Result.Mode}} run against {{.Config.URL}} from {{.Host}}, {{time .Result.StartTime}} to {{time .Result.EndTime}} ({{$.Duration}}){{if .Result.Interrupted}}, <span class="failed">interrupted</span>{{end}}{{if .Result.Labels}}<br>Labels: {{labels .Result.Labels}}{{end}}{{if .Result.ReportURL}}<br>Stored at <a href="{{.Result.ReportURL}}">{{.Result.ReportURL}}</a>{{end}}</p>
+
+<h2>Summary</h2>
+<div class="cards">
+<div class="card">Messages<b>{{.Result.TotalMsg}}</b></div>
+{{if eq .Result.Mode "basic"}}<div class="card">Succeeded<b>{{.Result.Succeeded}} of {{.Result.Files}}</b></div>{{end}}
+{{if .Result.AvgRate}}<div class="card">Average rate<b>{{printf "%.1f" .Result.AvgRate}} msg/s</b></div>{{end}}
+{{if .Result.RequestedRate}}<div class="card">Requested rate<b>{{printf "%.1f" .Result.RequestedRate}} msg/s</b></div>{{end}}
+<div class="card">Error rate<b>{{printf "%.3f" .Result.ErrorRate}}%</b></div>
+{{with .Result.Latency}}<div class="card">p50 latency<b>{{ms .P50}}</b></div>
+<div class="card">p99 latency<b>{{ms .P99}}</b></div>{{end}}
+{{if .Result.Connections}}<div class="card">Connections<b>{{.Result.Connections}}</b></div>{{end}}
+</div>
+{{end}}
+{{if .Chart.Timeline}}<h2>Rate</h2>
+<div class="chart" id="rate"></div>
+<h2>Latency Percentiles</h2>
+<div class="chart" id="latency"></div>
+{{end}}
+{{with .Report.Result.Latency}}<h2>Latency</h2>
+<table>
+<tr><th>min</th><th>mean</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>p99.9</th><th>max</th></tr>
+<tr><td class="num">{{ms .Min}}</td><td class="num">{{ms .Mean}}</td><td class="num">{{ms .P50}}</td><td class="num">{{ms .P90}}</td><td class="num">{{ms .P95}}</td><td class="num">{{ms .P99}}</td><td class="num">{{ms .P999}}</td><td class="num">{{ms .Max}}</td></tr>
+</table>
+{{end}}
+<h2>Errors</h2>
+{{if .Errors}}<table>
+<tr><th>Kind</th><th>Count</th><th></th></tr>
+{{range .Errors}}<tr><td>{{.Name}}</td><td class="num">{{.Count}}</td><td style="width: 300px"><div class="bar" style="width: {{printf "%.1f" .Share}}%"></div></td></tr>
+{{end}}</table>
+{{else}}<p class="passed">No errors.</p>
+{{end}}
+{{with .Report.Result.Checks}}<h2>Checks</h2>
+<table>
+<tr><th>Check</th><th>Result</th><th>Message</th></tr>
+{{range .}}<tr><td>{{.Name}}{{if .SLA}} (SLA){{end}}</td><td>{{if .Passed}}<span class="passed">passed</span>{{else}}<span class="failed">failed</span>{{end}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Configuration</h2>
+<pre>{{.Config}}</pre>
+{{if .Chart.Timeline}}<script>
+const chart = {{.Chart}};
+const svgNS = "http://www.w3.org/2000/svg";
+
+function el(name, attrs, parent) {
+  const e = document.createElementNS(svgNS, name);
+  for (const k in attrs) e.setAttribute(k, attrs[k]);
+  if (parent) parent.appendChild(e);
+  return e;
+}
+
+// lineChart draws the series, each {name, color, values}, one value per
+// second of the timeline, with a legend and the values under the pointer.
+function lineChart(id, unit, series) {
+  const W = 960, H = 280, L = 64, R = 16, T = 16, B = 40;
+  const host = document.getElementById(id);
+  const seconds = chart.timeline.map(t => t.second);
+  let max = 0;
+  for (const s of series) for (const v of s.values) if (v > max) max = v;
+  max = max > 0 ? max * 1.1 : 1;
+  const lastX = seconds[seconds.length - 1] || 1;
+  const x = sec => L + (W - L - R) * (seconds.length > 1 ? (sec - seconds[0]) / (lastX - seconds[0]) : 0.5);
+  const y = v => T + (H - T - B) * (1 - v / max);
+  const svg = el("svg", {width: W, height: H, viewBox: "0 0 " + W + " " + H}, host);
+  for (let i = 0; i <= 4; i++) {
+    const v = max * i / 4;
+    el("line", {x1: L, x2: W - R, y1: y(v), y2: y(v), stroke: "#eee"}, svg);
+    el("text", {x: L - 6, y: y(v) + 4, "text-anchor": "end"}, svg).textContent = v === 0 ? "0" : v.toFixed(v < 10 ? 2 : 0);
+  }
+  const ticks = Math.min(10, seconds.length);
+  for (let i = 0; i < ticks; i++) {
+    const sec = seconds[Math.round(i * (seconds.length - 1) / Math.max(ticks - 1, 1))];
+    el("text", {x: x(sec), y: H - B + 16, "text-anchor": "middle"}, svg).textContent = sec + "s";
+  }
+  el("text", {x: 12, y: T + (H - T - B) / 2, transform: "rotate(-90 12 " + (T + (H - T - B) / 2) + ")", "text-anchor": "middle"}, svg).textContent = unit;
+  series.forEach((s, i) => {
+    const points = s.values.map((v, j) => x(seconds[j]) + "," + y(v)).join(" ");
+    el("polyline", {points: points, fill: "none", stroke: s.color, "stroke-width": 1.5, "stroke-dasharray": s.dashed ? "4 3" : ""}, svg);
+    el("rect", {x: L + i * 140, y: H - 14, width: 10, height: 10, fill: s.color}, svg);
+    el("text", {x: L + i * 140 + 14, y: H - 5}, svg).textContent = s.name;
+  });
+  const cursor = el("line", {y1: T, y2: H - B, stroke: "#999", visibility: "hidden"}, svg);
+  const tip = document.createElement("div");
+  tip.className = "tip";
+  host.appendChild(tip);
+  svg.addEventListener("mousemove", ev => {
+    const px = (ev.clientX - svg.getBoundingClientRect().left) * W / svg.getBoundingClientRect().width;
+    let j = 0;
+    for (let k = 0; k < seconds.length; k++) if (Math.abs(x(seconds[k]) - px) < Math.abs(x(seconds[j]) - px)) j = k;
+    cursor.setAttribute("x1", x(seconds[j]));
+    cursor.setAttribute("x2", x(seconds[j]));
+    cursor.setAttribute("visibility", "visible");
+    tip.innerHTML = "";
+    tip.appendChild(document.createTextNode("second " + seconds[j]));
+    for (const s of series) {
+      tip.appendChild(document.createElement("br"));
+      tip.appendChild(document.createTextNode(s.name + ": " + (+s.values[j].toFixed(3)) + " " + unit));
+    }
+    tip.style.display = "block";
+    tip.style.left = (x(seconds[j]) * svg.getBoundingClientRect().width / W + 12) + "px";
+    tip.style.top = "8px";
+  });
+  svg.addEventListener("mouseleave", () => {
+    cursor.setAttribute("visibility", "hidden");
+    tip.style.display = "none";
+  });
+}
+
+const rate = [{name: "sent", color: "#2c7fb8", values: chart.timeline.map(t => t.sent)},
+  {name: "errors", color: "#c0392b", values: chart.timeline.map(t => t.errors || 0)}];
+if (chart.timeline.some(t => t.rate)) {
+  rate.push({name: "allowed rate", color: "#7f8c8d", values: chart.timeline.map(t => t.rate || 0)});
+} else if (chart.requested) {
+  rate.push({name: "requested", color: "#7f8c8d", dashed: true, values: chart.timeline.map(() => chart.requested)});
+}
+lineChart("rate", "msg/s", rate);
+if (chart.timeline.some(t => t.p50)) {
+  lineChart("latency", "ms", [{name: "p50", color: "#27ae60", values: chart.timeline.map(t => t.p50 || 0)},
+    {name: "p90", color: "#f39c12", values: chart.timeline.map(t => t.p90 || 0)},
+    {name: "p99", color: "#c0392b", values: chart.timeline.map(t => t.p99 || 0)}]);
+} else {
+  document.getElementById("latency").textContent = "No latency was measured.";
+}
+</script>
+{{end}}</body>
+</html>
+`))
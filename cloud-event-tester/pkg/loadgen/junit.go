@@ -0,0 +1,83 @@
+package loadgen
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// PerfChecks returns the checks of a performance run: that it ran to the
+// end, sent without errors and achieved the requested rate, and that its
+// events matched their schemas if it has any. The rate is not
+// checked when a shared token bucket sets it or an SLA threshold replaces
+// it.
+func PerfChecks(cfg *Config, result *report.Result, shared bool) []report.CheckResult {
+	failed, asserted := 0, 0
+	for _, n := range result.Errors {
+		failed += n
+	}
+	for _, n := range result.AssertionFailures {
+		asserted += n
+	}
+	checks := []report.CheckResult{
+		report.CheckResult{Name: "completed"}.PassIf(!result.Interrupted, "ran %.3f seconds", result.TotalSeconds),
+		report.CheckResult{Name: "send errors"}.PassIf(failed == 0, "%d sends failed", failed),
+		report.CheckResult{Name: "response assertions"}.PassIf(asserted == 0, "%d responses failed an assertion", asserted),
+	}
+	if cfg.SchemaDir != "" || len(cfg.Schemas) > 0 {
+		checks = append(checks, report.CheckResult{Name: "event schemas"}.PassIf(result.SchemaViolations == 0, "%d events violated their schemas", result.SchemaViolations))
+	}
+	if !shared && result.RequestedRate > 0 && cfg.MinAchievedRate == 0 {
+		achieved := result.AchievedRate()
+		checks = append(checks, report.CheckResult{Name: "rate"}.PassIf(achieved >= minAchievedRate,
+			"%.2f of %.2f msg/sec requested (%.1f%%, at least %d%% needed)", result.AvgRate, result.RequestedRate, achieved, minAchievedRate))
+	}
+	checks = append(checks, faultChecks(result)...)
+	checks = append(checks, soakChecks(result)...)
+	checks = append(checks, breakerChecks(result)...)
+	return checks
+}
+
+// WriteJUnitFile writes the checks of a run as a JUnit XML report, if a
+// JUnit file is configured, so CI systems such as Jenkins and Prow show
+// failed event files and checks natively. Failures are logged; they do not
+// fail the run.
+func WriteJUnitFile(cfg *Config, result *report.Result) {
+	if cfg.JUnitFile == "" || result == nil {
+		return
+	}
+	host, _ := os.Hostname()
+	suite := report.JUnitTestSuite{
+		Name:      "cloud-event-tester " + result.Mode,
+		Tests:     len(result.Checks),
+		Time:      fmt.Sprintf("%.3f", result.EndTime.Sub(result.StartTime).Seconds()),
+		Timestamp: result.StartTime.UTC().Format("2006-01-02T15:04:05"),
+		Hostname:  host,
+	}
+	class := "cloud-event-tester." + result.Mode
+	for _, c := range result.Checks {
+		tc := report.JUnitTestCase{Name: c.Name, ClassName: class, Time: fmt.Sprintf("%.3f", c.Seconds)}
+		if c.Passed {
+			tc.SystemOut = c.Message
+		} else {
+			suite.Failures++
+			tc.Failure = &report.JUnitFailure{Message: c.Message, Text: c.Message}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	data, err := xml.MarshalIndent(report.JUnitTestSuites{Suites: []report.JUnitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		log.Errorf("Failed to write JUnit report: %v", err)
+		return
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := os.WriteFile(cfg.JUnitFile, data, 0o644); err != nil {
+		log.Errorf("Failed to write JUnit report: %v", err)
+		return
+	}
+	log.Infof("JUnit report: %s, %d of %d checks failed", cfg.JUnitFile, suite.Failures, suite.Tests)
+}
@@ -0,0 +1,448 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// KubeClient is a minimal client for the Kubernetes REST API, configured
+// either from the pod's service account or from a kubeconfig file.
+type KubeClient struct {
+	Server    string
+	token     string
+	Namespace string
+	http      *http.Client
+}
+
+// NewKubeClient creates a client from the given kubeconfig file and context,
+// falling back to $KUBECONFIG, the in-cluster service account (unless a
+// context is given) and ~/.kube/config.
+func NewKubeClient(kubeconfig, contextName string) (*KubeClient, error) {
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+	if kubeconfig == "" && contextName == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return inClusterKubeClient()
+	}
+	if kubeconfig == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig = filepath.Join(home, ".kube", "config")
+	}
+	return kubeconfigClient(kubeconfig, contextName)
+}
+
+func inClusterKubeClient() (*KubeClient, error) {
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in service account CA")
+	}
+	host := net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	return &KubeClient{
+		Server:    "https://" + host,
+		token:     strings.TrimSpace(string(token)),
+		Namespace: strings.TrimSpace(string(namespace)),
+		http:      newKubeHTTPClient(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}),
+	}, nil
+}
+
+// kubeconfig is the subset of the kubeconfig file format used by the tester.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// kubeconfigClient creates a client for the given context of a kubeconfig
+// file, or its current context if contextName is empty.
+func kubeconfigClient(path, contextName string) (*KubeClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %s: %w", path, err)
+	}
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	dir := filepath.Dir(path)
+
+	c := &KubeClient{Namespace: "default"}
+	clusterName, userName := "", ""
+	found := false
+	for _, ctx := range kc.Contexts {
+		if ctx.Name == contextName {
+			clusterName, userName = ctx.Context.Cluster, ctx.Context.User
+			if ctx.Context.Namespace != "" {
+				c.Namespace = ctx.Context.Namespace
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig %s", contextName, path)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		c.Server = strings.TrimSuffix(cl.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify //nolint: gosec
+		ca, err := kubeconfigData(cl.Cluster.CertificateAuthorityData, cl.Cluster.CertificateAuthority, dir)
+		if err != nil {
+			return nil, err
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificates found in CA of cluster %s", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if c.Server == "" {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig %s", clusterName, path)
+	}
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		c.token = u.User.Token
+		if u.User.TokenFile != "" {
+			token, err := os.ReadFile(resolvePath(u.User.TokenFile, dir))
+			if err != nil {
+				return nil, err
+			}
+			c.token = strings.TrimSpace(string(token))
+		}
+		cert, err := kubeconfigData(u.User.ClientCertificateData, u.User.ClientCertificate, dir)
+		if err != nil {
+			return nil, err
+		}
+		key, err := kubeconfigData(u.User.ClientKeyData, u.User.ClientKey, dir)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	c.http = newKubeHTTPClient(tlsConfig)
+	return c, nil
+}
+
+// kubeconfigData returns inline base64 data or the content of the referenced
+// file, or nil if neither is set.
+func kubeconfigData(inline, file, dir string) ([]byte, error) {
+	if inline != "" {
+		return base64.StdEncoding.DecodeString(inline)
+	}
+	if file != "" {
+		return os.ReadFile(resolvePath(file, dir))
+	}
+	return nil, nil
+}
+
+// resolvePath resolves paths relative to the kubeconfig file, like kubectl.
+func resolvePath(path, dir string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func newKubeHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+}
+
+// Get fetches an API path and decodes the JSON response into out.
+func (c *KubeClient) Get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	resp, err := c.do(ctx, c.http, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer sender.DrainBody(resp.Body)
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Create posts an object to the collection at path.
+func (c *KubeClient) Create(ctx context.Context, path string, obj interface{}) error {
+	resp, err := c.do(ctx, c.http, http.MethodPost, path, nil, obj)
+	if err != nil {
+		return err
+	}
+	sender.DrainBody(resp.Body)
+	return nil
+}
+
+// Delete deletes the object at path, along with its dependents.
+func (c *KubeClient) Delete(ctx context.Context, path string) error {
+	resp, err := c.do(ctx, c.http, http.MethodDelete, path, url.Values{"propagationPolicy": {"Background"}}, nil)
+	if err != nil {
+		return err
+	}
+	sender.DrainBody(resp.Body)
+	return nil
+}
+
+// Stream opens an API path for reading as long as the server sends, like the
+// logs of a pod that are followed.
+func (c *KubeClient) Stream(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	hc := *c.http
+	hc.Timeout = 0
+	resp, err := c.do(ctx, &hc, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do sends a request to the API and returns the response if it succeeded.
+// body, if not nil, is sent as JSON.
+func (c *KubeClient) do(ctx context.Context, hc *http.Client, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	u := c.Server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer sender.DrainBody(resp.Body)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// The types below are the parts of the API responses used for discovery.
+
+type podList struct {
+	Items []struct {
+		Metadata ObjectMeta `json:"metadata"`
+		Status   struct {
+			Phase      string `json:"phase"`
+			PodIP      string `json:"podIP"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type endpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+type endpointSliceList struct {
+	Items []struct {
+		AddressType string `json:"addressType"`
+		Endpoints   []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+		} `json:"endpoints"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"items"`
+}
+
+// readyPodIPs returns the IPs of the running and ready pods matching the
+// label selector.
+func (c *KubeClient) readyPodIPs(ctx context.Context, namespace, selector string) ([]string, error) {
+	var pods podList
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods", url.PathEscape(namespace))
+	if err := c.Get(ctx, path, url.Values{"labelSelector": {selector}}, &pods); err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != "Running" || pod.Status.PodIP == "" {
+			continue
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == "Ready" && cond.Status == "True" {
+				ips = append(ips, pod.Status.PodIP)
+				break
+			}
+		}
+	}
+	return ips, nil
+}
+
+// serviceEndpoints returns the ready ip:port addresses behind a service,
+// from its EndpointSlices, or its Endpoints on clusters without the
+// discovery API. port is the name or number of a port of the service, the
+// first one if empty.
+func (c *KubeClient) serviceEndpoints(ctx context.Context, namespace, name, port string) ([]string, error) {
+	var slices endpointSliceList
+	path := fmt.Sprintf("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices", url.PathEscape(namespace))
+	if err := c.Get(ctx, path, url.Values{"labelSelector": {"kubernetes.io/service-name=" + name}}, &slices); err == nil {
+		var addrs []string
+		for _, slice := range slices.Items {
+			if slice.AddressType == "FQDN" {
+				continue
+			}
+			p, ok := endpointPort(port, len(slice.Ports), func(i int) (string, int) { return slice.Ports[i].Name, slice.Ports[i].Port })
+			if !ok {
+				continue
+			}
+			for _, ep := range slice.Endpoints {
+				// a nil condition is ready
+				if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+					continue
+				}
+				for _, ip := range ep.Addresses {
+					addrs = append(addrs, net.JoinHostPort(ip, fmt.Sprint(p)))
+				}
+			}
+		}
+		return addrs, nil
+	}
+
+	var ep endpoints
+	path = fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", url.PathEscape(namespace), url.PathEscape(name))
+	if err := c.Get(ctx, path, nil, &ep); err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, subset := range ep.Subsets {
+		p, ok := endpointPort(port, len(subset.Ports), func(i int) (string, int) { return subset.Ports[i].Name, subset.Ports[i].Port })
+		if !ok {
+			continue
+		}
+		for _, a := range subset.Addresses {
+			addrs = append(addrs, net.JoinHostPort(a.IP, fmt.Sprint(p)))
+		}
+	}
+	return addrs, nil
+}
+
+// endpointPort returns the port of the n ports of an EndpointSlice or an
+// Endpoints subset, looked up by port: a name, a number, which is used as
+// is, or the first port if empty.
+func endpointPort(port string, n int, portAt func(i int) (string, int)) (int, bool) {
+	if number, err := strconv.Atoi(port); err == nil {
+		return number, true
+	}
+	for i := 0; i < n; i++ {
+		if name, number := portAt(i); port == "" || name == port {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// ObjectMeta is the metadata of the Kubernetes API objects the tester reads
+// and writes. Like the other objects of the manifests it only carries json
+// tags.
+type ObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
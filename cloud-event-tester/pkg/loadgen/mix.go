@@ -0,0 +1,210 @@
+package loadgen
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// mixEventName names the events of an event mix in the stats and checks of
+// a run, as the file of every send differs.
+const mixEventName = "mix"
+
+// weightsFile is the manifest of -event-weights:
+//
+//	events:
+//	  - file: TMP0100.json
+//	    weight: 8
+//	  - file: FAN0001.json
+//	    weight: 2
+//
+// Files are found relative to the manifest; a weight of 0 leaves a file out.
+type weightsFile struct {
+	Events []struct {
+		File   string   `yaml:"file"`
+		Weight *float64 `yaml:"weight"`
+	} `yaml:"events"`
+}
+
+// mixEvent is one event file of a mix, with the cumulative weight of the
+// files up to it.
+type mixEvent struct {
+	name   string
+	event  []byte
+	tmpl   *events.Template
+	weight float64
+	cumul  float64
+}
+
+// eventMix sends a weighted random choice of event files with every send of
+// a performance run, instead of the one event file, as real traffic is a mix
+// of event types: every file of the data directory with the same weight, or
+// the files of a weights manifest. Files with placeholders are rendered as
+// templates, sharing the sequence of the run. It is the Generator of the
+// run, so its events are labeled, mutated, batched and typed like generated
+// ones.
+type eventMix struct {
+	events []mixEvent
+	seq    int64
+}
+
+// weightedFile is an event file of a mix with its weight.
+type weightedFile struct {
+	file   string
+	weight float64
+}
+
+// mixFiles returns the event files of the mix of a run with their weights:
+// those of the weights manifest, or every file of the data directory.
+func mixFiles(cfg *Config) ([]weightedFile, error) {
+	var files []weightedFile
+	if cfg.EventWeights != "" {
+		data, err := os.ReadFile(cfg.EventWeights)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read weights file: %w", err)
+		}
+		var f weightsFile
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse weights file %s: %w", cfg.EventWeights, err)
+		}
+		for i, e := range f.Events {
+			if e.File == "" {
+				return nil, fmt.Errorf("weights file %s: event %d has no file", cfg.EventWeights, i+1)
+			}
+			w := 1.0
+			if e.Weight != nil {
+				w = *e.Weight
+			}
+			if w < 0 {
+				return nil, fmt.Errorf("weights file %s: %s has a negative weight %g", cfg.EventWeights, e.File, w)
+			}
+			file := e.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(cfg.EventWeights), file)
+			}
+			files = append(files, weightedFile{file, w})
+		}
+	} else {
+		names, err := EventFiles(cfg)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range names {
+			files = append(files, weightedFile{file, 1})
+		}
+	}
+	return files, nil
+}
+
+func newEventMix(cfg *Config) (*eventMix, error) {
+	files, err := mixFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := &eventMix{}
+	var total float64
+	for _, f := range files {
+		if f.weight == 0 {
+			continue
+		}
+		if err := checkFileSize(f.file, cfg.MaxBodyBytes); err != nil {
+			return nil, err
+		}
+		event, err := events.ReadEventFile(f.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read event file %s: %w", f.file, err)
+		}
+		name := filepath.Base(f.file)
+		t, err := events.ParseTemplate(name, event, &m.seq)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			// fail before the run rather than on every send
+			if _, err := events.RenderEvent(name, event, new(int64)); err != nil {
+				return nil, err
+			}
+		}
+		total += f.weight
+		m.events = append(m.events, mixEvent{name: name, event: event, tmpl: t, weight: f.weight, cumul: total})
+	}
+	if len(m.events) == 0 {
+		return nil, noMixEvents(cfg)
+	}
+	return m, nil
+}
+
+// noMixEvents is the error of a mix without events.
+func noMixEvents(cfg *Config) error {
+	if cfg.EventWeights != "" {
+		return fmt.Errorf("weights file %s has no event with a positive weight", cfg.EventWeights)
+	}
+	return fmt.Errorf("no event files found in %s to mix", cfg.DataDir)
+}
+
+func (c *Config) isEventMix() bool {
+	return c.EventMix || c.EventWeights != ""
+}
+
+// validateEventMix checks the event mix settings of a run.
+func (c *Config) validateEventMix() error {
+	switch {
+	case !c.isEventMix():
+		return nil
+	case !c.IsPerf():
+		return fmt.Errorf("an event mix is sent by performance runs only")
+	case c.EventFile != "":
+		return fmt.Errorf("an event mix replaces the event file, it cannot be combined with -event-file")
+	case c.Generator != "":
+		return fmt.Errorf("an event mix replaces the event files, it cannot be combined with a generator")
+	}
+	return nil
+}
+
+// Next writes a weighted random choice of the events to buf.
+func (m *eventMix) Next(buf *bytes.Buffer) error {
+	e := &m.events[0]
+	if len(m.events) > 1 {
+		total := m.events[len(m.events)-1].cumul
+		r := rand.Float64() * total
+		e = &m.events[sort.Search(len(m.events), func(i int) bool { return m.events[i].cumul > r })]
+	}
+	if e.tmpl != nil {
+		return e.tmpl.Render(buf)
+	}
+	buf.Reset()
+	buf.Write(e.event)
+	return nil
+}
+
+// Sequence returns the events of the mix once each, in order.
+func (m *eventMix) Sequence() ([][]byte, error) {
+	seq := make([][]byte, len(m.events))
+	for i := range m.events {
+		event, err := events.RenderEvent(m.events[i].name, m.events[i].event, &m.seq)
+		if err != nil {
+			return nil, err
+		}
+		seq[i] = event
+	}
+	return seq, nil
+}
+
+// log logs the files of the mix and their share of the sends.
+func (m *eventMix) log() {
+	total := m.events[len(m.events)-1].cumul
+	shares := make([]string, len(m.events))
+	for i, e := range m.events {
+		shares[i] = fmt.Sprintf("%s %.1f%%", e.name, 100*e.weight/total)
+	}
+	log.Infof("Event Mix: %d event files, %s", len(m.events), strings.Join(shares, ", "))
+}
@@ -0,0 +1,121 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// notification statuses
+const (
+	notifyCompleted   = "completed"
+	notifyInterrupted = "interrupted"
+	notifyFailed      = "failed"
+)
+
+// notification is the generic JSON payload posted on run completion.
+type notification struct {
+	Status    string     `json:"status"`
+	Summary   string     `json:"summary"`
+	ReportURL string     `json:"reportUrl,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Report    *RunReport `json:"report,omitempty"`
+}
+
+// slackMessage is the payload of Slack-compatible incoming webhooks.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// NotifyCompletion posts the summary of a finished run to the notification
+// webhook, if one is configured. Failures are logged; they do not fail the
+// run.
+func NotifyCompletion(cfg *Config, result *report.Result, runErr error) {
+	if cfg.NotifyURL == "" {
+		return
+	}
+	n := &notification{Status: notifyCompleted}
+	switch {
+	case runErr != nil:
+		n.Status = notifyFailed
+		n.Error = runErr.Error()
+	case result.Interrupted:
+		n.Status = notifyInterrupted
+	}
+	if strings.ToLower(cfg.NotifyOn) == "failure" && n.Status == notifyCompleted {
+		return
+	}
+	if result != nil {
+		n.ReportURL = result.ReportURL
+		n.Report = NewRunReport(cfg, result)
+	}
+	n.Summary = n.summary(cfg, result)
+
+	var payload interface{} = n
+	if strings.ToLower(cfg.NotifyFormat) == "slack" {
+		text := n.Summary
+		if n.ReportURL != "" {
+			text += "\nReport: " + n.ReportURL
+		}
+		payload = slackMessage{Text: text}
+	}
+	if err := postJSON(cfg.NotifyURL, payload); err != nil {
+		log.Errorf("Failed to send notification to %s: %v", cfg.NotifyURL, err)
+		return
+	}
+	log.Infof("Sent %s notification to %s", n.Status, cfg.NotifyURL)
+}
+
+func (n *notification) summary(cfg *Config, result *report.Result) string {
+	host, _ := os.Hostname()
+	var b strings.Builder
+	if n.Status == notifyFailed {
+		fmt.Fprintf(&b, "cloud-event-tester run on %s failed: %s", host, n.Error)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "cloud-event-tester %s run on %s %s: %d msg", result.Mode, host, n.Status, result.TotalMsg)
+	if result.Mode == "perf" {
+		fmt.Fprintf(&b, " in %.1f s (%.2f msg/s)", result.TotalSeconds, result.AvgRate)
+	} else {
+		fmt.Fprintf(&b, ", %d succeeded", result.Succeeded)
+	}
+	fmt.Fprintf(&b, " to %s", cfg.URL)
+	if len(result.Labels) > 0 {
+		fmt.Fprintf(&b, " [%s]", events.FormatLabels(result.Labels))
+	}
+	return b.String()
+}
+
+func postJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer sender.DrainBody(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
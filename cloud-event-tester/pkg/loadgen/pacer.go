@@ -0,0 +1,439 @@
+package loadgen
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pacing strategies selectable with -pacing
+const (
+	pacingUniform     = "uniform"
+	pacingTokenBucket = "token-bucket"
+	pacingLeakyBucket = "leaky-bucket"
+)
+
+// load models selectable with -load-model
+const (
+	loadOpen   = "open"
+	loadClosed = "closed"
+)
+
+// distributions of the gaps between sends selectable with -distribution
+const (
+	distConstant = "constant"
+	distPoisson  = "poisson"
+	distUniform  = "uniform"
+)
+
+// spinWindow is how long before a send is due the pacer stops sleeping and
+// spins. Timers wake up tens of microseconds to a millisecond late; spinning
+// over the last stretch makes the sends accurate to tens of microseconds.
+const spinWindow = 200 * time.Microsecond
+
+// maxCatchUp is the longest stall the uniform pacer makes up for. Sends
+// missed beyond it are skipped instead of being sent in one burst.
+const maxCatchUp = 100 * time.Millisecond
+
+// pacer decides when the sends of a loop are due.
+type pacer interface {
+	// wait blocks until sends are due and returns how many are due now. It
+	// returns 0 once stop is closed.
+	wait(stop <-chan struct{}) int
+	// skipped returns the number of sends dropped because the loop fell
+	// behind.
+	skipped() int64
+}
+
+// newPacer returns the pacer of the given strategy for rate sends per
+// period. bucket is the capacity of the token bucket, 0 for a tenth of the
+// rate.
+func newPacer(strategy string, rate, bucket int, period time.Duration) pacer {
+	switch strategy {
+	case pacingTokenBucket:
+		return newTokenPacer(float64(rate)/period.Seconds(), tokenBucket(rate, bucket))
+	case pacingLeakyBucket:
+		return &leakyPacer{interval: period / time.Duration(rate), next: time.Now()}
+	}
+	return &uniformPacer{rate: int64(rate), period: int64(period), start: time.Now()}
+}
+
+// tokenBucket returns the capacity of the token bucket of rate sends per
+// period: bucket, or a tenth of the rate if it is 0, at least one.
+func tokenBucket(rate, bucket int) int {
+	if bucket <= 0 {
+		bucket = rate / 10
+	}
+	if bucket < 1 {
+		bucket = 1
+	}
+	return bucket
+}
+
+// sleepUntil waits until t, spinning over the last spinWindow if spin is
+// set. It returns false if stop is closed first.
+func sleepUntil(t time.Time, stop <-chan struct{}, spin bool) bool {
+	d := time.Until(t)
+	if spin {
+		d -= spinWindow
+	}
+	if d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+	for spin && time.Now().Before(t) {
+		runtime.Gosched()
+	}
+	select {
+	case <-stop:
+		return false
+	default:
+		return true
+	}
+}
+
+// uniformPacer spaces the sends evenly. The due time of send n is computed
+// from the start, so rounding errors do not add up over long runs, and after
+// a stall the loop catches up in a batch.
+type uniformPacer struct {
+	// rate sends per period nanoseconds
+	rate, period int64
+	start        time.Time
+	// n is the number of sends scheduled so far
+	n int64
+	// dropped counts the sends skipped after stalls longer than maxCatchUp
+	dropped int64
+}
+
+// due returns the time send n is due. The arithmetic is split in periods
+// and the rest so it does not overflow on long runs at high rates.
+func (p *uniformPacer) due(n int64) time.Time {
+	return p.start.Add(time.Duration(n/p.rate*p.period + n%p.rate*p.period/p.rate))
+}
+
+// count returns the number of sends due up to t.
+func (p *uniformPacer) count(t time.Time) int64 {
+	elapsed := int64(t.Sub(p.start))
+	periods, rest := elapsed/p.period, elapsed%p.period
+	return periods*p.rate + rest*p.rate/p.period + 1
+}
+
+func (p *uniformPacer) wait(stop <-chan struct{}) int {
+	if !sleepUntil(p.due(p.n), stop, true) {
+		return 0
+	}
+	due := p.count(time.Now()) - p.n
+	if due < 1 {
+		due = 1
+	}
+	if limit := int64(maxCatchUp)*p.rate/p.period + 1; due > limit {
+		// too far behind, skip what cannot be made up for
+		p.dropped += due - limit
+		p.n += due - limit
+		due = limit
+	}
+	p.n += due
+	return int(due)
+}
+
+func (p *uniformPacer) skipped() int64 {
+	return p.dropped
+}
+
+// tokenPacer is a token bucket: tokens accrue at the rate up to the capacity
+// of the bucket, and every token available is sent at once. The bucket
+// starts full and refills while the loop is held up, so sends come in bursts
+// of up to the capacity. It sleeps instead of spinning, which bunches sends
+// to the timer resolution at high rates.
+type tokenPacer struct {
+	rate   float64
+	bucket float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenPacer returns the token bucket of rate sends per second.
+func newTokenPacer(rate float64, bucket int) *tokenPacer {
+	return &tokenPacer{rate: rate, bucket: float64(bucket), tokens: float64(bucket), last: time.Now()}
+}
+
+func (p *tokenPacer) wait(stop <-chan struct{}) int {
+	for {
+		now := time.Now()
+		p.tokens += now.Sub(p.last).Seconds() * p.rate
+		p.last = now
+		if p.tokens > p.bucket {
+			p.tokens = p.bucket
+		}
+		if p.tokens >= 1 {
+			n := int(p.tokens)
+			p.tokens -= float64(n)
+			return n
+		}
+		next := now.Add(time.Duration((1 - p.tokens) / p.rate * float64(time.Second)))
+		if !sleepUntil(next, stop, false) {
+			return 0
+		}
+	}
+}
+
+func (p *tokenPacer) skipped() int64 {
+	return 0
+}
+
+// leakySlack is how many sends the leaky bucket pacer makes up for when the
+// loop runs late, so that timer jitter does not lower the rate.
+const leakySlack = 10
+
+// leakyPacer is a leaky bucket: sends leave one at a time at a constant
+// interval. When the loop runs late it makes up for at most leakySlack
+// sends; after a longer stall the schedule continues from the time the loop
+// resumed, so the missed sends are neither sent in a burst nor counted.
+type leakyPacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func (p *leakyPacer) wait(stop <-chan struct{}) int {
+	if !sleepUntil(p.next, stop, true) {
+		return 0
+	}
+	if slack := time.Now().Add(-leakySlack * p.interval); p.next.Before(slack) {
+		p.next = slack
+	}
+	p.next = p.next.Add(p.interval)
+	return 1
+}
+
+func (p *leakyPacer) skipped() int64 {
+	return 0
+}
+
+// minAchievedRate is the percentage of the requested rate below which a run
+// is reported as falling short.
+const minAchievedRate = 98
+
+// RequestedRate returns the average rate a run is paced at: the rate, the
+// bursts averaged over their interval, or the rate raised by the share of
+// the time spent in spikes, lowered by the share of the time traffic is
+// paused by network chaos. A shared token bucket may hold a run below it.
+func RequestedRate(cfg *Config) float64 {
+	rate := cfg.perSecond()
+	switch {
+	case cfg.BurstSize > 0:
+		rate = float64(cfg.BurstSize) / cfg.BurstInterval.Seconds()
+	case cfg.SpikeFactor > 0:
+		spiking := cfg.SpikeDuration.Seconds() / cfg.SpikeEvery.Seconds()
+		rate *= 1 + (cfg.SpikeFactor-1)*spiking
+	}
+	if cfg.ChaosPause > 0 {
+		rate *= 1 - cfg.ChaosPause.Seconds()/cfg.ChaosPauseEvery.Seconds()
+	}
+	return rate
+}
+
+// logLoadPattern logs how the sends of a run are spread over time.
+func logLoadPattern(cfg *Config) {
+	pacing := cfg.Pacing
+	if dist := strings.ToLower(cfg.Distribution); dist != distConstant {
+		pacing = dist + " gaps"
+	}
+	switch {
+	case cfg.BurstSize > 0:
+		log.Infof("Pacing: bursts of %d messages every %v", cfg.BurstSize, cfg.BurstInterval)
+	case cfg.SpikeFactor > 0:
+		log.Infof("Pacing: %s, spikes of %gx the rate for %v every %v", pacing, cfg.SpikeFactor, cfg.SpikeDuration, cfg.SpikeEvery)
+	default:
+		log.Infof("Pacing: %s", pacing)
+	}
+}
+
+// dueSends returns how many sends the pacer newRunPacer returns for rate,
+// bucket and burst has due in the first window of a run, before its end:
+// those due at once when it starts, a burst, a full token bucket or one
+// send, also when a spike begins or ends, and those of the rate after them.
+// A send loop that keeps up sends exactly these, so the rate of a run is
+// achieved in full if it sends them all, not rate times window, which
+// misses those due at the start. A window of 0 has the sends due at once.
+func dueSends(cfg *Config, rate, bucket, burst int, window time.Duration) int64 {
+	if burst > 0 {
+		bursts := int64(window / cfg.BurstInterval)
+		if window%cfg.BurstInterval != 0 || window == 0 {
+			bursts++
+		}
+		return bursts * int64(burst)
+	}
+	start := int64(1)
+	dist := strings.ToLower(cfg.Distribution)
+	if strings.ToLower(cfg.Pacing) == pacingTokenBucket && dist != distPoisson && dist != distUniform {
+		start = int64(tokenBucket(rate, bucket))
+	}
+	if cfg.SpikeFactor > 0 {
+		// every phase starts a pacer of its own
+		start += 2 * int64(window/cfg.SpikeEvery)
+	}
+	// spikes and pauses change the average rate of the loop as they change
+	// that of the run
+	perSecond := float64(rate) / cfg.Period().Seconds() * RequestedRate(cfg) / cfg.perSecond()
+	// the first of the sends of the rate is the one due at once; the
+	// rounding keeps a whole number of sends from turning into one more
+	due := start - 1 + int64(math.Ceil(perSecond*window.Seconds()-1e-9))
+	if due < start {
+		due = start
+	}
+	return due
+}
+
+// newRunPacer returns the pacer of a send loop with rate sends per period of
+// the rate of the run, bucket and burst its shares of the token bucket and
+// the burst size. Bursts replace the pacing strategy; spikes multiply the
+// rate of the strategy or of the random gaps.
+func newRunPacer(cfg *Config, rate, bucket, burst int) pacer {
+	strategy, dist := strings.ToLower(cfg.Pacing), strings.ToLower(cfg.Distribution)
+	period := cfg.Period()
+	paced := func(rate, bucket int) pacer {
+		if dist == distPoisson || dist == distUniform {
+			return newRandomPacer(dist, rate, period)
+		}
+		return newPacer(strategy, rate, bucket, period)
+	}
+	switch {
+	case burst > 0:
+		return &burstPacer{size: burst, interval: cfg.BurstInterval, next: time.Now()}
+	case cfg.SpikeFactor > 0:
+		p := &spikePacer{
+			base:   func() pacer { return paced(rate, bucket) },
+			every:  cfg.SpikeEvery,
+			length: cfg.SpikeDuration,
+		}
+		p.spike = func() pacer {
+			spikeRate := int(float64(rate) * cfg.SpikeFactor)
+			if spikeRate < 1 {
+				spikeRate = 1
+			}
+			return paced(spikeRate, int(float64(bucket)*cfg.SpikeFactor))
+		}
+		p.cur, p.until = p.base(), time.Now().Add(p.every)
+		return p
+	}
+	return paced(rate, bucket)
+}
+
+// randomPacer draws the gaps between sends at random around the mean gap of
+// the rate: from an exponential distribution, so that sends arrive like the
+// events of many independent sources, a Poisson process, or uniformly
+// between none and twice the mean. Unlike constant gaps, random ones let
+// requests queue up at the target now and then, as real traffic does. Like
+// the uniform pacer it catches up on sends missed in a stall of up to
+// maxCatchUp and skips the rest.
+type randomPacer struct {
+	mean float64
+	// gap returns the next gap in multiples of the mean
+	gap     func() float64
+	limit   int
+	next    time.Time
+	dropped int64
+}
+
+func newRandomPacer(dist string, rate int, period time.Duration) *randomPacer {
+	rng := rand.New(rand.NewSource(rand.Int63())) //nolint: gosec
+	p := &randomPacer{
+		mean:  float64(period) / float64(rate),
+		gap:   rng.ExpFloat64,
+		limit: int(int64(maxCatchUp)*int64(rate)/int64(period)) + 1,
+		next:  time.Now(),
+	}
+	if dist == distUniform {
+		p.gap = func() float64 { return 2 * rng.Float64() }
+	}
+	return p
+}
+
+func (p *randomPacer) wait(stop <-chan struct{}) int {
+	if !sleepUntil(p.next, stop, true) {
+		return 0
+	}
+	now, due := time.Now(), 0
+	for !p.next.After(now) {
+		p.next = p.next.Add(time.Duration(p.gap() * p.mean))
+		if due++; due > p.limit {
+			// too far behind, skip what cannot be made up for
+			p.dropped++
+		}
+	}
+	if due > p.limit {
+		due = p.limit
+	}
+	return due
+}
+
+func (p *randomPacer) skipped() int64 {
+	return p.dropped
+}
+
+// burstPacer sends bursts of size sends back to back, one burst every
+// interval, starting at once. Bursts missed while the loop was held up are
+// skipped, not sent in one go.
+type burstPacer struct {
+	size     int
+	interval time.Duration
+	next     time.Time
+	dropped  int64
+}
+
+func (p *burstPacer) wait(stop <-chan struct{}) int {
+	if !sleepUntil(p.next, stop, true) {
+		return 0
+	}
+	p.next = p.next.Add(p.interval)
+	if behind := time.Since(p.next); behind >= 0 {
+		missed := behind/p.interval + 1
+		p.dropped += int64(missed) * int64(p.size)
+		p.next = p.next.Add(missed * p.interval)
+	}
+	return p.size
+}
+
+func (p *burstPacer) skipped() int64 {
+	return p.dropped
+}
+
+// spikePacer multiplies the rate periodically: after every stretch of every
+// at the base rate it paces a spike of length at the spike rate. Each phase
+// starts with a new pacer, so changing the rate neither catches up on nor
+// carries over sends of the previous phase.
+type spikePacer struct {
+	base, spike   func() pacer
+	every, length time.Duration
+	cur           pacer
+	spiking       bool
+	// until is the end of the current phase
+	until   time.Time
+	dropped int64
+}
+
+func (p *spikePacer) wait(stop <-chan struct{}) int {
+	for !time.Now().Before(p.until) {
+		p.dropped += p.cur.skipped()
+		if p.spiking {
+			p.cur, p.until = p.base(), p.until.Add(p.every-p.length)
+		} else {
+			p.cur, p.until = p.spike(), p.until.Add(p.length)
+		}
+		p.spiking = !p.spiking
+	}
+	return p.cur.wait(stop)
+}
+
+func (p *spikePacer) skipped() int64 {
+	return p.dropped + p.cur.skipped()
+}
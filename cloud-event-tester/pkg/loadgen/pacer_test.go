@@ -0,0 +1,39 @@
+package loadgen
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueSends(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    func(*Config)
+		rate   int
+		bucket int
+		burst  int
+		window time.Duration
+		want   int64
+	}{
+		{"whole number of gaps", nil, 2, 0, 0, 1500 * time.Millisecond, 3},
+		{"one second", nil, 2, 0, 0, time.Second, 2},
+		{"part of a gap", nil, 2, 0, 0, 1250 * time.Millisecond, 3},
+		{"at once", nil, 2, 0, 0, 0, 1},
+		{"high rate", nil, 1000, 0, 0, 10 * time.Second, 10000},
+		{"slow rate", func(c *Config) { c.RatePeriod = 2 * time.Second }, 3, 0, 0, 5 * time.Second, 8},
+		{"token bucket", func(c *Config) { c.Pacing = pacingTokenBucket }, 50, 0, 0, 2 * time.Second, 104},
+		{"token bucket of its own", func(c *Config) { c.Pacing = pacingTokenBucket }, 50, 20, 0, 2 * time.Second, 119},
+		{"bursts", func(c *Config) { c.BurstSize, c.BurstInterval = 20, 500*time.Millisecond }, 100, 0, 20, 2 * time.Second, 80},
+		{"part of a burst interval", func(c *Config) { c.BurstSize, c.BurstInterval = 20, 500*time.Millisecond }, 100, 0, 20, 2100 * time.Millisecond, 100},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Rate = tt.rate
+		if tt.cfg != nil {
+			tt.cfg(&cfg)
+		}
+		if got := dueSends(&cfg, tt.rate, tt.bucket, tt.burst, tt.window); got != tt.want {
+			t.Errorf("%s: dueSends = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
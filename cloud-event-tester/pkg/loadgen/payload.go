@@ -0,0 +1,73 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// payloadPaddingAttr is the attribute the padding of -payload-size is sent in.
+const payloadPaddingAttr = "cetpadding"
+
+// ParseSize parses a size like 512, 10KB or 1MB; KB and MB are 1024 and
+// 1024*1024 bytes.
+func ParseSize(s string) (int, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	mult := 1
+	for _, u := range []struct {
+		suffix string
+		mult   int
+	}{{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"KB", 1 << 10}, {"MB", 1 << 20}, {"K", 1 << 10}, {"M", 1 << 20}, {"B", 1}} {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper, mult = strings.TrimSuffix(upper, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(upper))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 512, 10KB or 1MB", s)
+	}
+	return n * mult, nil
+}
+
+// SuffixPath returns the path of the file of a segment or workload: path
+// with suffix before its extension. Stdout and unset paths are kept.
+func SuffixPath(path, suffix string) string {
+	if path == "" || path == "-" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + suffix + ext
+}
+
+// padEventTo pads an event to size bytes. The padding is an attribute of the
+// data of a cloud event with an object as data, so it stays in the body in
+// binary content mode, and of the event otherwise. An event that is as large
+// already is returned unchanged.
+func padEventTo(body []byte, size int) []byte {
+	if len(body) >= size {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) == nil {
+		_, ce := fields["specversion"]
+		if data := bytes.TrimSpace(fields["data"]); ce && len(data) > 0 && data[0] == '{' {
+			pad := func(n int) []byte {
+				fields["data"] = padEvent(data, payloadPaddingAttr, n)
+				padded, _ := json.Marshal(fields)
+				return padded
+			}
+			if n := size - len(pad(0)); n > 0 {
+				return pad(n)
+			}
+			return body
+		}
+	}
+	if n := size - len(padEvent(body, payloadPaddingAttr, 0)); n > 0 {
+		return padEvent(body, payloadPaddingAttr, n)
+	}
+	return body
+}
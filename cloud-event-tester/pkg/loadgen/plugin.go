@@ -0,0 +1,139 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// execGeneratorName is the generator that runs -generator-command and sends
+// the events it writes to its stdout, for event schemas the built-in
+// generators do not have.
+const execGeneratorName = "exec"
+
+// execGenerator reads the events of a run from the stdout of a command, run
+// by the shell: JSON objects one after the other, like one per line. A
+// performance run reads an event for every send and runs the command again
+// whenever it exits, so a command may write events forever or a fixed set to
+// be sent over and over; a basic run sends the events it writes until it
+// exits. Its stderr is that of the tester.
+type execGenerator struct {
+	command string
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+	dec *json.Decoder
+	// events counts the events of the running command, so one that exits
+	// without any fails the run rather than being run again and again
+	events int
+}
+
+func newExecGenerator(cfg *Config) (Generator, error) {
+	if cfg.GeneratorCommand == "" {
+		return nil, fmt.Errorf("a generator command is required")
+	}
+	return &execGenerator{command: cfg.GeneratorCommand}, nil
+}
+
+func (g *execGenerator) start() error {
+	cmd := exec.Command("sh", "-c", g.command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start generator command %q: %w", g.command, err)
+	}
+	g.cmd = cmd
+	g.dec = json.NewDecoder(bufio.NewReader(out))
+	g.events = 0
+	return nil
+}
+
+// next reads the next event of the command, starting it if it is not
+// running. It returns io.EOF once the command has exited successfully; the
+// caller holds g.mu.
+func (g *execGenerator) next() ([]byte, error) {
+	if g.cmd == nil {
+		if err := g.start(); err != nil {
+			return nil, err
+		}
+	}
+	var event json.RawMessage
+	err := g.dec.Decode(&event)
+	if err == nil && (len(event) == 0 || event[0] != '{') {
+		err = fmt.Errorf("event %d is not a JSON object", g.events+1)
+	}
+	if err == nil {
+		g.events++
+		return event, nil
+	}
+	if err != io.EOF {
+		g.stop()
+		return nil, fmt.Errorf("generator command %q wrote an invalid event: %w", g.command, err)
+	}
+	err = g.cmd.Wait()
+	g.cmd = nil
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("generator command %q failed: %w", g.command, err)
+	case g.events == 0:
+		return nil, fmt.Errorf("generator command %q wrote no events", g.command)
+	}
+	return nil, io.EOF
+}
+
+func (g *execGenerator) Next(buf *bytes.Buffer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	event, err := g.next()
+	if err == io.EOF {
+		event, err = g.next()
+	}
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	buf.Write(event)
+	return nil
+}
+
+func (g *execGenerator) Sequence() ([][]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var events [][]byte
+	for {
+		event, err := g.next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+}
+
+// Close stops the command if it is still running.
+func (g *execGenerator) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stop()
+	return nil
+}
+
+// stop kills the running command, if any; the caller holds g.mu.
+func (g *execGenerator) stop() {
+	if g.cmd == nil {
+		return
+	}
+	g.cmd.Process.Kill() //nolint: errcheck
+	g.cmd.Wait()         //nolint: errcheck
+	g.cmd = nil
+}
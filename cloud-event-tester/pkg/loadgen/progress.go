@@ -0,0 +1,117 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// continuousSummaryInterval is the interval of the progress summary of a
+// continuous run without one.
+const continuousSummaryInterval = time.Minute
+
+// progressSummary logs a line every interval of a performance run with the
+// sends and errors so far, and the rate and p99 latency of the interval, so
+// a run of hours shows at a glance whether it is still healthy without the
+// per second debug log. It is fed the stats of every second by the run and
+// the latency of every successful send; a nil summary does nothing.
+type progressSummary struct {
+	interval time.Duration
+	duration float64
+	latency  *report.SharedHistogram
+	// errors of the run, and the counters of the current interval
+	errors       int
+	seconds      int
+	sent, failed uint64
+}
+
+func newProgressSummary(cfg *Config) *progressSummary {
+	interval := cfg.SummaryInterval
+	if interval == 0 && cfg.Continuous() {
+		interval = continuousSummaryInterval
+	}
+	if interval == 0 {
+		return nil
+	}
+	return &progressSummary{
+		interval: interval,
+		duration: cfg.Duration,
+		latency:  report.NewSharedHistogram(nil),
+	}
+}
+
+// validateSummaryInterval checks the interval of the progress summary.
+func (c *Config) validateSummaryInterval() error {
+	switch {
+	case c.SummaryInterval == 0:
+		return nil
+	case c.SummaryInterval < time.Second:
+		return fmt.Errorf("summary interval must be at least 1s, got %v", c.SummaryInterval)
+	case !c.IsPerf():
+		return fmt.Errorf("the progress summary is logged during performance runs only")
+	}
+	return nil
+}
+
+// record records the latency of a successful send.
+func (p *progressSummary) record(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.latency.Record(d)
+}
+
+// tick adds the stats of a second and logs the summary once an interval has
+// passed.
+func (p *progressSummary) tick(stats report.TickStats) {
+	if p == nil {
+		return
+	}
+	p.seconds++
+	p.sent += stats.Sent
+	p.failed += uint64(stats.Errors)
+	p.errors += stats.Errors
+	if time.Duration(p.seconds)*time.Second < p.interval {
+		return
+	}
+	errorRate := 0.0
+	if stats.TotalMsg+p.errors > 0 {
+		errorRate = 100 * float64(p.errors) / float64(stats.TotalMsg+p.errors)
+	}
+	p99 := ""
+	if l := p.latency.Drain(); l != nil {
+		p99 = fmt.Sprintf(", p99 %.3f ms", l.P99)
+	}
+	elapsed := (time.Duration(stats.Second) * time.Second).String()
+	if p.duration > 0 {
+		elapsed += fmt.Sprintf(" of %v", time.Duration(p.duration*float64(time.Second)))
+	}
+	log.Infof("Summary: %s, %d sent, %d errors (%.2f%%), %.1f msg/s and %d errors in the last %v%s",
+		elapsed, stats.TotalMsg, p.errors, errorRate, float64(p.sent)/float64(p.seconds), p.failed,
+		time.Duration(p.seconds)*time.Second, p99)
+	p.seconds, p.sent, p.failed = 0, 0, 0
+}
+
+// liveLatencyKey is the context key of the histogram the runs of a context
+// record their latencies in for the dashboard, see WithLiveLatency.
+type liveLatencyKey struct{}
+
+// WithLiveLatency returns a context whose performance runs record the
+// latencies of their successful sends in h as well.
+func WithLiveLatency(ctx context.Context, h *report.SharedHistogram) context.Context {
+	if h == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, liveLatencyKey{}, h)
+}
+
+// liveLatency returns the histogram the runs of ctx record their latencies
+// in for the dashboard, nil unless one is shown.
+func liveLatency(ctx context.Context) *report.SharedHistogram {
+	h, _ := ctx.Value(liveLatencyKey{}).(*report.SharedHistogram)
+	return h
+}
@@ -0,0 +1,123 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// autoURL as the target URL makes the tester discover the publish endpoint
+// of a cloud-event-proxy running alongside it.
+const autoURL = "auto"
+
+// DefaultProxyAPI lists the addresses where a sidecar cloud-event-proxy
+// usually serves its REST API.
+const DefaultProxyAPI = "http://localhost:9085,http://localhost:9089"
+
+// proxyAPIVersions are the REST API versions of cloud-event-proxy, newest
+// first.
+var proxyAPIVersions = []string{"v2", "v1"}
+
+// ProxyAPI is a discovered cloud-event-proxy REST API.
+type ProxyAPI struct {
+	Base       string           `json:"base"`
+	Version    string           `json:"version"`
+	Publishers []proxyPublisher `json:"publishers"`
+}
+
+type proxyPublisher struct {
+	ID          string `json:"id"`
+	EndpointURI string `json:"endpointUri"`
+	URILocation string `json:"uriLocation"`
+	Resource    string `json:"resource"`
+}
+
+// publishURL is where events are published through the API.
+func (p *ProxyAPI) publishURL() string {
+	return p.Base + "/create/event"
+}
+
+// DiscoverProxy probes the given addresses for the health endpoint of a
+// cloud-event-proxy REST API and lists the publishers of the first one found.
+func DiscoverProxy(ctx context.Context, addrs string) (*ProxyAPI, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	var tried []string
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimRight(strings.TrimSpace(addr), "/")
+		if addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		for _, version := range proxyAPIVersions {
+			base := addr + "/api/ocloudNotifications/" + version
+			tried = append(tried, base)
+			if err := proxyGet(ctx, client, base+"/health", nil); err != nil {
+				log.Debugf("No cloud-event-proxy API at %s: %v", base, err)
+				continue
+			}
+			api := &ProxyAPI{Base: base, Version: version}
+			if err := proxyGet(ctx, client, base+"/publishers", &api.Publishers); err != nil {
+				return nil, fmt.Errorf("failed to list publishers of %s: %w", base, err)
+			}
+			log.Infof("Found cloud-event-proxy API %s with %d publisher(s)", base, len(api.Publishers))
+			for _, p := range api.Publishers {
+				log.Infof("  resource %s (publisher %s)", p.Resource, p.ID)
+			}
+			return api, nil
+		}
+	}
+	return nil, fmt.Errorf("no cloud-event-proxy API found, tried %s", strings.Join(tried, ", "))
+}
+
+func proxyGet(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer sender.DrainBody(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// resolveProxyURL replaces the target URL "auto" with the publish endpoint of
+// the discovered cloud-event-proxy. If a resource is configured, a publisher
+// for it must exist.
+func resolveProxyURL(ctx context.Context, cfg *Config) error {
+	api, err := DiscoverProxy(ctx, cfg.ProxyAPI)
+	if err != nil {
+		return err
+	}
+	if cfg.Resource != "" {
+		found := false
+		for _, p := range api.Publishers {
+			if p.Resource == cfg.Resource {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no publisher for resource %s at %s", cfg.Resource, api.Base)
+		}
+	}
+	cfg.URL = api.publishURL()
+	log.Infof("Target URL: %s", cfg.URL)
+	return nil
+}
@@ -0,0 +1,190 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// publisher is one of the independent event sources a run simulates with
+// -publishers. Its events carry a source of their own, the source of the
+// event with /publisher-N appended, and IDs of their own, a prefix unique to
+// the publisher and its count of events; with -sequence it numbers its
+// events itself, with its prefix as the run ID, so a receiver tells the
+// lost events of every publisher apart. It sends over a client of its own,
+// with one connection to every target. A publisher belongs to one send
+// shard and is only used by its goroutine.
+type publisher struct {
+	id       int
+	prefix   string
+	client   sender.Doer
+	sequence bool
+	// events renders the events, nil to send body
+	events  events.Renderer
+	body    []byte
+	scratch bytes.Buffer
+	// renderer renders what the publisher sends, itself or a batch of its
+	// events
+	renderer events.Renderer
+
+	source string
+	seq    int64
+	sent   int
+	errors int
+	took   time.Duration
+}
+
+// newPublishers returns the publishers of a run, sending the events of
+// renderer, or body if it is nil, with a client each made by newClient. The
+// events of their batches are checked with check, if not nil. It returns nil
+// if the run does not simulate publishers.
+func newPublishers(cfg *Config, renderer events.Renderer, body []byte, check func([]byte) bool, newClient func(*Config) sender.Doer) ([]*publisher, error) {
+	if cfg.Publishers == 0 {
+		return nil, nil
+	}
+	if renderer == nil {
+		structured, err := events.ToStructuredEvent(body)
+		if err != nil {
+			return nil, fmt.Errorf("event cannot be sent by publishers: %w", err)
+		}
+		body = structured
+	}
+	single := cfg.Clone()
+	single.MaxConnsPerHost = 1
+	pubs := make([]*publisher, cfg.Publishers)
+	for i := range pubs {
+		p := &publisher{
+			id:       i,
+			prefix:   events.NewUUID(),
+			client:   newClient(&single),
+			sequence: cfg.Sequence,
+			events:   renderer,
+			body:     body,
+		}
+		p.renderer = p
+		if cfg.BatchSize > 1 {
+			p.renderer = events.NewBatchRenderer(p, cfg.BatchSize, check)
+		}
+		pubs[i] = p
+	}
+	log.Infof("Publishers: %d, each with its own source, IDs and connection", cfg.Publishers)
+	if cfg.Sequence {
+		log.Infof("Sequence: events numbered by each publisher, with its ID prefix as the run ID in the %s attribute", events.SequenceRunAttr)
+	}
+	return pubs, nil
+}
+
+// validatePublishers checks the publisher settings of a performance run.
+func (c *Config) validatePublishers() error {
+	switch {
+	case c.Publishers == 0:
+		return nil
+	case c.Publishers < 0:
+		return fmt.Errorf("publishers must not be negative, got %d", c.Publishers)
+	case strings.ToUpper(c.CheckResp) == "MULTI_THREAD":
+		return fmt.Errorf("publishers send over their own connections, MULTI_THREAD is not supported")
+	case c.Publishers < c.Shards:
+		return fmt.Errorf("publishers must be at least the shards, got %d publishers for %d shards", c.Publishers, c.Shards)
+	}
+	return nil
+}
+
+// Render writes the next event of the publisher to buf.
+func (p *publisher) Render(buf *bytes.Buffer) error {
+	event := p.body
+	if p.events != nil {
+		if err := p.events.Render(&p.scratch); err != nil {
+			return err
+		}
+		structured, err := events.ToStructuredEvent(p.scratch.Bytes())
+		if err != nil {
+			return err
+		}
+		event = structured
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return err
+	}
+	var source string
+	json.Unmarshal(fields["source"], &source) //nolint: errcheck
+	if source == "" {
+		source = events.DefaultEventSource
+	}
+	p.source = source + "/publisher-" + strconv.Itoa(p.id)
+	p.seq++
+	seq := strconv.FormatInt(p.seq, 10)
+	fields["source"] = jsonString(p.source)
+	fields["id"] = jsonString(p.prefix + "-" + seq)
+	if p.sequence {
+		fields[events.SequenceRunAttr] = jsonString(p.prefix)
+		fields[events.SequenceSeqAttr] = json.RawMessage(seq)
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	buf.Write(b)
+	return nil
+}
+
+// record counts a send of the publisher.
+func (p *publisher) record(latency time.Duration, failed bool) {
+	if p == nil {
+		return
+	}
+	p.sent++
+	if failed {
+		p.errors++
+		return
+	}
+	p.took += latency
+}
+
+// reportPublishers adds the per-publisher stats to a run result and logs
+// them, each at debug level and the spread of the sends at info.
+func reportPublishers(pubs []*publisher, result *report.Result) {
+	if len(pubs) == 0 {
+		return
+	}
+	result.Publishers = make([]report.PublisherStats, 0, len(pubs))
+	var failed int
+	minSent, maxSent := pubs[0].sent, pubs[0].sent
+	for _, p := range pubs {
+		st := report.PublisherStats{Publisher: p.id, Source: p.source, IDPrefix: p.prefix, Sent: p.sent, Errors: p.errors}
+		if p.sequence {
+			st.Last = p.seq
+		}
+		if ok := p.sent - p.errors; ok > 0 {
+			st.MeanLatency = float64(p.took) / float64(ok) / float64(time.Millisecond)
+		}
+		log.Debugf("Publisher %d (%s): %d sent, %d errors, mean latency %.3f ms", st.Publisher, st.Source, st.Sent, st.Errors, st.MeanLatency)
+		result.Publishers = append(result.Publishers, st)
+		if p.errors > 0 {
+			failed++
+		}
+		if p.sent < minSent {
+			minSent = p.sent
+		}
+		if p.sent > maxSent {
+			maxSent = p.sent
+		}
+	}
+	log.Infof("Publishers: %d, %d to %d sends each, %d with errors", len(pubs), minSent, maxSent, failed)
+}
+
+// jsonString returns s as a JSON string.
+func jsonString(s string) json.RawMessage {
+	b, _ := json.Marshal(s)
+	return b
+}
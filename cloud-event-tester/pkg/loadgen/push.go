@@ -0,0 +1,357 @@
+package loadgen
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// defaultPushJob is the job label of the pushed metrics.
+const defaultPushJob = "cloud_event_tester"
+
+// pushMetric is one sample of the metrics of a run pushed to Prometheus.
+type pushMetric struct {
+	name, help string
+	// quantile, if set, is the quantile label of a latency summary
+	quantile string
+	value    float64
+}
+
+// metricsPusher pushes the metrics of a performance run to a Prometheus
+// Pushgateway, a remote-write endpoint or both, for the short-lived CI runs
+// that are gone before they can be scraped: the totals of the run when it
+// ends and, with a push interval, the sends, errors and rate so far while it
+// runs. The metrics are labeled with the job, an ID of the run, the name of
+// its scenario if it has one and the labels of the run. The Pushgateway gets
+// a group per run, replaced on every push, so the final push leaves the
+// totals; remote write appends a sample to every series on every push. A
+// failed push is logged and does not fail the run; a nil pusher does
+// nothing.
+type metricsPusher struct {
+	gateway, remoteWrite string
+	interval             time.Duration
+	job, runID, scenario string
+	labels               map[string]string
+	client               *http.Client
+
+	// errors are the failed sends of the ticks so far
+	errors int
+	// pushing is 1 while an interval push runs
+	pushing int32
+	wg      sync.WaitGroup
+	seconds int
+}
+
+func newMetricsPusher(cfg *Config) *metricsPusher {
+	if cfg.PushGateway == "" && cfg.RemoteWrite == "" {
+		return nil
+	}
+	job := cfg.PushJob
+	if job == "" {
+		job = defaultPushJob
+	}
+	p := &metricsPusher{
+		gateway:     strings.TrimRight(cfg.PushGateway, "/"),
+		remoteWrite: cfg.RemoteWrite,
+		interval:    cfg.PushInterval,
+		job:         job,
+		runID:       NewRunID(),
+		scenario:    cfg.Scenario,
+		labels:      cfg.Labels,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	var to []string
+	if p.gateway != "" {
+		to = append(to, "Pushgateway "+p.gateway)
+	}
+	if p.remoteWrite != "" {
+		to = append(to, "remote write "+p.remoteWrite)
+	}
+	every := "at the end"
+	if p.interval > 0 {
+		every = "every " + p.interval.String() + " and at the end"
+	}
+	log.Infof("Push: metrics of run %s to %s %s", p.runID, strings.Join(to, " and "), every)
+	return p
+}
+
+// validatePush checks the metrics push settings of a run.
+func (c *Config) validatePush() error {
+	if c.PushGateway == "" && c.RemoteWrite == "" {
+		if c.PushInterval != 0 {
+			return fmt.Errorf("push interval needs -pushgateway or -remote-write")
+		}
+		return nil
+	}
+	if !c.IsPerf() {
+		return fmt.Errorf("metrics are pushed by performance runs only")
+	}
+	for _, endpoint := range []string{c.PushGateway, c.RemoteWrite} {
+		if endpoint == "" {
+			continue
+		}
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("push endpoint %q is not an http or https URL", endpoint)
+		}
+	}
+	if c.PushInterval != 0 && c.PushInterval < time.Second {
+		return fmt.Errorf("push interval must be at least 1s, got %v", c.PushInterval)
+	}
+	// the labels of the pusher itself would be duplicated or replaced
+	for _, name := range []string{"job", "run_id", "quantile", "scenario"} {
+		if _, ok := c.Labels[name]; ok && (name != "scenario" || c.Scenario != "") {
+			return fmt.Errorf("label %s is set on the pushed metrics by the tester, rename it", name)
+		}
+	}
+	return nil
+}
+
+// tick counts the stats of a second and pushes the metrics of the run so far
+// once an interval has passed, in the background. A push still running when
+// the next is due delays it to the next second.
+func (p *metricsPusher) tick(stats report.TickStats) {
+	if p == nil {
+		return
+	}
+	p.errors += stats.Errors
+	p.seconds++
+	if p.interval <= 0 || time.Duration(p.seconds)*time.Second < p.interval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&p.pushing, 0, 1) {
+		return
+	}
+	p.seconds = 0
+	metrics := []pushMetric{
+		{name: "sent_total", help: "Messages sent so far", value: float64(stats.TotalMsg)},
+		{name: "errors_total", help: "Failed sends so far", value: float64(p.errors)},
+		{name: "rate", help: "Messages sent in the last second", value: float64(stats.Sent)},
+		{name: "queue_depth", help: "Messages waiting in the send queue", value: float64(stats.QueueDepth)},
+		{name: "elapsed_seconds", help: "Seconds into the run", value: float64(stats.Second)},
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer atomic.StoreInt32(&p.pushing, 0)
+		p.push(metrics)
+	}()
+}
+
+// finish pushes the totals of a finished run, after any interval push still
+// running.
+func (p *metricsPusher) finish(result *report.Result) {
+	if p == nil {
+		return
+	}
+	p.wg.Wait()
+	interrupted := 0.0
+	if result.Interrupted {
+		interrupted = 1
+	}
+	failedChecks := 0
+	for _, c := range result.Checks {
+		if !c.Passed {
+			failedChecks++
+		}
+	}
+	metrics := []pushMetric{
+		{name: "sent_total", help: "Messages sent so far", value: float64(result.TotalMsg)},
+		{name: "errors_total", help: "Failed sends so far", value: float64(result.FailedSends())},
+		{name: "skipped_total", help: "Messages skipped by the send loop", value: float64(result.Skipped)},
+		{name: "elapsed_seconds", help: "Seconds into the run", value: result.TotalSeconds},
+		{name: "avg_rate", help: "Average messages per second of the run", value: result.AvgRate},
+		{name: "requested_rate", help: "Average messages per second requested", value: result.RequestedRate},
+		{name: "error_rate_percent", help: "Percentage of failed sends of the run", value: result.ErrorRate},
+		{name: "interrupted", help: "1 if the run was interrupted", value: interrupted},
+		{name: "failed_checks", help: "Checks of the run that failed", value: float64(failedChecks)},
+	}
+	if l := result.Latency; l != nil && l.Count > 0 {
+		for _, q := range []struct {
+			quantile string
+			value    float64
+		}{{"0.5", l.P50}, {"0.9", l.P90}, {"0.95", l.P95}, {"0.99", l.P99}, {"0.999", l.P999}} {
+			metrics = append(metrics, pushMetric{name: "latency_ms", help: "Latency of the successful sends in milliseconds", quantile: q.quantile, value: q.value})
+		}
+	}
+	p.push(metrics)
+}
+
+// push sends metrics to the endpoints of the pusher.
+func (p *metricsPusher) push(metrics []pushMetric) {
+	if p.gateway != "" {
+		if err := p.pushGateway(metrics); err != nil {
+			log.Errorf("Push: failed to push metrics to %s: %v", p.gateway, err)
+		} else {
+			log.Debugf("Push: %d metrics pushed to %s", len(metrics), p.gateway)
+		}
+	}
+	if p.remoteWrite != "" {
+		if err := p.pushRemoteWrite(metrics, time.Now()); err != nil {
+			log.Errorf("Push: failed to write metrics to %s: %v", p.remoteWrite, err)
+		} else {
+			log.Debugf("Push: %d metrics written to %s", len(metrics), p.remoteWrite)
+		}
+	}
+}
+
+// groupingKey returns the labels identifying the run, sorted by name: the ID
+// of the run and, if it has one, its scenario.
+func (p *metricsPusher) groupingKey() [][2]string {
+	key := [][2]string{{"run_id", p.runID}}
+	if p.scenario != "" {
+		key = append(key, [2]string{"scenario", p.scenario})
+	}
+	return key
+}
+
+// pushGateway replaces the group of the run on the Pushgateway with metrics
+// in the text exposition format.
+func (p *metricsPusher) pushGateway(metrics []pushMetric) error {
+	var path strings.Builder
+	path.WriteString(p.gateway + "/metrics/job/" + url.PathEscape(p.job))
+	for _, kv := range p.groupingKey() {
+		if strings.Contains(kv[1], "/") {
+			path.WriteString("/" + kv[0] + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(kv[1])))
+		} else {
+			path.WriteString("/" + kv[0] + "/" + url.PathEscape(kv[1]))
+		}
+	}
+	labels := sortedLabels(p.labels)
+	var body bytes.Buffer
+	written := map[string]bool{}
+	for _, m := range metrics {
+		name := defaultPushJob + "_" + m.name
+		if !written[name] {
+			typ := "gauge"
+			if strings.HasSuffix(name, "_total") {
+				typ = "counter"
+			}
+			fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, typ)
+			written[name] = true
+		}
+		pairs := labels
+		if m.quantile != "" {
+			pairs = append(append([][2]string(nil), labels...), [2]string{"quantile", m.quantile})
+		}
+		body.WriteString(name)
+		if len(pairs) > 0 {
+			body.WriteByte('{')
+			for i, kv := range pairs {
+				if i > 0 {
+					body.WriteByte(',')
+				}
+				body.WriteString(kv[0] + `="` + escapeLabelValue(kv[1]) + `"`)
+			}
+			body.WriteByte('}')
+		}
+		body.WriteString(" " + formatSample(m.value) + "\n")
+	}
+	req, err := http.NewRequest(http.MethodPut, path.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	return p.do(req)
+}
+
+// pushRemoteWrite writes metrics as samples at t with the Prometheus remote
+// write protocol: a snappy compressed protobuf WriteRequest.
+func (p *metricsPusher) pushRemoteWrite(metrics []pushMetric, t time.Time) error {
+	base := append([][2]string{{"job", p.job}}, p.groupingKey()...)
+	base = append(base, sortedLabels(p.labels)...)
+	var write []byte
+	for _, m := range metrics {
+		labels := append([][2]string{{"__name__", defaultPushJob + "_" + m.name}}, base...)
+		if m.quantile != "" {
+			labels = append(labels, [2]string{"quantile", m.quantile})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+		var series []byte
+		for _, kv := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, kv[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, kv[1])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(m.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(t.UnixMilli()))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+		write = protowire.AppendTag(write, 1, protowire.BytesType)
+		write = protowire.AppendBytes(write, series)
+	}
+	req, err := http.NewRequest(http.MethodPost, p.remoteWrite, bytes.NewReader(s2.EncodeSnappy(nil, write)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return p.do(req)
+}
+
+func (p *metricsPusher) do(req *http.Request) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer sender.DrainBody(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sortedLabels returns the labels of a run as pairs sorted by name.
+func sortedLabels(labels map[string]string) [][2]string {
+	pairs := make([][2]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, [2]string{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	return pairs
+}
+
+// escapeLabelValue escapes a label value of the text exposition format.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatSample formats the value of a sample of the text exposition format.
+func formatSample(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// NewRunID returns a random ID for a run, or the current time in hex if the
+// random source fails.
+func NewRunID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
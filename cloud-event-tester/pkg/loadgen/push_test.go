@@ -0,0 +1,35 @@
+package loadgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePushLabels(t *testing.T) {
+	tests := []struct {
+		label    string
+		scenario string
+		wantErr  bool
+	}{
+		{label: "team"},
+		{label: "job", wantErr: true},
+		{label: "run_id", wantErr: true},
+		{label: "quantile", wantErr: true},
+		{label: "scenario"},
+		{label: "scenario", scenario: "soak", wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Perf = "YES"
+		cfg.PushGateway = "http://localhost:9091"
+		cfg.Scenario = tt.scenario
+		cfg.Labels = map[string]string{tt.label: "blue"}
+		err := cfg.validatePush()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("label %s with scenario %q: got error %v, want error %v", tt.label, tt.scenario, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), tt.label) {
+			t.Errorf("error %q does not name label %s", err, tt.label)
+		}
+	}
+}
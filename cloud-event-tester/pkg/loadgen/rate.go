@@ -0,0 +1,170 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateUnits are the units of a rate of -rate, as in 90/m.
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// ParseRate parses a rate of messages per unit of time: 100 or 100/s is 100
+// messages per second, 90/m 90 per minute, 100/h 100 per hour, and 5/10m 5
+// every ten minutes. The rate is kept as the fewest whole messages per
+// period of a second or more it adds up to: 0.5/s as 1 every 2 seconds, 90/m
+// as 3 every 2 seconds, and a whole number per second as one, 120/m as 2/s,
+// so the rates the integer messages per second expressed are paced as
+// before.
+func ParseRate(s string) (int, time.Duration, error) {
+	value, unit := strings.TrimSpace(s), "s"
+	if i := strings.IndexByte(value, '/'); i >= 0 {
+		value, unit = strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	}
+	period, ok := rateUnits[unit]
+	if !ok {
+		d, err := time.ParseDuration(unit)
+		if err != nil || d < time.Millisecond {
+			return 0, 0, fmt.Errorf("rate %q is not messages per s, m, h, d or a duration, like 90/m", s)
+		}
+		period = d
+	}
+	r, ok := new(big.Rat).SetString(value)
+	if !ok || r.Sign() <= 0 {
+		return 0, 0, fmt.Errorf("rate %q must be a positive number of messages", s)
+	}
+	// messages per period as a fraction of n messages per d nanoseconds,
+	// in lowest terms
+	r.Quo(r, new(big.Rat).SetInt64(int64(period)))
+	n, d := r.Num(), r.Denom()
+	if !n.IsInt64() || !d.IsInt64() {
+		return 0, 0, fmt.Errorf("rate %q is out of range", s)
+	}
+	rate, ns := n.Int64(), time.Duration(d.Int64())
+	if ns < time.Second {
+		// a whole number per second, or per the least multiple of ns of a
+		// second or more
+		k := (time.Second + ns - 1) / ns
+		rate, ns = rate*int64(k), ns*k
+	}
+	// the pacers multiply the rate by the period in nanoseconds
+	if rate > int64(^uint32(0)>>1) || rate > math.MaxInt64/int64(ns) {
+		return 0, 0, fmt.Errorf("rate %q is out of range", s)
+	}
+	return int(rate), ns, nil
+}
+
+// FormatRate formats rate messages per period the way ParseRate reads it.
+func FormatRate(rate int, period time.Duration) string {
+	if period <= 0 || period == time.Second {
+		return strconv.Itoa(rate)
+	}
+	for unit, d := range rateUnits {
+		if period == d {
+			return strconv.Itoa(rate) + "/" + unit
+		}
+	}
+	return strconv.Itoa(rate) + "/" + period.String()
+}
+
+// ParseRateJSON parses a rate of the control API, a number of messages per
+// second or a rate string as ParseRate reads it, like "90/m".
+func ParseRateJSON(raw json.RawMessage) (int, time.Duration, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return 0, 0, fmt.Errorf("invalid rate %s: %w", raw, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return 0, 0, fmt.Errorf("invalid rate %s", raw)
+	}
+	switch v := v.(type) {
+	case json.Number:
+		return ParseRate(v.String())
+	case string:
+		return ParseRate(v)
+	}
+	return 0, 0, fmt.Errorf("rate must be a number of messages per second or a rate like \"90/m\", got %s", raw)
+}
+
+// rateFlag is -rate, setting the rate and its period of a run.
+type rateFlag struct {
+	c *Config
+}
+
+func (f *rateFlag) String() string {
+	if f == nil || f.c == nil {
+		return ""
+	}
+	return FormatRate(f.c.Rate, f.c.RatePeriod)
+}
+
+func (f *rateFlag) Set(value string) error {
+	rate, period, err := ParseRate(value)
+	if err != nil {
+		return err
+	}
+	f.c.Rate, f.c.RatePeriod = rate, period
+	return nil
+}
+
+// Period returns the period the rate of the run is a number of messages
+// of, a second unless a slower or fractional rate was asked for.
+func (c *Config) Period() time.Duration {
+	if c.RatePeriod <= 0 {
+		return time.Second
+	}
+	return c.RatePeriod
+}
+
+// perSecond returns the rate of the run in messages per second.
+func (c *Config) perSecond() float64 {
+	return float64(c.Rate) / c.Period().Seconds()
+}
+
+// slowRate reports whether the rate of the run is not a whole number of
+// messages per second, which the features changing or dividing the rate
+// per second do not support.
+func (c *Config) slowRate() bool {
+	return c.Period() != time.Second
+}
+
+// RateText returns the rate of the run for the log.
+func (c *Config) RateText() string {
+	if !c.slowRate() {
+		return strconv.Itoa(c.Rate)
+	}
+	return fmt.Sprintf("%.4g (%s, one message every %v)", c.perSecond(), FormatRate(c.Rate, c.RatePeriod),
+		(c.Period() / time.Duration(c.Rate)).Round(time.Millisecond))
+}
+
+// validateRate checks that the features that work in messages per second
+// are not combined with a slower or fractional rate.
+func (c *Config) validateRate() error {
+	if c.RatePeriod < 0 {
+		return fmt.Errorf("rate period must not be negative, got %v", c.RatePeriod)
+	}
+	if !c.slowRate() {
+		return nil
+	}
+	rate := FormatRate(c.Rate, c.RatePeriod)
+	switch {
+	case c.AdaptiveRate:
+		return fmt.Errorf("adaptive rate control steps the messages per second, the rate %s is not in messages per second", rate)
+	case c.FindMax != "":
+		return fmt.Errorf("find-max probes messages per second, the rate %s is not in messages per second", rate)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in     string
+		rate   int
+		period time.Duration
+	}{
+		{"100", 100, time.Second},
+		{"100/s", 100, time.Second},
+		{" 20 / s ", 20, time.Second},
+		{"0.5/s", 1, 2 * time.Second},
+		{"0.5", 1, 2 * time.Second},
+		{"90/m", 3, 2 * time.Second},
+		{"120/m", 2, time.Second},
+		{"100/h", 1, 36 * time.Second},
+		{"1/d", 1, 24 * time.Hour},
+		{"5/10m", 1, 2 * time.Minute},
+		{"1/500ms", 2, time.Second},
+		{"3/2s", 3, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		rate, period, err := ParseRate(tt.in)
+		if err != nil {
+			t.Errorf("parseRate(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if rate != tt.rate || period != tt.period {
+			t.Errorf("parseRate(%q) = %d/%v, want %d/%v", tt.in, rate, period, tt.rate, tt.period)
+		}
+	}
+}
+
+func TestParseRateMalformed(t *testing.T) {
+	for _, in := range []string{
+		"", "/s", "abc", "0", "-1", "-5/m", "10/x", "10/", "10/0.5ms", "10/-1s", "1/2/3", "1e30", "9999999999",
+	} {
+		if rate, period, err := ParseRate(in); err == nil {
+			t.Errorf("parseRate(%q) = %d/%v, want an error", in, rate, period)
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate   int
+		period time.Duration
+		want   string
+	}{
+		{100, time.Second, "100"},
+		{5, 0, "5"},
+		{3, 2 * time.Second, "3/2s"},
+		{1, time.Minute, "1/m"},
+		{1, time.Hour, "1/h"},
+		{1, 24 * time.Hour, "1/d"},
+		{1, 36 * time.Second, "1/36s"},
+		{1, 2 * time.Minute, "1/2m0s"},
+	}
+	for _, tt := range tests {
+		got := FormatRate(tt.rate, tt.period)
+		if got != tt.want {
+			t.Errorf("formatRate(%d, %v) = %q, want %q", tt.rate, tt.period, got, tt.want)
+			continue
+		}
+		// ParseRate reads what FormatRate writes
+		rate, period, err := ParseRate(got)
+		if err != nil || rate != tt.rate || (tt.period > 0 && period != tt.period) {
+			t.Errorf("parseRate(%q) = %d/%v, %v, want %d/%v", got, rate, period, err, tt.rate, tt.period)
+		}
+	}
+}
+
+func TestRatePeriod(t *testing.T) {
+	tests := []struct {
+		period time.Duration
+		want   time.Duration
+		slow   bool
+	}{
+		{0, time.Second, false},
+		{time.Second, time.Second, false},
+		{2 * time.Second, 2 * time.Second, true},
+		{time.Hour, time.Hour, true},
+	}
+	for _, tt := range tests {
+		c := Config{Rate: 3, RatePeriod: tt.period}
+		if got := c.Period(); got != tt.want {
+			t.Errorf("ratePeriod of %v = %v, want %v", tt.period, got, tt.want)
+		}
+		if got := c.slowRate(); got != tt.slow {
+			t.Errorf("slowRate of %v = %v, want %v", tt.period, got, tt.slow)
+		}
+	}
+	c := Config{Rate: 3, RatePeriod: 2 * time.Second}
+	if got := c.perSecond(); got != 1.5 {
+		t.Errorf("perSecond of 3/2s = %v, want 1.5", got)
+	}
+}
+
+func TestParseRateJSON(t *testing.T) {
+	tests := []struct {
+		in     string
+		rate   int
+		period time.Duration
+	}{
+		{`50`, 50, time.Second},
+		{`0.5`, 1, 2 * time.Second},
+		{`"50"`, 50, time.Second},
+		{`"90/m"`, 3, 2 * time.Second},
+		{`"100/h"`, 1, 36 * time.Second},
+	}
+	for _, tt := range tests {
+		rate, period, err := ParseRateJSON(json.RawMessage(tt.in))
+		if err != nil {
+			t.Errorf("parseRateJSON(%s) failed: %v", tt.in, err)
+			continue
+		}
+		if rate != tt.rate || period != tt.period {
+			t.Errorf("parseRateJSON(%s) = %d/%v, want %d/%v", tt.in, rate, period, tt.rate, tt.period)
+		}
+	}
+	// 50/m is not JSON, the rate strings are quoted
+	for _, in := range []string{`true`, `null`, `[50]`, `{"rate":50}`, `"fast"`, `-1`, `0`, `50/m`, ``} {
+		if _, _, err := ParseRateJSON(json.RawMessage(in)); err == nil {
+			t.Errorf("parseRateJSON(%s) succeeded, want an error", in)
+		}
+	}
+}
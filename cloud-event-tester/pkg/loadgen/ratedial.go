@@ -0,0 +1,113 @@
+package loadgen
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RateDial changes the rate of a running performance run, from the control
+// API of the daemon. The send loops of the shards pace their share of the
+// new rate from their next wait on, each with a new pacer, as the phases of
+// spikePacer, so a change neither catches up on nor carries over the sends
+// paced at the previous rate. A nil dial keeps the rate of the run.
+type RateDial struct {
+	initial int64
+	rate    int64
+}
+
+// NewRateDial returns the dial of the rate of a run, nil if it is not a
+// performance run.
+func NewRateDial(cfg *Config) *RateDial {
+	if !cfg.IsPerf() {
+		return nil
+	}
+	return &RateDial{initial: int64(cfg.Rate), rate: int64(cfg.Rate)}
+}
+
+// ValidateRateChange checks that the rate of a run can be changed to rate
+// messages per period while it runs.
+func (c *Config) ValidateRateChange(rate int, period time.Duration) error {
+	switch {
+	case c.RateDial == nil:
+		return fmt.Errorf("the rate of basic runs cannot be changed, they send every event file once")
+	case c.BurstSize > 0:
+		return fmt.Errorf("the run is paced by bursts of %d messages, its rate cannot be changed", c.BurstSize)
+	case c.AdaptiveRate:
+		return fmt.Errorf("the rate of the run is adapted to the target, it cannot be changed")
+	case c.RedisURL != "" || c.ShardRate:
+		return fmt.Errorf("the rate of the run is shared with other testers, it cannot be changed")
+	case c.FindMax != "":
+		return fmt.Errorf("find-max probes the rates, the rate of the run cannot be changed")
+	case c.slowRate():
+		return fmt.Errorf("the rate %s is not in messages per second, it cannot be changed", FormatRate(c.Rate, c.RatePeriod))
+	case period != time.Second:
+		return fmt.Errorf("the rate of a run in progress is changed in messages per second, got %s", FormatRate(rate, period))
+	case rate < c.Shards:
+		return fmt.Errorf("rate must be at least 1 msg/s for each of the %d shards, got %d", c.Shards, rate)
+	}
+	return nil
+}
+
+// Set changes the rate of the run to rate, checked by validateRateChange.
+func (d *RateDial) Set(rate int) {
+	if old := atomic.SwapInt64(&d.rate, int64(rate)); old != int64(rate) {
+		log.Infof("Rate changed from %d to %d msg/s", old, rate)
+	}
+}
+
+// changed reports whether the rate of the run was changed, so the run is
+// not held to the rate it started at.
+func (d *RateDial) changed() bool {
+	return d != nil && atomic.LoadInt64(&d.rate) != d.initial
+}
+
+// tick returns the rate of the second for the timeline, 0 if it was never
+// changed.
+func (d *RateDial) tick() int {
+	if !d.changed() {
+		return 0
+	}
+	return int(atomic.LoadInt64(&d.rate))
+}
+
+// pacer returns the pacer of a shard, p at the rate the run started at,
+// following the changes of the rate.
+func (d *RateDial) pacer(cfg *Config, shard int, p pacer) pacer {
+	if d == nil {
+		return p
+	}
+	return &dialPacer{
+		rate: d.initial,
+		dial: d,
+		cur:  p,
+		pace: func(rate int) pacer {
+			shardRate := ShardRates(rate, cfg.Shards)[shard]
+			return newRunPacer(cfg, shardRate, shardBucket(cfg.BucketSize, shardRate, rate), 0)
+		},
+	}
+}
+
+// dialPacer paces the share of a shard of the rate of a RateDial with cur,
+// replaced by a pacer of pace when the rate changes.
+type dialPacer struct {
+	rate    int64
+	dial    *RateDial
+	cur     pacer
+	pace    func(rate int) pacer
+	dropped int64
+}
+
+func (p *dialPacer) wait(stop <-chan struct{}) int {
+	if rate := atomic.LoadInt64(&p.dial.rate); rate != p.rate {
+		p.dropped += p.cur.skipped()
+		p.cur, p.rate = p.pace(int(rate)), rate
+	}
+	return p.cur.wait(stop)
+}
+
+func (p *dialPacer) skipped() int64 {
+	return p.dropped + p.cur.skipped()
+}
@@ -0,0 +1,189 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// redfishState is a state of the hardware a Redfish generator reports on:
+// the message of the DMTF ResourceEvent registry the BMC sends in it, with
+// its arguments and severity, and how likely the next event is still in
+// this state.
+type redfishState struct {
+	messageID string
+	message   string
+	args      []string
+	severity  string
+	stay      float64
+}
+
+// redfishSpec describes the events of a Redfish generator: the resource the
+// BMC reports on and its states, in the order the hardware goes through
+// them, like the generatorSpec of the PTP generators.
+type redfishSpec struct {
+	// origin is the OriginOfCondition of the events
+	origin string
+	states []redfishState
+}
+
+// redfishGenerators are the built-in Redfish hardware event generators. The
+// events are the Event arrays a BMC posts to its event subscription, the
+// webhook of the hardware event proxy of cloud-event-proxy, like the event
+// files of the data directory.
+var redfishGenerators = map[string]*redfishSpec{
+	"redfish-temperature": {
+		origin: "/redfish/v1/Chassis/System.Embedded.1/Thermal#/Temperatures/0",
+		states: []redfishState{
+			{messageID: "ResourceEvent.1.0.ResourceWarningThresholdCleared", message: "The resource property %s has cleared the warning threshold of value %s.",
+				args: []string{"System Board Inlet Temp", "42"}, severity: "OK", stay: 0.98},
+			{messageID: "ResourceEvent.1.0.ResourceWarningThresholdExceeded", message: "The resource property %s has exceeded its warning threshold of value %s.",
+				args: []string{"System Board Inlet Temp", "42"}, severity: "Warning", stay: 0.9},
+			{messageID: "ResourceEvent.1.0.ResourceErrorThresholdExceeded", message: "The resource property %s has exceeded error threshold of value %s.",
+				args: []string{"System Board Inlet Temp", "47"}, severity: "Critical", stay: 0.8},
+		},
+	},
+	"redfish-fan": {
+		origin: "/redfish/v1/Chassis/System.Embedded.1/Thermal#/Fans/0",
+		states: []redfishState{
+			{messageID: "ResourceEvent.1.0.ResourceStatusChangedOK", message: "The health of resource `%s` has changed to %s.",
+				args: []string{"Fan 1", "OK"}, severity: "OK", stay: 0.98},
+			{messageID: "ResourceEvent.1.0.ResourceErrorThresholdExceeded", message: "The resource property %s has exceeded error threshold of value %s.",
+				args: []string{"Fan 1 RPM", "600"}, severity: "Warning", stay: 0.9},
+			{messageID: "ResourceEvent.1.0.ResourceStatusChangedCritical", message: "The health of resource `%s` has changed to %s.",
+				args: []string{"Fan 1", "Critical"}, severity: "Critical", stay: 0.8},
+		},
+	},
+	"redfish-power-supply": {
+		origin: "/redfish/v1/Chassis/System.Embedded.1/Power#/PowerSupplies/0",
+		states: []redfishState{
+			{messageID: "ResourceEvent.1.0.ResourceStatusChangedOK", message: "The health of resource `%s` has changed to %s.",
+				args: []string{"PSU 1", "OK"}, severity: "OK", stay: 0.98},
+			{messageID: "ResourceEvent.1.0.ResourceStatusChangedWarning", message: "The health of resource `%s` has changed to %s.",
+				args: []string{"PSU 1", "Warning"}, severity: "Warning", stay: 0.9},
+			{messageID: "ResourceEvent.1.0.ResourceStatusChangedCritical", message: "The health of resource `%s` has changed to %s.",
+				args: []string{"PSU 1", "Critical"}, severity: "Critical", stay: 0.85},
+			{messageID: "ResourceEvent.1.0.ResourceRemoved", message: "The resource `%s` has been removed.",
+				args: []string{"PSU 1"}, severity: "Critical", stay: 0.8},
+		},
+	},
+}
+
+func init() {
+	for name, spec := range redfishGenerators {
+		spec := spec
+		RegisterGenerator(name, func(*Config) (Generator, error) {
+			return newRedfishGenerator(spec), nil
+		})
+	}
+}
+
+// redfishGenerator is a built-in Redfish hardware event generator. Its
+// events walk through the states of its spec like those of the PTP
+// generators, with an event ID counting up as the BMC numbers them.
+type redfishGenerator struct {
+	spec *redfishSpec
+
+	mu      sync.Mutex
+	state   int
+	eventID int
+	rng     *rand.Rand
+}
+
+func newRedfishGenerator(spec *redfishSpec) *redfishGenerator {
+	return &redfishGenerator{
+		spec:    spec,
+		eventID: 1000,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next moves to the next state with the probability of leaving the current
+// one and generates an event in it.
+func (g *redfishGenerator) Next(buf *bytes.Buffer) error {
+	g.mu.Lock()
+	if g.rng.Float64() >= g.spec.states[g.state].stay {
+		g.state = (g.state + 1) % len(g.spec.states)
+	}
+	event := g.event(g.spec.states[g.state])
+	g.mu.Unlock()
+	buf.Reset()
+	buf.Write(event)
+	return nil
+}
+
+// Sequence returns an event for every state, in the order of the
+// transitions.
+func (g *redfishGenerator) Sequence() ([][]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	events := make([][]byte, len(g.spec.states))
+	for i, st := range g.spec.states {
+		events[i] = g.event(st)
+	}
+	return events, nil
+}
+
+// redfishRecord is an event record of a Redfish Event.
+type redfishRecord struct {
+	EventID           string            `json:"EventId"`
+	EventTimestamp    string            `json:"EventTimestamp"`
+	EventType         string            `json:"EventType"`
+	MemberID          string            `json:"MemberId"`
+	Message           string            `json:"Message"`
+	MessageArgs       []string          `json:"MessageArgs"`
+	MessageArgsCount  int               `json:"MessageArgs@odata.count"`
+	MessageID         string            `json:"MessageId"`
+	OriginOfCondition map[string]string `json:"OriginOfCondition"`
+	Severity          string            `json:"Severity"`
+}
+
+// redfishEvent is a Redfish Event as a BMC posts it.
+type redfishEvent struct {
+	ODataContext string          `json:"@odata.context"`
+	ODataID      string          `json:"@odata.id"`
+	ODataType    string          `json:"@odata.type"`
+	Context      string          `json:"Context"`
+	Events       []redfishRecord `json:"Events"`
+	ID           string          `json:"Id"`
+	Name         string          `json:"Name"`
+}
+
+// event builds an event in state st; the caller holds g.mu for the event
+// ID.
+func (g *redfishGenerator) event(st redfishState) []byte {
+	g.eventID++
+	args := make([]interface{}, len(st.args))
+	for i, a := range st.args {
+		args[i] = a
+	}
+	id := events.NewUUID()
+	e := redfishEvent{
+		ODataContext: "/redfish/v1/$metadata#Event.Event",
+		ODataID:      "/redfish/v1/EventService/Events/" + id,
+		ODataType:    "#Event.v1_3_0.Event",
+		Context:      "cloud-event-tester",
+		ID:           id,
+		Name:         "Event Array",
+		Events: []redfishRecord{{
+			EventID:           strconv.Itoa(g.eventID),
+			EventTimestamp:    time.Now().Format("2006-01-02T15:04:05-0700"),
+			EventType:         "Alert",
+			MemberID:          strconv.Itoa(g.eventID),
+			Message:           fmt.Sprintf(st.message, args...),
+			MessageArgs:       st.args,
+			MessageArgsCount:  len(st.args),
+			MessageID:         st.messageID,
+			OriginOfCondition: map[string]string{"@odata.id": g.spec.origin},
+			Severity:          st.severity,
+		}},
+	}
+	event, _ := json.Marshal(&e)
+	return event
+}
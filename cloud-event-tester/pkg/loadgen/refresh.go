@@ -0,0 +1,160 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// eventRefresher gives every event of a run a new ID and time, so consumers
+// that drop the events they have seen, by their ID, process every send: the
+// id of a cloud event is replaced with a random UUID and its time with the
+// time of the send, added if it has none; other events, like the Redfish
+// sample events, have their Id replaced, which is also the ID of the cloud
+// event they are sent as. The attributes are replaced in place, so the
+// events are not parsed again for every send, unlike with mutation rules.
+// Like the renderers it wraps it is safe for the send shards to call
+// concurrently.
+type eventRefresher struct {
+	// events renders the events, nil to send body
+	events events.Renderer
+	body   []byte
+	// where the ID and time of body are
+	spans   []refreshSpan
+	scratch sync.Pool
+}
+
+// refreshSpan is the value of the ID or time of an event, in the order of
+// the event; a time to add is an empty span after the opening brace.
+type refreshSpan struct {
+	start, end int
+	id, insert bool
+}
+
+// newEventRefresher returns the refresher of the events of renderer, or of
+// body if it is nil; a basic run, which refreshes the events it sends,
+// passes neither. It returns nil if the run does not refresh its events.
+func newEventRefresher(cfg *Config, renderer events.Renderer, body []byte) (*eventRefresher, error) {
+	if !cfg.RefreshIDs {
+		return nil, nil
+	}
+	r := &eventRefresher{
+		events:  renderer,
+		scratch: sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+	if renderer == nil && body != nil {
+		spans, err := findRefreshSpans(body)
+		if err != nil {
+			return nil, fmt.Errorf("event cannot be refreshed: %w", err)
+		}
+		r.body, r.spans = body, spans
+	}
+	log.Infof("Refresh IDs: every event sent with a new id and time")
+	return r, nil
+}
+
+func (r *eventRefresher) Render(buf *bytes.Buffer) error {
+	if r.events == nil {
+		writeRefreshed(buf, r.body, r.spans)
+		return nil
+	}
+	scratch := r.scratch.Get().(*bytes.Buffer)
+	defer r.scratch.Put(scratch)
+	if err := r.events.Render(scratch); err != nil {
+		return err
+	}
+	return r.refresh(buf, scratch.Bytes())
+}
+
+// refresh writes event to buf with a new ID and time.
+func (r *eventRefresher) refresh(buf *bytes.Buffer, event []byte) error {
+	spans, err := findRefreshSpans(event)
+	if err != nil {
+		return err
+	}
+	writeRefreshed(buf, event, spans)
+	return nil
+}
+
+// writeRefreshed writes event to buf with a new value at each of spans.
+func writeRefreshed(buf *bytes.Buffer, event []byte, spans []refreshSpan) {
+	var ts [64]byte
+	now := time.Now().UTC().AppendFormat(ts[:0], time.RFC3339Nano)
+	buf.Reset()
+	pos := 0
+	for _, s := range spans {
+		buf.Write(event[pos:s.start])
+		switch {
+		case s.id:
+			buf.WriteString(`"` + events.NewUUID() + `"`)
+		case s.insert:
+			buf.WriteString(`"time":"`)
+			buf.Write(now)
+			buf.WriteString(`",`)
+		default:
+			buf.WriteByte('"')
+			buf.Write(now)
+			buf.WriteByte('"')
+		}
+		pos = s.end
+	}
+	buf.Write(event[pos:])
+}
+
+// findRefreshSpans finds the ID and time of an event among its top-level
+// members.
+func findRefreshSpans(event []byte) ([]refreshSpan, error) {
+	dec := json.NewDecoder(bytes.NewReader(event))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("event is not a JSON object")
+	}
+	var cloudEvent bool
+	var id, legacyID, eventTime *refreshSpan
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("event is not a JSON object: %w", err)
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("event is not a JSON object: %w", err)
+		}
+		end := int(dec.InputOffset())
+		span := &refreshSpan{start: end - len(value), end: end}
+		switch key {
+		case "specversion":
+			cloudEvent = true
+		case "id":
+			span.id, id = true, span
+		case "Id":
+			span.id, legacyID = true, span
+		case "time":
+			eventTime = span
+		}
+	}
+	if _, err := dec.Token(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("event is not a JSON object: %w", err)
+	}
+	switch {
+	case !cloudEvent && legacyID == nil:
+		return nil, nil
+	case !cloudEvent:
+		return []refreshSpan{*legacyID}, nil
+	case id == nil:
+		return nil, fmt.Errorf("cloud event without id")
+	case eventTime == nil:
+		brace := bytes.IndexByte(event, '{') + 1
+		return []refreshSpan{{start: brace, end: brace, insert: true}, *id}, nil
+	case eventTime.start < id.start:
+		return []refreshSpan{*eventTime, *id}, nil
+	}
+	return []refreshSpan{*id, *eventTime}, nil
+}
@@ -0,0 +1,197 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// ReplayTiming replays a recording with the gaps between its events as they
+// were received, sped up or slowed down by speed.
+type ReplayTiming struct {
+	Original bool
+	Speed    float64
+}
+
+// Replay sends the events of a recording in order, at cfg.Rate events per
+// second, as fast as the target accepts them with rate 0, or as they were
+// received with the original timing. cfg is not changed by the replay.
+func Replay(ctx context.Context, cfg *Config, file string, loops int, timing ReplayTiming) (result *report.Result, err error) {
+	own := cfg.Clone()
+	cfg = &own
+	rec, err := events.OpenRecording(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Close()
+	state := &runState{breakdown: newLatencyBreakdown(cfg)}
+	defer func() { state.breakdown.report(result) }()
+	if rec.Len() == 0 {
+		return nil, fmt.Errorf("no events in recording %s", file)
+	}
+	// the offsets of the events from the first one, at the replay speed
+	var offsets []time.Duration
+	if timing.Original {
+		offsets = make([]time.Duration, rec.Len())
+		first, _ := rec.ReceivedAt(0)
+		for i := range offsets {
+			at, ok := rec.ReceivedAt(i)
+			if !ok {
+				return nil, fmt.Errorf("event %d of %s has no receive time, record it with receive -record", i+1, file)
+			}
+			offsets[i] = time.Duration(float64(at.Sub(first)) / timing.Speed)
+		}
+		log.Infof("Original timing: %v of traffic at %gx speed", offsets[len(offsets)-1], timing.Speed)
+	}
+	targets, err := resolveTargets(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	trec := newTargetRecorder(targets)
+	types := newEventTypeRecorder()
+	assert, _, err := cfg.assertions()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Replaying %d events from %s to %s, %d times", rec.Len(), file, cfg.URL, loops)
+	var pc pacer
+	// the original timing paces the replay itself, regardless of the rate
+	if !timing.Original && (cfg.Rate > 0 || cfg.BurstSize > 0) {
+		log.Infof("Messages Per Second: %s", cfg.RateText())
+		logLoadPattern(cfg)
+		pc = newRunPacer(cfg, cfg.Rate, cfg.BucketSize, cfg.BurstSize)
+	}
+
+	auth, err := sender.NewAuthenticator(&cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer auth.Close()
+	client := sender.WithAuth(state.client(cfg, nil), auth)
+	defer sender.CloseClient(client)
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	sender.SetHeaders(req, cfg.Headers)
+	events.SetLabelHeaders(req, cfg.Labels)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+
+	health := NewRunHealth(ctx)
+	defer health.close()
+	health.setReady(true)
+	health.loopStarted()
+	defer health.loopStopped()
+	result = &report.Result{Mode: "replay", StartTime: time.Now()}
+	total := rec.Len() * loops
+	lastLog := result.StartTime
+	latency := report.NewLatencyHistogram()
+	due := 0
+	// how far the replay fell behind the original timing
+	var maxLag time.Duration
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+loop:
+	for pass := 0; pass < loops; pass++ {
+		// the passes follow each other right away
+		passStart := time.Now()
+		for i := 0; i < rec.Len(); i++ {
+			if offsets != nil {
+				if wait := time.Until(passStart.Add(offsets[i])); wait > 0 {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(wait)
+					select {
+					case <-ctx.Done():
+						break loop
+					case <-timer.C:
+					}
+				} else if -wait > maxLag {
+					maxLag = -wait
+				}
+				if ctx.Err() != nil {
+					break loop
+				}
+			} else if pc != nil {
+				if due == 0 {
+					if due = pc.wait(ctx.Done()); due == 0 {
+						break loop
+					}
+				}
+				// a stopped replay does not send the rest of a batch
+				if ctx.Err() != nil {
+					break loop
+				}
+				due--
+			} else if ctx.Err() != nil {
+				break loop
+			}
+			health.Beat()
+			target := targets[result.TotalMsg%len(targets)]
+			req.SetRequestURI(sender.TargetURI(target))
+			result.TotalMsg++
+			start := time.Now()
+			event := events.LabelEvent(rec.Event(i), cfg.Labels)
+			if err := events.SetEvent(req, event, cfg.contentMode()); err != nil {
+				log.Debugf("Failed to convert event %d: %v", i+1, err)
+			} else if err := client.Do(req, res); err != nil {
+				log.WithFields(sendFields(filepath.Base(file), target, 0, time.Since(start))).Debugf("Failed to send event %d: %v", i+1, err)
+				trec.record(target, 0, true)
+				types.record(eventType(event), 0, true)
+			} else {
+				took := time.Since(start)
+				report.RecordLatency(latency, took)
+				result.CountStatus(res.StatusCode(), 1)
+				if kind, reason := assert.check(res); kind != "" {
+					log.WithFields(sendFields(filepath.Base(file), target, res.StatusCode(), took)).Debugf("Event %d failed an assertion: %s", i+1, reason)
+					result.CountAssertion(kind)
+					trec.record(target, took, true)
+					types.record(eventType(event), took, true)
+				} else {
+					result.Succeeded++
+					trec.record(target, took, false)
+					types.record(eventType(event), took, false)
+				}
+			}
+			if time.Since(lastLog) >= 10*time.Second {
+				lastLog = time.Now()
+				log.Infof("Replayed %d/%d events, %d succeeded", result.TotalMsg, total, result.Succeeded)
+			}
+		}
+	}
+
+	result.EndTime = time.Now()
+	result.Interrupted = ctx.Err() != nil
+	if result.Interrupted {
+		log.Info("******** Replay Interrupted ********")
+	}
+	elapsed := result.EndTime.Sub(result.StartTime).Seconds()
+	result.TotalSeconds = elapsed
+	if elapsed > 0 {
+		result.AvgRate = float64(result.TotalMsg) / elapsed
+	}
+	log.Infof("Replayed %d/%d events, %d succeeded, %.2f msg/s", result.TotalMsg, total, result.Succeeded, result.AvgRate)
+	logCounts("Assertion failures", result.AssertionFailures)
+	logStatusCodes(result.StatusCodes, assert)
+	if maxLag > 10*time.Millisecond {
+		log.Warnf("The replay fell up to %v behind the original timing", maxLag.Round(time.Millisecond))
+	}
+	result.Latency = report.SummarizeLatency(latency)
+	result.Latency.Log()
+	trec.report(result)
+	types.report(result)
+	return result, nil
+}
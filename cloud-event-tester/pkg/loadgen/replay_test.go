@@ -0,0 +1,37 @@
+package loadgen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReplayStops replays a recording at a rate the target cannot keep up
+// with and checks a cancelled replay stops within the catch-up batch of its
+// pacer.
+func TestReplayStops(t *testing.T) {
+	url, _ := testTarget(t)
+	file := filepath.Join(t.TempDir(), "recording.ndjson")
+	event := `{"specversion":"1.0","id":"1","source":"test","type":"test"}` + "\n"
+	if err := os.WriteFile(file, []byte(event+event), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.Rate = 1000000000
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := Replay(ctx, &cfg, file, 1<<30, ReplayTiming{})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("replay took %v to stop, want about 500ms", took)
+	}
+	if !result.Interrupted {
+		t.Errorf("replay not reported interrupted")
+	}
+}
@@ -0,0 +1,221 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// RunReport is the JSON report of a finished run, as stored by the results
+// server.
+type RunReport struct {
+	ID       string         `json:"id,omitempty"`
+	Received *time.Time     `json:"received,omitempty"`
+	Host     string         `json:"host,omitempty"`
+	Config   Config         `json:"config"`
+	Result   *report.Result `json:"result"`
+}
+
+// NewRunReport returns the report of a run with the settings cfg.
+func NewRunReport(cfg *Config, result *report.Result) *RunReport {
+	host, _ := os.Hostname()
+	return &RunReport{Host: host, Config: cfg.Redacted(), Result: result}
+}
+
+// uploadReport sends the report of a run to a results server and returns
+// the URL of the stored report.
+func uploadReport(ctx context.Context, server string, report *RunReport) (string, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	server = strings.TrimRight(server, "/")
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+"/api/reports", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer sender.DrainBody(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("results server returned %s", resp.Status)
+	}
+	var stored RunReport
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return "", err
+	}
+	return server + "/reports/" + stored.ID, nil
+}
+
+// PublishReport uploads the report of a run if a results server is
+// configured. Failures are logged; they do not fail the run.
+func PublishReport(cfg *Config, result *report.Result) {
+	if cfg.ResultsServer == "" || result == nil {
+		return
+	}
+	// the run may have been cancelled, the upload should still happen
+	url, err := uploadReport(context.Background(), cfg.ResultsServer, NewRunReport(cfg, result))
+	if err != nil {
+		log.Errorf("Failed to upload report to %s: %v", cfg.ResultsServer, err)
+		return
+	}
+	result.ReportURL = url
+	log.Infof("Report: %s", url)
+}
+
+// formats of the report file
+const (
+	ReportJSON = "json"
+	reportCSV  = "csv"
+)
+
+// WriteReportFile writes the report of a run to the report file, if one is
+// configured, for CI jobs that should not parse the log. Failures are
+// logged; they do not fail the run.
+func WriteReportFile(cfg *Config, result *report.Result) {
+	if cfg.ReportFile == "" || result == nil {
+		return
+	}
+	var buf bytes.Buffer
+	rep := NewRunReport(cfg, result)
+	switch strings.ToLower(cfg.ReportFormat) {
+	case reportCSV:
+		writeReportCSV(&buf, rep)
+	case reportHTML:
+		if err := WriteReportHTML(&buf, rep); err != nil {
+			log.Errorf("Failed to render HTML report: %v", err)
+			return
+		}
+	default:
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep) //nolint: errcheck
+	}
+	if cfg.ReportFile == "-" {
+		os.Stdout.Write(buf.Bytes()) //nolint: errcheck
+		return
+	}
+	if err := os.WriteFile(cfg.ReportFile, buf.Bytes(), 0o644); err != nil {
+		log.Errorf("Failed to write report file: %v", err)
+		return
+	}
+	log.Infof("Report file: %s", cfg.ReportFile)
+}
+
+// writeReportCSV writes a report as metric,value rows: the totals, the
+// latency percentiles, the errors by kind and one row per counter of every
+// second, like timeline.3.sent.
+func writeReportCSV(w io.Writer, rep *RunReport) {
+	cw := csv.NewWriter(w)
+	row := func(metric string, value interface{}) {
+		cw.Write([]string{metric, fmt.Sprint(value)}) //nolint: errcheck
+	}
+	r := rep.Result
+	row("metric", "value")
+	row("mode", r.Mode)
+	row("host", rep.Host)
+	row("url", rep.Config.URL)
+	row("startTime", r.StartTime.Format(time.RFC3339Nano))
+	row("endTime", r.EndTime.Format(time.RFC3339Nano))
+	row("totalSeconds", r.TotalSeconds)
+	row("totalMsg", r.TotalMsg)
+	if r.Mode == "basic" {
+		row("succeeded", r.Succeeded)
+		row("files", r.Files)
+	}
+	row("avgRate", r.AvgRate)
+	if r.BatchSize > 1 {
+		row("batchSize", r.BatchSize)
+		row("events", r.Events)
+		row("eventRate", r.EventRate)
+	}
+	row("requestedRate", r.RequestedRate)
+	row("dueMsg", r.DueMsg)
+	row("overDelivered", r.OverDelivered)
+	row("interrupted", r.Interrupted)
+	row("skipped", r.Skipped)
+	row("connections", r.Connections)
+	row("connectionReuse", r.ConnectionReuse)
+	row("schemaViolations", r.SchemaViolations)
+	if r.Pool != nil {
+		row("pool.dropped", r.Pool.Dropped)
+		row("pool.failed", r.Pool.Failed)
+		row("pool.maxQueueDepth", r.Pool.MaxQueueDepth)
+	}
+	if l := r.Latency; l != nil {
+		row("latency.count", l.Count)
+		row("latency.min", l.Min)
+		row("latency.mean", l.Mean)
+		row("latency.stddev", l.StdDev)
+		row("latency.p50", l.P50)
+		row("latency.p90", l.P90)
+		row("latency.p95", l.P95)
+		row("latency.p99", l.P99)
+		row("latency.p999", l.P999)
+		row("latency.max", l.Max)
+	}
+	if b := r.LatencyBreakdown; b != nil {
+		row("latencyBreakdown.samples", b.Samples)
+		for _, p := range b.Phases() {
+			row("latencyBreakdown."+p.Name+".p50", p.Stats.P50)
+			row("latencyBreakdown."+p.Name+".p99", p.Stats.P99)
+		}
+	}
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		row("errors."+kind, r.Errors[kind])
+	}
+	kinds = kinds[:0]
+	for kind := range r.AssertionFailures {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		row("assertionFailures."+kind, r.AssertionFailures[kind])
+	}
+	kinds = kinds[:0]
+	for code := range r.StatusCodes {
+		kinds = append(kinds, code)
+	}
+	sort.Strings(kinds)
+	for _, code := range kinds {
+		row("statusCodes."+code, r.StatusCodes[code])
+	}
+	for _, t := range r.Timeline {
+		prefix := fmt.Sprintf("timeline.%d.", t.Second)
+		row(prefix+"sent", t.Sent)
+		row(prefix+"totalMsg", t.TotalMsg)
+		row(prefix+"queueDepth", t.QueueDepth)
+		row(prefix+"errors", t.Errors)
+		if t.P50 > 0 {
+			row(prefix+"p50", t.P50)
+			row(prefix+"p90", t.P90)
+			row(prefix+"p95", t.P95)
+			row(prefix+"p99", t.P99)
+			row(prefix+"max", t.Max)
+		}
+	}
+	cw.Flush()
+}
@@ -0,0 +1,198 @@
+package loadgen
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// resultsSchema creates the tables of a results database: a row per run with
+// its headline metrics and its whole report, the seconds of the timeline of
+// every performance run and the sampled requests of the runs that kept
+// samples.
+const resultsSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at TEXT NOT NULL,
+	ended_at TEXT NOT NULL,
+	host TEXT NOT NULL,
+	mode TEXT NOT NULL,
+	url TEXT NOT NULL,
+	labels TEXT,
+	rate INTEGER,
+	duration REAL,
+	total_msg INTEGER,
+	total_seconds REAL,
+	avg_rate REAL,
+	error_rate REAL,
+	p50_ms REAL,
+	p99_ms REAL,
+	max_ms REAL,
+	interrupted INTEGER NOT NULL,
+	config TEXT NOT NULL,
+	result TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_started_at ON runs (started_at);
+CREATE TABLE IF NOT EXISTS timeline (
+	run_id INTEGER NOT NULL REFERENCES runs (id),
+	second INTEGER NOT NULL,
+	sent INTEGER NOT NULL,
+	errors INTEGER NOT NULL,
+	queue_depth INTEGER NOT NULL,
+	rate INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS timeline_run_id ON timeline (run_id);
+CREATE TABLE IF NOT EXISTS samples (
+	run_id INTEGER NOT NULL REFERENCES runs (id),
+	sent_at TEXT NOT NULL,
+	target TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	latency_ms REAL NOT NULL,
+	error TEXT
+);
+CREATE INDEX IF NOT EXISTS samples_run_id ON samples (run_id);
+`
+
+// requestSampler keeps a uniform random sample of the requests of a
+// performance run, at most size of them, so the requests of a run of hours
+// fit into the database. It is safe for concurrent use; a nil sampler keeps
+// nothing.
+type requestSampler struct {
+	mu      sync.Mutex
+	size    int
+	seen    int64
+	rng     *rand.Rand
+	samples []report.RequestSample
+}
+
+func newRequestSampler(cfg *Config) *requestSampler {
+	if cfg.ResultsDB == "" || cfg.ResultsDBSamples == 0 {
+		return nil
+	}
+	return &requestSampler{size: cfg.ResultsDBSamples, rng: rand.New(rand.NewSource(time.Now().UnixNano()))} //nolint: gosec
+}
+
+// record offers a request that was sent at sent and answered with status
+// after latency, or failed with err, to the sample.
+func (s *requestSampler) record(sent time.Time, target string, status int, latency time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	sample := report.RequestSample{SentAt: sent, Target: target, Status: status, Latency: latency}
+	if err != nil {
+		sample.Status, sample.Error = 0, err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	if len(s.samples) < s.size {
+		s.samples = append(s.samples, sample)
+		return
+	}
+	// reservoir sampling: the n-th request replaces one at random with a
+	// chance of size/n
+	if i := s.rng.Int63n(s.seen); i < int64(s.size) {
+		s.samples[i] = sample
+	}
+}
+
+// report hands the sample to the run result, for the results database.
+func (s *requestSampler) report(result *report.Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result.Samples = s.samples
+}
+
+// WriteResultsDB appends a run to the results database, if one is
+// configured, creating it on first use. Failures are logged; they do not
+// fail the run.
+func WriteResultsDB(cfg *Config, result *report.Result) {
+	if cfg.ResultsDB == "" || result == nil {
+		return
+	}
+	id, err := insertRun(cfg, result)
+	if err != nil {
+		log.Errorf("Failed to write the run to results database %s: %v", cfg.ResultsDB, err)
+		return
+	}
+	log.Infof("Results database: run %d in %s", id, cfg.ResultsDB)
+}
+
+func insertRun(cfg *Config, result *report.Result) (int64, error) {
+	db, err := sql.Open("sqlite", cfg.ResultsDB)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	// nightly runs of several testers may share a database
+	if _, err := db.Exec("PRAGMA busy_timeout = 10000"); err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec(resultsSchema); err != nil {
+		return 0, err
+	}
+	rep := NewRunReport(cfg, result)
+	config, err := json.Marshal(rep.Config)
+	if err != nil {
+		return 0, err
+	}
+	res, err := json.Marshal(rep.Result)
+	if err != nil {
+		return 0, err
+	}
+	var labels interface{}
+	if len(result.Labels) > 0 {
+		data, _ := json.Marshal(result.Labels)
+		labels = string(data)
+	}
+	var p50, p99, slowest interface{}
+	if l := result.Latency; l != nil {
+		p50, p99, slowest = l.P50, l.P99, l.Max
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint: errcheck
+	row, err := tx.Exec(`INSERT INTO runs (started_at, ended_at, host, mode, url, labels, rate, duration,
+		total_msg, total_seconds, avg_rate, error_rate, p50_ms, p99_ms, max_ms, interrupted, config, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		result.StartTime.UTC().Format(time.RFC3339Nano), result.EndTime.UTC().Format(time.RFC3339Nano),
+		rep.Host, result.Mode, cfg.URL, labels, cfg.Rate, cfg.Duration,
+		result.TotalMsg, result.TotalSeconds, result.AvgRate, result.ErrorRate, p50, p99, slowest,
+		result.Interrupted, string(config), string(res))
+	if err != nil {
+		return 0, err
+	}
+	id, err := row.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range result.Timeline {
+		if _, err := tx.Exec("INSERT INTO timeline (run_id, second, sent, errors, queue_depth, rate) VALUES (?, ?, ?, ?, ?, ?)",
+			id, t.Second, t.Sent, t.Errors, t.QueueDepth, t.Rate); err != nil {
+			return 0, err
+		}
+	}
+	for _, s := range result.Samples {
+		var errText interface{}
+		if s.Error != "" {
+			errText = s.Error
+		}
+		if _, err := tx.Exec("INSERT INTO samples (run_id, sent_at, target, status, latency_ms, error) VALUES (?, ?, ?, ?, ?, ?)",
+			id, s.SentAt.UTC().Format(time.RFC3339Nano), s.Target, s.Status, float64(s.Latency)/float64(time.Millisecond), errText); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
@@ -0,0 +1,1385 @@
+// Package loadgen runs the tests of cloud-event-tester: the basic runs, which
+// send event files once, and the performance runs, which send events at a
+// rate for a duration. Test harnesses embed it instead of running the binary:
+//
+//	cfg := loadgen.DefaultConfig()
+//	cfg.URL = "http://localhost:9043/webhook"
+//	cfg.Perf = "YES"
+//	cfg.Rate = 1000
+//	cfg.Duration = 30
+//	result, err := loadgen.Run(ctx, &cfg, nil)
+//
+// The settings are those of the flags and environment variables of the tool,
+// with the same defaults, and a run reports its results, notifications and
+// report files as the tool does.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// ErrorRate returns the percentage of failed sends of a performance run with
+// totalMsg messages sent. NO counts failed sends as sent.
+func ErrorRate(checkResp string, totalMsg, failed int) float64 {
+	attempts := totalMsg + failed
+	if strings.ToUpper(checkResp) == "NO" {
+		attempts = totalMsg
+	}
+	if attempts == 0 {
+		return 0
+	}
+	return 100 * float64(failed) / float64(attempts)
+}
+
+// StatsListener is called with the stats of every second of a performance
+// run.
+type StatsListener func(report.TickStats)
+
+// runState is the state of a run that its clients and stamper share, kept
+// for the run only so the settings of the caller are left as they are.
+type runState struct {
+	// breakdown samples the requests of the run for their latency
+	// breakdown, see latencyBreakdown
+	breakdown *latencyBreakdown
+	// bodies streams the bodies of the requests of a performance run, see
+	// bodyStreamer
+	bodies *bodyStreamer
+	// duplicates sends the copies of the requests of the run, see duplicator
+	duplicates *duplicator
+	// clock is the clock offset of the receiver of the run, see clockSync
+	clock *clockSync
+}
+
+// Run runs a basic or performance test with the given settings until it
+// completes or ctx is cancelled. onTick, if not nil, receives the per-second
+// stats of performance runs. Once the settings are valid, the outcome is
+// reported to the results server and notification webhook, if configured.
+// cfg is not changed by the run.
+func Run(ctx context.Context, cfg *Config, onTick StatsListener) (result *report.Result, err error) {
+	// the run resolves its target URL and shard rate on a copy
+	own := cfg.Clone()
+	cfg = &own
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.FindMax != "" {
+		// every probe is a run of its own
+		return findMaxRate(ctx, cfg, onTick)
+	}
+	state := &runState{breakdown: newLatencyBreakdown(cfg), duplicates: newDuplicator(cfg)}
+	defer func() {
+		state.breakdown.report(result)
+		state.duplicates.report(result)
+		if result != nil {
+			result.Labels = cfg.Labels
+			PublishReport(cfg, result)
+			WriteReportFile(cfg, result)
+			WriteTimeseriesFile(cfg, result)
+			WriteJUnitFile(cfg, result)
+			WriteResultsDB(cfg, result)
+		}
+		NotifyCompletion(cfg, result, err)
+	}()
+
+	if cfg.URL == autoURL {
+		if err := resolveProxyURL(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+	clock, err := newClockSync(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	state.clock = clock
+	defer func() {
+		clock.close()
+		clock.report(result)
+	}()
+	if cfg.SubscribeEndpoint != "" {
+		sub, err := subscribe(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			uerr := sub.unsubscribe()
+			if result != nil {
+				result.Checks = append(sub.checks, result.Checks...)
+			}
+			if err == nil {
+				err = uerr
+			}
+		}()
+	}
+	switch {
+	case cfg.IsPerf():
+		if cfg.ShardRate {
+			if err := shardRate(ctx, cfg); err != nil {
+				return nil, err
+			}
+		}
+		return perfTest(ctx, cfg, state, onTick)
+	case cfg.isWatch():
+		return watchTest(ctx, cfg, state)
+	default:
+		return basicTest(ctx, cfg, state)
+	}
+}
+
+// sendFields are the fields of the log of a send, the same in every mode so
+// JSON logs can be parsed alike. A send without a response has no status.
+func sendFields(file, target string, status int, latency time.Duration) log.Fields {
+	fields := log.Fields{"event_file": file, "target": target}
+	if status > 0 {
+		fields["status"] = status
+	}
+	if latency > 0 {
+		fields["latency_ms"] = float64(latency.Microseconds()) / 1000
+	}
+	return fields
+}
+
+// EventFiles returns the event files of a basic test: the configured event
+// file, or all JSON files in the data directory.
+func EventFiles(cfg *Config) ([]string, error) {
+	if cfg.EventFile != "" {
+		return []string{cfg.EventFile}, nil
+	}
+	return filepath.Glob(cfg.DataDir + "*.json")
+}
+
+func basicTest(ctx context.Context, cfg *Config, state *runState) (*report.Result, error) {
+	// a generator sends an event for each of its states, named after it
+	var files []string
+	var generated [][]byte
+	if cfg.Generator != "" {
+		gen, err := newGenerator(cfg)
+		if err != nil {
+			return nil, err
+		}
+		generated, err = gen.sequence()
+		gen.close()
+		if err != nil {
+			return nil, err
+		}
+		for i := range generated {
+			files = append(files, fmt.Sprintf("%s-%d", cfg.Generator, i+1))
+		}
+		log.Infof("Testing with %d events of generator %s", len(files), cfg.Generator)
+	} else {
+		var err error
+		if files, err = EventFiles(cfg); err != nil {
+			return nil, err
+		}
+		if cfg.EventFile != "" {
+			log.Infof("Testing with specific event file: %s", cfg.EventFile)
+		} else {
+			log.Infof("Testing with %d event files from directory: %s", len(files), cfg.DataDir)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no event files found to test")
+	}
+	allAsserts, fileAsserts, err := cfg.assertions()
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := events.LoadSchemas(cfg.SchemaDir, cfg.Schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := resolveTargets(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	trec := newTargetRecorder(targets)
+	types := newEventTypeRecorder()
+	capture := newResponseCapture(cfg)
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	sender.SetHeaders(req, cfg.Headers)
+	events.SetLabelHeaders(req, cfg.Labels)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	auth, err := sender.NewAuthenticator(&cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer auth.Close()
+	var connections int64
+	client := sender.WithAuth(state.client(cfg, &connections), auth)
+	defer sender.CloseClient(client)
+	cfg.LogProxy()
+
+	encoder, err := events.NewDataEncoder(cfg.DataEncoding, cfg.DataSchema, cfg.DataMessage, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	refresher, err := newEventRefresher(cfg, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var refreshed bytes.Buffer
+	stamper, err := newEventStamper(cfg, state.clock, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var stamped bytes.Buffer
+	fo := newFailover(cfg)
+	health := NewRunHealth(ctx)
+	defer health.close()
+	health.setReady(true)
+	result := &report.Result{Mode: "basic", StartTime: time.Now(), Files: len(files)}
+	var seq int64
+	// set when a strict schema violation aborts the run
+	var schemaErr error
+	total := len(files) * cfg.Loop
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	n := 0
+sends:
+	for loop := 0; loop < cfg.Loop; loop++ {
+		if cfg.Shuffle {
+			rand.Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+		}
+		for _, i := range order {
+			file := files[i]
+			name := filepath.Base(file)
+			// the checks of a file are told apart by their loop
+			check := report.CheckResult{Name: name}
+			if cfg.Loop > 1 {
+				check.Name = fmt.Sprintf("%s (loop %d)", name, loop+1)
+			}
+			n++
+			start := time.Now()
+			var event []byte
+			// a streamed file is sent as it is, from disk
+			var stream *streamFile
+			var err error
+			switch {
+			case generated != nil:
+				event = generated[i]
+			case cfg.StreamBodies:
+				sf, err := statStreamFile(file, cfg.contentMode(), cfg.MaxBodyBytes)
+				if err != nil {
+					log.Errorf("Failed to stream file %s: %v", file, err)
+					result.Checks = append(result.Checks, check.Fail("failed to stream: %v", err))
+					continue
+				}
+				stream = &sf
+			default:
+				if err = checkFileSize(file, cfg.MaxBodyBytes); err == nil {
+					event, err = events.ReadEventFile(file)
+				}
+				if err != nil {
+					log.Errorf("Failed to read file %s: %v", file, err)
+					result.Checks = append(result.Checks, check.Fail("failed to read: %v", err))
+					continue
+				}
+			}
+			if stream == nil {
+				if event, err = events.RenderEvent(name, events.LabelEvent(event, cfg.Labels), &seq); err != nil {
+					log.Errorf("Failed to render %s: %v", name, err)
+					result.Checks = append(result.Checks, check.Fail("failed to render: %v", err))
+					continue
+				}
+				if encoder != nil {
+					if err := encoder.EncodeEvent(&encoded, event); err != nil {
+						log.Errorf("Failed to encode %s: %v", name, err)
+						result.Checks = append(result.Checks, check.Fail("failed to encode: %v", err))
+						continue
+					}
+					event = encoded.Bytes()
+				}
+				if refresher != nil {
+					if err := refresher.refresh(&refreshed, event); err != nil {
+						log.Errorf("Failed to refresh %s: %v", name, err)
+						result.Checks = append(result.Checks, check.Fail("failed to refresh: %v", err))
+						continue
+					}
+					event = refreshed.Bytes()
+				}
+				if stamper != nil {
+					if err := stamper.stamp(&stamped, event); err != nil {
+						log.Errorf("Failed to stamp %s: %v", name, err)
+						result.Checks = append(result.Checks, check.Fail("failed to stamp: %v", err))
+						continue
+					}
+					event = stamped.Bytes()
+				}
+				if err := schemas.Check(name, event); err != nil {
+					result.SchemaViolations++
+					if cfg.SchemaStrict {
+						log.Errorf("Aborting the run: %v", err)
+						result.Checks = append(result.Checks, check.Fail("%v", err))
+						schemaErr = err
+						break sends
+					}
+					log.Warnf("%v", err)
+				}
+			}
+
+			// files are sent to the targets in turn
+			target, peer := targets[(n-1)%len(targets)], cfg.BackupURL
+			if fo.onBackup() {
+				target, peer = cfg.BackupURL, cfg.URL
+			}
+			log.WithFields(sendFields(name, target, 0, 0)).Infof("[%d/%d] Sending event from file: %s", n, total, name)
+			req.SetRequestURI(sender.TargetURI(target))
+			if stream != nil {
+				log.Debugf("Event content: %s streamed from disk", FormatBytes(uint64(stream.size)))
+				err = setEventStream(req, *stream)
+			} else {
+				log.Debugf("Event content: %s", string(event))
+				err = events.SetEvent(req, event, cfg.contentMode())
+			}
+			if err != nil {
+				log.WithFields(sendFields(name, target, 0, 0)).Errorf("Failed to send event: %v", err)
+				result.Checks = append(result.Checks, check.Fail("%v", err))
+				continue
+			}
+			result.TotalMsg++
+			sent := time.Now()
+			err = client.Do(req, res)
+			took := time.Since(sent)
+			fo.record(target, peer, err)
+			check.Seconds = time.Since(start).Seconds()
+			if err != nil {
+				log.WithFields(sendFields(name, target, 0, took)).Errorf("Failed to send event: %v", err)
+				check = check.Fail("failed to send: %v", err)
+			} else {
+				fields := sendFields(name, target, res.StatusCode(), took)
+				log.WithFields(fields).Infof("Event sent successfully, response status: %d", res.StatusCode())
+				result.CountStatus(res.StatusCode(), 1)
+				if kind, reason := assertionFor(allAsserts, fileAsserts, name).check(res); kind != "" {
+					log.WithFields(fields).Errorf("Response assertion failed: %s", reason)
+					result.CountAssertion(kind)
+					capture.record(sent, req, res, target, reason)
+					check = check.Fail("%s", reason)
+				} else {
+					result.Succeeded++
+					check.Passed = true
+				}
+			}
+			trec.record(target, time.Since(start), !check.Passed)
+			types.record(eventType(event), time.Since(start), !check.Passed)
+			result.Checks = append(result.Checks, check)
+			if n == total {
+				break sends
+			}
+			select {
+			case <-ctx.Done():
+				result.Interrupted = true
+				break sends
+			case <-time.After(cfg.Interval):
+			}
+		}
+	}
+
+	result.EndTime = time.Now()
+	result.CountConnections(atomic.LoadInt64(&connections), cfg.NoKeepAlive)
+	trec.report(result)
+	types.report(result)
+	fo.report(result)
+	stamper.report(result)
+	capture.report(result)
+	logStatusCodes(result.StatusCodes, allAsserts)
+	if len(result.Checks) > 0 {
+		failed := 0
+		for _, c := range result.Checks {
+			if !c.Passed {
+				failed++
+			}
+		}
+		result.ErrorRate = 100 * float64(failed) / float64(len(result.Checks))
+	}
+	result.Checks = append(result.Checks, SLAChecks(cfg, result)...)
+	LogChecks(result)
+	if result.Interrupted {
+		log.Infof("Basic test interrupted. Successfully sent %d/%d events", result.Succeeded, result.TotalMsg)
+	} else {
+		log.Infof("Basic test completed. Successfully sent %d/%d events", result.Succeeded, total)
+	}
+	if result.SchemaViolations > 0 {
+		log.Warnf("%d events violated their schemas", result.SchemaViolations)
+	}
+	return result, schemaErr
+}
+
+// NewEventRequest builds the POST of an event to url in the content mode of
+// the run; the caller checks the event with events.CheckContentMode. Performance runs build
+// one per target up front and send it over and over, so nothing is
+// serialized or allocated per message.
+func NewEventRequest(url string, body []byte, mode events.ContentMode, headers, labels map[string]string) *fasthttp.Request {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	events.SetEvent(req, body, mode) //nolint: errcheck
+	req.SetRequestURI(sender.TargetURI(url))
+	sender.SetHeaders(req, headers)
+	events.SetLabelHeaders(req, labels)
+	return req
+}
+
+func perfTest(ctx context.Context, cfg *Config, state *runState, onTick StatsListener) (*report.Result, error) {
+	// Use default event file or specified one
+	defaultEventFile := filepath.Join(cfg.DataDir, "TMP0100.json")
+	if cfg.EventFile != "" {
+		defaultEventFile = cfg.EventFile
+	}
+
+	// a generator replaces the event files; the first event it generates
+	// builds the requests
+	eventName := filepath.Base(defaultEventFile)
+	var eventTMP0100 []byte
+	var gen *runGenerator
+	// an event mix is the generator of the run
+	var mix *eventMix
+	var err error
+	switch {
+	case cfg.StreamBodies:
+		// the requests are built without a body, the streamer sets it on
+		// every send
+		if state.bodies, err = newBodyStreamer(cfg, defaultEventFile); err != nil {
+			return nil, err
+		}
+		if cfg.isEventMix() {
+			eventName = mixEventName
+		}
+	case cfg.Generator != "":
+		if gen, err = newGenerator(cfg); err != nil {
+			return nil, err
+		}
+		eventName = cfg.Generator
+	case cfg.isEventMix():
+		if mix, err = newEventMix(cfg); err != nil {
+			return nil, err
+		}
+		gen = &runGenerator{gen: mix, labels: cfg.Labels}
+		eventName = mixEventName
+	default:
+		if err := checkFileSize(defaultEventFile, cfg.MaxBodyBytes); err != nil {
+			return nil, err
+		}
+		if eventTMP0100, err = events.ReadEventFile(defaultEventFile); err != nil {
+			return nil, fmt.Errorf("failed to read event file %s: %w", defaultEventFile, err)
+		}
+	}
+	if gen != nil {
+		defer gen.close()
+		var first bytes.Buffer
+		if err := gen.Render(&first); err != nil {
+			return nil, err
+		}
+		eventTMP0100 = first.Bytes()
+	}
+	allAsserts, fileAsserts, err := cfg.assertions()
+	if err != nil {
+		return nil, err
+	}
+	assert := assertionFor(allAsserts, fileAsserts, eventName)
+
+	targets, err := resolveTargets(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	trec := newTargetRecorder(targets)
+	limiter, err := newGlobalLimiter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if limiter != nil {
+		defer limiter.close()
+	}
+
+	log.Infof("=== Performance Test Configuration ===")
+	log.Infof("Webhook URL: %v", cfg.URL)
+	log.Infof("Messages Per Second: %s", cfg.RateText())
+	logLoadPattern(cfg)
+	log.Infof("Test Duration: %s", cfg.durationText())
+	log.Infof("Initial Delay: %d seconds", cfg.Delay)
+	log.Infof("CHECK_RESP: %v", cfg.CheckResp)
+	log.Infof("WITH_MESSAGE_FIELD: %v", cfg.WithMessage)
+	switch {
+	case state.bodies != nil:
+	case mix != nil:
+		mix.log()
+	case gen != nil:
+		log.Infof("Event Generator: %s", cfg.Generator)
+	default:
+		log.Infof("Event File: %s", defaultEventFile)
+	}
+	if cfg.isKafka() {
+		log.Infof("Kafka Topic: %s on %s", cfg.KafkaTopic, cfg.KafkaBrokers)
+	} else {
+		log.Infof("HTTP Stack: %s, Max Conns Per Host: %d, Request Timeout: %v", cfg.HTTPStack, cfg.MaxConnsPerHost, cfg.RequestTimeout)
+		if v := cfg.Version(); v != "" {
+			log.Infof("HTTP Version: %s", v)
+		}
+		if cfg.NoKeepAlive {
+			log.Infof("Keep-Alive: off, a new connection for every request")
+		} else if cfg.ConnMaxLifetime > 0 {
+			log.Infof("Connection Lifetime: %v", cfg.ConnMaxLifetime)
+		}
+		cfg.LogProxy()
+	}
+	if len(cfg.Labels) > 0 {
+		log.Infof("Labels: %s", events.FormatLabels(cfg.Labels))
+	}
+
+	var cp *checkpoint
+	if cfg.Resume {
+		if cp, err = loadCheckpoint(cfg.CheckpointFile); err != nil {
+			return nil, err
+		}
+		if cp != nil {
+			if err := cp.compatible(cfg); err != nil {
+				return nil, err
+			}
+			if cp.Completed {
+				log.Infof("Run in checkpoint %s has already completed", cfg.CheckpointFile)
+				return checkpointResult(cp), nil
+			}
+			log.Infof("Resuming run started at %v after %.1f seconds, test duration %s, %d msg sent",
+				cp.StartTime.Format(time.RFC3339), cp.ElapsedSeconds, cfg.durationText(), cp.TotalMsg)
+		} else {
+			log.Infof("No checkpoint found in %s, starting a new run", cfg.CheckpointFile)
+		}
+	}
+
+	// a resumed run has already waited for its initial delay
+	if cp == nil {
+		log.Infof("Sleeping %d sec...", cfg.Delay)
+		select {
+		case <-ctx.Done():
+			log.Info("******** Performance Test Interrupted before start ********")
+			now := time.Now()
+			return &report.Result{Mode: "perf", StartTime: now, EndTime: now, Interrupted: true}, nil
+		case <-time.After(time.Duration(cfg.Delay) * time.Second):
+		}
+	}
+
+	var (
+		totalPerSecMsgCount uint64
+		// whole seconds of the run, for the per second stats
+		totalSeconds int
+		// time sent in earlier segments of a resumed run
+		resumedElapsed time.Duration
+		totalMsg       int64
+		// connections opened by the clients of the shards
+		connections int64
+		// the per second stats, appended by the ticker
+		timeline []report.TickStats
+		sendErrs = newSendErrors(assert)
+	)
+
+	body := eventTMP0100
+	// -with-msg NO is a mutation rule too; rules that change every event
+	// alike are applied once, before the event is labeled and padded
+	mutations, err := cfg.mutationRules()
+	if err != nil {
+		return nil, err
+	}
+	if gen == nil && !bytes.Contains(body, []byte("{{")) {
+		if body, mutations, err = events.ApplyStaticMutations(body, mutations); err != nil {
+			return nil, err
+		}
+	}
+	body = events.LabelEvent(body, cfg.Labels)
+	if cfg.PayloadSize > 0 {
+		if body = padEventTo(body, cfg.PayloadSize); len(body) > cfg.PayloadSize {
+			log.Warnf("Payload Size: the event has %d bytes, more than %d", len(body), cfg.PayloadSize)
+		} else {
+			log.Infof("Payload Size: event padded to %d bytes", len(body))
+		}
+	}
+	// placeholders are rendered for every send, which costs time and
+	// allocations the prebuilt requests otherwise avoid
+	var seq int64
+	var tmpl events.Renderer
+	// the broken events of -fault-rate are made of the event as sent
+	faultBody := body
+	t, err := events.ParseTemplate(eventName, body, &seq)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case gen != nil:
+		// so is every generated event
+		tmpl = gen
+		if err := events.CheckContentMode(body, cfg.isBinary()); err != nil {
+			return nil, err
+		}
+		if mix == nil {
+			log.Infof("Event Generator: an event generated for every send")
+		}
+	case t != nil:
+		tmpl = t
+		// fail before the run rather than on every send
+		event, err := events.RenderEvent(eventName, body, new(int64))
+		if err != nil {
+			return nil, err
+		}
+		if err := events.CheckContentMode(event, cfg.isBinary()); err != nil {
+			return nil, err
+		}
+		faultBody = event
+		log.Infof("Event Template: placeholders rendered for every send")
+	default:
+		if err := events.CheckContentMode(body, cfg.isBinary()); err != nil {
+			return nil, err
+		}
+	}
+	mutator, err := events.NewEventMutator(mutations, tmpl, body)
+	if err != nil {
+		return nil, err
+	}
+	if mutator != nil {
+		// mutated events are rendered for every send, before they are
+		// stamped
+		tmpl = mutator
+	}
+	encoder, err := events.NewDataEncoder(cfg.DataEncoding, cfg.DataSchema, cfg.DataMessage, tmpl, body)
+	if err != nil {
+		return nil, err
+	}
+	if encoder != nil {
+		// encoded after the mutations, which change the JSON data
+		tmpl = encoder
+	}
+	refresher, err := newEventRefresher(cfg, tmpl, body)
+	if err != nil {
+		return nil, err
+	}
+	if refresher != nil {
+		// so are refreshed events
+		tmpl = refresher
+	}
+	stamper, err := newEventStamper(cfg, state.clock, tmpl, body, cp)
+	if err != nil {
+		return nil, err
+	}
+	if stamper != nil {
+		// stamped events are rendered for every send too
+		tmpl = stamper
+	}
+	log.Infof("Content Mode: %s", cfg.ContentMode)
+	schemas, err := events.LoadSchemas(cfg.SchemaDir, cfg.Schemas)
+	if err != nil {
+		return nil, err
+	}
+	// an event without placeholders is checked once, a template on every
+	// render; a strict violation stops the run
+	var schemaViolations int64
+	var schemaErr error
+	var schemaAbort sync.Once
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+	checkSchema := func(event []byte) bool {
+		err := schemas.Check(eventName, event)
+		if err == nil {
+			return true
+		}
+		if cfg.SchemaStrict {
+			schemaAbort.Do(func() {
+				atomic.AddInt64(&schemaViolations, 1)
+				log.Errorf("Aborting the run: %v", err)
+				schemaErr = err
+				abort()
+			})
+			return false
+		}
+		if atomic.AddInt64(&schemaViolations, 1) == 1 {
+			log.Warnf("%v", err)
+		}
+		return true
+	}
+	if tmpl == nil && schemas != nil && !checkSchema(body) {
+		return nil, schemaErr
+	}
+	// publishers send the events as they are before batching, and batch
+	// them themselves
+	pubEvents, pubBody := tmpl, body
+	mode := cfg.contentMode()
+	// a batch is sent as one message, the rate is of requests; a batch of
+	// rendered events checks each of them
+	batch := cfg.BatchSize > 1
+	if batch {
+		switch {
+		case tmpl != nil && schemas != nil:
+			tmpl = events.NewBatchRenderer(tmpl, cfg.BatchSize, checkSchema)
+		case tmpl != nil:
+			tmpl = events.NewBatchRenderer(tmpl, cfg.BatchSize, nil)
+		default:
+			if body, err = events.NewBatch(body, cfg.BatchSize); err != nil {
+				return nil, fmt.Errorf("failed to batch event %s: %w", eventName, err)
+			}
+			faultBody = body
+		}
+		log.Infof("Batch Size: %d events per request (%s)", cfg.BatchSize, events.BatchContentType)
+	}
+	if tmpl == nil {
+		if err := checkBodySize(eventName, int64(len(body)), cfg.MaxBodyBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	// the same event is sent over and over unless it is rendered, which
+	// may be of another type every time
+	var types *eventTypeRecorder
+	if tmpl != nil {
+		types = newEventTypeRecorder()
+	}
+	faults := newFaultInjector(cfg, faultBody)
+	if faults != nil {
+		log.Infof("Fault Injection: %g%% of sends broken (%s)", cfg.FaultRate, strings.Join(cfg.faultClasses(), ", "))
+	}
+	chaos, err := newChaosMonkey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	chaos.log()
+	adapt := newRateController(cfg)
+	breaker := newCircuitBreaker(cfg)
+	budget := newErrorBudget(cfg, cp, abort)
+	sampler := newRequestSampler(cfg)
+	progress := newProgressSummary(cfg)
+	statsd, err := newStatsDEmitter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pusher := newMetricsPusher(cfg)
+	tickLatency := newTickLatency(cfg)
+	live := liveLatency(ctx)
+	cpLatency := newCheckpointLatency(cfg, cp)
+	capture := newResponseCapture(cfg)
+	fo := newFailover(cfg)
+	if fo != nil {
+		log.Infof("Backup URL: %s (failover after %d seconds)", cfg.BackupURL, cfg.FailoverAfter)
+	}
+	auth, err := sender.NewAuthenticator(&cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer auth.Close()
+	tracer, err := newRequestTracer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer tracer.close()
+	var pubCheck func([]byte) bool
+	if schemas != nil {
+		pubCheck = checkSchema
+	}
+	pubs, err := newPublishers(cfg, pubEvents, pubBody, pubCheck, func(c *Config) sender.Doer {
+		return sender.WithAuth(state.client(c, &connections), auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pubs {
+		defer sender.CloseClient(p.client)
+	}
+	tc, err := newTraceContext(cfg, body, tmpl != nil || len(pubs) > 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tc.close()
+	shards := make([]*sendShard, cfg.Shards)
+	for i, rate := range ShardRates(cfg.Rate, cfg.Shards) {
+		shards[i] = newSendShard(i, rate, cfg, state, targets, body, &connections)
+		shards[i].client = sender.WithAuth(shards[i].client, auth)
+		shards[i].pooled = strings.ToUpper(cfg.CheckResp) == "MULTI_THREAD"
+		if faults != nil || chaos != nil {
+			shards[i].faultReq = fasthttp.AcquireRequest()
+		}
+		if chaos != nil {
+			shards[i].rng = rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+		}
+		defer shards[i].release()
+	}
+	// every shard sends for its share of the publishers
+	for i, p := range pubs {
+		shards[i%len(shards)].pubs = append(shards[i%len(shards)].pubs, p)
+	}
+	if cfg.Shards > 1 {
+		log.Infof("Send Shards: %d", cfg.Shards)
+		if procs := runtime.GOMAXPROCS(0); cfg.Shards > procs {
+			log.Warnf("%d shards share %d CPUs, they will not run in parallel", cfg.Shards, procs)
+		}
+	}
+
+	checkRespUpper := strings.ToUpper(cfg.CheckResp)
+	// In the open model messages are sent on schedule whatever the responses,
+	// and latency runs from the time a message was due, so a slow target
+	// cannot hide behind the sends it delayed. In the closed model the next
+	// message waits for the response to the previous, and a slow response is
+	// not made up for; the rate is only an upper bound.
+	loadModel := strings.ToLower(cfg.LoadModel)
+	if loadModel != "" {
+		log.Infof("Load Model: %s", loadModel)
+	}
+	var pool *sendPool
+	if checkRespUpper == "MULTI_THREAD" {
+		var clients []sender.Doer
+		if cfg.Connections > 0 {
+			clients = connectionClients(cfg, state, auth, &connections)
+			for _, c := range clients {
+				defer sender.CloseClient(c)
+			}
+			log.Infof("Connections: %d, one client per worker", cfg.Connections)
+		} else {
+			clients = make([]sender.Doer, cfg.Workers)
+			for i := range clients {
+				clients[i] = shards[0].client
+			}
+		}
+		pool = newSendPool(clients, cfg.QueueSize, strings.ToLower(cfg.DropPolicy), cfg.contentMode(), fo, trec, sendErrs, assert)
+		pool.event = eventName
+		pool.types = types
+		pool.adapt = adapt
+		pool.breaker = breaker
+		pool.budget = budget
+		pool.sampler = sampler
+		pool.progress = progress
+		pool.statsd = statsd
+		pool.tickLatency = tickLatency
+		pool.live = live
+		pool.cpLatency = cpLatency
+		pool.tracer = tracer
+		pool.traceCtx = tc
+		pool.capture = capture
+		log.Infof("Workers: %d, send queue: %d (%s when full)", len(clients), cfg.QueueSize, cfg.DropPolicy)
+		if cfg.Connections > 0 {
+			// each worker client keeps one connection to every target
+			n := cfg.WarmupConns
+			if n > len(clients) {
+				n = len(clients)
+			}
+			warmUp(ctx, n, clients, targets, &connections)
+		}
+	}
+	if cfg.Connections == 0 {
+		clients := make([]sender.Doer, len(shards))
+		for i, s := range shards {
+			clients[i] = s.client
+		}
+		if pubs != nil {
+			clients = make([]sender.Doer, len(pubs))
+			for i, p := range pubs {
+				clients[i] = p.client
+			}
+		}
+		warmUp(ctx, cfg.WarmupConns, clients, targets, &connections)
+	}
+
+	result := &report.Result{Mode: "perf", StartTime: time.Now(), LoadModel: loadModel}
+	if cp != nil {
+		result.StartTime = cp.StartTime
+		result.Resumed = true
+		resumedElapsed = time.Duration(cp.ElapsedSeconds * float64(time.Second))
+		totalSeconds = int(cp.ElapsedSeconds)
+		totalMsg = int64(cp.TotalMsg)
+		atomic.AddInt64(&connections, int64(cp.Connections))
+		sendErrs.restore(cp)
+	} else {
+		cp = &checkpoint{Config: cfg.Redacted(), StartTime: result.StartTime}
+	}
+	cp.Segments++
+	if result.Resumed {
+		result.Segments = cp.Segments
+		log.Infof("Segment %d of the run, the counters and latencies continue from the checkpoint", cp.Segments)
+	}
+	// time.Since uses the monotonic clock, so the elapsed time is exact even
+	// if the wall clock is stepped during the run
+	segmentStart := time.Now()
+	elapsed := func() time.Duration {
+		return resumedElapsed + time.Since(segmentStart)
+	}
+	// the sends of a run with a duration are those due before its end
+	var end time.Time
+	if !cfg.Continuous() {
+		end = segmentStart.Add(time.Duration(cfg.Duration*float64(time.Second)) - resumedElapsed)
+	}
+	writeCheckpoint := func(completed bool) {
+		if cfg.CheckpointFile == "" {
+			return
+		}
+		cp.ElapsedSeconds = elapsed().Seconds()
+		cp.TotalMsg = int(atomic.LoadInt64(&totalMsg))
+		cp.Completed = completed
+		cp.Connections = int(atomic.LoadInt64(&connections))
+		sendErrs.save(cp)
+		cp.Latency = cpLatency.Export()
+		stamper.save(cp)
+		if err := saveCheckpoint(cfg.CheckpointFile, cp); err != nil {
+			log.Errorf("Failed to write checkpoint %s: %v", cfg.CheckpointFile, err)
+		}
+	}
+
+	soak := newSoakMonitor(cfg)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the run ends exactly after its duration, which need not be whole
+		// seconds; the stats are still logged every second. A continuous
+		// run has no deadline, it runs until it is stopped.
+		var deadline <-chan time.Time
+		if !cfg.Continuous() {
+			timer := time.NewTimer(time.Duration(cfg.Duration*float64(time.Second)) - resumedElapsed)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		secTicker := time.NewTicker(time.Second)
+		defer secTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-deadline:
+				return
+			case <-secTicker.C:
+			}
+			sent := atomic.SwapUint64(&totalPerSecMsgCount, 0)
+			depth := pool.depth()
+			log.Debugf("|Total message sent mps:|%2.2f|queue depth:|%d|", float64(sent), depth)
+			totalSeconds++
+			tick := report.TickStats{Second: totalSeconds, Sent: sent, TotalMsg: int(atomic.LoadInt64(&totalMsg)), QueueDepth: depth, Errors: sendErrs.second(), Rate: adapt.tick()}
+			if tick.Rate == 0 {
+				tick.Rate = cfg.RateDial.tick()
+			}
+			tickPercentiles(tickLatency, &tick)
+			timeline = append(timeline, tick)
+			if onTick != nil {
+				onTick(tick)
+			}
+			soak.tick(tick)
+			progress.tick(tick)
+			statsd.tick(tick)
+			pusher.tick(tick)
+			if cfg.CheckpointFile != "" && totalSeconds%cfg.CheckpointInterval == 0 {
+				writeCheckpoint(false)
+			}
+		}
+	}()
+
+	targetHealth := newHealthPoller(cfg, elapsed)
+	targetHealth.start(done)
+	watcher := newTargetWatcher(cfg, targets)
+	watcher.start(ctx, done)
+	defer watcher.stop()
+
+	health := NewRunHealth(ctx)
+	defer health.close()
+	health.setReady(true)
+	health.loopStarted()
+	defer health.loopStopped()
+	log.Infof("******** Performance Test Started ********")
+	// log these again for convenient of splitting logs
+	log.Infof("Webhook URL: %v", cfg.URL)
+	log.Infof("Messages Per Second: %s", cfg.RateText())
+	log.Infof("Test Duration: %s", cfg.durationText())
+	log.Infof("Initial Delay: %d seconds", cfg.Delay)
+	log.Infof("CHECK_RESP: %v", cfg.CheckResp)
+
+	// countSent counts a message sent by a shard
+	countSent := func(s *sendShard) {
+		s.sent++
+		atomic.AddInt64(&totalMsg, 1)
+		atomic.AddUint64(&totalPerSecMsgCount, 1)
+	}
+	sendLoop := func(s *sendShard) {
+		for {
+			// more than one send is due when the loop was held up
+			due := s.pacer.wait(done)
+			if due == 0 || !end.IsZero() && !time.Now().Before(end) {
+				return
+			}
+			if loadModel == loadClosed {
+				due = 1
+			}
+			scheduled := time.Time{}
+			if loadModel == loadOpen {
+				scheduled = time.Now()
+			}
+			watcher.retarget(s)
+			for ; due > 0; due-- {
+				// a catch-up batch of a saturated run is a tenth of a
+				// second of sends, the run stops within it when it is
+				// stopped or its duration is over
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if !end.IsZero() && !time.Now().Before(end) {
+					return
+				}
+				health.Beat()
+				if chaos.pausing() {
+					continue
+				}
+				if !adapt.admit(&s.adaptCredit) || !breaker.allow() {
+					continue
+				}
+				if limiter != nil && limiter.wait(ctx) != nil {
+					continue
+				}
+				req, target, peer := s.reqs[s.next], s.targets[s.next], cfg.BackupURL
+				slot := requestSlot{shard: s.id, target: s.next}
+				s.next = (s.next + 1) % len(s.reqs)
+				if fo.onBackup() {
+					req, target, peer = s.backupReq, cfg.BackupURL, cfg.URL
+					slot.target = -1
+				}
+				// a broken event takes the place of the event, its response
+				// is counted apart from those of the events; so are the
+				// aborted and truncated sends
+				if ft := faults.due(&s.faultCredit); ft != nil {
+					req.CopyTo(s.faultReq)
+					ft.apply(s.faultReq)
+					err := s.client.Do(s.faultReq, s.res)
+					ft.record(s.res.StatusCode(), err)
+					countSent(s)
+					continue
+				}
+				chaos.delay(s.rng, target, done)
+				switch chaos.pick(s.rng) {
+				case chaosAbort:
+					chaos.abort(req, target)
+					countSent(s)
+					continue
+				case chaosTruncate:
+					req.CopyTo(s.faultReq)
+					chaos.truncate(s.rng, s.faultReq, target)
+					if err := s.client.Do(s.faultReq, s.res); err != nil {
+						log.Debugf("Chaos: truncated send failed: %v", err)
+					} else {
+						log.Debugf("Chaos: truncated send answered with status %d", s.res.StatusCode())
+					}
+					countSent(s)
+					continue
+				}
+				// the rendered event, nil to send the event of the request
+				var event []byte
+				var typ string
+				// a publisher sends its own events over its own connection
+				client, renderer := s.client, tmpl
+				pub := s.publisher()
+				if pub != nil {
+					client, renderer = pub.client, pub.renderer
+				}
+				if renderer != nil {
+					if err := renderer.Render(&s.body); err != nil {
+						log.Errorf("Failed to render event: %v", err)
+						continue
+					}
+					event = s.body.Bytes()
+					if schemas != nil && !batch && !checkSchema(event) {
+						continue
+					}
+					typ = eventType(event)
+					if checkRespUpper != "MULTI_THREAD" {
+						if err := events.SetEvent(req, event, mode); err != nil {
+							log.Errorf("Failed to render event: %v", err)
+							continue
+						}
+					}
+				}
+				var traceparent string
+				if tc != nil {
+					traceparent = tc.next()
+					if tc.attrs {
+						stamped, err := tc.stamp(&s.traceBody, event, traceparent)
+						if err != nil {
+							log.Errorf("Failed to add the trace context: %v", err)
+							continue
+						}
+						event = stamped
+						if checkRespUpper != "MULTI_THREAD" {
+							req.SetBody(event)
+						}
+					}
+					if checkRespUpper != "MULTI_THREAD" {
+						tc.setHeaders(&req.Header, traceparent)
+					}
+				}
+				if checkRespUpper == "YES" {
+					start := time.Now()
+					err := client.Do(req, s.res)
+					fo.record(target, peer, err)
+					latency := time.Since(start)
+					if err == nil {
+						adapt.observe(s.res)
+						sendErrs.recordStatus(s.res.StatusCode())
+					}
+					if err != nil {
+						log.WithFields(sendFields(eventName, target, 0, latency)).Errorf("Sending error: %v", err)
+						sendErrs.record(err)
+						trec.record(target, 0, true)
+						types.record(typ, 0, true)
+						breaker.record(true)
+						budget.record(true, err.Error())
+						sampler.record(start, target, 0, latency, err)
+						tracer.record(start, req, target, 0, latency, err.Error())
+						tc.record(start, req, target, 0, latency, err.Error())
+						pub.record(latency, true)
+					} else if kind, reason := assert.check(s.res); kind != "" {
+						sendErrs.recordAssertion(kind, reason)
+						trec.record(target, 0, true)
+						types.record(typ, 0, true)
+						breaker.record(true)
+						budget.record(true, reason)
+						sampler.record(start, target, s.res.StatusCode(), latency, nil)
+						tracer.record(start, req, target, s.res.StatusCode(), latency, kind+": "+reason)
+						tc.record(start, req, target, s.res.StatusCode(), latency, kind+": "+reason)
+						capture.record(start, req, s.res, target, reason)
+						pub.record(latency, true)
+					} else {
+						breaker.record(false)
+						budget.record(false, "")
+						sampler.record(start, target, s.res.StatusCode(), latency, nil)
+						tracer.record(start, req, target, s.res.StatusCode(), latency, "")
+						tc.record(start, req, target, s.res.StatusCode(), latency, "")
+						report.RecordLatency(s.latency, latency)
+						live.Record(latency)
+						progress.record(latency)
+						statsd.record(latency)
+						tickLatency.Record(latency)
+						cpLatency.Record(latency)
+						trec.record(target, latency, false)
+						types.record(typ, latency, false)
+						pub.record(latency, false)
+						s.sent++
+						atomic.AddInt64(&totalMsg, 1)
+					}
+				} else if checkRespUpper == "NO" {
+					start := time.Now()
+					err := client.Do(req, s.res)
+					fo.record(target, peer, err)
+					latency := time.Since(start)
+					if err == nil {
+						adapt.observe(s.res)
+						sendErrs.recordStatus(s.res.StatusCode())
+						report.RecordLatency(s.latency, latency)
+						live.Record(latency)
+						progress.record(latency)
+						statsd.record(latency)
+						tickLatency.Record(latency)
+						cpLatency.Record(latency)
+						if code := s.res.StatusCode(); capture != nil && !assert.expectedStatus(code) {
+							capture.record(start, req, s.res, target, fmt.Sprintf("response status %d, expected %s", code, assert.spec))
+						}
+					} else {
+						sendErrs.record(err)
+						budget.record(true, err.Error())
+					}
+					trec.record(target, latency, err != nil)
+					types.record(typ, latency, err != nil)
+					breaker.record(err != nil)
+					if err == nil {
+						budget.record(false, "")
+					}
+					pub.record(latency, err != nil)
+					sampler.record(start, target, s.res.StatusCode(), latency, err)
+					if tracer != nil || tc != nil {
+						if err != nil {
+							tracer.record(start, req, target, 0, latency, err.Error())
+							tc.record(start, req, target, 0, latency, err.Error())
+						} else {
+							tracer.record(start, req, target, s.res.StatusCode(), latency, "")
+							tc.record(start, req, target, s.res.StatusCode(), latency, "")
+						}
+					}
+					s.sent++
+					atomic.AddInt64(&totalMsg, 1)
+				} else if checkRespUpper == "MULTI_THREAD" {
+					if !pool.submit(done, sendJob{req: req, slot: slot, target: target, peer: peer, body: bytes.Clone(event), eventType: typ, scheduled: scheduled, traceparent: traceparent}) {
+						continue
+					}
+					s.sent++
+					atomic.AddInt64(&totalMsg, 1)
+				}
+				atomic.AddUint64(&totalPerSecMsgCount, 1)
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	bursts := ShardRates(cfg.BurstSize, cfg.Shards)
+	for _, s := range shards {
+		s.pacer = cfg.RateDial.pacer(cfg, s.id, newRunPacer(cfg, s.rate, shardBucket(cfg.BucketSize, s.rate, cfg.Rate), bursts[s.id]))
+	}
+	for _, s := range shards[1:] {
+		wg.Add(1)
+		go func(s *sendShard) {
+			defer wg.Done()
+			// keep each shard on its own thread, and so its own core
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			sendLoop(s)
+		}(s)
+	}
+	sendLoop(shards[0])
+	// stop sending and wait for queued requests before summarizing
+	wg.Wait()
+	if pool != nil {
+		atomic.AddInt64(&totalMsg, -int64(pool.close()))
+	}
+	tc.close()
+
+	if schemaErr != nil || budget.exhausted() {
+		result.Interrupted = true
+		log.Info("******** Performance Test Aborted ********")
+	} else if ctx.Err() != nil && cfg.Continuous() {
+		// stopping is how a continuous run ends
+		log.Info("******** Performance Test Stopped ********")
+	} else if ctx.Err() != nil {
+		result.Interrupted = true
+		log.Info("******** Performance Test Interrupted ********")
+	} else {
+		log.Info("******** Performance Test Completed ********")
+	}
+	writeCheckpoint(!result.Interrupted)
+	result.EndTime = time.Now()
+	result.TotalSeconds = elapsed().Seconds()
+	log.Infof("Total Seconds : %.3f", result.TotalSeconds)
+	log.Infof("Total Msg Sent: %d", totalMsg)
+	result.TotalMsg = int(totalMsg)
+	result.CountConnections(atomic.LoadInt64(&connections), cfg.NoKeepAlive)
+	for _, s := range shards {
+		result.Skipped += int(s.pacer.skipped())
+	}
+	if result.Skipped > 0 {
+		log.Warnf("Send loop fell behind, %d messages skipped", result.Skipped)
+	}
+	if cfg.Shards > 1 {
+		for _, s := range shards {
+			result.Shards = append(result.Shards, report.ShardStats{Shard: s.id, Rate: s.rate, Sent: s.sent})
+			log.Infof("Shard %d: %d msg at %d msg/s", s.id, s.sent, s.rate)
+		}
+	}
+	reportPublishers(pubs, result)
+	trec.report(result)
+	types.report(result)
+	fo.report(result)
+	faults.report(result)
+	chaos.report(result)
+	adapt.report(result)
+	breaker.report(result)
+	budget.report(result)
+	sampler.report(result)
+	mutator.Report(result)
+	capture.report(result)
+	tc.report(result)
+	soak.finish(result)
+	stamper.report(result)
+	pool.report(result)
+	sendErrs.report(result)
+	// the timeline is only read here, after the ticker stopped
+	result.Timeline = timeline
+	targetHealth.report(result)
+	latency := report.NewLatencyHistogram()
+	for _, s := range shards {
+		latency.Merge(s.latency)
+	}
+	pool.mergeLatency(latency)
+	if cpLatency != nil {
+		// it has the latencies of the earlier segments too
+		latency = cpLatency.Histogram()
+	}
+	result.Latency = report.SummarizeLatency(latency)
+	result.Latency.Log()
+	if result.TotalSeconds > 0 {
+		result.AvgRate = float64(totalMsg) / result.TotalSeconds
+		log.Infof("Average Msg/Second: %2.2f", result.AvgRate)
+		result.RequestedRate = RequestedRate(cfg)
+		// a run that ran its course sends the sends due up to its end, a
+		// stopped one those due up to when it stopped
+		window := time.Duration(result.TotalSeconds * float64(time.Second))
+		if !cfg.Continuous() && !result.Interrupted {
+			window = time.Duration(cfg.Duration * float64(time.Second))
+		}
+		for _, s := range shards {
+			bucket := shardBucket(cfg.BucketSize, s.rate, cfg.Rate)
+			result.DueMsg += int(dueSends(cfg, s.rate, bucket, bursts[s.id], window))
+			// every later segment of a resumed run starts its pacers anew,
+			// with the sends due at once
+			result.DueMsg += (cp.Segments - 1) * int(dueSends(cfg, s.rate, bucket, bursts[s.id], 0))
+		}
+		achieved := result.AchievedRate()
+		log.Infof("Achieved Rate: %.2f of %.2f msg/sec requested, %d of %d msg due (%.1f%%)", result.AvgRate, result.RequestedRate, totalMsg, result.DueMsg, achieved)
+		paced := limiter == nil && adapt == nil && !cfg.RateDial.changed()
+		if achieved < minAchievedRate && !result.Interrupted && paced {
+			log.Warnf("The run fell short of the requested rate, see the skipped, dropped and failed messages")
+		}
+		if over := int(totalMsg) - result.DueMsg; over > 0 && paced {
+			result.OverDelivered = over
+			log.Warnf("The run sent %d msg more than were due at the requested rate", over)
+		}
+	}
+	if batch {
+		result.BatchSize = cfg.BatchSize
+		result.Events = result.TotalMsg * cfg.BatchSize
+		result.EventRate = result.AvgRate * float64(cfg.BatchSize)
+		log.Infof("Events Sent: %d in %d requests of %d, %2.2f events/sec, %2.2f requests/sec", result.Events, result.TotalMsg, cfg.BatchSize, result.EventRate, result.AvgRate)
+	}
+	result.SchemaViolations = int(schemaViolations)
+	if result.SchemaViolations > 0 {
+		log.Warnf("%d events violated their schemas", result.SchemaViolations)
+	}
+	result.ErrorRate = ErrorRate(cfg.CheckResp, result.TotalMsg, result.FailedSends())
+	statsd.finish(result)
+	result.LatencyHistogram = latency
+	result.Checks = append(PerfChecks(cfg, result, limiter != nil || adapt != nil || cfg.RateDial.changed()), SLAChecks(cfg, result)...)
+	LogChecks(result)
+	pusher.finish(result)
+	return result, schemaErr
+}
+
+// checkpointResult summarizes the run saved in a checkpoint.
+func checkpointResult(cp *checkpoint) *report.Result {
+	result := &report.Result{
+		Mode:              "perf",
+		StartTime:         cp.StartTime,
+		EndTime:           cp.Updated,
+		TotalSeconds:      cp.ElapsedSeconds,
+		TotalMsg:          cp.TotalMsg,
+		Resumed:           true,
+		Segments:          cp.Segments,
+		Connections:       cp.Connections,
+		Errors:            cp.Errors,
+		AssertionFailures: cp.AssertionFailures,
+		StatusCodes:       cp.StatusCodes,
+	}
+	if cp.ElapsedSeconds > 0 {
+		result.AvgRate = float64(cp.TotalMsg) / cp.ElapsedSeconds
+	}
+	if cp.Latency != nil {
+		result.LatencyHistogram = hdrhistogram.Import(cp.Latency)
+		result.Latency = report.SummarizeLatency(result.LatencyHistogram)
+	}
+	result.ErrorRate = ErrorRate(cp.Config.CheckResp, result.TotalMsg, result.FailedSends())
+	return result
+}
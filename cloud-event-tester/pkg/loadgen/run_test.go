@@ -0,0 +1,140 @@
+package loadgen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// TestRunConcurrent runs two performance runs at the same time, as a harness
+// embedding the tester may, each with its own health and dashboard latency.
+// Run it with -race.
+func TestRunConcurrent(t *testing.T) {
+	url, event := testTarget(t)
+
+	probes := NewHealthProbes()
+	ctx := WithHealthProbes(context.Background(), probes)
+	var wg sync.WaitGroup
+	results := make([]*report.Result, 2)
+	errs := make([]error, 2)
+	lives := make([]*report.SharedHistogram, 2)
+	for i := range results {
+		lives[i] = report.NewSharedHistogram(report.NewLatencyHistogram())
+		cfg := DefaultConfig()
+		cfg.URL = url
+		cfg.EventFile = event
+		cfg.Perf = "YES"
+		cfg.Rate = 50
+		cfg.Duration = 1
+		cfg.Delay = 0
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = Run(WithLiveLatency(ctx, lives[i]), &cfg, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if errs[i] != nil {
+			t.Fatalf("run %d failed: %v", i, errs[i])
+		}
+		if result.TotalMsg == 0 || result.FailedSends() != 0 {
+			t.Errorf("run %d sent %d messages, %d failed, want all sent", i, result.TotalMsg, result.FailedSends())
+		}
+		if got := lives[i].Stats().Count; got != int64(result.TotalMsg) {
+			t.Errorf("dashboard latency of run %d has %d sends, want the %d of the run", i, got, result.TotalMsg)
+		}
+	}
+	if len(probes.runs) != 0 {
+		t.Errorf("%d runs still registered with the health probes after they finished", len(probes.runs))
+	}
+}
+
+// TestRunSaturatedStops runs at a rate no target keeps up with, so the pacer
+// returns catch-up batches, and checks the run stops at the end of its
+// duration and when it is cancelled, not when a batch is sent.
+func TestRunSaturatedStops(t *testing.T) {
+	url, event := testTarget(t)
+	tests := []struct {
+		name     string
+		duration float64
+		cancel   time.Duration
+	}{
+		{name: "duration", duration: 0.5},
+		{name: "cancel", cancel: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.URL = url
+			cfg.EventFile = event
+			cfg.Perf = "YES"
+			cfg.Rate = 1000000000
+			cfg.Duration = tt.duration
+			cfg.Delay = 0
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			start := time.Now()
+			result, err := Run(ctx, &cfg, nil)
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if took := time.Since(start); took > 2*time.Second {
+				t.Errorf("run took %v to stop, want about 500ms", took)
+			}
+			if result.TotalSeconds > 1 {
+				t.Errorf("run reported %.3f s, want about 0.5 s", result.TotalSeconds)
+			}
+		})
+	}
+}
+
+// testTarget returns the URL of a target answering every event with 204 and
+// the path of an event file.
+func testTarget(t *testing.T) (url, event string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	event = filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(event, []byte(`{"specversion":"1.0","id":"1","source":"test","type":"test"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return srv.URL, event
+}
+
+// TestRunKeepsConfig runs the same settings twice and checks a run resolves
+// its target URL and shard rate on a copy, so the second run divides the
+// total rate again rather than the share of the first.
+func TestRunKeepsConfig(t *testing.T) {
+	url, event := testTarget(t)
+	cfg := DefaultConfig()
+	cfg.Targets = []targetSpec{{URL: url}}
+	cfg.EventFile = event
+	cfg.Perf = "YES"
+	cfg.Rate = 40
+	cfg.Duration = 0.2
+	cfg.Delay = 0
+	cfg.ShardRate = true
+	cfg.Replicas = 2
+	cfg.Ordinal = 0
+	want := cfg.Clone()
+	for i := 0; i < 2; i++ {
+		if _, err := Run(context.Background(), &cfg, nil); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+		if cfg.Rate != want.Rate || cfg.URL != want.URL {
+			t.Errorf("after run %d the settings have rate %d and URL %q, want %d and %q", i, cfg.Rate, cfg.URL, want.Rate, want.URL)
+		}
+	}
+}
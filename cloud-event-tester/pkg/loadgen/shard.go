@@ -0,0 +1,153 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// replicaInfo identifies this tester among the replicas of its workload.
+type replicaInfo struct {
+	Replicas int
+	Ordinal  int
+}
+
+// shardRate divides the configured total rate among the replicas of the
+// workload this tester runs in. The remainder of the division goes to the
+// lowest ordinals so the replicas add up to the total.
+func shardRate(ctx context.Context, cfg *Config) error {
+	info, err := detectReplicas(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	total := cfg.Rate
+	rate := total / info.Replicas
+	if info.Ordinal < total%info.Replicas {
+		rate++
+	}
+	if rate == 0 {
+		return fmt.Errorf("total rate %d is too low to be divided among %d replicas", total, info.Replicas)
+	}
+	log.Infof("Replica %d of %d: sending %d of %d msg/sec", info.Ordinal, info.Replicas, rate, total)
+	cfg.Rate = rate
+	if cfg.BurstSize > 0 {
+		burst := ShardRates(cfg.BurstSize, info.Replicas)[info.Ordinal]
+		if burst == 0 {
+			return fmt.Errorf("burst size %d is too low to be divided among %d replicas", cfg.BurstSize, info.Replicas)
+		}
+		log.Infof("Replica %d of %d: sending bursts of %d of %d messages", info.Ordinal, info.Replicas, burst, cfg.BurstSize)
+		cfg.BurstSize = burst
+	}
+	return nil
+}
+
+// detectReplicas determines the replica count and ordinal from the settings,
+// the downward API environment or, for the replica count, the owner of the
+// pod in the Kubernetes API.
+func detectReplicas(ctx context.Context, cfg *Config) (replicaInfo, error) {
+	info := replicaInfo{Replicas: cfg.Replicas, Ordinal: cfg.Ordinal}
+	if info.Ordinal < 0 {
+		ordinal, ok := envOrdinal()
+		if !ok {
+			return info, fmt.Errorf("cannot determine the replica ordinal, set -ordinal or REPLICA_ORDINAL")
+		}
+		info.Ordinal = ordinal
+	}
+	if info.Replicas <= 0 {
+		replicas, err := ownerReplicas(ctx, cfg)
+		if err != nil {
+			return info, fmt.Errorf("cannot determine the replica count, set -replicas or REPLICAS: %w", err)
+		}
+		info.Replicas = replicas
+	}
+	if info.Ordinal >= info.Replicas {
+		return info, fmt.Errorf("replica ordinal %d is out of range for %d replicas", info.Ordinal, info.Replicas)
+	}
+	return info, nil
+}
+
+// envOrdinal looks for the ordinal of this pod in the environment: an
+// explicit REPLICA_ORDINAL, the index of an Indexed Job, the StatefulSet pod
+// index label, or the suffix of a StatefulSet pod name.
+func envOrdinal() (int, bool) {
+	for _, name := range []string{"REPLICA_ORDINAL", "JOB_COMPLETION_INDEX", "POD_INDEX"} {
+		if v := os.Getenv(name); v != "" {
+			if ordinal, err := strconv.Atoi(v); err == nil {
+				return ordinal, true
+			}
+		}
+	}
+	podName := os.Getenv("MY_POD_NAME")
+	if i := strings.LastIndex(podName, "-"); i >= 0 {
+		if ordinal, err := strconv.Atoi(podName[i+1:]); err == nil {
+			return ordinal, true
+		}
+	}
+	return 0, false
+}
+
+type ownerReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// ownerReplicas reads the desired replica count of the workload owning this
+// pod: spec.replicas of a StatefulSet or ReplicaSet, spec.parallelism of a
+// Job.
+func ownerReplicas(ctx context.Context, cfg *Config) (int, error) {
+	podName, namespace := os.Getenv("MY_POD_NAME"), os.Getenv("MY_POD_NAMESPACE")
+	if podName == "" {
+		return 0, fmt.Errorf("MY_POD_NAME is not set")
+	}
+	client, err := NewKubeClient(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		return 0, err
+	}
+	if namespace == "" {
+		namespace = client.Namespace
+	}
+	var pod struct {
+		Metadata struct {
+			OwnerReferences []ownerReference `json:"ownerReferences"`
+		} `json:"metadata"`
+	}
+	ns := url.PathEscape(namespace)
+	if err := client.Get(ctx, "/api/v1/namespaces/"+ns+"/pods/"+url.PathEscape(podName), nil, &pod); err != nil {
+		return 0, err
+	}
+	for _, owner := range pod.Metadata.OwnerReferences {
+		var workload struct {
+			Spec struct {
+				Replicas    *int `json:"replicas"`
+				Parallelism *int `json:"parallelism"`
+			} `json:"spec"`
+		}
+		var path string
+		switch owner.Kind {
+		case "StatefulSet":
+			path = "/apis/apps/v1/namespaces/" + ns + "/statefulsets/" + url.PathEscape(owner.Name)
+		case "ReplicaSet":
+			path = "/apis/apps/v1/namespaces/" + ns + "/replicasets/" + url.PathEscape(owner.Name)
+		case "Job":
+			path = "/apis/batch/v1/namespaces/" + ns + "/jobs/" + url.PathEscape(owner.Name)
+		default:
+			continue
+		}
+		if err := client.Get(ctx, path, nil, &workload); err != nil {
+			return 0, err
+		}
+		if workload.Spec.Replicas != nil {
+			return *workload.Spec.Replicas, nil
+		}
+		if workload.Spec.Parallelism != nil {
+			return *workload.Spec.Parallelism, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("pod %s/%s has no StatefulSet, ReplicaSet or Job owner", namespace, podName)
+}
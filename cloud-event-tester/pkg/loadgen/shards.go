@@ -0,0 +1,143 @@
+package loadgen
+
+import (
+	"bytes"
+	"math/rand"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// sendShard is one pacing loop of a performance run. The rate of the run is
+// split among the shards; each has its own ticker, client and requests, so
+// the shards only share atomic counters, the failover state and the global
+// limiter and can run on separate cores.
+type sendShard struct {
+	id        int
+	rate      int
+	client    sender.Doer
+	reqs      []*fasthttp.Request
+	backupReq *fasthttp.Request
+	res       *fasthttp.Response
+	pacer     pacer
+	latency   *hdrhistogram.Histogram
+	// body is the buffer event templates are rendered into
+	body bytes.Buffer
+	// traceBody is the buffer events are given the trace context in, see
+	// traceContext
+	traceBody bytes.Buffer
+	// faultReq is the request of the broken events and truncated sends,
+	// faultCredit the share of broken events due, see faultInjector
+	faultReq    *fasthttp.Request
+	faultCredit float64
+	// adaptCredit is the share of the paced sends adaptive rate control
+	// lets through, see rateController
+	adaptCredit float64
+	// rng draws the network chaos of the shard, see chaosMonkey
+	rng *rand.Rand
+	// pubs are the publishers of the shard, sending in turn, see publisher
+	pubs    []*publisher
+	nextPub int
+	// targets are the URLs of reqs, of the generation targetGen of the
+	// targetWatcher
+	targets   []string
+	targetGen int64
+	// pooled is set if the requests are submitted to a send pool
+	pooled bool
+	next   int
+	sent   int
+}
+
+func newSendShard(id, rate int, cfg *Config, state *runState, targets []string, body []byte, conns *int64) *sendShard {
+	s := &sendShard{
+		id:      id,
+		rate:    rate,
+		client:  state.client(cfg, conns),
+		reqs:    make([]*fasthttp.Request, len(targets)),
+		res:     fasthttp.AcquireResponse(),
+		latency: report.NewLatencyHistogram(),
+		targets: targets,
+		// start at different targets so the shards spread over them
+		next: id % len(targets),
+	}
+	// one request per target, used in turn
+	for i, target := range targets {
+		s.reqs[i] = NewEventRequest(target, body, cfg.contentMode(), cfg.Headers, cfg.Labels)
+	}
+	if cfg.BackupURL != "" {
+		s.backupReq = NewEventRequest(cfg.BackupURL, body, cfg.contentMode(), cfg.Headers, cfg.Labels)
+	}
+	return s
+}
+
+// retarget sends to targets from the next send on. The requests of the
+// previous targets are released, unless the shard submits them to a send
+// pool, which may still hold them; those are left to the garbage collector.
+func (s *sendShard) retarget(targets []string) {
+	reqs := make([]*fasthttp.Request, len(targets))
+	for i, target := range targets {
+		reqs[i] = fasthttp.AcquireRequest()
+		s.reqs[0].CopyTo(reqs[i])
+		reqs[i].SetRequestURI(sender.TargetURI(target))
+	}
+	if !s.pooled {
+		for _, req := range s.reqs {
+			fasthttp.ReleaseRequest(req)
+		}
+	}
+	s.reqs, s.targets = reqs, targets
+	s.next = s.id % len(targets)
+}
+
+func (s *sendShard) release() {
+	for _, req := range s.reqs {
+		fasthttp.ReleaseRequest(req)
+	}
+	if s.backupReq != nil {
+		fasthttp.ReleaseRequest(s.backupReq)
+	}
+	if s.faultReq != nil {
+		fasthttp.ReleaseRequest(s.faultReq)
+	}
+	fasthttp.ReleaseResponse(s.res)
+	sender.CloseClient(s.client)
+}
+
+// publisher returns the publisher of the next send, nil if the run does not
+// simulate publishers.
+func (s *sendShard) publisher() *publisher {
+	if len(s.pubs) == 0 {
+		return nil
+	}
+	p := s.pubs[s.nextPub]
+	s.nextPub = (s.nextPub + 1) % len(s.pubs)
+	return p
+}
+
+// ShardRates splits rate among n shards, giving the remainder to the first
+// ones.
+func ShardRates(rate, n int) []int {
+	rates := make([]int, n)
+	for i := range rates {
+		rates[i] = rate / n
+		if i < rate%n {
+			rates[i]++
+		}
+	}
+	return rates
+}
+
+// shardBucket gives a shard its share of the token bucket of the run, so the
+// bursts of all shards together stay within bucket. 0 keeps the default.
+func shardBucket(bucket, shardRate, rate int) int {
+	if bucket <= 0 {
+		return 0
+	}
+	if share := bucket * shardRate / rate; share > 0 {
+		return share
+	}
+	return 1
+}
@@ -0,0 +1,92 @@
+package loadgen
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// hasSLA reports whether any SLA threshold is set.
+func (c *Config) hasSLA() bool {
+	return c.MaxErrorRate > 0 || c.MaxP99Ms > 0 || c.MinAchievedRate > 0 || c.MaxErrors > 0 || c.MaxConsecutiveErrors > 0
+}
+
+// SLAChecks returns the checks of the SLA thresholds of a run. The latency
+// and rate thresholds only apply to performance runs.
+func SLAChecks(cfg *Config, result *report.Result) []report.CheckResult {
+	var checks []report.CheckResult
+	if cfg.MaxErrorRate > 0 {
+		checks = append(checks, report.CheckResult{Name: "max-error-rate", SLA: true}.PassIf(result.ErrorRate <= cfg.MaxErrorRate,
+			"error rate %.3f%%, at most %g%% allowed", result.ErrorRate, cfg.MaxErrorRate))
+	}
+	if cfg.MaxP99Ms > 0 {
+		check := report.CheckResult{Name: "max-p99-ms", SLA: true}
+		if result.Latency == nil {
+			check = check.Fail("no successful sends to measure, at most %gms allowed", cfg.MaxP99Ms)
+		} else {
+			check = check.PassIf(result.Latency.P99 <= cfg.MaxP99Ms, "p99 %.3fms, at most %gms allowed", result.Latency.P99, cfg.MaxP99Ms)
+		}
+		checks = append(checks, check)
+	}
+	if cfg.MinAchievedRate > 0 {
+		achieved := result.AchievedRate()
+		checks = append(checks, report.CheckResult{Name: "min-achieved-rate", SLA: true}.PassIf(achieved >= cfg.MinAchievedRate,
+			"%.2f of %.2f msg/sec requested (%.1f%%), at least %g%% needed", result.AvgRate, result.RequestedRate, achieved, cfg.MinAchievedRate))
+	}
+	return append(checks, errorBudgetChecks(result)...)
+}
+
+// LogChecks logs the outcome of the SLA checks of a run.
+func LogChecks(result *report.Result) {
+	for _, c := range result.Checks {
+		if c.SLA && !c.Passed {
+			log.Errorf("SLA %s violated: %s", c.Name, c.Message)
+		} else if c.SLA {
+			log.Infof("SLA %s met: %s", c.Name, c.Message)
+		}
+	}
+}
+
+// SLAViolation is the error of runs that violated SLA thresholds.
+type SLAViolation struct {
+	checks []report.CheckResult
+}
+
+// AddViolations adds the failed SLA checks of a run.
+func (v *SLAViolation) AddViolations(result *report.Result) {
+	if result == nil {
+		return
+	}
+	for _, c := range result.Checks {
+		if c.SLA && !c.Passed {
+			v.checks = append(v.checks, c)
+		}
+	}
+}
+
+// Err returns v as error, nil if no threshold was violated.
+func (v *SLAViolation) Err() error {
+	if len(v.checks) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *SLAViolation) Error() string {
+	failures := make([]string, len(v.checks))
+	for i, c := range v.checks {
+		failures[i] = fmt.Sprintf("%s (%s)", c.Name, c.Message)
+	}
+	return "SLA violated: " + strings.Join(failures, "; ")
+}
+
+// SLAError returns the SLA thresholds a run violated as an error, or nil if
+// it violated none.
+func SLAError(result *report.Result) error {
+	var v SLAViolation
+	v.AddViolations(result)
+	return v.Err()
+}
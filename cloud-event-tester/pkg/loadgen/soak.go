@@ -0,0 +1,166 @@
+package loadgen
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// Drift thresholds of soak runs, relative to the first sample: the tester
+// is drifting if it runs twice the goroutines or holds twice the heap, its
+// rate drops by a tenth or its error rate rises by a percentage point.
+const (
+	soakGrowthFactor = 2
+	soakMinRate      = 0.9
+	soakMaxErrorRise = 1
+)
+
+// soakMonitor samples the goroutines, memory and garbage collection of the
+// tester and the rate and errors of its sends every interval of a long run,
+// logs a line for each sample and flags the drift from the first one, so a
+// run of hours shows whether the tester itself degrades. It is fed the
+// stats of every second by the run; a nil monitor does nothing.
+type soakMonitor struct {
+	interval time.Duration
+	start    time.Time
+	report   report.SoakReport
+	// counters of the current interval
+	seconds      int
+	sent, errors uint64
+	lastPause    uint64
+	// drift by kind, and in the order it was first seen
+	drift      map[string]*report.SoakDrift
+	driftOrder []*report.SoakDrift
+}
+
+func newSoakMonitor(cfg *Config) *soakMonitor {
+	if !cfg.Soak {
+		return nil
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	log.Infof("Soak Mode: sampling the tester every %v", cfg.SoakInterval)
+	return &soakMonitor{
+		interval:  cfg.SoakInterval,
+		start:     time.Now(),
+		report:    report.SoakReport{Interval: cfg.SoakInterval.Seconds()},
+		lastPause: ms.PauseTotalNs,
+		drift:     map[string]*report.SoakDrift{},
+	}
+}
+
+// tick adds the stats of a second and samples the tester once an interval
+// has passed.
+func (m *soakMonitor) tick(stats report.TickStats) {
+	if m == nil {
+		return
+	}
+	m.seconds++
+	m.sent += stats.Sent
+	m.errors += uint64(stats.Errors)
+	if time.Duration(m.seconds)*time.Second < m.interval {
+		return
+	}
+	m.sample()
+}
+
+func (m *soakMonitor) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := report.SoakSample{
+		Elapsed:    time.Since(m.start).Seconds(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  ms.HeapAlloc,
+		SysBytes:   ms.Sys,
+		NumGC:      ms.NumGC,
+		GCPauseMs:  float64(ms.PauseTotalNs-m.lastPause) / 1e6,
+		Rate:       float64(m.sent) / float64(m.seconds),
+	}
+	if m.sent > 0 {
+		s.ErrorRate = 100 * float64(m.errors) / float64(m.sent)
+	}
+	m.lastPause = ms.PauseTotalNs
+	m.seconds, m.sent, m.errors = 0, 0, 0
+	m.report.Samples = append(m.report.Samples, s)
+	log.Infof("Soak: %v goroutines %d heap %s sys %s gc %d (%.1fms) rate %.1f msg/s errors %.2f%%",
+		time.Duration(s.Elapsed)*time.Second, s.Goroutines, FormatBytes(s.HeapBytes), FormatBytes(s.SysBytes),
+		s.NumGC, s.GCPauseMs, s.Rate, s.ErrorRate)
+	m.checkDrift(s)
+}
+
+// checkDrift flags the ways a sample is off the first one.
+func (m *soakMonitor) checkDrift(s report.SoakSample) {
+	base := m.report.Samples[0]
+	var found []string
+	flag := func(kind string, drifting bool, format string, args ...interface{}) {
+		if !drifting {
+			return
+		}
+		msg := fmt.Sprintf(format, args...)
+		d, ok := m.drift[kind]
+		if !ok {
+			d = &report.SoakDrift{Kind: kind, FirstAt: s.Elapsed}
+			m.drift[kind] = d
+			m.driftOrder = append(m.driftOrder, d)
+		}
+		d.Message = msg
+		found = append(found, msg)
+	}
+	flag("goroutines", s.Goroutines > soakGrowthFactor*base.Goroutines,
+		"goroutines grew from %d to %d", base.Goroutines, s.Goroutines)
+	flag("heap", s.HeapBytes > soakGrowthFactor*base.HeapBytes,
+		"heap grew from %s to %s", FormatBytes(base.HeapBytes), FormatBytes(s.HeapBytes))
+	flag("rate", s.Rate < soakMinRate*base.Rate,
+		"rate dropped from %.1f to %.1f msg/s", base.Rate, s.Rate)
+	flag("errors", s.ErrorRate > base.ErrorRate+soakMaxErrorRise,
+		"error rate rose from %.2f%% to %.2f%%", base.ErrorRate, s.ErrorRate)
+	if len(found) > 0 {
+		log.Warnf("Soak: drifting: %s", strings.Join(found, ", "))
+	}
+}
+
+// finish adds the samples and the drift to a run result.
+func (m *soakMonitor) finish(result *report.Result) {
+	if m == nil {
+		return
+	}
+	for _, d := range m.driftOrder {
+		m.report.Drift = append(m.report.Drift, *d)
+	}
+	result.Soak = &m.report
+}
+
+// soakChecks check that a soak run did not drift.
+func soakChecks(result *report.Result) []report.CheckResult {
+	if result.Soak == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("no drift in %d samples", len(result.Soak.Samples))
+	var drift []string
+	for _, d := range result.Soak.Drift {
+		drift = append(drift, d.Message)
+	}
+	if len(drift) > 0 {
+		msg = strings.Join(drift, ", ")
+	}
+	return []report.CheckResult{report.CheckResult{Name: "soak drift"}.PassIf(len(drift) == 0, "%s", msg)}
+}
+
+// FormatBytes formats a size with a binary unit.
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
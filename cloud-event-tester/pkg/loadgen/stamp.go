@@ -0,0 +1,143 @@
+package loadgen
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// eventStamper stamps every event of a run with extension attributes for a
+// receiver: with -sequence the run ID and the next sequence number, so the
+// lost, duplicated and reordered events can be told apart, and with
+// -send-time the time it was sent, so the delivery latency can be measured.
+// Events that are not cloud events are sent as the data of a new one to
+// carry the attributes, as in batches. Like the renderers it wraps it is
+// safe for the send shards to call concurrently.
+type eventStamper struct {
+	// runID is empty if the events are not numbered
+	runID    string
+	sendTime bool
+	// clock is the offset of the clock of the receiver the send times are
+	// on
+	clock *clockSync
+	// events renders the events, nil to send body
+	events  events.Renderer
+	body    []byte
+	last    int64
+	scratch sync.Pool
+}
+
+// newEventStamper returns the stamper of the events of renderer, or of body if
+// it is nil; a basic run, which stamps the events it sends, passes neither.
+// The numbered events of a resumed run continue the run ID and the sequence
+// numbers of its checkpoint cp. It returns nil if the run does not stamp its
+// events.
+func newEventStamper(cfg *Config, clock *clockSync, renderer events.Renderer, body []byte, cp *checkpoint) (*eventStamper, error) {
+	// the publishers of a run number their events themselves
+	sequence := cfg.Sequence && cfg.Publishers == 0
+	if !sequence && !cfg.SendTime {
+		return nil, nil
+	}
+	s := &eventStamper{
+		sendTime: cfg.SendTime,
+		clock:    clock,
+		events:   renderer,
+		scratch:  sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+	}
+	if renderer == nil && body != nil {
+		structured, err := events.ToStructuredEvent(body)
+		if err != nil {
+			return nil, fmt.Errorf("event cannot be stamped: %w", err)
+		}
+		s.body = structured
+	}
+	switch {
+	case sequence && cp != nil && cp.RunID != "":
+		s.runID, s.last = cp.RunID, cp.LastSequence
+		log.Infof("Sequence: events of run %s numbered in the %s and %s attributes, continuing after %d", s.runID, events.SequenceRunAttr, events.SequenceSeqAttr, s.last)
+	case sequence:
+		s.runID = events.NewUUID()
+		log.Infof("Sequence: events of run %s numbered in the %s and %s attributes", s.runID, events.SequenceRunAttr, events.SequenceSeqAttr)
+	}
+	if cfg.SendTime {
+		log.Infof("Send Time: events stamped with the time they are sent in the %s attribute", events.SendTimeAttr)
+	}
+	return s, nil
+}
+
+func (s *eventStamper) Render(buf *bytes.Buffer) error {
+	if s.events == nil {
+		s.write(buf, s.body)
+		return nil
+	}
+	scratch := s.scratch.Get().(*bytes.Buffer)
+	defer s.scratch.Put(scratch)
+	if err := s.events.Render(scratch); err != nil {
+		return err
+	}
+	return s.stamp(buf, scratch.Bytes())
+}
+
+// stamp writes event to buf with the attributes of the stamper.
+func (s *eventStamper) stamp(buf *bytes.Buffer, event []byte) error {
+	structured, err := events.ToStructuredEvent(event)
+	if err != nil {
+		return err
+	}
+	s.write(buf, structured)
+	return nil
+}
+
+// write writes a structured event to buf with the attributes in front of its
+// own.
+func (s *eventStamper) write(buf *bytes.Buffer, event []byte) {
+	buf.Reset()
+	buf.WriteByte('{')
+	if s.runID != "" {
+		buf.WriteString(`"` + events.SequenceRunAttr + `":"`)
+		buf.WriteString(s.runID)
+		buf.WriteString(`","` + events.SequenceSeqAttr + `":`)
+		buf.WriteString(strconv.FormatInt(atomic.AddInt64(&s.last, 1), 10))
+		if s.sendTime {
+			buf.WriteByte(',')
+		}
+	}
+	if s.sendTime {
+		var ts [64]byte
+		buf.WriteString(`"` + events.SendTimeAttr + `":"`)
+		buf.Write(time.Now().Add(s.clock.offsetValue()).UTC().AppendFormat(ts[:0], time.RFC3339Nano))
+		buf.WriteByte('"')
+	}
+	rest := event[1:]
+	if t := bytes.TrimSpace(rest); len(t) > 0 && t[0] != '}' {
+		buf.WriteByte(',')
+	}
+	buf.Write(rest)
+}
+
+// report adds the run ID and the last sequence number of numbered events to
+// a run result and logs them.
+func (s *eventStamper) report(result *report.Result) {
+	if s == nil || s.runID == "" {
+		return
+	}
+	result.Sequence = &report.SequenceStats{RunID: s.runID, Last: atomic.LoadInt64(&s.last)}
+	log.Infof("Sequence: run %s numbered events 1 to %d", s.runID, result.Sequence.Last)
+}
+
+// save stores the run ID and the last sequence number of numbered events in
+// a checkpoint.
+func (s *eventStamper) save(cp *checkpoint) {
+	if s == nil || s.runID == "" {
+		return
+	}
+	cp.RunID, cp.LastSequence = s.runID, atomic.LoadInt64(&s.last)
+}
@@ -0,0 +1,201 @@
+package loadgen
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// formats of the StatsD metrics
+const (
+	statsdPlain = "statsd"
+	statsdDog   = "dogstatsd"
+)
+
+// defaultStatsDPrefix is the prefix of the StatsD metric names.
+const defaultStatsDPrefix = "cloud_event_tester"
+
+// statsdPacket is the largest UDP payload sent, small enough not to be
+// fragmented on an Ethernet link.
+const statsdPacket = 1432
+
+// statsdEmitter streams the metrics of a performance run to a StatsD server
+// over UDP: every second the sends and errors of the second as counters and
+// the depth of the send queue and the rate allowed by adaptive rate control
+// as gauges, the latency of the successful sends as timers, sampled at the
+// sample rate, and the totals of the run as gauges when it ends. The
+// DogStatsD format tags every metric with the labels of the run. Metrics are
+// packed into as few packets as fit and sent as they fill up or once a
+// second; a StatsD server that is down costs no more than the failed writes.
+// It is safe for concurrent use; a nil emitter does nothing.
+type statsdEmitter struct {
+	addr       string
+	prefix     string
+	tags       string
+	sampleRate float64
+	conn       net.Conn
+
+	mu      sync.Mutex
+	buf     []byte
+	packets int
+	failed  int
+}
+
+func newStatsDEmitter(cfg *Config) (*statsdEmitter, error) {
+	if cfg.StatsDAddr == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", cfg.StatsDAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD server %s: %w", cfg.StatsDAddr, err)
+	}
+	prefix := cfg.StatsDPrefix
+	if prefix == "" {
+		prefix = defaultStatsDPrefix
+	}
+	s := &statsdEmitter{
+		addr:       cfg.StatsDAddr,
+		prefix:     strings.TrimSuffix(prefix, ".") + ".",
+		sampleRate: cfg.StatsDSampleRate,
+		conn:       conn,
+		buf:        make([]byte, 0, statsdPacket),
+	}
+	format := strings.ToLower(cfg.StatsDFormat)
+	if format == statsdDog && len(cfg.Labels) > 0 {
+		keys := make([]string, 0, len(cfg.Labels))
+		for k := range cfg.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		tags := make([]string, len(keys))
+		for i, k := range keys {
+			tags[i] = k + ":" + cfg.Labels[k]
+		}
+		s.tags = "|#" + strings.Join(tags, ",")
+	}
+	if format == "" {
+		format = statsdPlain
+	}
+	log.Infof("StatsD: metrics to %s as %s*, %s format, latency sampled at %g", s.addr, s.prefix, format, s.sampleRate)
+	return s, nil
+}
+
+// validateStatsD checks the StatsD settings of a run.
+func (c *Config) validateStatsD() error {
+	if c.StatsDAddr == "" {
+		return nil
+	}
+	switch strings.ToLower(c.StatsDFormat) {
+	case "", statsdPlain, statsdDog:
+	default:
+		return fmt.Errorf("StatsD format %q is not statsd or dogstatsd", c.StatsDFormat)
+	}
+	switch {
+	case !c.IsPerf():
+		return fmt.Errorf("StatsD metrics are streamed by performance runs only")
+	case c.StatsDSampleRate <= 0 || c.StatsDSampleRate > 1:
+		return fmt.Errorf("StatsD sample rate must be above 0 and at most 1, got %g", c.StatsDSampleRate)
+	}
+	if _, _, err := net.SplitHostPort(c.StatsDAddr); err != nil {
+		return fmt.Errorf("StatsD address %q is not host:port", c.StatsDAddr)
+	}
+	return nil
+}
+
+// record records the latency of a successful send, if it is sampled.
+func (s *statsdEmitter) record(d time.Duration) {
+	if s == nil {
+		return
+	}
+	if s.sampleRate < 1 && rand.Float64() >= s.sampleRate {
+		return
+	}
+	ms := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	if s.sampleRate < 1 {
+		ms += "|ms|@" + strconv.FormatFloat(s.sampleRate, 'g', -1, 64)
+	} else {
+		ms += "|ms"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add("latency", ms)
+}
+
+// tick sends the stats of a second and the metrics buffered.
+func (s *statsdEmitter) tick(stats report.TickStats) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add("sent", strconv.FormatUint(stats.Sent, 10)+"|c")
+	s.add("errors", strconv.Itoa(stats.Errors)+"|c")
+	s.add("queue_depth", strconv.Itoa(stats.QueueDepth)+"|g")
+	if stats.Rate > 0 {
+		s.add("rate", strconv.Itoa(stats.Rate)+"|g")
+	}
+	s.flush()
+}
+
+// finish sends the totals of a run and the metrics buffered, and closes the
+// connection.
+func (s *statsdEmitter) finish(result *report.Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gauge := func(name string, v float64) {
+		s.add(name, strconv.FormatFloat(v, 'f', -1, 64)+"|g")
+	}
+	gauge("run.total_msg", float64(result.TotalMsg))
+	gauge("run.avg_rate", result.AvgRate)
+	gauge("run.error_rate", result.ErrorRate)
+	if result.Latency != nil {
+		gauge("run.latency_p50", result.Latency.P50)
+		gauge("run.latency_p99", result.Latency.P99)
+	}
+	s.flush()
+	s.conn.Close()
+	if s.failed > 0 {
+		log.Warnf("StatsD: %d of %d packets to %s failed", s.failed, s.packets, s.addr)
+		return
+	}
+	log.Infof("StatsD: %d packets sent to %s", s.packets, s.addr)
+}
+
+// add buffers a metric, sending the buffer first if the metric does not fit.
+// The caller holds mu.
+func (s *statsdEmitter) add(name, value string) {
+	line := s.prefix + name + ":" + value + s.tags
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > statsdPacket {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// flush sends the buffered metrics. The caller holds mu.
+func (s *statsdEmitter) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+	s.packets++
+	if _, err := s.conn.Write(s.buf); err != nil {
+		if s.failed++; s.failed == 1 {
+			log.Warnf("StatsD: failed to send metrics to %s: %v", s.addr, err)
+		}
+	}
+	s.buf = s.buf[:0]
+}
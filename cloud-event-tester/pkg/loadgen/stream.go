@@ -0,0 +1,236 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// sizeFlag is a size flag in bytes that also takes a binary unit, like 64MB.
+type sizeFlag int
+
+func (s *sizeFlag) String() string {
+	if s == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*s))
+}
+
+func (s *sizeFlag) Set(value string) error {
+	if strings.TrimSpace(value) == "0" {
+		*s = 0
+		return nil
+	}
+	n, err := ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
+// checkFileSize fails an event file larger than -max-body-bytes, before it
+// is read, so a stray fixture of GBs does not take the memory of the run.
+func checkFileSize(file string, maxBytes int) error {
+	if maxBytes == 0 {
+		return nil
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return checkBodySize(filepath.Base(file), st.Size(), maxBytes)
+}
+
+// checkBodySize fails a body of size bytes larger than -max-body-bytes.
+func checkBodySize(name string, size int64, maxBytes int) error {
+	if maxBytes > 0 && size > int64(maxBytes) {
+		return fmt.Errorf("%s has %s, more than the %s of max-body-bytes", name, FormatBytes(uint64(size)), FormatBytes(uint64(maxBytes)))
+	}
+	return nil
+}
+
+// streamHeadSize is how much of an event file is read to pick its
+// Content-Type when it is streamed.
+const streamHeadSize = 4096
+
+// streamFile is an event file whose body is streamed from disk.
+type streamFile struct {
+	path        string
+	name        string
+	size        int64
+	contentType string
+	cumul       float64
+}
+
+// statStreamFile checks an event file to stream and picks its Content-Type
+// from its first bytes, as events.SetEvent does from the whole event.
+func statStreamFile(path string, mode events.ContentMode, maxBytes int) (streamFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return streamFile{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return streamFile{}, err
+	}
+	name := filepath.Base(path)
+	if err := checkBodySize(name, st.Size(), maxBytes); err != nil {
+		return streamFile{}, err
+	}
+	head := make([]byte, streamHeadSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return streamFile{}, err
+	}
+	return streamFile{path: path, name: name, size: st.Size(), contentType: mode.StructuredType(head[:n])}, nil
+}
+
+// setEventStream makes req send the content of an event file, read from
+// disk as it is written to the connection with chunked transfer encoding, so
+// the body is never in memory as a whole. The request closes the file once
+// it is sent, or when its body is set again.
+func setEventStream(req *fasthttp.Request, f streamFile) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	req.SetBodyStream(file, -1)
+	req.Header.SetContentType(f.contentType)
+	return nil
+}
+
+// bodyStreamer streams the event files of a performance run from disk with
+// every send instead of keeping the event in memory, once for every target
+// of every shard: the event file, or a weighted random choice of the files
+// of the event mix. The files are sent as they are, without the
+// placeholders, includes and environment variables of event files
+// expanded. A nil streamer leaves the bodies of the requests as they are.
+type bodyStreamer struct {
+	files []streamFile
+}
+
+func newBodyStreamer(cfg *Config, eventFile string) (*bodyStreamer, error) {
+	files := []weightedFile{{eventFile, 1}}
+	if cfg.isEventMix() {
+		var err error
+		if files, err = mixFiles(cfg); err != nil {
+			return nil, err
+		}
+	}
+	s := &bodyStreamer{}
+	var total float64
+	var size int64
+	for _, wf := range files {
+		if wf.weight == 0 {
+			continue
+		}
+		f, err := statStreamFile(wf.file, cfg.contentMode(), cfg.MaxBodyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stream event file %s: %w", wf.file, err)
+		}
+		total += wf.weight
+		f.cumul = total
+		size += f.size
+		s.files = append(s.files, f)
+	}
+	if len(s.files) == 0 {
+		return nil, noMixEvents(cfg)
+	}
+	log.Infof("Streaming Bodies: %d event files, %s, sent from disk with chunked encoding", len(s.files), FormatBytes(uint64(size)))
+	return s, nil
+}
+
+// pick returns the file of the next send.
+func (s *bodyStreamer) pick() streamFile {
+	if len(s.files) == 1 {
+		return s.files[0]
+	}
+	r := rand.Float64() * s.files[len(s.files)-1].cumul
+	return s.files[sort.Search(len(s.files), func(i int) bool { return s.files[i].cumul > r })]
+}
+
+// stream returns client sending the requests with a streamed body.
+func (s *bodyStreamer) stream(client sender.Doer) sender.Doer {
+	if s == nil {
+		return client
+	}
+	return streamingClient{Doer: client, s: s}
+}
+
+// streamingClient sets the body stream of every request of a client.
+type streamingClient struct {
+	sender.Doer
+	s *bodyStreamer
+}
+
+func (c streamingClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	if err := setEventStream(req, c.s.pick()); err != nil {
+		return err
+	}
+	return c.Doer.Do(req, res)
+}
+
+func (c streamingClient) Close() error {
+	sender.CloseClient(c.Doer)
+	return nil
+}
+
+// validateStreamBodies checks the body size guard and that nothing of the
+// run changes the streamed events, which are sent as they are on disk.
+func (c *Config) validateStreamBodies() error {
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("max-body-bytes must not be negative, got %d", c.MaxBodyBytes)
+	}
+	if !c.StreamBodies {
+		return nil
+	}
+	if c.isWatch() {
+		return fmt.Errorf("stream-bodies streams the event files of basic and performance runs only")
+	}
+	var conflicts []string
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"-generator", c.Generator != ""},
+		{"binary content mode", c.isBinary()},
+		{"-transport " + c.Transport, c.Transport != "" && strings.ToLower(c.Transport) != sender.TransportHTTP},
+		{"-label", len(c.Labels) > 0},
+		{"-batch-size", c.BatchSize > 1},
+		{"-payload-size", c.PayloadSize > 0},
+		{"-mutations", c.Mutations != ""},
+		{"-with-msg NO", strings.ToUpper(c.WithMessage) == "NO"},
+		{"-data-encoding", c.DataEncoding != ""},
+		{"-refresh-ids", c.RefreshIDs},
+		{"-sequence", c.Sequence},
+		{"-send-time", c.SendTime},
+		{"schemas", c.SchemaDir != "" || len(c.Schemas) > 0},
+		{"-fault-rate", c.FaultRate > 0},
+		{"-chaos-abort-rate", c.ChaosAbortRate > 0},
+		{"-chaos-truncate-rate", c.ChaosTruncateRate > 0},
+		{"-sign-secret", c.SignSecret != ""},
+		{"-trace-context", c.TraceContext},
+		{"-publishers", c.Publishers > 0},
+	} {
+		if o.set {
+			conflicts = append(conflicts, o.name)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("stream-bodies sends the event files as they are, it cannot be combined with %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// subscriptionTimeout bounds each call of the subscription phase. The calls
+// after the run do not use the context of the run, so an interrupted run
+// still removes its subscription.
+const subscriptionTimeout = 10 * time.Second
+
+// proxySubscription is a subscription of the consumer endpoint of a run to
+// its resource at a cloud-event-proxy, created before the events are sent
+// and deleted after, so the run exercises the pub/sub flow of the proxy
+// besides the webhook.
+type proxySubscription struct {
+	api      *ProxyAPI
+	resource string
+	endpoint string
+	id       string
+	client   *http.Client
+	// checks are the outcomes of the steps, for the report of the run
+	checks []report.CheckResult
+}
+
+// subscriptionInfo is a subscription as returned by the REST API; v1 names
+// its fields in camel case, v2 after the O-RAN specification.
+type subscriptionInfo struct {
+	ID              string `json:"id,omitempty"`
+	Resource        string `json:"resource,omitempty"`
+	EndpointURI     string `json:"endpointUri,omitempty"`
+	SubscriptionID  string `json:"SubscriptionId,omitempty"`
+	ResourceAddress string `json:"ResourceAddress,omitempty"`
+	EndpointURIV2   string `json:"EndpointUri,omitempty"`
+}
+
+func (s *subscriptionInfo) subscriptionID() string {
+	if s.SubscriptionID != "" {
+		return s.SubscriptionID
+	}
+	return s.ID
+}
+
+func (s *subscriptionInfo) resource() string {
+	if s.ResourceAddress != "" {
+		return s.ResourceAddress
+	}
+	return s.Resource
+}
+
+// subscribe discovers the cloud-event-proxy of the run, subscribes the
+// consumer endpoint to the resource and checks the subscription is listed.
+// A failed step fails the run before any event is sent.
+func subscribe(ctx context.Context, cfg *Config) (*proxySubscription, error) {
+	api, err := DiscoverProxy(ctx, cfg.ProxyAPI)
+	if err != nil {
+		return nil, err
+	}
+	s := &proxySubscription{
+		api:      api,
+		resource: cfg.Resource,
+		endpoint: cfg.SubscribeEndpoint,
+		client:   &http.Client{Timeout: subscriptionTimeout},
+	}
+	s.check("proxy status", true, "%s is healthy", api.Base)
+
+	body := subscriptionInfo{Resource: s.resource, EndpointURI: s.endpoint}
+	if api.Version != "v1" {
+		body = subscriptionInfo{ResourceAddress: s.resource, EndpointURIV2: s.endpoint}
+	}
+	var created subscriptionInfo
+	status, err := s.call(ctx, http.MethodPost, api.Base+"/subscriptions", &body, &created)
+	if err == nil && status != http.StatusCreated && status != http.StatusOK {
+		err = fmt.Errorf("POST %s/subscriptions: status %d", api.Base, status)
+	}
+	if err == nil && created.subscriptionID() == "" {
+		err = fmt.Errorf("POST %s/subscriptions: no subscription id in the response", api.Base)
+	}
+	if err != nil {
+		s.check("subscription create", false, "%v", err)
+		return nil, fmt.Errorf("failed to subscribe %s to %s: %w", s.endpoint, s.resource, err)
+	}
+	s.id = created.subscriptionID()
+	s.check("subscription create", true, "subscription %s of %s to %s", s.id, s.endpoint, s.resource)
+
+	var got subscriptionInfo
+	status, err = s.call(ctx, http.MethodGet, s.url(), nil, &got)
+	switch {
+	case err != nil:
+	case status != http.StatusOK:
+		err = fmt.Errorf("GET %s: status %d", s.url(), status)
+	case got.resource() != s.resource:
+		err = fmt.Errorf("subscription %s is for %s, not %s", s.id, got.resource(), s.resource)
+	}
+	if err != nil {
+		s.check("subscription status", false, "%v", err)
+		s.unsubscribe()
+		return nil, fmt.Errorf("subscription %s not active: %w", s.id, err)
+	}
+	s.check("subscription status", true, "subscription %s is active", s.id)
+	return s, nil
+}
+
+// unsubscribe deletes the subscription and checks that the proxy no longer
+// lists it and is still healthy.
+func (s *proxySubscription) unsubscribe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*subscriptionTimeout)
+	defer cancel()
+	status, err := s.call(ctx, http.MethodDelete, s.url(), nil, nil)
+	if err == nil && status != http.StatusNoContent && status != http.StatusOK {
+		err = fmt.Errorf("DELETE %s: status %d", s.url(), status)
+	}
+	if err != nil {
+		s.check("subscription delete", false, "%v", err)
+		return fmt.Errorf("failed to delete subscription %s: %w", s.id, err)
+	}
+	s.check("subscription delete", true, "subscription %s deleted", s.id)
+
+	status, err = s.call(ctx, http.MethodGet, s.url(), nil, nil)
+	if err == nil && status != http.StatusNotFound {
+		err = fmt.Errorf("GET %s: status %d after the delete", s.url(), status)
+	}
+	if err != nil {
+		s.check("subscription removed", false, "%v", err)
+		return fmt.Errorf("subscription %s still listed: %w", s.id, err)
+	}
+	s.check("subscription removed", true, "subscription %s no longer listed", s.id)
+
+	if err := proxyGet(ctx, s.client, s.api.Base+"/health", nil); err != nil {
+		s.check("proxy status after run", false, "%v", err)
+		return fmt.Errorf("cloud-event-proxy unhealthy after the run: %w", err)
+	}
+	s.check("proxy status after run", true, "%s is healthy", s.api.Base)
+	return nil
+}
+
+func (s *proxySubscription) url() string {
+	return s.api.Base + "/subscriptions/" + s.id
+}
+
+// check records the outcome of a step and logs it.
+func (s *proxySubscription) check(name string, ok bool, format string, args ...interface{}) {
+	c := report.CheckResult{Name: name}.PassIf(ok, format, args...)
+	if ok {
+		log.Infof("Subscription phase: %s: %s", name, c.Message)
+	} else {
+		log.Errorf("Subscription phase: %s failed: %s", name, c.Message)
+	}
+	s.checks = append(s.checks, c)
+}
+
+// call sends a request to the REST API and decodes a JSON response into out
+// if it is not nil. It returns the status of the response.
+func (s *proxySubscription) call(ctx context.Context, method, url string, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer sender.DrainBody(resp.Body)
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s %s: %w", method, url, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,177 @@
+package loadgen
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// spikeErrorShare is the share of the sends of a second of a run that failed
+// for the second to count as a send error spike.
+const spikeErrorShare = 0.1
+
+// healthPoller polls the health endpoint of the target of a performance run
+// every interval while the run sends, and relates the periods the target
+// reported itself unhealthy to the send errors of the timeline of the run,
+// so an error spike can be told from a consumer that went down. A 2xx
+// response is healthy, any other and a failed poll are not. A nil poller
+// polls nothing.
+type healthPoller struct {
+	url      string
+	interval time.Duration
+	client   *fasthttp.Client
+	// elapsed is the time into the run, as the timeline counts it
+	elapsed func() time.Duration
+	wg      sync.WaitGroup
+
+	stats report.TargetHealthStats
+	// open is the current unhealthy period, nil while healthy
+	open *report.UnhealthyPeriod
+}
+
+func newHealthPoller(cfg *Config, elapsed func() time.Duration) *healthPoller {
+	if cfg.HealthURL == "" {
+		return nil
+	}
+	tlsConfig, _ := cfg.TLSConfig()
+	log.Infof("Target Health: polling %s every %v", cfg.HealthURL, cfg.HealthInterval)
+	return &healthPoller{
+		url:      cfg.HealthURL,
+		interval: cfg.HealthInterval,
+		client:   &fasthttp.Client{TLSConfig: tlsConfig, Dial: sender.NewDialer(&cfg.Options)},
+		elapsed:  elapsed,
+		stats:    report.TargetHealthStats{URL: cfg.HealthURL},
+	}
+}
+
+// validateHealthURL checks the health endpoint of the target.
+func (c *Config) validateHealthURL() error {
+	if c.HealthURL == "" {
+		return nil
+	}
+	switch {
+	case !c.IsPerf():
+		return fmt.Errorf("the health endpoint of the target is polled during performance runs only")
+	case c.HealthInterval <= 0:
+		return fmt.Errorf("health interval must be positive, got %v", c.HealthInterval)
+	}
+	if _, _, ok := sender.ParseUnixTarget(c.HealthURL); ok {
+		return nil
+	}
+	u, err := url.Parse(c.HealthURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("health URL %s is not an http or https URL", c.HealthURL)
+	}
+	return nil
+}
+
+// start polls until stop is closed.
+func (p *healthPoller) start(stop <-chan struct{}) {
+	if p == nil {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			p.poll()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// poll checks the health endpoint once and logs the changes of its health.
+func (p *healthPoller) poll() {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	req.SetRequestURI(sender.TargetURI(p.url))
+	req.Header.SetMethod(fasthttp.MethodGet)
+	reason := ""
+	if err := p.client.DoTimeout(req, res, p.interval); err != nil {
+		reason = fmt.Sprintf("poll failed: %v", err)
+	} else if code := res.StatusCode(); code < 200 || code > 299 {
+		reason = fmt.Sprintf("status %d", code)
+	}
+	at := p.elapsed().Seconds()
+
+	p.stats.Polls++
+	switch {
+	case reason != "" && p.open == nil:
+		p.stats.Failed++
+		p.stats.Flaps++
+		p.open = &report.UnhealthyPeriod{From: at, To: at, Reason: reason}
+		log.Warnf("Target Health: unhealthy at %.1fs, %s", at, reason)
+	case reason != "":
+		p.stats.Failed++
+		p.open.To = at
+	case p.open != nil:
+		p.open.To = at
+		log.Infof("Target Health: healthy again at %.1fs after %.1fs", at, p.open.To-p.open.From)
+		p.stats.Unhealthy = append(p.stats.Unhealthy, *p.open)
+		p.open = nil
+	}
+}
+
+// report adds the health of the target to a run result and relates it to
+// the send errors of its timeline. It must only be called once the run
+// stopped sending, with the timeline of the result set.
+func (p *healthPoller) report(result *report.Result) {
+	if p == nil {
+		return
+	}
+	p.wg.Wait()
+	stats := p.stats
+	if p.open != nil {
+		p.open.To = p.elapsed().Seconds()
+		stats.Unhealthy = append(stats.Unhealthy, *p.open)
+	}
+	interval := p.interval.Seconds()
+	for _, tick := range result.Timeline {
+		// the second of a tick is the one that ended at that time
+		from, to := float64(tick.Second-1), float64(tick.Second)
+		unhealthy, near := false, false
+		for i := range stats.Unhealthy {
+			u := &stats.Unhealthy[i]
+			if from <= u.To && to >= u.From {
+				u.SendErrors += tick.Errors
+				unhealthy = true
+			}
+			if from <= u.To+interval && to >= u.From-interval {
+				near = true
+			}
+		}
+		if unhealthy {
+			stats.ErrorsWhileUnhealthy += tick.Errors
+		} else {
+			stats.ErrorsWhileHealthy += tick.Errors
+		}
+		if tick.Errors > 0 && float64(tick.Errors) >= spikeErrorShare*float64(tick.Sent) {
+			stats.Spikes++
+			if near {
+				stats.CorrelatedSpikes++
+			}
+		}
+	}
+	for _, u := range stats.Unhealthy {
+		stats.UnhealthySeconds += u.To - u.From
+	}
+	result.TargetHealth = &stats
+	log.Infof("Target Health: %d polls, %d failed, %d flaps, unhealthy for %.1f seconds", stats.Polls, stats.Failed, stats.Flaps, stats.UnhealthySeconds)
+	log.Infof("Target Health: %d send errors while unhealthy, %d while healthy, %d of %d send error spikes near an unhealthy period",
+		stats.ErrorsWhileUnhealthy, stats.ErrorsWhileHealthy, stats.CorrelatedSpikes, stats.Spikes)
+}
@@ -0,0 +1,137 @@
+package loadgen
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// targetWatcher discovers the Kubernetes targets of a performance run again
+// every refresh interval, so the sends follow the endpoints of the service
+// or the pods of the selector as they are scaled, rolled or rescheduled.
+// The send loops of the shards take a new set of targets before their next
+// send, see retarget. A discovery finding no endpoints or failing keeps the
+// targets, a run is not stopped by a rollout. A nil watcher keeps the
+// targets of the run.
+type targetWatcher struct {
+	cfg      *Config
+	client   *KubeClient
+	interval time.Duration
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	targets []string
+	// gen counts the changes of targets, the shards compare it with the
+	// generation they send to
+	gen int64
+}
+
+func newTargetWatcher(cfg *Config, targets []string) *targetWatcher {
+	if (cfg.TargetSelector == "" && cfg.TargetService == "") || cfg.TargetRefresh <= 0 {
+		return nil
+	}
+	client, err := NewKubeClient(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		log.Warnf("Target refresh disabled, failed to create kubernetes client: %v", err)
+		return nil
+	}
+	log.Infof("Target Refresh: discovering the endpoints of %s every %v", cfg.discoveryText(client), cfg.TargetRefresh)
+	return &targetWatcher{cfg: cfg, client: client, interval: cfg.TargetRefresh, targets: targets}
+}
+
+// start discovers the targets until stop is closed.
+func (w *targetWatcher) start(ctx context.Context, stop <-chan struct{}) {
+	if w == nil {
+		return
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			w.refresh(ctx)
+		}
+	}()
+}
+
+// stop waits for the discovery in progress.
+func (w *targetWatcher) stop() {
+	if w == nil {
+		return
+	}
+	w.wg.Wait()
+}
+
+func (w *targetWatcher) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, w.interval)
+	defer cancel()
+	targets, err := discoverTargets(ctx, w.cfg, w.client)
+	if err != nil {
+		log.Warnf("Target refresh failed, keeping %d target(s): %v", len(w.current()), err)
+		return
+	}
+	if len(targets) == 0 {
+		log.Warnf("Target refresh found no ready endpoints for %s, keeping %d target(s)", w.cfg.discoveryText(w.client), len(w.current()))
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	added, removed := diffTargets(w.targets, targets)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	log.Infof("Targets changed to %d endpoint(s), added %v, removed %v", len(targets), added, removed)
+	w.targets = targets
+	atomic.AddInt64(&w.gen, 1)
+}
+
+func (w *targetWatcher) current() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.targets
+}
+
+// retarget gives s the current targets if they changed since it took them.
+func (w *targetWatcher) retarget(s *sendShard) {
+	if w == nil || atomic.LoadInt64(&w.gen) == s.targetGen {
+		return
+	}
+	w.mu.Lock()
+	targets, gen := w.targets, atomic.LoadInt64(&w.gen)
+	w.mu.Unlock()
+	s.retarget(targets)
+	s.targetGen = gen
+}
+
+// diffTargets returns the targets of next not in prev and those of prev not
+// in next.
+func diffTargets(prev, next []string) (added, removed []string) {
+	in := func(targets []string, t string) bool {
+		for _, u := range targets {
+			if u == t {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range next {
+		if !in(prev, t) {
+			added = append(added, t)
+		}
+	}
+	for _, t := range prev {
+		if !in(next, t) {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
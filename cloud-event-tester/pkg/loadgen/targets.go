@@ -0,0 +1,431 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// maxTargetSlots bounds the sum of the weights of the targets of a run, after
+// dividing them by their greatest common divisor; the events are distributed
+// over that many slots.
+const maxTargetSlots = 1000
+
+// targetSpec is a target URL of a run and its share of the events.
+type targetSpec struct {
+	URL string `yaml:"url" json:"url"`
+	// Weight is the share of the events relative to the other targets, 1 if
+	// not set
+	Weight int `yaml:"weight" json:"weight,omitempty"`
+}
+
+// parseTargetSpec parses a target given as URL or "URL;weight=N".
+func parseTargetSpec(s string) (targetSpec, error) {
+	t := targetSpec{URL: strings.TrimSpace(s)}
+	if i := strings.LastIndex(t.URL, ";weight="); i >= 0 {
+		w, err := strconv.Atoi(t.URL[i+len(";weight="):])
+		if err != nil || w < 1 {
+			return targetSpec{}, fmt.Errorf("target %q has an invalid weight, it must be a positive integer", s)
+		}
+		t.URL, t.Weight = t.URL[:i], w
+	}
+	if t.URL == "" {
+		return targetSpec{}, fmt.Errorf("target URL must not be empty")
+	}
+	return t, nil
+}
+
+// urlsFlag is the -url flag. Given once it sets the target URL of the run;
+// given more than once, every URL is a target, with an optional weight.
+type urlsFlag struct {
+	c   *Config
+	set bool
+}
+
+func (f *urlsFlag) String() string {
+	if f == nil || f.c == nil {
+		return ""
+	}
+	return f.c.URL
+}
+
+func (f *urlsFlag) Set(value string) error {
+	t, err := parseTargetSpec(value)
+	if err != nil {
+		return err
+	}
+	if !f.set {
+		// the first one replaces the default and the targets of a scenario
+		f.set = true
+		f.c.URL, f.c.Targets = t.URL, nil
+	}
+	f.c.Targets = append(f.c.Targets, t)
+	return nil
+}
+
+// targetSpecs returns the target URLs of a run, from the targets file, the
+// targets or else the URL.
+func (c *Config) targetSpecs() ([]targetSpec, error) {
+	if c.TargetsFile != "" {
+		return readTargetsFile(c.TargetsFile)
+	}
+	if len(c.Targets) > 0 {
+		return c.Targets, nil
+	}
+	return []targetSpec{{URL: c.URL}}, nil
+}
+
+// readTargetsFile reads a file with a target per line, its URL optionally
+// followed by its weight. Empty lines and lines starting with # are skipped.
+func readTargetsFile(path string) ([]targetSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var specs []targetSpec
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		t := targetSpec{URL: fields[0]}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a URL and an optional weight", path, n)
+		}
+		if len(fields) == 2 {
+			if t.Weight, err = strconv.Atoi(fields[1]); err != nil || t.Weight < 1 {
+				return nil, fmt.Errorf("%s:%d: weight %q must be a positive integer", path, n, fields[1])
+			}
+		}
+		specs = append(specs, t)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no targets in %s", path)
+	}
+	return specs, nil
+}
+
+// validateTargets checks the targets of a run with several. They are
+// given explicitly, so they cannot be combined with discovery.
+func (c *Config) validateTargets() error {
+	specs, err := c.targetSpecs()
+	if err != nil {
+		return err
+	}
+	if len(specs) < 2 && c.TargetsFile == "" {
+		return nil
+	}
+	if c.TargetSelector != "" || c.TargetService != "" {
+		return fmt.Errorf("several target URLs cannot be combined with Kubernetes target discovery")
+	}
+	for _, t := range specs {
+		if t.URL == autoURL {
+			return fmt.Errorf("the cloud-event-proxy is discovered for a single target, not one of several")
+		}
+		if t.Weight < 0 {
+			return fmt.Errorf("target %s has a negative weight", t.URL)
+		}
+	}
+	_, err = weightedTargets(specs)
+	return err
+}
+
+// weightedTargets spreads the targets over slots by their weights, which
+// the send loops go through in turn. The slots are interleaved like smooth
+// weighted round-robin does, so a target with weight 3 next to one with 1
+// gets three of every four events but never all of a burst.
+func weightedTargets(specs []targetSpec) ([]string, error) {
+	weights := make([]int, len(specs))
+	divisor := 0
+	for i, t := range specs {
+		weights[i] = t.Weight
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+		divisor = gcd(divisor, weights[i])
+	}
+	total := 0
+	for i := range weights {
+		weights[i] /= divisor
+		total += weights[i]
+	}
+	if total > maxTargetSlots {
+		return nil, fmt.Errorf("the target weights add up to %d, at most %d are supported", total, maxTargetSlots)
+	}
+	slots := make([]string, 0, total)
+	current := make([]int, len(weights))
+	for len(slots) < total {
+		best := 0
+		for i, w := range weights {
+			current[i] += w
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		slots = append(slots, specs[best].URL)
+	}
+	return slots, nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// resolveTargets returns the URLs events are sent to, which the sends go
+// through in turn. Without Kubernetes discovery settings these are the
+// configured URL, or the slots of the targets of a run with several;
+// otherwise the host of the URL is replaced by the discovered pod endpoints.
+func resolveTargets(ctx context.Context, cfg *Config) ([]string, error) {
+	if cfg.TargetSelector == "" && cfg.TargetService == "" {
+		specs, err := cfg.targetSpecs()
+		if err != nil {
+			return nil, err
+		}
+		slots, err := weightedTargets(specs)
+		if err != nil {
+			return nil, err
+		}
+		// the first target stands for the run in logs and reports
+		cfg.URL = specs[0].URL
+		if len(specs) == 1 {
+			return slots, nil
+		}
+		for _, t := range specs {
+			weight := t.Weight
+			if weight == 0 {
+				weight = 1
+			}
+			log.Infof("Target %s, weight %d", t.URL, weight)
+		}
+		return slots, nil
+	}
+	client, err := NewKubeClient(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	targets, err := discoverTargets(ctx, cfg, client)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no ready endpoints found for %s", cfg.discoveryText(client))
+	}
+	log.Infof("Discovered %d target endpoint(s): %v", len(targets), targets)
+	return targets, nil
+}
+
+// discoverTargets returns the URLs of the ready endpoints of the Kubernetes
+// discovery settings, in a stable order, so discoveries can be compared.
+func discoverTargets(ctx context.Context, cfg *Config, client *KubeClient) ([]string, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %s: %w", cfg.URL, err)
+	}
+	var hosts []string
+	if cfg.TargetService != "" {
+		namespace, name, port := cfg.targetService(client)
+		if port == "" && cfg.TargetPort > 0 {
+			port = strconv.Itoa(cfg.TargetPort)
+		}
+		hosts, err = client.serviceEndpoints(ctx, namespace, name, port)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve service %s/%s: %w", namespace, name, err)
+		}
+	} else {
+		namespace := cfg.TargetNamespace
+		if namespace == "" {
+			namespace = client.Namespace
+		}
+		ips, err := client.readyPodIPs(ctx, namespace, cfg.TargetSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve pods %s in %s: %w", cfg.TargetSelector, namespace, err)
+		}
+		port := cfg.TargetPort
+		if port == 0 {
+			if port, err = urlPort(base); err != nil {
+				return nil, err
+			}
+		}
+		for _, ip := range ips {
+			hosts = append(hosts, net.JoinHostPort(ip, strconv.Itoa(port)))
+		}
+	}
+	sort.Strings(hosts)
+	if !cfg.Spread && len(hosts) > 1 {
+		hosts = hosts[:1]
+	}
+
+	targets := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		u := *base
+		u.Host = host
+		targets = append(targets, u.String())
+	}
+	return targets, nil
+}
+
+// targetService returns the namespace, name and port of -target-service,
+// given as [namespace/]name[:port]; the namespace defaults to
+// -target-namespace, then to the namespace of client.
+func (c *Config) targetService(client *KubeClient) (namespace, name, port string) {
+	name = c.TargetService
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, port = name[:i], name[i+1:]
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		namespace, name = name[:i], name[i+1:]
+	}
+	if namespace == "" {
+		namespace = c.TargetNamespace
+	}
+	if namespace == "" && client != nil {
+		namespace = client.Namespace
+	}
+	return namespace, name, port
+}
+
+// discoveryText describes the Kubernetes discovery settings in logs.
+func (c *Config) discoveryText(client *KubeClient) string {
+	if c.TargetService != "" {
+		namespace, name, _ := c.targetService(client)
+		return "service " + namespace + "/" + name
+	}
+	namespace := c.TargetNamespace
+	if namespace == "" {
+		namespace = client.Namespace
+	}
+	return "pods " + c.TargetSelector + " in " + namespace
+}
+
+// validateDiscovery checks the Kubernetes target discovery settings.
+func (c *Config) validateDiscovery() error {
+	if c.TargetSelector != "" && c.TargetService != "" {
+		return fmt.Errorf("only one of target selector and target service can be set")
+	}
+	if c.TargetRefresh < 0 {
+		return fmt.Errorf("target refresh must not be negative, got %v", c.TargetRefresh)
+	}
+	if c.TargetService == "" {
+		return nil
+	}
+	namespace, name, port := c.targetService(nil)
+	switch {
+	case name == "" || strings.Contains(name, "/"):
+		return fmt.Errorf("target service %q is not [namespace/]name[:port]", c.TargetService)
+	case strings.Contains(c.TargetService, ":") && port == "":
+		return fmt.Errorf("target service %q has an empty port", c.TargetService)
+	case port != "" && c.TargetPort > 0:
+		return fmt.Errorf("target service %s has a port, it cannot be combined with -port", c.TargetService)
+	case c.TargetNamespace != "" && namespace != c.TargetNamespace:
+		return fmt.Errorf("target service %s is in another namespace than -target-namespace %s", c.TargetService, c.TargetNamespace)
+	}
+	return nil
+}
+
+// urlPort returns the explicit or default port of a URL.
+func urlPort(u *url.URL) (int, error) {
+	if p := u.Port(); p != "" {
+		return strconv.Atoi(p)
+	}
+	if u.Scheme == "https" {
+		return 443, nil
+	}
+	return 80, nil
+}
+
+// targetRecorder counts the sends to every target of a run with several,
+// and records the latencies of their successful sends. It is safe for
+// concurrent use.
+type targetRecorder struct {
+	mu    sync.Mutex
+	stats map[string]*targetCounts
+	order []string
+}
+
+type targetCounts struct {
+	stats   report.TargetStats
+	latency *hdrhistogram.Histogram
+}
+
+// newTargetRecorder returns the recorder of the targets of a run, or nil if
+// it sends to a single URL.
+func newTargetRecorder(targets []string) *targetRecorder {
+	r := &targetRecorder{stats: map[string]*targetCounts{}}
+	for _, t := range targets {
+		if _, ok := r.stats[t]; !ok {
+			r.stats[t] = &targetCounts{stats: report.TargetStats{URL: t}, latency: report.NewLatencyHistogram()}
+			r.order = append(r.order, t)
+		}
+	}
+	if len(r.order) < 2 {
+		return nil
+	}
+	return r
+}
+
+// record accounts a send to target that failed, or succeeded after latency.
+// Sends to a backup URL are accounted as well. A nil recorder records
+// nothing.
+func (r *targetRecorder) record(target string, latency time.Duration, failed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.stats[target]
+	if !ok {
+		c = &targetCounts{stats: report.TargetStats{URL: target}, latency: report.NewLatencyHistogram()}
+		r.stats[target] = c
+		r.order = append(r.order, target)
+	}
+	c.stats.Sent++
+	if failed {
+		c.stats.Errors++
+		return
+	}
+	report.RecordLatency(c.latency, latency)
+}
+
+// report adds the per-target stats to a run result, in place of those of
+// the failover.
+func (r *targetRecorder) report(result *report.Result) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result.Targets = make([]report.TargetStats, 0, len(r.order))
+	for _, target := range r.order {
+		c := r.stats[target]
+		st := c.stats
+		st.Latency = report.SummarizeLatency(c.latency)
+		if st.Latency != nil {
+			log.Infof("Target %s: %d sent, %d errors, latency (ms) p50 %.3f p99 %.3f", st.URL, st.Sent, st.Errors, st.Latency.P50, st.Latency.P99)
+		} else {
+			log.Infof("Target %s: %d sent, %d errors", st.URL, st.Sent, st.Errors)
+		}
+		result.Targets = append(result.Targets, st)
+	}
+}
@@ -0,0 +1,212 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// TimelineManifest is an ordered sequence of events, such as the state
+// transitions of a clock from locked to holdover to freerun, sent one after
+// the other at their times from the start of the playback. Unlike the event
+// files of a basic run, which go out in directory order at a fixed
+// interval, every step has its own time and expected response, so the run
+// plays the same sequence the same way every time.
+type TimelineManifest struct {
+	// StopOnFailure does not send the steps after one that failed, as the
+	// consumer is not in the state they expect
+	StopOnFailure bool           `yaml:"stopOnFailure"`
+	Steps         []timelineStep `yaml:"steps"`
+}
+
+// timelineStep is an event of a timeline: an event file, relative to the
+// manifest, or an inline event, sent at At from the start.
+type timelineStep struct {
+	Name  string                 `yaml:"name"`
+	At    time.Duration          `yaml:"at"`
+	File  string                 `yaml:"file"`
+	Event map[string]interface{} `yaml:"event"`
+	// Headers are sent with this event only, in addition to -header
+	Headers map[string]string `yaml:"headers"`
+	// Expect is the expected response, the settings it leaves empty taken
+	// from the assertions of the run
+	Expect AssertionSpec `yaml:"expect"`
+
+	event  []byte
+	assert *responseAssertion
+}
+
+// LoadTimeline reads and checks a timeline manifest, with the events of its
+// steps and their assertions.
+func LoadTimeline(path string, global AssertionSpec) (*TimelineManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m TimelineManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid timeline manifest %s: %w", path, err)
+	}
+	if len(m.Steps) == 0 {
+		return nil, fmt.Errorf("timeline manifest %s has no steps", path)
+	}
+	dir := filepath.Dir(path)
+	for i := range m.Steps {
+		s := &m.Steps[i]
+		if s.Name == "" {
+			s.Name = "step " + strconv.Itoa(i+1)
+			if s.File != "" {
+				s.Name += " (" + filepath.Base(s.File) + ")"
+			}
+		}
+		switch {
+		case s.At < 0:
+			return nil, fmt.Errorf("timeline step %s is at %v, it must not be negative", s.Name, s.At)
+		case i > 0 && s.At < m.Steps[i-1].At:
+			return nil, fmt.Errorf("timeline step %s is at %v, before the step %s at %v; the steps are played in order", s.Name, s.At, m.Steps[i-1].Name, m.Steps[i-1].At)
+		case (s.File == "") == (s.Event == nil):
+			return nil, fmt.Errorf("timeline step %s needs either a file or an event", s.Name)
+		}
+		if s.File != "" {
+			if s.event, err = os.ReadFile(resolvePath(s.File, dir)); err != nil {
+				return nil, fmt.Errorf("timeline step %s: %w", s.Name, err)
+			}
+		} else if s.event, err = json.Marshal(s.Event); err != nil {
+			return nil, fmt.Errorf("timeline step %s: invalid event: %w", s.Name, err)
+		}
+		if s.assert, err = newResponseAssertion(s.Expect.withDefaults(global)); err != nil {
+			return nil, fmt.Errorf("timeline step %s: %w", s.Name, err)
+		}
+	}
+	return &m, nil
+}
+
+// PlayTimeline sends the steps of m in order, each at its time from the
+// start divided by speed, or right after the response to the previous one
+// if that came later, and checks the response to each with its assertion.
+// Each step is a check of the result. cfg is not changed by the timeline.
+func PlayTimeline(ctx context.Context, cfg *Config, m *TimelineManifest, speed float64) (*report.Result, error) {
+	own := cfg.Clone()
+	cfg = &own
+	targets, err := resolveTargets(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := sender.NewAuthenticator(&cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer auth.Close()
+	client := sender.WithAuth(NewHTTPClient(cfg, nil), auth)
+	defer sender.CloseClient(client)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	last := time.Duration(float64(m.Steps[len(m.Steps)-1].At) / speed)
+	log.Infof("Playing %d timeline steps over %v to %s", len(m.Steps), last, cfg.URL)
+	health := NewRunHealth(ctx)
+	defer health.close()
+	health.setReady(true)
+	result := &report.Result{Mode: "timeline", StartTime: time.Now()}
+	var seq int64
+	// how far the playback fell behind the times of the steps
+	var maxLag time.Duration
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var failedStep string
+	for i, step := range m.Steps {
+		check := report.CheckResult{Name: step.Name}
+		if failedStep != "" {
+			result.Checks = append(result.Checks, check.Fail("not sent, step %s failed", failedStep))
+			continue
+		}
+		if wait := time.Until(result.StartTime.Add(time.Duration(float64(step.At) / speed))); wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+		} else if -wait > maxLag {
+			maxLag = -wait
+		}
+		if ctx.Err() != nil {
+			result.Interrupted = true
+			break
+		}
+
+		target := targets[i%len(targets)]
+		check.Seconds = time.Since(result.StartTime).Seconds()
+		event, err := events.RenderEvent(step.Name, events.LabelEvent(step.event, cfg.Labels), &seq)
+		if err != nil {
+			log.Errorf("Timeline step %s: %v", step.Name, err)
+			result.Checks = append(result.Checks, check.Fail("failed to render: %v", err))
+		} else {
+			var answered bool
+			check, answered = playStep(client, res, cfg, target, step, event, check)
+			if answered {
+				result.CountStatus(res.StatusCode(), 1)
+			}
+			result.TotalMsg++
+			if check.Passed {
+				result.Succeeded++
+			}
+			result.Checks = append(result.Checks, check)
+		}
+		if !check.Passed && m.StopOnFailure {
+			failedStep = step.Name
+		}
+	}
+
+	result.EndTime = time.Now()
+	result.TotalSeconds = result.EndTime.Sub(result.StartTime).Seconds()
+	if maxLag > 10*time.Millisecond {
+		log.Warnf("The playback fell up to %v behind the times of the steps", maxLag.Round(time.Millisecond))
+	}
+	logStatusCodes(result.StatusCodes, nil)
+	if result.Interrupted {
+		log.Infof("Timeline interrupted. %d/%d steps passed", result.Succeeded, len(m.Steps))
+	} else {
+		log.Infof("Timeline completed. %d/%d steps passed", result.Succeeded, len(m.Steps))
+	}
+	return result, nil
+}
+
+// playStep sends the event of a step to target and checks the response in
+// res; answered is false if the send got none.
+func playStep(client sender.Doer, res *fasthttp.Response, cfg *Config, target string, step timelineStep, event []byte, check report.CheckResult) (_ report.CheckResult, answered bool) {
+	req := NewEventRequest(target, event, cfg.contentMode(), cfg.Headers, cfg.Labels)
+	defer fasthttp.ReleaseRequest(req)
+	sender.SetHeaders(req, step.Headers)
+	start := time.Now()
+	err := client.Do(req, res)
+	took := time.Since(start)
+	fields := sendFields(step.Name, target, res.StatusCode(), took)
+	if err != nil {
+		log.WithFields(fields).Errorf("Timeline step %s at %.3fs failed: %v", step.Name, check.Seconds, err)
+		return check.Fail("failed to send: %v", err), false
+	}
+	if kind, reason := step.assert.check(res); kind != "" {
+		log.WithFields(fields).Errorf("Timeline step %s at %.3fs: %s", step.Name, check.Seconds, reason)
+		return check.Fail("%s", reason), true
+	}
+	log.WithFields(fields).Infof("Timeline step %s at %.3fs: status %d", step.Name, check.Seconds, res.StatusCode())
+	return check.PassIf(true, "status %d in %v", res.StatusCode(), took.Round(time.Microsecond)), true
+}
@@ -0,0 +1,41 @@
+package loadgen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// WriteTimeseriesFile writes the stats of every second of a performance run
+// to the time series file, if one is configured, as CSV to chart or load
+// into a spreadsheet, so the second a run started to degrade shows and not
+// only its totals. Failures are logged; they do not fail the run.
+func WriteTimeseriesFile(cfg *Config, result *report.Result) {
+	if cfg.TimeseriesFile == "" || result == nil || len(result.Timeline) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	report.WriteTimeseriesCSV(&buf, result)
+	if cfg.TimeseriesFile == "-" {
+		os.Stdout.Write(buf.Bytes()) //nolint: errcheck
+		return
+	}
+	if err := os.WriteFile(cfg.TimeseriesFile, buf.Bytes(), 0o644); err != nil {
+		log.Errorf("Failed to write time series file: %v", err)
+		return
+	}
+	log.Infof("Time series file: %s, %d seconds", cfg.TimeseriesFile, len(result.Timeline))
+}
+
+// validateTimeseries checks that the time series file is of a performance
+// run, the runs with a timeline.
+func (c *Config) validateTimeseries() error {
+	if c.TimeseriesFile != "" && !c.IsPerf() {
+		return fmt.Errorf("the time series file has the seconds of performance runs only")
+	}
+	return nil
+}
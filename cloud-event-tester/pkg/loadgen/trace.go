@@ -0,0 +1,141 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// traceRecord is a line of the trace file of a run: a request, when it was
+// sent, the IDs of its events, comma separated for a batch, and how it was
+// answered. A request that failed has no status; one whose response failed
+// an assertion has the status and the failure as its error.
+type traceRecord struct {
+	Time      string  `json:"time"`
+	ID        string  `json:"id,omitempty"`
+	Target    string  `json:"target"`
+	Status    int     `json:"status,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// requestTracer writes a line of JSON for every request of a performance run
+// to the trace file, apart from the log, so a lost event can be looked up by
+// its ID after a run of millions. It is safe for concurrent use; a nil
+// tracer writes nothing.
+type requestTracer struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+	n    int
+	err  error
+}
+
+func newRequestTracer(cfg *Config) (*requestTracer, error) {
+	if cfg.TraceFile == "" {
+		return nil, nil
+	}
+	f, err := os.Create(cfg.TraceFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace file: %w", err)
+	}
+	t := &requestTracer{path: cfg.TraceFile, f: f, w: bufio.NewWriterSize(f, 1<<16)}
+	t.enc = json.NewEncoder(t.w)
+	log.Infof("Trace: writing every request to %s", cfg.TraceFile)
+	return t, nil
+}
+
+// record writes a request sent at sent to target, answered with status after
+// latency or failed with errText.
+func (t *requestTracer) record(sent time.Time, req *fasthttp.Request, target string, status int, latency time.Duration, errText string) {
+	if t == nil {
+		return
+	}
+	rec := traceRecord{
+		Time:      sent.UTC().Format(time.RFC3339Nano),
+		ID:        requestEventID(req),
+		Target:    target,
+		Status:    status,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		Error:     errText,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	if t.err = t.enc.Encode(&rec); t.err != nil {
+		log.Errorf("Failed to write trace file %s, not tracing any more: %v", t.path, t.err)
+		return
+	}
+	t.n++
+}
+
+// close flushes and closes the trace file.
+func (t *requestTracer) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.w.Flush()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && t.err == nil {
+		log.Errorf("Failed to write trace file %s: %v", t.path, err)
+		return
+	}
+	log.Infof("Trace: %d requests written to %s", t.n, t.path)
+}
+
+// requestEventID returns the ID of the event of a request: its ce-id header
+// in binary content mode, else the id of the cloud event or the Id of the
+// Redfish event of the body, those of all its events for a batch.
+func requestEventID(req *fasthttp.Request) string {
+	if id := req.Header.Peek("ce-id"); len(id) > 0 {
+		return events.DecodeHeaderValue(string(id))
+	}
+	body := bytes.TrimSpace(req.Body())
+	if len(body) > 0 && body[0] == '[' {
+		var batch []eventIDs
+		if json.Unmarshal(body, &batch) != nil {
+			return ""
+		}
+		ids := make([]string, 0, len(batch))
+		for _, e := range batch {
+			ids = append(ids, e.id())
+		}
+		return strings.Join(ids, ",")
+	}
+	var e eventIDs
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	return e.id()
+}
+
+// eventIDs are the fields an event is identified by.
+type eventIDs struct {
+	ID        string `json:"id"`
+	RedfishID string `json:"Id"`
+}
+
+func (e *eventIDs) id() string {
+	if e.ID != "" {
+		return e.ID
+	}
+	return e.RedfishID
+}
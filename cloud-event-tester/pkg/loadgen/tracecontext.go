@@ -0,0 +1,413 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// headers and attributes of the W3C trace context, the same for the
+// CloudEvents distributed tracing extension
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// otlp export settings: spans are posted in batches of up to spanBatch, at
+// least every spanFlushInterval; up to spanQueue wait for the exporter
+// before more are dropped
+const (
+	spanBatch         = 512
+	spanFlushInterval = 2 * time.Second
+	spanQueue         = 8192
+	// defaultServiceName is the service.name of the exported spans
+	defaultServiceName = "cloud-event-tester"
+)
+
+// traceContext starts a trace for every send of a performance run: a new
+// trace ID and the ID of the span of the send, in the W3C traceparent header
+// and the tracestate of the run, so the delivery of an event can be followed
+// through the tracing backend of the consumer. In structured content mode the
+// event carries them as the attributes of the CloudEvents distributed
+// tracing extension as well; events that are not cloud events are sent as
+// the data of one, as in batches. Batches and binary events carry them in the
+// headers only. With an OTLP endpoint the span of every send is exported, so
+// the consumer's spans have a parent. It is safe for concurrent use.
+type traceContext struct {
+	state string
+	// attrs is true if the events carry the extension attributes
+	attrs bool
+	// body is the event sent when the events are not rendered
+	body  []byte
+	spans *spanExporter
+}
+
+// newTraceContext returns the trace context of a run sending body unless
+// its events are rendered, or nil if the run does not propagate one.
+func newTraceContext(cfg *Config, body []byte, rendered bool) (*traceContext, error) {
+	if !cfg.TraceContext {
+		return nil, nil
+	}
+	tc := &traceContext{
+		state: cfg.TraceState,
+		attrs: !cfg.isBinary() && cfg.BatchSize <= 1,
+	}
+	if tc.attrs && !rendered {
+		structured, err := events.ToStructuredEvent(body)
+		if err != nil {
+			return nil, fmt.Errorf("event cannot carry the trace context: %w", err)
+		}
+		tc.body = structured
+	}
+	where := "headers"
+	if tc.attrs {
+		where = "headers and event attributes"
+	}
+	log.Infof("Trace Context: a trace for every send in the %s and %s %s", traceparentHeader, tracestateHeader, where)
+	if cfg.OTLPEndpoint != "" {
+		tc.spans = newSpanExporter(cfg)
+	}
+	return tc, nil
+}
+
+// validateTraceContext checks the trace context settings of a run.
+func (c *Config) validateTraceContext() error {
+	switch {
+	case !c.TraceContext && (c.TraceState != "" || c.OTLPEndpoint != ""):
+		return fmt.Errorf("tracestate and the OTLP endpoint need -trace-context")
+	case !c.TraceContext:
+		return nil
+	case !c.IsPerf():
+		return fmt.Errorf("the trace context is propagated by performance runs only")
+	}
+	if c.TraceState != "" {
+		for _, member := range strings.Split(c.TraceState, ",") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(member), "="); !ok || k == "" || v == "" {
+				return fmt.Errorf("tracestate %q is not a list of key=value members", c.TraceState)
+			}
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("OTLP endpoint %q is not an http or https URL", c.OTLPEndpoint)
+		}
+	}
+	return nil
+}
+
+// next returns the traceparent of a new trace, sampled, whose parent is the
+// span of the send.
+func (tc *traceContext) next() string {
+	var ids [24]byte
+	for {
+		hi, lo, span := rand.Uint64(), rand.Uint64(), rand.Uint64()
+		if (hi != 0 || lo != 0) && span != 0 {
+			putUint64(ids[0:], hi)
+			putUint64(ids[8:], lo)
+			putUint64(ids[16:], span)
+			break
+		}
+	}
+	var b [55]byte
+	copy(b[:], "00-")
+	hex.Encode(b[3:35], ids[:16])
+	b[35] = '-'
+	hex.Encode(b[36:52], ids[16:])
+	copy(b[52:], "-01")
+	return string(b[:])
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := 7; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+// sender.SetHeaders sets the trace context headers of a request.
+func (tc *traceContext) setHeaders(h *fasthttp.RequestHeader, traceparent string) {
+	h.Set(traceparentHeader, traceparent)
+	if tc.state != "" {
+		h.Set(tracestateHeader, tc.state)
+	}
+}
+
+// stamp writes event, or the event of the run if it is nil, to buf with the
+// extension attributes of traceparent. They are added after the attributes
+// of the event, so they take the place of any it has.
+func (tc *traceContext) stamp(buf *bytes.Buffer, event []byte, traceparent string) ([]byte, error) {
+	if event == nil {
+		event = tc.body
+	} else {
+		structured, err := events.ToStructuredEvent(event)
+		if err != nil {
+			return nil, err
+		}
+		event = structured
+	}
+	end := bytes.LastIndexByte(event, '}')
+	if end < 0 {
+		return nil, fmt.Errorf("event is not a JSON object")
+	}
+	buf.Reset()
+	buf.Write(event[:end])
+	if t := bytes.TrimSpace(event[1:end]); len(t) > 0 {
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"` + traceparentHeader + `":"` + traceparent + `"`)
+	if tc.state != "" {
+		buf.WriteString(`,"` + tracestateHeader + `":`)
+		buf.Write(jsonString(tc.state))
+	}
+	buf.Write(event[end:])
+	return buf.Bytes(), nil
+}
+
+// record exports the span of a request sent at sent to target, answered
+// with status after latency or failed with errText, if spans are exported.
+func (tc *traceContext) record(sent time.Time, req *fasthttp.Request, target string, status int, latency time.Duration, errText string) {
+	if tc == nil || tc.spans == nil {
+		return
+	}
+	tc.spans.record(sent, req, target, status, latency, errText)
+}
+
+// close exports the spans still queued.
+func (tc *traceContext) close() {
+	if tc == nil || tc.spans == nil {
+		return
+	}
+	tc.spans.close()
+}
+
+// report adds the exported spans to a run result and logs them.
+func (tc *traceContext) report(result *report.Result) {
+	if tc == nil || tc.spans == nil {
+		return
+	}
+	tc.spans.report(result)
+}
+
+// otlp JSON encoding of spans, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID    string          `json:"traceId"`
+		SpanID     string          `json:"spanId"`
+		Name       string          `json:"name"`
+		Kind       int             `json:"kind"`
+		Start      string          `json:"startTimeUnixNano"`
+		End        string          `json:"endTimeUnixNano"`
+		Attributes []otlpAttribute `json:"attributes"`
+		Status     otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		String *string `json:"stringValue,omitempty"`
+		Int    *string `json:"intValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// span kind and status codes of OTLP
+const (
+	otlpKindClient  = 3
+	otlpStatusError = 2
+)
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{String: &value}}
+}
+
+func intAttr(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{Int: &s}}
+}
+
+// spanExporter posts the spans of the sends of a run to the traces endpoint
+// of an OTLP/HTTP collector in the JSON encoding, in batches from a goroutine
+// of its own. A span that finds the queue full is dropped rather than slow
+// down the run.
+type spanExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	queue    chan otlpSpan
+	done     sync.WaitGroup
+	closed   sync.Once
+
+	exported, dropped, failed int64
+}
+
+func newSpanExporter(cfg *Config) *spanExporter {
+	endpoint := strings.TrimRight(cfg.OTLPEndpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	service := cfg.OTLPServiceName
+	if service == "" {
+		service = defaultServiceName
+	}
+	e := &spanExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan otlpSpan, spanQueue),
+	}
+	e.done.Add(1)
+	go e.run()
+	log.Infof("Trace Context: exporting the span of every send to %s as %s", endpoint, service)
+	return e
+}
+
+func (e *spanExporter) record(sent time.Time, req *fasthttp.Request, target string, status int, latency time.Duration, errText string) {
+	traceparent := string(req.Header.Peek(traceparentHeader))
+	if len(traceparent) != 55 {
+		return
+	}
+	span := otlpSpan{
+		TraceID: traceparent[3:35],
+		SpanID:  traceparent[36:52],
+		Name:    "cloudevent send",
+		Kind:    otlpKindClient,
+		Start:   strconv.FormatInt(sent.UnixNano(), 10),
+		End:     strconv.FormatInt(sent.Add(latency).UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttr("http.request.method", string(req.Header.Method())),
+			stringAttr("url.full", target),
+		},
+	}
+	if id := requestEventID(req); id != "" {
+		span.Attributes = append(span.Attributes, stringAttr("cloudevents.event_id", id))
+	}
+	if status > 0 {
+		span.Attributes = append(span.Attributes, intAttr("http.response.status_code", status))
+	}
+	if errText != "" {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: errText}
+	}
+	select {
+	case e.queue <- span:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+// run posts the queued spans until the queue is closed.
+func (e *spanExporter) run() {
+	defer e.done.Done()
+	ticker := time.NewTicker(spanFlushInterval)
+	defer ticker.Stop()
+	batch := make([]otlpSpan, 0, spanBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.post(batch); err != nil {
+			if atomic.AddInt64(&e.failed, int64(len(batch))) == int64(len(batch)) {
+				log.Warnf("Trace Context: failed to export spans to %s: %v", e.endpoint, err)
+			}
+		} else {
+			atomic.AddInt64(&e.exported, int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, span); len(batch) == spanBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *spanExporter) post(spans []otlpSpan) error {
+	data, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{stringAttr("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: defaultServiceName}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer sender.DrainBody(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// close exports the queued spans and stops the exporter. No span may be
+// recorded after it.
+func (e *spanExporter) close() {
+	e.closed.Do(func() {
+		close(e.queue)
+		e.done.Wait()
+	})
+}
+
+func (e *spanExporter) report(result *report.Result) {
+	stats := &report.SpanStats{
+		Endpoint: e.endpoint,
+		Exported: int(atomic.LoadInt64(&e.exported)),
+		Dropped:  int(atomic.LoadInt64(&e.dropped)),
+		Failed:   int(atomic.LoadInt64(&e.failed)),
+	}
+	result.Spans = stats
+	log.Infof("Trace Context: %d spans exported to %s, %d dropped, %d failed", stats.Exported, stats.Endpoint, stats.Dropped, stats.Failed)
+}
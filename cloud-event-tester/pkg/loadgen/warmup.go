@@ -0,0 +1,62 @@
+package loadgen
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// warmUp opens connections to the targets before the measured phase of a
+// performance run, so its first seconds measure event handling rather than
+// connection and TLS setup. The n connections are split among the clients
+// of the shards or workers like the rate; each client opens its share to
+// every target.
+func warmUp(ctx context.Context, n int, clients []sender.Doer, targets []string, conns *int64) {
+	if n <= 0 {
+		return
+	}
+	start, before := time.Now(), atomic.LoadInt64(conns)
+	var wg sync.WaitGroup
+	failed := int64(0)
+	for i, n := range ShardRates(n, len(clients)) {
+		for _, target := range targets {
+			for j := 0; j < n; j++ {
+				wg.Add(1)
+				go func(client sender.Doer, target string) {
+					defer wg.Done()
+					if err := warmUpConn(ctx, client, target); err != nil {
+						log.Debugf("Warm-up request to %s failed: %v", target, err)
+						atomic.AddInt64(&failed, 1)
+					}
+				}(clients[i], target)
+			}
+		}
+	}
+	wg.Wait()
+	log.Infof("Warm-up opened %d connections in %v", atomic.LoadInt64(conns)-before, time.Since(start).Round(time.Millisecond))
+	if failed > 0 {
+		log.Warnf("%d warm-up requests failed", failed)
+	}
+}
+
+// warmUpConn sends a HEAD request to target, which carries no event, so the
+// client opens a connection and keeps it. The response is ignored; any
+// answer leaves the connection established.
+func warmUpConn(ctx context.Context, client sender.Doer, target string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	req.Header.SetMethod(fasthttp.MethodHead)
+	req.SetRequestURI(sender.TargetURI(target))
+	return client.Do(req, res)
+}
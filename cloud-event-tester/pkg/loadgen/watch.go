@@ -0,0 +1,213 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// watchPollInterval is how often watched event files are checked for changes.
+const watchPollInterval = 500 * time.Millisecond
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// isWatch reports whether a basic run watches its event files.
+func (c *Config) isWatch() bool {
+	return c.Watch || c.WatchDir
+}
+
+// watchTest sends the event files of a basic test once and then again every
+// time one changes on disk, showing the response right away, until ctx is
+// cancelled. Files added to the data directory are picked up as well. With
+// -watch-dir the files already in the data directory are not sent, only
+// those created or modified later, as a bridge from tooling that drops
+// event fixtures into a folder.
+func watchTest(ctx context.Context, cfg *Config, state *runState) (*report.Result, error) {
+	targets, err := resolveTargets(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	trec := newTargetRecorder(targets)
+	types := newEventTypeRecorder()
+	allAsserts, fileAsserts, err := cfg.assertions()
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := events.LoadSchemas(cfg.SchemaDir, cfg.Schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	sender.SetHeaders(req, cfg.Headers)
+	events.SetLabelHeaders(req, cfg.Labels)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	auth, err := sender.NewAuthenticator(&cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer auth.Close()
+	client := sender.WithAuth(state.client(cfg, nil), auth)
+	defer sender.CloseClient(client)
+
+	refresher, err := newEventRefresher(cfg, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var refreshed bytes.Buffer
+
+	health := NewRunHealth(ctx)
+	defer health.close()
+	health.setReady(true)
+	result := &report.Result{Mode: "watch", StartTime: time.Now()}
+	var seq int64
+	send := func(file string, event []byte) {
+		event, err := events.RenderEvent(filepath.Base(file), events.LabelEvent(event, cfg.Labels), &seq)
+		if err != nil {
+			log.Errorf("Failed to render %s: %v", filepath.Base(file), err)
+			return
+		}
+		if refresher != nil {
+			if err := refresher.refresh(&refreshed, event); err != nil {
+				log.Errorf("Failed to refresh %s: %v", filepath.Base(file), err)
+				return
+			}
+			event = refreshed.Bytes()
+		}
+		// the file is being edited, so a strict violation holds it back
+		// until it is fixed rather than ending the watch
+		if err := schemas.Check(filepath.Base(file), event); err != nil {
+			result.SchemaViolations++
+			if cfg.SchemaStrict {
+				log.Errorf("Not sending %s: %v", filepath.Base(file), err)
+				return
+			}
+			log.Warnf("%v", err)
+		}
+		target := targets[result.TotalMsg%len(targets)]
+		req.SetRequestURI(sender.TargetURI(target))
+		if err := events.SetEvent(req, event, cfg.contentMode()); err != nil {
+			log.WithFields(sendFields(filepath.Base(file), target, 0, 0)).Errorf("Failed to send %s: %v", filepath.Base(file), err)
+			return
+		}
+		result.TotalMsg++
+		start := time.Now()
+		if err := client.Do(req, res); err != nil {
+			trec.record(target, 0, true)
+			types.record(eventType(event), 0, true)
+			log.WithFields(sendFields(filepath.Base(file), target, 0, time.Since(start))).Errorf("Failed to send %s: %v", filepath.Base(file), err)
+			return
+		}
+		took := time.Since(start)
+		fields := sendFields(filepath.Base(file), target, res.StatusCode(), took)
+		log.WithFields(fields).Infof("Sent %s: status %d in %v", filepath.Base(file), res.StatusCode(), took.Round(time.Microsecond))
+		result.CountStatus(res.StatusCode(), 1)
+		if kind, reason := assertionFor(allAsserts, fileAsserts, filepath.Base(file)).check(res); kind != "" {
+			log.WithFields(fields).Errorf("Response assertion failed: %s", reason)
+			result.CountAssertion(kind)
+			trec.record(target, took, true)
+			types.record(eventType(event), took, true)
+		} else {
+			result.Succeeded++
+			trec.record(target, took, false)
+			types.record(eventType(event), took, false)
+		}
+		if body := res.Body(); len(body) > 0 {
+			log.Infof("Response body: %s", body)
+		}
+	}
+
+	switch {
+	case cfg.WatchDir:
+		log.Infof("Watching %s for new and modified event files, press Ctrl+C to stop", cfg.DataDir)
+	case cfg.EventFile != "":
+		log.Infof("Watching event file %s, press Ctrl+C to stop", cfg.EventFile)
+	default:
+		log.Infof("Watching event files in %s, press Ctrl+C to stop", cfg.DataDir)
+	}
+	versions := map[string]fileVersion{}
+	// held are the files of -watch-dir that were not valid JSON yet, at the
+	// version they had: they are sent once they are, or once they have not
+	// changed for a poll, so a file being written is not sent half-way
+	held := map[string]fileVersion{}
+	skip := cfg.WatchDir
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		files, err := EventFiles(cfg)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(files))
+		for _, file := range files {
+			seen[file] = true
+			info, err := os.Stat(file)
+			if err != nil {
+				// the file may be in the middle of being replaced by an editor
+				continue
+			}
+			v := fileVersion{modTime: info.ModTime(), size: info.Size()}
+			if old, ok := versions[file]; ok && old == v {
+				continue
+			}
+			if skip {
+				versions[file] = v
+				continue
+			}
+			event, err := events.ReadEventFile(file)
+			if err != nil {
+				log.Errorf("Failed to read file %s: %v", file, err)
+				versions[file] = v
+				continue
+			}
+			if cfg.WatchDir && !json.Valid(event) && held[file] != v {
+				held[file] = v
+				continue
+			}
+			delete(held, file)
+			versions[file] = v
+			send(file, event)
+		}
+		if skip {
+			log.Infof("Not sending the %d event files already in %s", len(versions), cfg.DataDir)
+			skip = false
+		}
+		for file := range versions {
+			if !seen[file] {
+				delete(versions, file)
+			}
+		}
+		for file := range held {
+			if !seen[file] {
+				delete(held, file)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			result.EndTime = time.Now()
+			trec.report(result)
+			types.report(result)
+			logStatusCodes(result.StatusCodes, allAsserts)
+			log.Infof("Watch stopped. Successfully sent %d/%d events", result.Succeeded, result.TotalMsg)
+			return result, nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package loadgen
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// drop policies of a full send queue
+const (
+	dropBlock = "block"
+	dropNew   = "drop-new"
+	dropOld   = "drop-old"
+)
+
+// sendJob is a message submitted to the worker pool. body, if not nil,
+// replaces the event of req, for rendered event templates, and eventType is
+// its type. scheduled, if
+// set, is when the message was due; its latency is measured from then, so
+// time spent queued counts, as in an open load model.
+type sendJob struct {
+	req *fasthttp.Request
+	// slot is the place of req among the requests of the shards
+	slot         requestSlot
+	target, peer string
+	body         []byte
+	eventType    string
+	scheduled    time.Time
+	// traceparent is the trace context of the message, if the run has one
+	traceparent string
+}
+
+// requestSlot is the place of a request among those of the send shards of
+// a run: the request of the target-th target of a shard, or of the backup
+// target if target is -1. The request of a slot changes when its shard is
+// retargeted, the slots stay.
+type requestSlot struct {
+	shard, target int
+}
+
+// pooledRequest is the copy a worker sends the requests of a slot with, and
+// the request it is a copy of.
+type pooledRequest struct {
+	src, req *fasthttp.Request
+}
+
+// sendPool sends the messages of MULTI_THREAD mode with a fixed number of
+// workers, one per client given; the workers share a client, or each has its
+// own with one connection to every target when the connections of a run are
+// limited. See connectionClients. Messages are submitted through a bounded queue. When the queue is
+// full, the drop policy decides: block waits for room, so a slow target slows
+// down the generator by a measured amount instead of piling up goroutines;
+// drop-new discards the new message and drop-old the oldest queued one, so
+// the schedule of the generator is kept.
+type sendPool struct {
+	jobs chan sendJob
+	fo   *failover
+	errs *sendErrors
+	// the per-target stats, nil for a single target
+	targets *targetRecorder
+	// the per-type stats, nil if the event is not rendered
+	types *eventTypeRecorder
+	// the adaptive rate control responses are reported to, nil if none
+	adapt *rateController
+	// the circuit breaker the results are reported to, nil if none
+	breaker *circuitBreaker
+	// the error budget the results are reported to, nil if none
+	budget *errorBudget
+	// the sampler of the requests, nil if none
+	sampler *requestSampler
+	// the progress summary of the run, nil if none
+	progress *progressSummary
+	// the StatsD emitter of the run, nil if none
+	statsd *statsdEmitter
+	// the latency of the current second, nil if not recorded
+	tickLatency *report.SharedHistogram
+	// the latency the dashboard shows, nil if none, see liveLatency
+	live *report.SharedHistogram
+	// the latency saved in the checkpoints, nil if none
+	cpLatency *report.SharedHistogram
+	// the trace file of the run, nil if none
+	tracer *requestTracer
+	// the trace context of the run, nil if none
+	traceCtx *traceContext
+	// the capture of the failed responses, nil if none
+	capture *responseCapture
+	// event is the name of the event file or generator, for the logs
+	event string
+	// the assertion responses are checked with, nil if they are not
+	assert *responseAssertion
+	wg     sync.WaitGroup
+	// content mode of the replaced events
+	mode events.ContentMode
+
+	failed int64
+	// latencies recorded by each worker
+	latencies []*hdrhistogram.Histogram
+	// only touched by the submitting goroutine
+	stats   report.PoolStats
+	blocked time.Duration
+}
+
+func newSendPool(clients []sender.Doer, queueSize int, dropPolicy string, mode events.ContentMode, fo *failover, targets *targetRecorder, errs *sendErrors, assert *responseAssertion) *sendPool {
+	p := &sendPool{
+		jobs:    make(chan sendJob, queueSize),
+		fo:      fo,
+		targets: targets,
+		errs:    errs,
+		assert:  assert,
+		mode:    mode,
+		stats:   report.PoolStats{Workers: len(clients), QueueSize: queueSize, DropPolicy: dropPolicy},
+	}
+	for i, client := range clients {
+		p.latencies = append(p.latencies, report.NewLatencyHistogram())
+		p.wg.Add(1)
+		go p.work(client, p.latencies[i])
+	}
+	return p
+}
+
+// NewHTTPClient returns the client the events of cfg are sent with: the
+// client of its sender options, with its Host header and signature. The
+// Kafka and WebSocket clients are returned as they are.
+func NewHTTPClient(cfg *Config, conns *int64) sender.Doer {
+	return new(runState).client(cfg, conns)
+}
+
+// client returns the client of NewHTTPClient that also sends the sampled
+// requests of the latency breakdown, the streamed bodies and the duplicates
+// of the run.
+func (s *runState) client(cfg *Config, conns *int64) sender.Doer {
+	client := sender.NewClient(&cfg.Options, conns)
+	switch strings.ToLower(cfg.Transport) {
+	case sender.TransportKafka, sender.TransportWebSocket:
+		return client
+	}
+	return s.duplicates.resend(sender.WithSignature(sender.WithHostHeader(s.bodies.stream(s.breakdown.sample(client)), &cfg.Options), &cfg.Options))
+}
+
+// connectionClients returns a client per connection of a run, each limited to
+// one connection to every target. A worker sending with its own client keeps
+// its connection, so the run holds exactly that many open.
+func connectionClients(cfg *Config, state *runState, auth *sender.Authenticator, conns *int64) []sender.Doer {
+	single := cfg.Clone()
+	single.MaxConnsPerHost = 1
+	clients := make([]sender.Doer, cfg.Connections)
+	for i := range clients {
+		clients[i] = sender.WithAuth(state.client(&single, conns), auth)
+	}
+	return clients
+}
+
+// work sends the submitted messages. fasthttp requests and responses must not
+// be shared between goroutines, so each worker sends its own copies of the
+// submitted requests, one per slot, so the copies do not pile up as the
+// shards are retargeted. A copy is made again only when the request of its
+// slot changed.
+func (p *sendPool) work(client sender.Doer, latency *hdrhistogram.Histogram) {
+	defer p.wg.Done()
+	copies := map[requestSlot]*pooledRequest{}
+	defer func() {
+		for _, c := range copies {
+			fasthttp.ReleaseRequest(c.req)
+		}
+	}()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+	for job := range p.jobs {
+		c, ok := copies[job.slot]
+		if !ok {
+			c = &pooledRequest{req: fasthttp.AcquireRequest()}
+			copies[job.slot] = c
+		}
+		if c.src != job.req {
+			job.req.CopyTo(c.req)
+			c.src = job.req
+		}
+		req := c.req
+		if job.body != nil {
+			if err := events.SetEvent(req, job.body, p.mode); err != nil {
+				log.Errorf("Failed to render event: %v", err)
+				atomic.AddInt64(&p.failed, 1)
+				continue
+			}
+		}
+		if job.traceparent != "" {
+			p.traceCtx.setHeaders(&req.Header, job.traceparent)
+		}
+		start := job.scheduled
+		if start.IsZero() {
+			start = time.Now()
+		}
+		err := client.Do(req, res)
+		p.fo.record(job.target, job.peer, err)
+		if err == nil {
+			p.adapt.observe(res)
+			p.errs.recordStatus(res.StatusCode())
+		}
+		if err != nil {
+			log.WithFields(sendFields(p.event, job.target, 0, time.Since(start))).Errorf("Sending error: %v", err)
+			p.errs.record(err)
+			atomic.AddInt64(&p.failed, 1)
+			p.targets.record(job.target, 0, true)
+			p.types.record(job.eventType, 0, true)
+			p.breaker.record(true)
+			p.budget.record(true, err.Error())
+			p.sampler.record(start, job.target, 0, time.Since(start), err)
+			p.tracer.record(start, req, job.target, 0, time.Since(start), err.Error())
+			p.traceCtx.record(start, req, job.target, 0, time.Since(start), err.Error())
+		} else if kind, reason := p.check(res); kind != "" {
+			p.errs.recordAssertion(kind, reason)
+			atomic.AddInt64(&p.failed, 1)
+			p.targets.record(job.target, 0, true)
+			p.types.record(job.eventType, 0, true)
+			p.breaker.record(true)
+			p.budget.record(true, reason)
+			p.sampler.record(start, job.target, res.StatusCode(), time.Since(start), nil)
+			p.tracer.record(start, req, job.target, res.StatusCode(), time.Since(start), kind+": "+reason)
+			p.traceCtx.record(start, req, job.target, res.StatusCode(), time.Since(start), kind+": "+reason)
+			p.capture.record(start, req, res, job.target, reason)
+		} else {
+			p.breaker.record(false)
+			p.budget.record(false, "")
+			took := time.Since(start)
+			p.sampler.record(start, job.target, res.StatusCode(), took, nil)
+			p.tracer.record(start, req, job.target, res.StatusCode(), took, "")
+			p.traceCtx.record(start, req, job.target, res.StatusCode(), took, "")
+			report.RecordLatency(latency, took)
+			p.live.Record(took)
+			p.progress.record(took)
+			p.statsd.record(took)
+			p.tickLatency.Record(took)
+			p.cpLatency.Record(took)
+			p.targets.record(job.target, took, false)
+			p.types.record(job.eventType, took, false)
+		}
+	}
+}
+
+// check checks a response with the assertion of the pool.
+func (p *sendPool) check(res *fasthttp.Response) (kind, reason string) {
+	if p.assert == nil {
+		return "", ""
+	}
+	return p.assert.check(res)
+}
+
+// submit queues a message, applying the drop policy while the queue is full.
+// It returns false if the message was dropped or stop is closed before it
+// could be queued.
+func (p *sendPool) submit(stop <-chan struct{}, job sendJob) bool {
+	select {
+	case p.jobs <- job:
+	default:
+		switch p.stats.DropPolicy {
+		case dropNew:
+			p.stats.Dropped++
+			return false
+		case dropOld:
+			for {
+				select {
+				case <-p.jobs:
+					p.stats.Dropped++
+				default:
+				}
+				select {
+				case p.jobs <- job:
+					p.stats.MaxQueueDepth = p.stats.QueueSize
+					return true
+				default:
+				}
+			}
+		}
+		p.stats.BlockedSends++
+		start := time.Now()
+		select {
+		case p.jobs <- job:
+			p.blocked += time.Since(start)
+		case <-stop:
+			p.blocked += time.Since(start)
+			return false
+		}
+	}
+	if depth := len(p.jobs); depth > p.stats.MaxQueueDepth {
+		p.stats.MaxQueueDepth = depth
+	}
+	return true
+}
+
+// depth returns the number of queued messages.
+func (p *sendPool) depth() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.jobs)
+}
+
+// close waits for the queued messages to be sent and returns the number of
+// submitted messages that were not sent: failed sends and messages dropped
+// from the queue by drop-old.
+func (p *sendPool) close() int {
+	close(p.jobs)
+	p.wg.Wait()
+	p.stats.Failed = int(atomic.LoadInt64(&p.failed))
+	unsent := p.stats.Failed
+	if p.stats.DropPolicy == dropOld {
+		unsent += p.stats.Dropped
+	}
+	return unsent
+}
+
+// mergeLatency adds the latencies recorded by the workers to h. It must only
+// be called after close.
+func (p *sendPool) mergeLatency(h *hdrhistogram.Histogram) {
+	if p == nil {
+		return
+	}
+	for _, l := range p.latencies {
+		h.Merge(l)
+	}
+}
+
+// report adds the pool stats to the result of the run.
+func (p *sendPool) report(result *report.Result) {
+	if p == nil {
+		return
+	}
+	stats := p.stats
+	stats.BlockedSecs = p.blocked.Seconds()
+	result.Pool = &stats
+	if stats.Dropped > 0 {
+		log.Warnf("Send queue was full, generator dropped %d messages (%s), %d sends failed at the target",
+			stats.Dropped, stats.DropPolicy, stats.Failed)
+	}
+	if stats.BlockedSends > 0 {
+		log.Warnf("Send queue was full %d times, generator blocked for %.2f s (max queue depth %d of %d)",
+			stats.BlockedSends, stats.BlockedSecs, stats.MaxQueueDepth, stats.QueueSize)
+	} else {
+		log.Infof("Max send queue depth: %d of %d", stats.MaxQueueDepth, stats.QueueSize)
+	}
+}
@@ -0,0 +1,15 @@
+package report
+
+// AdaptiveStats summarize the adaptive rate control of a run. Throttled are
+// the 429 and 503 responses, RetryAfter the holds of all sends until the
+// Retry-After of a response, Held the sends held back by a lowered rate or a
+// Retry-After and Decreases the seconds the rate was lowered. The rate of
+// every second is in the timeline of the run.
+type AdaptiveStats struct {
+	Throttled  int `json:"throttled"`
+	RetryAfter int `json:"retryAfter,omitempty"`
+	Held       int `json:"held"`
+	Decreases  int `json:"decreases"`
+	LowestRate int `json:"lowestRate"`
+	FinalRate  int `json:"finalRate"`
+}
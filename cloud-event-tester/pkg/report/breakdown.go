@@ -0,0 +1,36 @@
+package report
+
+// BreakdownStats summarize the latency breakdown of a run: the phases of the
+// sampled requests, in milliseconds. TTFB is the wait from the request
+// written to the first byte of the response, the time the consumer took to
+// handle it plus a round trip; Total is the whole request, connection
+// included.
+type BreakdownStats struct {
+	Every   int           `json:"every"`
+	Samples int64         `json:"samples"`
+	Failed  int64         `json:"failed,omitempty"`
+	DNS     *LatencyStats `json:"dns,omitempty"`
+	Connect *LatencyStats `json:"connect,omitempty"`
+	TLS     *LatencyStats `json:"tls,omitempty"`
+	TTFB    *LatencyStats `json:"ttfb,omitempty"`
+	Total   *LatencyStats `json:"total,omitempty"`
+}
+
+// BreakdownPhase is a phase of a latency breakdown with its stats.
+type BreakdownPhase struct {
+	Name  string
+	Stats *LatencyStats
+}
+
+// Phases returns the timed phases of the breakdown, in the order of a
+// request; a phase that did not happen, like the DNS lookup of an address
+// or the handshake of plain HTTP, is left out.
+func (s *BreakdownStats) Phases() []BreakdownPhase {
+	var phases []BreakdownPhase
+	for _, p := range []BreakdownPhase{{"dns", s.DNS}, {"connect", s.Connect}, {"tls", s.TLS}, {"ttfb", s.TTFB}, {"total", s.Total}} {
+		if p.Stats != nil {
+			phases = append(phases, p)
+		}
+	}
+	return phases
+}
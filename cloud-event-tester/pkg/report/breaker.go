@@ -0,0 +1,19 @@
+package report
+
+// BreakerTransition is a change of state of the circuit breaker, At seconds
+// into the run.
+type BreakerTransition struct {
+	At     float64 `json:"at"`
+	State  string  `json:"state"`
+	Reason string  `json:"reason"`
+}
+
+// BreakerStats summarize the circuit breaker of a run. Held are the sends
+// not sent while it was open, OpenSeconds the time it was open or probing.
+type BreakerStats struct {
+	Opens       int                 `json:"opens"`
+	Probes      int                 `json:"probes"`
+	Held        int                 `json:"held"`
+	OpenSeconds float64             `json:"openSeconds"`
+	Transitions []BreakerTransition `json:"transitions,omitempty"`
+}
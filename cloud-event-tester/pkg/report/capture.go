@@ -0,0 +1,9 @@
+package report
+
+// CaptureStats are the failed responses of a run written to its capture
+// directory, and those left out once the most were captured.
+type CaptureStats struct {
+	Dir      string `json:"dir,omitempty"`
+	Captured int    `json:"captured"`
+	Skipped  int    `json:"skipped,omitempty"`
+}
@@ -0,0 +1,11 @@
+package report
+
+// ChaosStats tally the network faults injected into a run.
+type ChaosStats struct {
+	Delayed   int `json:"delayed,omitempty"`
+	Aborted   int `json:"aborted,omitempty"`
+	Truncated int `json:"truncated,omitempty"`
+	// Pauses are the pause windows, Paused the sends not sent in them
+	Pauses int `json:"pauses,omitempty"`
+	Paused int `json:"paused,omitempty"`
+}
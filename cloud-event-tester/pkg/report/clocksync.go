@@ -0,0 +1,13 @@
+package report
+
+// ClockSyncStats summarize the clock sync of a run: the offset of the clock
+// of the receiver from that of the sender when it was last estimated, and
+// the error bound of the estimate, half the round trip it was taken from,
+// in milliseconds.
+type ClockSyncStats struct {
+	URL      string  `json:"url"`
+	OffsetMs float64 `json:"offsetMs"`
+	ErrorMs  float64 `json:"errorMs"`
+	Syncs    int64   `json:"syncs"`
+	Failed   int64   `json:"failed,omitempty"`
+}
@@ -0,0 +1,17 @@
+package report
+
+// DuplicateStats summarize the copies of the events of a run. Unique are the
+// events that were accepted by the consumer at least once, the original or
+// a copy, and Processed the events the consumer reported it processed, when
+// it was asked: a consumer that collapses duplicates processed every unique
+// event once.
+type DuplicateStats struct {
+	Copies    int    `json:"copies"`
+	Events    int64  `json:"events"`
+	Unique    int64  `json:"unique"`
+	Sent      int64  `json:"sent"`
+	Accepted  int64  `json:"accepted"`
+	Rejected  int64  `json:"rejected"`
+	Failed    int64  `json:"failed"`
+	Processed *int64 `json:"processed,omitempty"`
+}
@@ -0,0 +1,15 @@
+package report
+
+// ErrorBudgetStats summarize the error budget of a run. Failed are the
+// failed sends it counted, LongestStreak the most in a row; Reason tells
+// why it stopped the run, At seconds into it, empty if it did not.
+type ErrorBudgetStats struct {
+	MaxErrors            int     `json:"maxErrors,omitempty"`
+	MaxConsecutiveErrors int     `json:"maxConsecutiveErrors,omitempty"`
+	Failed               int     `json:"failed"`
+	LongestStreak        int     `json:"longestStreak"`
+	Reason               string  `json:"reason,omitempty"`
+	At                   float64 `json:"at,omitempty"`
+	// LastFailure is the failure that exhausted the budget
+	LastFailure string `json:"lastFailure,omitempty"`
+}
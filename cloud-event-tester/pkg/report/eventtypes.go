@@ -0,0 +1,10 @@
+package report
+
+// EventTypeStats counts the sends of the events of one type, see eventType.
+type EventTypeStats struct {
+	Type   string `json:"type"`
+	Sent   int    `json:"sent"`
+	Errors int    `json:"errors"`
+	// Latency of the successful sends
+	Latency *LatencyStats `json:"latency,omitempty"`
+}
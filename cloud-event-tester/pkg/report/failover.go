@@ -0,0 +1,22 @@
+package report
+
+import (
+	"time"
+)
+
+// TargetStats counts the requests sent to one target.
+type TargetStats struct {
+	URL    string `json:"url"`
+	Sent   int    `json:"sent"`
+	Errors int    `json:"errors"`
+	// Latency of the successful sends, for runs with several targets, see
+	// targetRecorder
+	Latency *LatencyStats `json:"latency,omitempty"`
+}
+
+// FailoverEvent records a switch between the primary and backup targets.
+type FailoverEvent struct {
+	Time time.Time `json:"time"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
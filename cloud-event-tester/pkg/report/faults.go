@@ -0,0 +1,15 @@
+package report
+
+// FaultStats are the responses of the consumer to the broken events of one
+// fault class. A consumer that handles them well rejects every one with a
+// client error, or closes the connection of an oversized event, which fails
+// the send; a server error means it did not. A consumer that crashed fails
+// the sends of the events after.
+type FaultStats struct {
+	Class        string `json:"class"`
+	Sent         int    `json:"sent"`
+	Rejected     int    `json:"rejected"`
+	Accepted     int    `json:"accepted,omitempty"`
+	ServerErrors int    `json:"serverErrors,omitempty"`
+	Failed       int    `json:"failed,omitempty"`
+}
@@ -0,0 +1,20 @@
+package report
+
+// FindMaxProbe is one probe of a throughput search: a run at one rate and
+// whether it met the SLA thresholds.
+type FindMaxProbe struct {
+	Rate      int           `json:"rate"`
+	TotalMsg  int           `json:"totalMsg"`
+	AvgRate   float64       `json:"avgRate"`
+	ErrorRate float64       `json:"errorRate"`
+	Latency   *LatencyStats `json:"latency,omitempty"`
+	Passed    bool          `json:"passed"`
+}
+
+// FindMaxStats are the outcome of a throughput search: the highest rate
+// probed that met the SLA thresholds, 0 if none did, and the probes.
+type FindMaxStats struct {
+	Strategy string         `json:"strategy"`
+	Capacity int            `json:"capacity"`
+	Probes   []FindMaxProbe `json:"probes"`
+}
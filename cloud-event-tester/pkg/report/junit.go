@@ -0,0 +1,63 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// CheckResult is the outcome of one check of a run: an event file of a basic
+// run, a check of a performance run or an SLA threshold, see loadgen.SLAChecks.
+// Checks become the test cases of the JUnit report.
+type CheckResult struct {
+	Name    string  `json:"name"`
+	Passed  bool    `json:"passed"`
+	Message string  `json:"message,omitempty"`
+	Seconds float64 `json:"seconds,omitempty"`
+	SLA     bool    `json:"sla,omitempty"`
+}
+
+// Fail returns c failed with a message.
+func (c CheckResult) Fail(format string, args ...interface{}) CheckResult {
+	c.Passed = false
+	c.Message = fmt.Sprintf(format, args...)
+	return c
+}
+
+// PassIf returns c passed if ok, else failed with a message.
+func (c CheckResult) PassIf(ok bool, format string, args ...interface{}) CheckResult {
+	c.Passed = ok
+	c.Message = fmt.Sprintf(format, args...)
+	return c
+}
+
+// JUnitTestSuites is the root element of a JUnit XML report.
+type JUnitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []JUnitTestSuite `xml:"testsuite"`
+}
+
+// JUnitTestSuite is a testsuite of a JUnit XML file.
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Hostname  string          `xml:"hostname,attr,omitempty"`
+	Cases     []JUnitTestCase `xml:"testcase"`
+}
+
+// JUnitTestCase is a testcase of a JUnit XML file.
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// JUnitFailure is the failure of a JUnit testcase.
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
@@ -0,0 +1,141 @@
+package report
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Latencies are recorded in microseconds, from 1µs to a minute, with three
+// significant digits. Longer latencies are recorded as a minute.
+const (
+	latencyUnit   = time.Microsecond
+	latencyMax    = int64(time.Minute / latencyUnit)
+	latencyDigits = 3
+)
+
+// LatencyStats summarizes the response latencies of a run, in milliseconds.
+// Only successful sends are recorded.
+type LatencyStats struct {
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p999"`
+}
+
+// NewLatencyHistogram returns an empty histogram. Histograms are not safe for
+// concurrent use, so every sending goroutine records into its own and they
+// are merged at the end of the run.
+func NewLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(1, latencyMax, latencyDigits)
+}
+
+// RecordLatency records the latency of one send.
+func RecordLatency(h *hdrhistogram.Histogram, d time.Duration) {
+	v := int64(d / latencyUnit)
+	if v > latencyMax {
+		v = latencyMax
+	}
+	h.RecordValue(v) //nolint: errcheck
+}
+
+// SummarizeLatency returns the stats of h, nil if nothing was recorded.
+func SummarizeLatency(h *hdrhistogram.Histogram) *LatencyStats {
+	if h.TotalCount() == 0 {
+		return nil
+	}
+	ms := func(v float64) float64 {
+		return v * float64(latencyUnit) / float64(time.Millisecond)
+	}
+	at := func(p float64) float64 {
+		return ms(float64(h.ValueAtPercentile(p)))
+	}
+	return &LatencyStats{
+		Count:  h.TotalCount(),
+		Min:    ms(float64(h.Min())),
+		Max:    ms(float64(h.Max())),
+		Mean:   ms(h.Mean()),
+		StdDev: ms(h.StdDev()),
+		P50:    at(50),
+		P90:    at(90),
+		P95:    at(95),
+		P99:    at(99),
+		P999:   at(99.9),
+	}
+}
+
+// Log logs the latency percentiles.
+func (l *LatencyStats) Log() {
+	if l == nil {
+		return
+	}
+	log.Infof("Latency (ms) of %d sends: min %.3f p50 %.3f p90 %.3f p95 %.3f p99 %.3f p99.9 %.3f max %.3f",
+		l.Count, l.Min, l.P50, l.P90, l.P95, l.P99, l.P999, l.Max)
+	log.Infof("Latency (ms) mean %.3f stddev %.3f", l.Mean, l.StdDev)
+}
+
+// SharedHistogram is a latency histogram that is safe for concurrent use. A
+// nil histogram records nothing.
+type SharedHistogram struct {
+	mu sync.Mutex
+	h  *hdrhistogram.Histogram
+}
+
+// NewSharedHistogram returns a shared histogram recording into h, into a new
+// latency histogram if h is nil.
+func NewSharedHistogram(h *hdrhistogram.Histogram) *SharedHistogram {
+	if h == nil {
+		h = NewLatencyHistogram()
+	}
+	return &SharedHistogram{h: h}
+}
+
+// Record records a latency.
+func (s *SharedHistogram) Record(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	RecordLatency(s.h, d)
+	s.mu.Unlock()
+}
+
+// Stats returns the percentiles of the recorded latencies.
+func (s *SharedHistogram) Stats() *LatencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SummarizeLatency(s.h)
+}
+
+// Export returns a copy of the histogram, nil for a nil histogram.
+func (s *SharedHistogram) Export() *hdrhistogram.Snapshot {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Export()
+}
+
+// Histogram returns the histogram, to be read once nothing records into it
+// anymore.
+func (s *SharedHistogram) Histogram() *hdrhistogram.Histogram {
+	return s.h
+}
+
+// Drain returns the stats of the latencies recorded since the last drain.
+func (s *SharedHistogram) Drain() *LatencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := SummarizeLatency(s.h)
+	s.h.Reset()
+	return stats
+}
@@ -0,0 +1,7 @@
+package report
+
+// MutationStats are the events of a run a mutation rule changed.
+type MutationStats struct {
+	Rule    string `json:"rule"`
+	Applied int    `json:"applied"`
+}
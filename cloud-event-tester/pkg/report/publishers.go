@@ -0,0 +1,14 @@
+package report
+
+// PublisherStats are the counters of one virtual publisher. Sent counts the
+// sends, failed or not, Last its last sequence number, and MeanLatency the
+// mean latency of the successful sends in milliseconds.
+type PublisherStats struct {
+	Publisher   int     `json:"publisher"`
+	Source      string  `json:"source"`
+	IDPrefix    string  `json:"idPrefix"`
+	Sent        int     `json:"sent"`
+	Errors      int     `json:"errors"`
+	Last        int64   `json:"last,omitempty"`
+	MeanLatency float64 `json:"meanLatencyMs,omitempty"`
+}
@@ -0,0 +1,221 @@
+// Package report holds the results of the runs of cloud-event-tester: the
+// Result of a run, its latency percentiles and the stats of the features it
+// used, as they are written to the report files and the results database.
+package report
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Result summarizes a finished test run.
+type Result struct {
+	Mode      string    `json:"mode"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// TotalSeconds is the wall-clock time the events were sent in, measured
+	// on the monotonic clock
+	TotalSeconds float64 `json:"totalSeconds,omitempty"`
+	TotalMsg     int     `json:"totalMsg"`
+	AvgRate      float64 `json:"avgRate,omitempty"`
+	// RequestedRate is the average rate the pacing aimed at, see
+	// loadgen.RequestedRate
+	RequestedRate float64 `json:"requestedRate,omitempty"`
+	// DueMsg is the number of messages due at the requested rate in the
+	// time the run sent, see dueSends, and OverDelivered the messages it
+	// sent on top of them
+	DueMsg        int  `json:"dueMsg,omitempty"`
+	OverDelivered int  `json:"overDelivered,omitempty"`
+	Succeeded     int  `json:"succeeded,omitempty"`
+	Files         int  `json:"files,omitempty"`
+	Interrupted   bool `json:"interrupted,omitempty"`
+	Resumed       bool `json:"resumed,omitempty"`
+	// Segments is the number of times a resumed run was started
+	Segments int `json:"segments,omitempty"`
+	// ErrorRate is the percentage of failed sends, or of failed event files
+	// of a basic run
+	ErrorRate float64 `json:"errorRate,omitempty"`
+
+	Labels    map[string]string `json:"labels,omitempty"`
+	ReportURL string            `json:"reportUrl,omitempty"`
+
+	Targets   []TargetStats   `json:"targets,omitempty"`
+	Failovers []FailoverEvent `json:"failovers,omitempty"`
+	Pool      *PoolStats      `json:"pool,omitempty"`
+	Shards    []ShardStats    `json:"shards,omitempty"`
+	// Publishers are the sends of every publisher a run simulated, see
+	// publisher
+	Publishers []PublisherStats `json:"publishers,omitempty"`
+	// Spans are the spans of the sends exported to an OTLP collector, see
+	// traceContext
+	Spans *SpanStats `json:"spans,omitempty"`
+	// EventTypes are the sends by CloudEvents type of a run that sent
+	// several, see eventTypeRecorder
+	EventTypes []EventTypeStats `json:"eventTypes,omitempty"`
+	// BatchSize is the number of events of every message of a batched run;
+	// Events and EventRate count the events, TotalMsg and AvgRate the
+	// requests
+	BatchSize int     `json:"batchSize,omitempty"`
+	Events    int     `json:"events,omitempty"`
+	EventRate float64 `json:"eventRate,omitempty"`
+	// Faults are the responses to the broken events of a run, by fault
+	// class, see faultInjector
+	Faults []FaultStats `json:"faults,omitempty"`
+	// Chaos are the network faults injected, see chaosMonkey
+	Chaos *ChaosStats `json:"chaos,omitempty"`
+	// Adaptive is the adaptive rate control of a performance run, see
+	// rateController
+	Adaptive *AdaptiveStats `json:"adaptiveRate,omitempty"`
+	// Breaker is the circuit breaker of a performance run, see
+	// circuitBreaker
+	Breaker *BreakerStats `json:"breaker,omitempty"`
+	// ErrorBudget is the error budget of a performance run, see errorBudget
+	ErrorBudget *ErrorBudgetStats `json:"errorBudget,omitempty"`
+	// LatencyBreakdown are the phases of the sampled requests, see
+	// latencyBreakdown
+	LatencyBreakdown *BreakdownStats `json:"latencyBreakdown,omitempty"`
+	// Duplicates are the copies of the events sent to test the idempotency
+	// of the consumer, see duplicator
+	Duplicates *DuplicateStats `json:"duplicates,omitempty"`
+	// Mutations are the events every mutation rule applied on every send
+	// changed
+	Mutations []MutationStats `json:"mutations,omitempty"`
+	// Captures are the failed responses written to the capture directory,
+	// see responseCapture
+	Captures *CaptureStats `json:"captures,omitempty"`
+	// FindMax is the throughput search the run was the probe at the
+	// capacity of, see findMaxRate
+	FindMax *FindMaxStats `json:"findMax,omitempty"`
+	// TargetHealth are the polls of the health endpoint of the target of a
+	// performance run, see healthPoller
+	TargetHealth *TargetHealthStats `json:"targetHealth,omitempty"`
+	// Soak is the self monitoring of a soak run, see soakMonitor
+	Soak *SoakReport `json:"soak,omitempty"`
+	// Sequence identifies the numbered events of a run, see eventStamper
+	Sequence *SequenceStats `json:"sequence,omitempty"`
+	// ClockSync is the clock offset of the receiver the send times were
+	// corrected by, see clockSync
+	ClockSync *ClockSyncStats `json:"clockSync,omitempty"`
+	// Connections opened to the targets, see loadgen.NewHTTPClient, and
+	// ConnectionReuse the percentage of the messages sent on a connection
+	// that was already open
+	Connections     int     `json:"connections,omitempty"`
+	ConnectionReuse float64 `json:"connectionReuse,omitempty"`
+	// Skipped are the messages not sent because the send loop fell too far
+	// behind, see pacer
+	Skipped int           `json:"skipped,omitempty"`
+	Latency *LatencyStats `json:"latency,omitempty"`
+	// LoadModel is the explicit load model of a performance run, see perfTest
+	LoadModel string `json:"loadModel,omitempty"`
+	// Errors are the failed sends of a performance run by kind, see
+	// errorKind
+	Errors map[string]int `json:"errors,omitempty"`
+	// AssertionFailures are the responses that failed an assertion, by
+	// kind, see responseAssertion
+	AssertionFailures map[string]int `json:"assertionFailures,omitempty"`
+	// StatusCodes are the responses by status code, whether or not they
+	// count as success, see -expect-status
+	StatusCodes map[string]int `json:"statusCodes,omitempty"`
+	// SchemaViolations are the events whose data violated its schema, see
+	// events.Schemas
+	SchemaViolations int `json:"schemaViolations,omitempty"`
+	// Timeline are the stats of every second of a performance run
+	Timeline []TickStats `json:"timeline,omitempty"`
+	// Checks are the outcomes of the event files of a basic run or the checks
+	// of a performance run, see loadgen.WriteJUnitFile
+	Checks []CheckResult `json:"checks,omitempty"`
+
+	// LatencyHistogram is the histogram of a performance run, for the coordinator of
+	// a distributed run
+	LatencyHistogram *hdrhistogram.Histogram `json:"-"`
+	// Samples are the sampled requests of a performance run, for the
+	// results database
+	Samples []RequestSample `json:"-"`
+}
+
+// CountConnections sets the connections opened to the targets of a run and
+// the share of its messages sent on a connection that was already open, and
+// logs them. A run with noKeepAlive opens a connection per message on
+// purpose.
+func (r *Result) CountConnections(opened int64, noKeepAlive bool) {
+	r.Connections = int(opened)
+	log.Infof("Connections opened: %d", r.Connections)
+	if r.TotalMsg > 0 && r.Connections > 0 && r.Connections < r.TotalMsg {
+		r.ConnectionReuse = 100 * float64(r.TotalMsg-r.Connections) / float64(r.TotalMsg)
+	}
+	if r.TotalMsg > 0 {
+		log.Infof("Connection reuse: %.1f%% of the messages sent on an open connection", r.ConnectionReuse)
+	}
+	// a healthy target keeps connections alive; a new one for most messages
+	// means the rate is bound by connection setup, or the responses are not
+	// read to the end
+	if !noKeepAlive && r.TotalMsg >= 100 && r.Connections > r.TotalMsg/2 {
+		log.Warnf("%d connections for %d messages, the target does not keep connections alive", r.Connections, r.TotalMsg)
+	}
+}
+
+// FailedSends returns the sends of a performance run that failed or whose
+// response failed an assertion.
+func (r *Result) FailedSends() int {
+	failed := 0
+	for _, n := range r.Errors {
+		failed += n
+	}
+	for _, n := range r.AssertionFailures {
+		failed += n
+	}
+	return failed
+}
+
+// CountAssertion counts a response that failed an assertion of the given
+// kind, for the runs that send from one goroutine.
+func (r *Result) CountAssertion(kind string) {
+	if r.AssertionFailures == nil {
+		r.AssertionFailures = map[string]int{}
+	}
+	r.AssertionFailures[kind]++
+}
+
+// CountStatus counts n responses with status code.
+func (r *Result) CountStatus(code, n int) {
+	if r.StatusCodes == nil {
+		r.StatusCodes = map[string]int{}
+	}
+	r.StatusCodes[strconv.Itoa(code)] += n
+}
+
+// TickStats are the counters of one second of a performance run.
+// TickStats are the counters of one second of a performance run.
+type TickStats struct {
+	Second     int    `json:"second"`
+	Sent       uint64 `json:"sent"`
+	TotalMsg   int    `json:"totalMsg"`
+	QueueDepth int    `json:"queueDepth,omitempty"`
+	Errors     int    `json:"errors,omitempty"`
+	// Rate is the rate adaptive rate control allowed in the second, or the
+	// rate set through the control API of the daemon
+	Rate int `json:"rate,omitempty"`
+	// latency percentiles of the successful sends of the second in
+	// milliseconds, for the reports that chart them, see newTickLatency
+	P50 float64 `json:"p50,omitempty"`
+	P90 float64 `json:"p90,omitempty"`
+	P95 float64 `json:"p95,omitempty"`
+	P99 float64 `json:"p99,omitempty"`
+	Max float64 `json:"max,omitempty"`
+}
+
+// AchievedRate returns the messages a performance run sent in percent of
+// those due at the requested rate, or its average rate in percent of the
+// requested rate if it has no count of the messages due.
+func (r *Result) AchievedRate() float64 {
+	switch {
+	case r.DueMsg > 0:
+		return 100 * float64(r.TotalMsg) / float64(r.DueMsg)
+	case r.RequestedRate > 0:
+		return 100 * r.AvgRate / r.RequestedRate
+	}
+	return 0
+}
@@ -0,0 +1,15 @@
+package report
+
+import (
+	"time"
+)
+
+// RequestSample is a request of a run kept for the results database. Status
+// is 0 for a send that failed with Error.
+type RequestSample struct {
+	SentAt  time.Time
+	Target  string
+	Status  int
+	Latency time.Duration
+	Error   string
+}
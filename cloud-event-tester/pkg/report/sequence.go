@@ -0,0 +1,8 @@
+package report
+
+// SequenceStats identify the sequenced events of a run: a receiver that saw
+// every number from 1 to Last of RunID lost none of them.
+type SequenceStats struct {
+	RunID string `json:"runId"`
+	Last  int64  `json:"last"`
+}
@@ -0,0 +1,8 @@
+package report
+
+// ShardStats are the counters of one send shard.
+type ShardStats struct {
+	Shard int `json:"shard"`
+	Rate  int `json:"rate"`
+	Sent  int `json:"sent"`
+}
@@ -0,0 +1,31 @@
+package report
+
+// SoakSample is the state of the tester and its sends in one interval of a
+// soak run.
+type SoakSample struct {
+	Elapsed    float64 `json:"elapsed"`
+	Goroutines int     `json:"goroutines"`
+	HeapBytes  uint64  `json:"heapBytes"`
+	SysBytes   uint64  `json:"sysBytes"`
+	NumGC      uint32  `json:"numGC"`
+	// GCPauseMs is the time the garbage collector stopped the tester in
+	// the interval
+	GCPauseMs float64 `json:"gcPauseMs"`
+	Rate      float64 `json:"rate"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// SoakDrift is a kind of drift of a soak run: when it was first seen and how
+// far the latest sample is off the first.
+type SoakDrift struct {
+	Kind    string  `json:"kind"`
+	FirstAt float64 `json:"firstAt"`
+	Message string  `json:"message"`
+}
+
+// SoakReport is the self monitoring of a soak run.
+type SoakReport struct {
+	Interval float64      `json:"interval"`
+	Samples  []SoakSample `json:"samples"`
+	Drift    []SoakDrift  `json:"drift,omitempty"`
+}
@@ -0,0 +1,28 @@
+package report
+
+// UnhealthyPeriod is a stretch of a run in which the health endpoint of the
+// target failed, From and To seconds into the run, with the send errors of
+// the seconds of the timeline it overlaps.
+type UnhealthyPeriod struct {
+	From       float64 `json:"from"`
+	To         float64 `json:"to"`
+	Reason     string  `json:"reason"`
+	SendErrors int     `json:"sendErrors"`
+}
+
+// TargetHealthStats are the polls of the health endpoint of the target of a
+// run. Flaps are the changes from healthy to unhealthy, Spikes the seconds
+// in which at least a tenth of the sends failed and CorrelatedSpikes those
+// of them within a poll interval of an unhealthy period.
+type TargetHealthStats struct {
+	URL                  string            `json:"url"`
+	Polls                int               `json:"polls"`
+	Failed               int               `json:"failed"`
+	Flaps                int               `json:"flaps"`
+	UnhealthySeconds     float64           `json:"unhealthySeconds"`
+	Unhealthy            []UnhealthyPeriod `json:"unhealthy,omitempty"`
+	ErrorsWhileUnhealthy int               `json:"errorsWhileUnhealthy"`
+	ErrorsWhileHealthy   int               `json:"errorsWhileHealthy"`
+	Spikes               int               `json:"spikes"`
+	CorrelatedSpikes     int               `json:"correlatedSpikes"`
+}
@@ -0,0 +1,39 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// timeseriesColumns are the columns of the time series file, one row per
+// second of the run.
+var timeseriesColumns = []string{"second", "time", "sent", "totalMsg", "errors", "queueDepth", "rate", "p50", "p90", "p95", "p99", "max"}
+
+// WriteTimeseriesCSV writes the timeline of a result with a header row. The
+// time is the end of the second; the latency percentiles of a second
+// without successful sends are left empty.
+func WriteTimeseriesCSV(w io.Writer, result *Result) {
+	cw := csv.NewWriter(w)
+	cw.Write(timeseriesColumns) //nolint: errcheck
+	ms := func(v float64) string {
+		if v <= 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	}
+	for _, t := range result.Timeline {
+		cw.Write([]string{ //nolint: errcheck
+			strconv.Itoa(t.Second),
+			result.StartTime.Add(time.Duration(t.Second) * time.Second).UTC().Format(time.RFC3339),
+			strconv.FormatUint(t.Sent, 10),
+			strconv.Itoa(t.TotalMsg),
+			strconv.Itoa(t.Errors),
+			strconv.Itoa(t.QueueDepth),
+			strconv.Itoa(t.Rate),
+			ms(t.P50), ms(t.P90), ms(t.P95), ms(t.P99), ms(t.Max),
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,9 @@
+package report
+
+// SpanStats are the spans of a run exported to an OTLP endpoint.
+type SpanStats struct {
+	Endpoint string `json:"endpoint"`
+	Exported int    `json:"exported"`
+	Dropped  int    `json:"dropped,omitempty"`
+	Failed   int    `json:"failed,omitempty"`
+}
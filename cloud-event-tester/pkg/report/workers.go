@@ -0,0 +1,15 @@
+package report
+
+// PoolStats summarizes the worker pool of a MULTI_THREAD run. Dropped are
+// the messages the generator discarded because the queue was full, Failed
+// the sends that failed at the target or whose response failed an assertion.
+type PoolStats struct {
+	Workers       int     `json:"workers"`
+	QueueSize     int     `json:"queueSize"`
+	DropPolicy    string  `json:"dropPolicy"`
+	MaxQueueDepth int     `json:"maxQueueDepth"`
+	BlockedSends  int     `json:"blockedSends"`
+	BlockedSecs   float64 `json:"blockedSeconds"`
+	Dropped       int     `json:"dropped"`
+	Failed        int     `json:"failed"`
+}
@@ -0,0 +1,227 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// tokenFileInterval is how often a token file is read again; the kubelet
+	// rotates projected service account tokens well before they expire
+	tokenFileInterval = time.Minute
+	// oauthRetryInterval is how long a failed token refresh waits to retry;
+	// the previous token is sent until then
+	oauthRetryInterval = 10 * time.Second
+	// oauthDefaultExpiry is assumed for tokens without expires_in
+	oauthDefaultExpiry = time.Hour
+)
+
+// Authenticator keeps the Authorization header of the events of a run
+// current: a static bearer token, a token file such as a Kubernetes service
+// account token, read again periodically, or an OAuth2 client credentials
+// token, refreshed before it expires. Senders only load the header, so
+// refreshing never holds up the send loop.
+type Authenticator struct {
+	header atomic.Value
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewAuthenticator returns the authenticator of a run, nil if the events are
+// sent without authentication. It fails if the first token cannot be had.
+func NewAuthenticator(opts *Options) (*Authenticator, error) {
+	a := &Authenticator{stop: make(chan struct{}), done: make(chan struct{})}
+	logSignature(opts)
+	switch {
+	case opts.BearerToken != "":
+		a.header.Store("Bearer " + opts.BearerToken)
+		close(a.done)
+	case opts.TokenFile != "":
+		token, err := readTokenFile(opts.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.header.Store("Bearer " + token)
+		go a.refreshTokenFile(opts.TokenFile)
+		log.Infof("Authentication: token file %s", opts.TokenFile)
+	case opts.OAuthTokenURL != "":
+		oc := &oauthClient{
+			tokenURL: opts.OAuthTokenURL,
+			id:       opts.OAuthClientID,
+			secret:   opts.OAuthClientSecret,
+			scopes:   opts.OAuthScopes,
+			http:     &http.Client{Timeout: 30 * time.Second},
+		}
+		token, expiry, err := oc.fetch()
+		if err != nil {
+			return nil, err
+		}
+		a.header.Store("Bearer " + token)
+		go a.refreshOAuth(oc, expiry)
+		log.Infof("Authentication: OAuth2 client credentials of %s, token valid for %v", opts.OAuthClientID, expiry)
+	default:
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Close stops refreshing the token.
+func (a *Authenticator) Close() {
+	if a == nil {
+		return
+	}
+	select {
+	case <-a.done:
+	default:
+		close(a.stop)
+		<-a.done
+	}
+}
+
+func (a *Authenticator) refreshTokenFile(path string) {
+	defer close(a.done)
+	ticker := time.NewTicker(tokenFileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		token, err := readTokenFile(path)
+		if err != nil {
+			log.Warnf("Failed to read token file, sending the previous token: %v", err)
+			continue
+		}
+		a.header.Store("Bearer " + token)
+	}
+}
+
+// refreshOAuth fetches a new token when four fifths of the lifetime of the
+// current one have passed.
+func (a *Authenticator) refreshOAuth(oc *oauthClient, expiry time.Duration) {
+	defer close(a.done)
+	next := expiry * 4 / 5
+	for {
+		timer := time.NewTimer(next)
+		select {
+		case <-a.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		token, expiry, err := oc.fetch()
+		if err != nil {
+			log.Warnf("Failed to refresh OAuth2 token, retrying in %v: %v", oauthRetryInterval, err)
+			next = oauthRetryInterval
+			continue
+		}
+		log.Debugf("Refreshed OAuth2 token, valid for %v", expiry)
+		a.header.Store("Bearer " + token)
+		next = expiry * 4 / 5
+	}
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// oauthClient fetches tokens with the OAuth2 client credentials grant.
+type oauthClient struct {
+	tokenURL, id, secret, scopes string
+	http                         *http.Client
+}
+
+// fetch returns a new access token and how long it is valid.
+func (oc *oauthClient) fetch() (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if oc.scopes != "" {
+		form.Set("scope", strings.Join(strings.FieldsFunc(oc.scopes, func(r rune) bool { return r == ',' || r == ' ' }), " "))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), oc.http.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oc.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(oc.id), url.QueryEscape(oc.secret))
+	resp, err := oc.http.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch OAuth2 token: %w", err)
+	}
+	defer DrainBody(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read OAuth2 token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", 0, fmt.Errorf("malformed OAuth2 token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", 0, fmt.Errorf("OAuth2 token response without access_token")
+	}
+	if tok.TokenType != "" && !strings.EqualFold(tok.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("OAuth2 token type %q is not bearer", tok.TokenType)
+	}
+	expiry := oauthDefaultExpiry
+	if tok.ExpiresIn > 0 {
+		expiry = time.Duration(tok.ExpiresIn) * time.Second
+	}
+	return tok.AccessToken, expiry, nil
+}
+
+// authDoer sends with the current Authorization header of a run. The header
+// is set on the request sent, so each worker of MULTI_THREAD mode sets it on
+// its own copy.
+type authDoer struct {
+	Doer
+	auth *Authenticator
+}
+
+// WithAuth returns client, sending with the Authorization header of auth if
+// it is not nil.
+func WithAuth(client Doer, auth *Authenticator) Doer {
+	if auth == nil {
+		return client
+	}
+	return authDoer{Doer: client, auth: auth}
+}
+
+func (d authDoer) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	req.Header.Set(fasthttp.HeaderAuthorization, d.auth.header.Load().(string))
+	return d.Doer.Do(req, res)
+}
+
+func (d authDoer) Close() error {
+	CloseClient(d.Doer)
+	return nil
+}
@@ -0,0 +1,214 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HTTP stacks selectable with -http-stack
+const (
+	StackFastHTTP = "fasthttp"
+	StackNetHTTP  = "nethttp"
+)
+
+// defaultRequestTimeout bounds a send unless -request-timeout is given, so a
+// consumer that accepts the connection but never answers does not stall the
+// run.
+const defaultRequestTimeout = 30 * time.Second
+
+// Transports selectable with -transport
+const (
+	TransportHTTP      = "http"
+	TransportKafka     = "kafka"
+	TransportWebSocket = "websocket"
+)
+
+// maxDrain bounds the rest of a net/http response body DrainBody reads to
+// keep its connection; closing the connection of a larger one is cheaper.
+const maxDrain = 256 << 10
+
+// DrainBody reads the rest of a net/http response body and closes it.
+// net/http only reuses the connection of a body read to the end, closing
+// one that is not closes its connection.
+func DrainBody(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrain) //nolint: errcheck
+	body.Close()
+}
+
+// Doer sends a request and reads the response into res. Requests are
+// built as fasthttp requests whatever the stack, so the send paths are the
+// same for both.
+type Doer interface {
+	Do(req *fasthttp.Request, res *fasthttp.Response) error
+}
+
+// NewClient returns the client the events of a run are sent with. Its
+// connection behavior comes from the options; zero values keep the defaults
+// of the stack. If conns is not nil, it counts the connections the client
+// opens, which shows whether they are kept alive. The TLS settings were
+// checked by validate.
+func NewClient(opts *Options, conns *int64) Doer {
+	switch strings.ToLower(opts.Transport) {
+	case TransportKafka:
+		return newKafkaClient(opts)
+	case TransportWebSocket:
+		return newWebSocketClient(opts, conns)
+	}
+	tlsConfig, _ := opts.TLSConfig()
+	if opts.NetHTTP() {
+		return NewNetHTTPClient(opts, tlsConfig, conns)
+	}
+	client := &fasthttp.Client{
+		TLSConfig:                     tlsConfig,
+		MaxConnsPerHost:               opts.MaxConnsPerHost,
+		MaxConnWaitTimeout:            opts.ConnWaitTimeout,
+		ReadTimeout:                   opts.ReadTimeout,
+		WriteTimeout:                  opts.WriteTimeout,
+		ReadBufferSize:                opts.ReadBufferSize,
+		WriteBufferSize:               opts.WriteBufferSize,
+		MaxIdleConnDuration:           opts.KeepAlive,
+		MaxConnDuration:               opts.ConnMaxLifetime,
+		DisableHeaderNamesNormalizing: opts.RawHeaderNames,
+	}
+	client.Dial = NewDialer(opts)
+	if conns != nil {
+		next := client.Dial
+		client.Dial = func(addr string) (net.Conn, error) {
+			atomic.AddInt64(conns, 1)
+			return next(addr)
+		}
+	}
+	var doer Doer = client
+	if opts.RequestTimeout > 0 {
+		doer = &deadlineClient{client: client, timeout: opts.RequestTimeout}
+	}
+	if opts.NoKeepAlive {
+		doer = freshConnClient{doer}
+	}
+	return doer
+}
+
+// freshConnClient sends every request of a fasthttp client with
+// "Connection: close", so each gets a new connection that is closed after
+// the response.
+type freshConnClient struct {
+	Doer
+}
+
+func (c freshConnClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	req.SetConnectionClose()
+	return c.Doer.Do(req, res)
+}
+
+// deadlineClient bounds every send of a fasthttp client; Do of fasthttp
+// waits as long as the connection is open.
+type deadlineClient struct {
+	client  *fasthttp.Client
+	timeout time.Duration
+}
+
+func (c *deadlineClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	return c.client.DoTimeout(req, res, c.timeout)
+}
+
+// NetHTTPClient sends with net/http, for targets that need HTTP/2 or HTTP/3,
+// proxies from the environment or the standard TLS stack. It converts every
+// request, so it is slower than fasthttp.
+type NetHTTPClient struct {
+	client *http.Client
+}
+
+// NewNetHTTPClient returns the net/http client of opts, counting the
+// connections it opens in conns if not nil.
+func NewNetHTTPClient(opts *Options, tlsConfig *tls.Config, conns *int64) *NetHTTPClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = unixDialContext(NewResolver(opts).dialContext(transport.DialContext))
+	if conns != nil {
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt64(conns, 1)
+			return dial(ctx, network, addr)
+		}
+	}
+	transport.Proxy = opts.proxyFunc()
+	transport.ForceAttemptHTTP2 = true
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	transport.MaxIdleConnsPerHost = opts.MaxConnsPerHost
+	transport.ResponseHeaderTimeout = opts.ReadTimeout
+	transport.ReadBufferSize = opts.ReadBufferSize
+	transport.WriteBufferSize = opts.WriteBufferSize
+	if opts.KeepAlive > 0 {
+		transport.IdleConnTimeout = opts.KeepAlive
+	}
+	transport.DisableKeepAlives = opts.NoKeepAlive
+	var rt http.RoundTripper = transport
+	if v := newVersionTransport(opts, transport, tlsConfig, conns); v != nil {
+		rt = v
+	}
+	return &NetHTTPClient{client: &http.Client{Transport: rt, Timeout: opts.RequestTimeout}}
+}
+
+func (c *NetHTTPClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	return c.DoContext(context.Background(), req, res)
+}
+
+// DoContext sends a request with ctx, which may carry an httptrace.ClientTrace.
+func (c *NetHTTPClient) DoContext(ctx context.Context, req *fasthttp.Request, res *fasthttp.Response) error {
+	// a streamed body is sent as it is read, with chunked encoding; Body
+	// would read all of it
+	var reqBody io.Reader
+	if req.IsBodyStream() {
+		reqBody = req.BodyStream()
+		defer req.CloseBodyStream() //nolint: errcheck
+	} else {
+		reqBody = bytes.NewReader(req.Body())
+	}
+	hreq, err := http.NewRequestWithContext(ctx, string(req.Header.Method()), req.URI().String(), reqBody)
+	if err != nil {
+		return err
+	}
+	if req.UseHostHeader {
+		hreq.Host = string(req.Header.Host())
+	}
+	req.Header.VisitAll(func(k, v []byte) {
+		if key := string(k); !HopHeaders[key] {
+			// assigned directly so the case of raw header names is kept
+			hreq.Header[key] = append(hreq.Header[key], string(v))
+		}
+	})
+	resp, err := c.client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	// net/http only reuses the connection once the body is read to the end
+	// and closed
+	defer DrainBody(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	res.Reset()
+	res.SetStatusCode(resp.StatusCode)
+	for k, vs := range resp.Header {
+		if HopHeaders[k] {
+			continue
+		}
+		for _, v := range vs {
+			res.Header.Add(k, v)
+		}
+	}
+	res.SetBody(body)
+	return nil
+}
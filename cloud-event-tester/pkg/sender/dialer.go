@@ -0,0 +1,261 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// schemes of the proxies of -proxy, -socks5 and the environment
+const (
+	ProxyHTTP    = "http"
+	ProxySOCKS5  = "socks5"
+	ProxySOCKS5H = "socks5h"
+)
+
+// unixScheme is the prefix of the URLs of targets listening on a Unix domain
+// socket, unix:///path/to/socket:/request/path.
+const unixScheme = "unix://"
+
+// unixSockets maps the hosts that stand for Unix domain sockets in the
+// request URIs of unix targets to the sockets, so the dial functions, which
+// only see the host of a request, know where to connect.
+var unixSockets = struct {
+	sync.RWMutex
+	hosts map[string]string
+	// names are the hosts by socket
+	names map[string]string
+}{hosts: map[string]string{}, names: map[string]string{}}
+
+// proxyURL returns the proxy of -socks5 or -proxy, nil if neither is set.
+// The settings were checked by validate.
+func (o *Options) proxyURL() *url.URL {
+	var u *url.URL
+	switch {
+	case o.Socks5 != "":
+		u, _ = ParseProxyURL(o.Socks5, ProxySOCKS5)
+	case o.Proxy != "":
+		u, _ = ParseProxyURL(o.Proxy, ProxyHTTP)
+	}
+	return u
+}
+
+// ParseProxyURL parses the address of a proxy, a URL or host:port of a proxy
+// of scheme.
+func ParseProxyURL(proxy, scheme string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = scheme + "://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", proxy, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %q has no host", proxy)
+	}
+	return u, nil
+}
+
+// LogProxy logs the proxy the events of a run are sent through, and the
+// addresses and Host header of -resolve and -host-header.
+func (o *Options) LogProxy() {
+	if len(o.Resolve) > 0 {
+		log.Infof("Resolve: %s", strings.Join(o.Resolve, ", "))
+	}
+	if o.HostHeader != "" {
+		log.Infof("Host Header: %s", o.HostHeader)
+	}
+	if u := o.proxyURL(); u != nil {
+		log.Infof("Proxy: %s", u.Redacted())
+		return
+	}
+	if env := httpproxy.FromEnvironment(); env.HTTPProxy != "" || env.HTTPSProxy != "" {
+		log.Infof("Proxy: from HTTP_PROXY/HTTPS_PROXY, except NO_PROXY")
+	}
+}
+
+// proxyFunc returns the proxy of the requests of the net/http and WebSocket
+// clients: that of -socks5 or -proxy for every target, or that of the
+// environment. Unix targets are never sent through a proxy.
+func (o *Options) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := http.ProxyFromEnvironment
+	if u := o.proxyURL(); u != nil {
+		proxy = http.ProxyURL(u)
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if _, ok := UnixSocket(req.URL.Host); ok {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// NewDialer returns the dial function of the fasthttp clients of a run: to
+// the socket of a unix target, else through the proxy of NewProxyDialer or
+// directly, to the address of -resolve if it has one.
+func NewDialer(opts *Options) fasthttp.DialFunc {
+	next := NewProxyDialer(opts)
+	if next == nil {
+		next = NewResolver(opts).dial(fasthttp.Dial)
+	}
+	return func(addr string) (net.Conn, error) {
+		if socket, ok := UnixSocket(addr); ok {
+			return net.DialTimeout("unix", socket, fasthttp.DefaultDialTimeout)
+		}
+		return next(addr)
+	}
+}
+
+// unixDialContext wraps the dial function of the net/http and WebSocket
+// clients to connect to the sockets of unix targets.
+func unixDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socket, ok := UnixSocket(addr); ok {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// NewProxyDialer returns the dial function of the fasthttp clients of a run
+// that connects through a proxy: the SOCKS5 proxy of -socks5 or the HTTP
+// proxy of -proxy for every target, or else the proxy of HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY for each target, which never applies to loopback
+// targets. Connections through an HTTP proxy are tunneled with CONNECT, plain
+// HTTP targets too. A dial function only sees the address of a target, so
+// the HTTPS_PROXY of the environment is used for port 443. It returns nil
+// to dial directly.
+func NewProxyDialer(opts *Options) fasthttp.DialFunc {
+	if u := opts.proxyURL(); u != nil {
+		return proxyDialer(u)
+	}
+	env := httpproxy.FromEnvironment()
+	if env.HTTPProxy == "" && env.HTTPSProxy == "" {
+		return nil
+	}
+	proxyFor := env.ProxyFunc()
+	direct := NewResolver(opts).dial(fasthttp.Dial)
+	var mu sync.Mutex
+	dialers := map[string]fasthttp.DialFunc{}
+	return func(addr string) (net.Conn, error) {
+		target := &url.URL{Scheme: "http", Host: addr}
+		if _, port, _ := net.SplitHostPort(addr); port == "443" {
+			target.Scheme = "https"
+		}
+		u, err := proxyFor(target)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil {
+			return direct(addr)
+		}
+		mu.Lock()
+		dial, ok := dialers[u.String()]
+		if !ok {
+			dial = proxyDialer(u)
+			dialers[u.String()] = dial
+		}
+		mu.Unlock()
+		return dial(addr)
+	}
+}
+
+// proxyDialer returns the dial function through one proxy.
+func proxyDialer(u *url.URL) fasthttp.DialFunc {
+	if u.Scheme == ProxySOCKS5 || u.Scheme == ProxySOCKS5H {
+		return fasthttpproxy.FasthttpSocksDialer(u.String())
+	}
+	proxy := u.Host
+	if u.User != nil {
+		password, _ := u.User.Password()
+		proxy = u.User.Username() + ":" + password + "@" + proxy
+	}
+	return fasthttpproxy.FasthttpHTTPDialerTimeout(proxy, fasthttp.DefaultDialTimeout)
+}
+
+// ParseUnixTarget splits the URL of a unix target into the path of its socket
+// and the path of its requests, / if it has none. ok is false for any other
+// URL.
+func ParseUnixTarget(target string) (socket, path string, ok bool) {
+	if !strings.HasPrefix(target, unixScheme) {
+		return "", "", false
+	}
+	socket, path = strings.TrimPrefix(target, unixScheme), "/"
+	if i := strings.IndexByte(socket, ':'); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	return socket, path, true
+}
+
+// TargetURI returns the request URI of a target. That of a unix target is an
+// http URL whose host stands for the socket, named after it.
+func TargetURI(target string) string {
+	socket, path, ok := ParseUnixTarget(target)
+	if !ok {
+		return target
+	}
+	return "http://" + unixHost(socket) + path
+}
+
+// unixHost returns the host that stands for a socket in request URIs, which
+// is also sent as the Host header: the name of the socket file, numbered if
+// another socket has the same name.
+func unixHost(socket string) string {
+	unixSockets.RLock()
+	host, ok := unixSockets.names[socket]
+	unixSockets.RUnlock()
+	if ok {
+		return host
+	}
+	unixSockets.Lock()
+	defer unixSockets.Unlock()
+	if host, ok := unixSockets.names[socket]; ok {
+		return host
+	}
+	base := strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, filepath.Base(socket)), ".-")
+	if base == "" {
+		base = "unix"
+	}
+	host = base
+	for i := 2; unixSockets.hosts[host] != ""; i++ {
+		host = fmt.Sprintf("%s-%d", base, i)
+	}
+	unixSockets.hosts[host] = socket
+	unixSockets.names[socket] = host
+	return host
+}
+
+// UnixSocket returns the socket of the host of a request URI or address, if
+// it stands for one.
+func UnixSocket(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	unixSockets.RLock()
+	defer unixSockets.RUnlock()
+	socket, ok := unixSockets.hosts[host]
+	return socket, ok
+}
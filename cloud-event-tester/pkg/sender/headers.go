@@ -0,0 +1,128 @@
+package sender
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// HeadersFlag is a repeatable flag of extra request headers, given as
+// "Name: value" like in a request or as Name=value. Whichever separator comes
+// first splits the header, so values may contain the other one.
+type HeadersFlag map[string]string
+
+func (h *HeadersFlag) String() string {
+	if h == nil || len(*h) == 0 {
+		return ""
+	}
+	return events.FormatLabels(*h)
+}
+
+func (h *HeadersFlag) Set(value string) error {
+	i := strings.IndexAny(value, ":=")
+	if i < 0 {
+		return fmt.Errorf("header %q is not \"Name: value\" or Name=value", value)
+	}
+	name, val := value[:i], value[i+1:]
+	if value[i] == ':' {
+		val = strings.TrimSpace(val)
+	}
+	if *h == nil {
+		*h = HeadersFlag{}
+	}
+	(*h)[strings.TrimSpace(name)] = val
+	return nil
+}
+
+// ValidateHeaders rejects empty header names and the headers the HTTP client
+// sets itself.
+func ValidateHeaders(headers map[string]string) error {
+	for name := range headers {
+		if name == "" {
+			return fmt.Errorf("header name must not be empty")
+		}
+		if HopHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("header %s is set by the HTTP client and cannot be overridden", name)
+		}
+	}
+	return nil
+}
+
+// RedactedValue replaces the values of sensitive headers in the reports,
+// checkpoints and control API responses of a run.
+const RedactedValue = "REDACTED"
+
+// sensitiveHeaders are the headers that carry credentials, and
+// sensitiveHeaderWords the words of header names, like X-Auth-Token, that
+// are taken to.
+var (
+	sensitiveHeaders = map[string]bool{
+		"Authorization":        true,
+		"Proxy-Authorization":  true,
+		"Cookie":               true,
+		"Set-Cookie":           true,
+		"X-Api-Key":            true,
+		"Api-Key":              true,
+		"X-Amz-Security-Token": true,
+	}
+	sensitiveHeaderWords = []string{"token", "secret", "password", "apikey", "api-key", "session", "credential"}
+)
+
+// sensitiveHeader reports whether the value of a header is a credential.
+func sensitiveHeader(name string) bool {
+	if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeaders returns headers with the values of the sensitive ones
+// replaced by RedactedValue, headers itself if there are none.
+func RedactHeaders(headers map[string]string) map[string]string {
+	var redacted map[string]string
+	for name := range headers {
+		if !sensitiveHeader(name) {
+			continue
+		}
+		if redacted == nil {
+			redacted = make(map[string]string, len(headers))
+			for k, v := range headers {
+				redacted[k] = v
+			}
+		}
+		redacted[name] = RedactedValue
+	}
+	if redacted == nil {
+		return headers
+	}
+	return redacted
+}
+
+// SetHeaders adds the extra headers of a run to a request. A Content-Type
+// among them is the content type of every event, see events.ContentMode.
+func SetHeaders(req *fasthttp.Request, headers map[string]string) {
+	for name, v := range headers {
+		req.Header.Set(name, v)
+	}
+}
+
+// HopHeaders are not copied to forwarded and mirrored requests.
+var HopHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
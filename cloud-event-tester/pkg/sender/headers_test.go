@@ -0,0 +1,26 @@
+package sender
+
+import (
+	"testing"
+)
+
+func TestSensitiveHeader(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Authorization":       true,
+		"authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"X-Api-Key":           true,
+		"x-api-key":           true,
+		"X-Auth-Token":        true,
+		"X-Client-Secret":     true,
+		"X-Session-Id":        true,
+		"Content-Type":        false,
+		"Ce-Source":           false,
+		"X-Tenant":            false,
+	} {
+		if got := sensitiveHeader(name); got != want {
+			t.Errorf("sensitiveHeader(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
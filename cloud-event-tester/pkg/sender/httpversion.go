@@ -0,0 +1,110 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// HTTP versions selectable with -http-version; without it the stack
+// negotiates the version, fasthttp always sends HTTP/1.1.
+const (
+	HTTPVersion11 = "1.1"
+	HTTPVersion2  = "2"
+	HTTPVersion3  = "3"
+)
+
+// Version returns the HTTP version of -http-version, "" if it is not
+// set.
+func (o *Options) Version() string {
+	switch v := strings.TrimPrefix(strings.ToUpper(o.HTTPVersion), "HTTP/"); v {
+	case "1", "1.1":
+		return HTTPVersion11
+	case "2", "2.0", "H2", "H2C":
+		return HTTPVersion2
+	case "3", "3.0", "H3":
+		return HTTPVersion3
+	default:
+		return v
+	}
+}
+
+// NetHTTP reports whether the run sends with net/http, which it does on
+// -http-stack nethttp and for HTTP/2 and HTTP/3, which fasthttp cannot send.
+func (o *Options) NetHTTP() bool {
+	v := o.Version()
+	return strings.ToLower(o.HTTPStack) == StackNetHTTP || v == HTTPVersion2 || v == HTTPVersion3
+}
+
+// newVersionTransport returns the transport of the net/http client of a run
+// with an HTTP version, nil to keep the transport of the stack. HTTP/1.1
+// turns off the negotiation of HTTP/2; HTTP/2 is sent over TLS to https
+// targets and in plaintext, as h2c with prior knowledge, to http ones, as
+// in-cluster consumers often are; HTTP/3 is sent over QUIC.
+func newVersionTransport(opts *Options, base *http.Transport, tlsConfig *tls.Config, conns *int64) http.RoundTripper {
+	switch opts.Version() {
+	case HTTPVersion11:
+		base.ForceAttemptHTTP2 = false
+		base.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		return base
+	case HTTPVersion2:
+		dial := base.DialContext
+		h2 := &http2.Transport{
+			TLSClientConfig:  tlsConfig,
+			WriteByteTimeout: opts.WriteTimeout,
+			DialTLSContext: func(ctx context.Context, network, addr string, tlsCfg *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tc := tls.Client(conn, tlsCfg)
+				if err := tc.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tc, nil
+			},
+		}
+		h2c := &http2.Transport{
+			AllowHTTP:        true,
+			WriteByteTimeout: opts.WriteTimeout,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+		return schemeTransport{"https": h2, "http": h2c}
+	case HTTPVersion3:
+		resolve := NewResolver(opts)
+		quicConfig := &quic.Config{MaxIdleTimeout: opts.KeepAlive}
+		return &http3.RoundTripper{
+			TLSClientConfig: tlsConfig,
+			QuicConfig:      quicConfig,
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, qc *quic.Config) (quic.EarlyConnection, error) {
+				if conns != nil {
+					atomic.AddInt64(conns, 1)
+				}
+				return quic.DialAddrEarly(ctx, resolve.Addr(addr), tlsCfg, qc)
+			},
+		}
+	}
+	return nil
+}
+
+// schemeTransport sends the requests of each URL scheme with its transport.
+type schemeTransport map[string]http.RoundTripper
+
+func (t schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, ok := t[req.URL.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported scheme %q", req.URL.Scheme)
+	}
+	return rt.RoundTrip(req)
+}
@@ -0,0 +1,136 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// SASL mechanisms of the Kafka transport
+const (
+	saslPlain       = "plain"
+	saslSCRAMSHA256 = "scram-sha-256"
+	saslSCRAMSHA512 = "scram-sha-512"
+)
+
+// kafkaBatchTimeout bounds how long a message waits for others to share its
+// produce request. Sends are synchronous, so the default of a second would
+// cap a sender at one message a second.
+const kafkaBatchTimeout = time.Millisecond
+
+// kafkaClient publishes the events of a run to a Kafka topic instead of
+// posting them. It takes the requests the HTTP clients send, so every mode,
+// pacing and content mode works the same with both transports, and maps them
+// with the CloudEvents Kafka protocol binding: ce- headers of binary mode
+// become ce_ headers, the content type the content-type header and the body
+// the value. Other headers, like labels, are kept. A send succeeds when the
+// leader has written the message, and is answered with 204.
+type kafkaClient struct {
+	writer *kafka.Writer
+}
+
+// newKafkaClient returns the Kafka client of a run. The TLS and SASL settings
+// were checked by validate.
+func newKafkaClient(opts *Options) *kafkaClient {
+	transport := &kafka.Transport{}
+	if opts.KafkaTLS {
+		tlsConfig, _ := opts.TLSConfig()
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLS = tlsConfig
+	}
+	transport.SASL, _ = opts.KafkaSASL()
+	return &kafkaClient{writer: &kafka.Writer{
+		Addr:         kafka.TCP(SplitList(opts.KafkaBrokers)...),
+		Topic:        opts.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: kafkaBatchTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		Transport:    transport,
+	}}
+}
+
+// KafkaSASL returns the SASL mechanism of the Kafka transport, nil for none.
+func (o *Options) KafkaSASL() (sasl.Mechanism, error) {
+	switch strings.ToLower(o.KafkaSASLMechanism) {
+	case "":
+		return nil, nil
+	case saslPlain:
+		return plain.Mechanism{Username: o.KafkaUsername, Password: o.KafkaPassword}, nil
+	case saslSCRAMSHA256:
+		return scram.Mechanism(scram.SHA256, o.KafkaUsername, o.KafkaPassword)
+	case saslSCRAMSHA512:
+		return scram.Mechanism(scram.SHA512, o.KafkaUsername, o.KafkaPassword)
+	default:
+		return nil, fmt.Errorf("SASL mechanism %q is not plain, scram-sha-256 or scram-sha-512", o.KafkaSASLMechanism)
+	}
+}
+
+func (c *kafkaClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	res.Reset()
+	if req.Header.IsHead() {
+		// warm-up requests carry no event; the writer connects on the first
+		// message
+		res.SetStatusCode(fasthttp.StatusNoContent)
+		return nil
+	}
+	msg := kafka.Message{Value: append([]byte(nil), req.Body()...)}
+	req.Header.VisitAll(func(k, v []byte) {
+		name := string(k)
+		switch {
+		case HopHeaders[name], strings.EqualFold(name, fasthttp.HeaderUserAgent):
+			return
+		case strings.EqualFold(name, fasthttp.HeaderContentType):
+			name = "content-type"
+		case len(name) > 3 && strings.EqualFold(name[:3], "ce-"):
+			name = "ce_" + strings.ToLower(name[3:])
+			// the Kafka binding sends the attribute values as they are
+			v = []byte(events.DecodeHeaderValue(string(v)))
+			if name == "ce_partitionkey" {
+				msg.Key = append([]byte(nil), v...)
+			}
+		}
+		msg.Headers = append(msg.Headers, kafka.Header{Key: name, Value: append([]byte(nil), v...)})
+	})
+	if err := c.writer.WriteMessages(context.Background(), msg); err != nil {
+		return err
+	}
+	res.SetStatusCode(fasthttp.StatusNoContent)
+	return nil
+}
+
+func (c *kafkaClient) Close() error {
+	return c.writer.Close()
+}
+
+// CloseClient closes a client that holds resources beyond idle connections,
+// like the writer of the Kafka transport.
+func CloseClient(client Doer) {
+	if c, ok := client.(io.Closer); ok {
+		c.Close() //nolint: errcheck
+	}
+}
+
+// SplitList splits a comma separated list, dropping empty entries.
+func SplitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
@@ -0,0 +1,83 @@
+// Package sender sends the events of a run: the HTTP, Kafka and WebSocket
+// clients, their dialers, proxies and TLS settings, and the authentication,
+// signature and headers of the requests. Its Options are part of the
+// settings of a run.
+package sender
+
+import (
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Options are the settings of the clients that send the events of a
+// run. They are filled from the flags, the environment and the scenario
+// files with the other settings of the run, see loadgen.Config.
+type Options struct {
+	// Transport of the events, see websocketClient and kafkaClient
+	Transport          string `yaml:"transport" json:"transport,omitempty"`
+	KafkaBrokers       string `yaml:"kafkaBrokers" json:"kafkaBrokers,omitempty"`
+	KafkaTopic         string `yaml:"kafkaTopic" json:"kafkaTopic,omitempty"`
+	KafkaSASLMechanism string `yaml:"kafkaSaslMechanism" json:"kafkaSaslMechanism,omitempty"`
+	KafkaUsername      string `yaml:"kafkaUsername" json:"kafkaUsername,omitempty"`
+	KafkaPassword      string `yaml:"kafkaPassword" json:"-"`
+	KafkaTLS           bool   `yaml:"kafkaTls" json:"kafkaTls,omitempty"`
+
+	// HTTP client tuning, see NewClient
+	HTTPStack       string        `yaml:"httpStack" json:"httpStack,omitempty"`
+	HTTPVersion     string        `yaml:"httpVersion" json:"httpVersion,omitempty"`
+	MaxConnsPerHost int           `yaml:"maxConnsPerHost" json:"maxConnsPerHost,omitempty"`
+	ConnWaitTimeout time.Duration `yaml:"connWaitTimeout" json:"connWaitTimeout,omitempty"`
+	ReadTimeout     time.Duration `yaml:"readTimeout" json:"readTimeout,omitempty"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout" json:"writeTimeout,omitempty"`
+	RequestTimeout  time.Duration `yaml:"requestTimeout" json:"requestTimeout,omitempty"`
+	ReadBufferSize  int           `yaml:"readBufferSize" json:"readBufferSize,omitempty"`
+	WriteBufferSize int           `yaml:"writeBufferSize" json:"writeBufferSize,omitempty"`
+	KeepAlive       time.Duration `yaml:"keepAlive" json:"keepAlive,omitempty"`
+	// NoKeepAlive sends every request on a new connection, ConnMaxLifetime
+	// closes connections that have been open that long after their next
+	// response
+	NoKeepAlive     bool          `yaml:"noKeepAlive" json:"noKeepAlive,omitempty"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" json:"connMaxLifetime,omitempty"`
+	RawHeaderNames  bool          `yaml:"rawHeaderNames" json:"rawHeaderNames,omitempty"`
+	// Proxy and Socks5 are the HTTP or SOCKS5 proxy of every target instead
+	// of the proxy of the environment, see NewProxyDialer
+	Proxy  string `yaml:"proxy" json:"proxy,omitempty"`
+	Socks5 string `yaml:"socks5" json:"socks5,omitempty"`
+
+	// TLS of HTTPS targets, see tlsConfig
+	CACert             string `yaml:"caCert" json:"caCert,omitempty"`
+	ClientCert         string `yaml:"clientCert" json:"clientCert,omitempty"`
+	ClientKey          string `yaml:"clientKey" json:"clientKey,omitempty"`
+	ServerName         string `yaml:"serverName" json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify,omitempty"`
+
+	// HostHeader replaces the host of the target URLs in the Host header,
+	// and Resolve the addresses their hosts resolve to, see resolver
+	HostHeader string   `yaml:"hostHeader" json:"hostHeader,omitempty"`
+	Resolve    []string `yaml:"resolve" json:"resolve,omitempty"`
+
+	// Authentication of the events, see NewAuthenticator. Secrets are not
+	// reported with the run.
+	BearerToken       string `yaml:"bearerToken" json:"-"`
+	TokenFile         string `yaml:"tokenFile" json:"tokenFile,omitempty"`
+	OAuthTokenURL     string `yaml:"oauthTokenUrl" json:"oauthTokenUrl,omitempty"`
+	OAuthClientID     string `yaml:"oauthClientId" json:"oauthClientId,omitempty"`
+	OAuthClientSecret string `yaml:"oauthClientSecret" json:"-"`
+	OAuthScopes       string `yaml:"oauthScopes" json:"oauthScopes,omitempty"`
+	// Signature of the request bodies, see RequestSigner
+	SignSecret string `yaml:"signSecret" json:"-"`
+	SignHeader string `yaml:"signHeader" json:"signHeader,omitempty"`
+	SignFormat string `yaml:"signFormat" json:"signFormat,omitempty"`
+}
+
+// DefaultOptions returns the sender options used when nothing is
+// configured.
+func DefaultOptions() Options {
+	return Options{
+		Transport:       TransportHTTP,
+		HTTPStack:       StackFastHTTP,
+		MaxConnsPerHost: fasthttp.DefaultMaxConnsPerHost,
+		RequestTimeout:  defaultRequestTimeout,
+	}
+}
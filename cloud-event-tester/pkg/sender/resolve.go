@@ -0,0 +1,160 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ResolveFlag is the repeatable -resolve flag, host:addr or, like curl,
+// host:port:addr.
+type ResolveFlag []string
+
+func (r *ResolveFlag) String() string {
+	if r == nil {
+		return ""
+	}
+	return strings.Join(*r, ",")
+}
+
+func (r *ResolveFlag) Set(value string) error {
+	if _, _, err := ParseResolve(value); err != nil {
+		return err
+	}
+	*r = append(*r, value)
+	return nil
+}
+
+// ParseResolve parses a -resolve entry into the host, or host:port, it
+// applies to and the address connected to instead: an IP address, connected
+// to at the port of the target, or an IP address and port. In host:port:addr
+// the entry applies to that port only, as --resolve of curl; IPv6 addresses
+// are written in brackets.
+func ParseResolve(entry string) (from, to string, err error) {
+	host, rest, ok := strings.Cut(entry, ":")
+	if !ok || host == "" || rest == "" {
+		return "", "", fmt.Errorf("resolve %q is not host:addr or host:port:addr", entry)
+	}
+	from = host
+	if port, addr, ok := strings.Cut(rest, ":"); ok && port != "" && strings.Trim(port, "0123456789") == "" && !strings.HasPrefix(rest, "[") {
+		from, rest = net.JoinHostPort(host, port), addr
+	}
+	ip := rest
+	if h, _, err := net.SplitHostPort(rest); err == nil {
+		ip = h
+	} else {
+		ip = strings.TrimSuffix(strings.TrimPrefix(rest, "["), "]")
+		rest = ip
+	}
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("resolve %q does not map %s to an IP address", entry, host)
+	}
+	return strings.ToLower(from), rest, nil
+}
+
+// Resolver connects to the addresses of -resolve instead of those the
+// targets resolve to, to send to one backend behind a load balancer under
+// the name of the load balancer. It applies to the connections made
+// directly, not through a proxy. A nil resolver dials the address as is.
+type Resolver map[string]string
+
+// NewResolver returns the resolver of the -resolve entries of opts, nil if
+// there are none.
+func NewResolver(opts *Options) Resolver {
+	if len(opts.Resolve) == 0 {
+		return nil
+	}
+	r := Resolver{}
+	for _, entry := range opts.Resolve {
+		from, to, _ := ParseResolve(entry)
+		r[from] = to
+	}
+	return r
+}
+
+// Addr returns the address to connect to for addr, host:port.
+func (r Resolver) Addr(addr string) string {
+	if r == nil {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	host = strings.ToLower(host)
+	to, ok := r[net.JoinHostPort(host, port)]
+	if !ok {
+		if to, ok = r[host]; !ok {
+			return addr
+		}
+	}
+	if _, _, err := net.SplitHostPort(to); err == nil {
+		return to
+	}
+	return net.JoinHostPort(to, port)
+}
+
+// dial wraps the dial function of the fasthttp clients of a run.
+func (r Resolver) dial(next fasthttp.DialFunc) fasthttp.DialFunc {
+	if r == nil {
+		return next
+	}
+	return func(addr string) (net.Conn, error) {
+		return next(r.Addr(addr))
+	}
+}
+
+// dialContext wraps the dial function of the net/http and WebSocket clients
+// of a run.
+func (r Resolver) dialContext(next func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if r == nil {
+		return next
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, r.Addr(addr))
+	}
+}
+
+// hostHeaderClient sends every request with the Host header of -host-header
+// instead of the host of its URL, e.g. to send to the IP address of a pod
+// under the name of the ingress.
+type hostHeaderClient struct {
+	Doer
+	host string
+}
+
+// WithHostHeader returns client, overriding the Host header if the run sets
+// one.
+func WithHostHeader(client Doer, opts *Options) Doer {
+	if opts.HostHeader == "" {
+		return client
+	}
+	return hostHeaderClient{Doer: client, host: opts.HostHeader}
+}
+
+func (c hostHeaderClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	req.Header.SetHost(c.host)
+	req.UseHostHeader = true
+	return c.Doer.Do(req, res)
+}
+
+func (c hostHeaderClient) Close() error {
+	CloseClient(c.Doer)
+	return nil
+}
+
+// tlsServerName returns the server name of the TLS handshakes of a run, sent
+// as SNI and verified against the certificates: -server-name, else the host
+// of -host-header, else empty for the host of the URL.
+func (o *Options) tlsServerName() string {
+	if o.ServerName != "" || o.HostHeader == "" {
+		return o.ServerName
+	}
+	if host, _, err := net.SplitHostPort(o.HostHeader); err == nil {
+		return host
+	}
+	return o.HostHeader
+}
@@ -0,0 +1,161 @@
+package sender
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// formats of the webhook signatures
+const (
+	signHMACSHA256 = "hmac-sha256"
+	signHMACSHA512 = "hmac-sha512"
+	signGitHub     = "github"
+	signSvix       = "svix"
+)
+
+// svixSecretPrefix is the prefix of the base64 encoded secrets of Svix.
+const svixSecretPrefix = "whsec_"
+
+// RequestSigner signs the body of every request with an HMAC of the signing
+// secret, for webhook receivers that reject unsigned deliveries. The
+// hmac-sha256 and hmac-sha512 formats send the hex digest of the body; the
+// github format sends sha256=<hex digest>, as the X-Hub-Signature-256 header
+// of GitHub; the svix format, the one of Standard Webhooks as well, signs
+// the message ID, the timestamp and the body, and sends them in the ID,
+// timestamp and signature headers, v1,<base64 digest>. Every request gets a
+// new message ID, as receivers may drop the ones they have seen.
+type RequestSigner struct {
+	format string
+	Header string
+	// IDHeader and timestampHeader are the headers of the svix format
+	IDHeader, TimestampHeader string
+	key                       []byte
+	hash                      func() hash.Hash
+}
+
+// NewRequestSigner returns the signer of opts, nil if the requests are not
+// signed.
+func NewRequestSigner(opts *Options) (*RequestSigner, error) {
+	if opts.SignSecret == "" {
+		return nil, nil
+	}
+	s := &RequestSigner{
+		format: strings.ToLower(opts.SignFormat),
+		Header: opts.SignHeader,
+		key:    []byte(opts.SignSecret),
+		hash:   sha256.New,
+	}
+	if s.format == "" {
+		s.format = signHMACSHA256
+	}
+	var header string
+	switch s.format {
+	case signHMACSHA256:
+		header = "X-Signature"
+	case signHMACSHA512:
+		header, s.hash = "X-Signature", sha512.New
+	case signGitHub:
+		header = "X-Hub-Signature-256"
+	case signSvix:
+		header = "svix-signature"
+		if strings.HasPrefix(opts.SignSecret, svixSecretPrefix) {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(opts.SignSecret, svixSecretPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("signing secret %s... is not base64: %w", svixSecretPrefix, err)
+			}
+			s.key = key
+		}
+	default:
+		return nil, fmt.Errorf("signature format %q is not hmac-sha256, hmac-sha512, github or svix", opts.SignFormat)
+	}
+	if s.Header == "" {
+		s.Header = header
+	}
+	if s.format == signSvix {
+		// the headers of a signature header webhook-signature are
+		// webhook-id and webhook-timestamp
+		prefix := strings.TrimSuffix(strings.ToLower(s.Header), "-signature")
+		s.IDHeader, s.TimestampHeader = prefix+"-id", prefix+"-timestamp"
+	}
+	return s, nil
+}
+
+// sign sets the signature headers of req for its body, with a new message
+// ID and the current time in the svix format.
+func (s *RequestSigner) sign(req *fasthttp.Request) {
+	id := "msg_" + strings.ReplaceAll(events.NewUUID(), "-", "")
+	s.signMessage(req, id, time.Now())
+}
+
+// signMessage sets the signature headers of req for its body, sent as the
+// message id at time at in the svix format.
+func (s *RequestSigner) signMessage(req *fasthttp.Request, id string, at time.Time) {
+	mac := hmac.New(s.hash, s.key)
+	switch s.format {
+	case signSvix:
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		mac.Write([]byte(id + "." + timestamp + "."))
+		mac.Write(req.Body())
+		req.Header.Set(s.IDHeader, id)
+		req.Header.Set(s.TimestampHeader, timestamp)
+		req.Header.Set(s.Header, "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	case signGitHub:
+		mac.Write(req.Body())
+		req.Header.Set(s.Header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	default:
+		mac.Write(req.Body())
+		req.Header.Set(s.Header, hex.EncodeToString(mac.Sum(nil)))
+	}
+}
+
+// signingClient signs every request before it is sent. The signature is set
+// on the request sent, so each worker of MULTI_THREAD mode signs its own
+// copy, with the body it sends.
+type signingClient struct {
+	Doer
+	signer *RequestSigner
+}
+
+// WithSignature returns client, signing the requests if the run has a
+// signing secret. The settings were checked by validate.
+func WithSignature(client Doer, opts *Options) Doer {
+	signer, _ := NewRequestSigner(opts)
+	if signer == nil {
+		return client
+	}
+	return signingClient{Doer: client, signer: signer}
+}
+
+func (c signingClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	c.signer.sign(req)
+	return c.Doer.Do(req, res)
+}
+
+func (c signingClient) Close() error {
+	CloseClient(c.Doer)
+	return nil
+}
+
+// logSignature logs how the requests of a run are signed, if they are.
+func logSignature(opts *Options) {
+	if s, _ := NewRequestSigner(opts); s != nil {
+		if s.format == signSvix {
+			log.Infof("Signature: %s of the body in the %s, %s and %s headers", s.format, s.IDHeader, s.TimestampHeader, s.Header)
+			return
+		}
+		log.Infof("Signature: %s of the body in the %s header", s.format, s.Header)
+	}
+}
@@ -0,0 +1,100 @@
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// TestRequestSigner checks the signatures of every format against the
+// published vectors: RFC 4231 test case 2 for the HMACs, the example of the
+// GitHub webhook documentation and the one of the Svix documentation.
+func TestRequestSigner(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		body string
+		// id and at are the message ID and the time of the svix format
+		id   string
+		at   int64
+		want map[string]string
+	}{
+		{
+			name: "hmac-sha256",
+			opts: Options{SignSecret: "Jefe"},
+			body: "what do ya want for nothing?",
+			want: map[string]string{"X-Signature": "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		},
+		{
+			name: "hmac-sha512",
+			opts: Options{SignSecret: "Jefe", SignFormat: "HMAC-SHA512", SignHeader: "X-Body-Signature"},
+			body: "what do ya want for nothing?",
+			want: map[string]string{"X-Body-Signature": "164b7a7bfcf819e2e395fbe73b56e0a387bd64222e831fd610270cd7ea2505549758bf75c05a994a6d034f65f8f0e6fdcaeab1a34d4a6b4b636e070a38bce737"},
+		},
+		{
+			name: "github",
+			opts: Options{SignSecret: "It's a Secret to Everybody", SignFormat: "github"},
+			body: "Hello, World!",
+			want: map[string]string{"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"},
+		},
+		{
+			name: "svix",
+			opts: Options{SignSecret: "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw", SignFormat: "svix"},
+			body: `{"test": 2432232314}`,
+			id:   "msg_p5jXN8AQM9LWM0D4loKWxJek",
+			at:   1614265330,
+			want: map[string]string{
+				"svix-id":        "msg_p5jXN8AQM9LWM0D4loKWxJek",
+				"svix-timestamp": "1614265330",
+				"svix-signature": "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=",
+			},
+		},
+		{
+			name: "standard webhooks",
+			opts: Options{SignSecret: "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw", SignFormat: "svix", SignHeader: "Webhook-Signature"},
+			body: `{"test": 2432232314}`,
+			id:   "msg_p5jXN8AQM9LWM0D4loKWxJek",
+			at:   1614265330,
+			want: map[string]string{
+				"webhook-id":        "msg_p5jXN8AQM9LWM0D4loKWxJek",
+				"webhook-timestamp": "1614265330",
+				"Webhook-Signature": "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewRequestSigner(&tt.opts)
+			if err != nil {
+				t.Fatalf("NewRequestSigner failed: %v", err)
+			}
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetBodyString(tt.body)
+			s.signMessage(req, tt.id, time.Unix(tt.at, 0))
+			for header, want := range tt.want {
+				if got := string(req.Header.Peek(header)); got != want {
+					t.Errorf("header %s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestSignerSecret(t *testing.T) {
+	if _, err := NewRequestSigner(&Options{SignSecret: "whsec_not base64!", SignFormat: "svix"}); err == nil {
+		t.Errorf("a whsec_ secret that is not base64 was accepted")
+	}
+	// only the svix format decodes whsec_ secrets
+	s, err := NewRequestSigner(&Options{SignSecret: "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"})
+	if err != nil {
+		t.Fatalf("NewRequestSigner failed: %v", err)
+	}
+	if string(s.key) != "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw" {
+		t.Errorf("hmac-sha256 key = %q, want the secret as it is", s.key)
+	}
+	if _, err := NewRequestSigner(&Options{SignSecret: "s3cret", SignFormat: "md5"}); err == nil {
+		t.Errorf("signature format md5 was accepted")
+	}
+}
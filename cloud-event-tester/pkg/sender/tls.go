@@ -0,0 +1,45 @@
+package sender
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig returns the TLS settings of HTTPS targets, nil to keep the
+// defaults of the HTTP stack. A CA bundle replaces the system roots, so
+// targets with certificates of a cluster CA can be verified; a client
+// certificate and key are presented to targets that require mutual TLS.
+func (o *Options) TLSConfig() (*tls.Config, error) {
+	if o.CACert == "" && o.ClientCert == "" && o.ClientKey == "" && o.tlsServerName() == "" && !o.InsecureSkipVerify {
+		return nil, nil
+	}
+	if (o.ClientCert == "") != (o.ClientKey == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.tlsServerName(),
+		InsecureSkipVerify: o.InsecureSkipVerify, //nolint: gosec
+	}
+	if o.CACert != "" {
+		ca, err := os.ReadFile(o.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CACert)
+		}
+		config.RootCAs = pool
+	}
+	if o.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
@@ -0,0 +1,232 @@
+package sender
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+// Reconnects of the WebSocket transport back off from wsMinBackoff to
+// wsMaxBackoff while the target refuses them; sends fail at once meanwhile
+// rather than holding up the send loop with a dial per message.
+const (
+	wsMinBackoff       = 100 * time.Millisecond
+	wsMaxBackoff       = 5 * time.Second
+	wsHandshakeTimeout = 10 * time.Second
+)
+
+// wsConnStats are the stats of one WebSocket connection.
+type wsConnStats struct {
+	target string
+	opened time.Time
+	closed time.Time
+	frames int64
+	bytes  int64
+	err    error
+}
+
+// wsConn is an open WebSocket connection. Its reader handles the control
+// frames of the target and discards data frames; broken is closed when the
+// connection fails.
+type wsConn struct {
+	conn   *websocket.Conn
+	stats  *wsConnStats
+	broken chan struct{}
+	once   sync.Once
+}
+
+func (wc *wsConn) fail(err error) {
+	wc.once.Do(func() {
+		wc.stats.closed = time.Now()
+		wc.stats.err = err
+		close(wc.broken)
+		wc.conn.Close()
+	})
+}
+
+func (wc *wsConn) read() {
+	for {
+		if _, _, err := wc.conn.NextReader(); err != nil {
+			wc.fail(err)
+			return
+		}
+	}
+}
+
+// websocketClient streams the events of a run as text frames over one
+// WebSocket to each target, for consumers that only accept pushed events. It
+// takes the requests the HTTP clients send, so every mode and pacing works the
+// same; the headers of the first request to a target, like custom headers,
+// labels and authentication, are sent with the handshake. A send succeeds when
+// the frame is written, and is answered with 204, as WebSockets have no
+// responses. A broken connection is dialed again on the next send.
+type websocketClient struct {
+	dialer       *websocket.Dialer
+	writeTimeout time.Duration
+	conns        *int64
+
+	// frames are written by one sender at a time
+	mu      sync.Mutex
+	open    map[string]*wsConn
+	retryAt map[string]time.Time
+	backoff map[string]time.Duration
+	// every connection opened, for the stats
+	all        []*wsConn
+	reconnects int
+}
+
+// newWebSocketClient returns the WebSocket client of a run. The TLS settings
+// were checked by validate.
+func newWebSocketClient(opts *Options, conns *int64) *websocketClient {
+	tlsConfig, _ := opts.TLSConfig()
+	return &websocketClient{
+		dialer: &websocket.Dialer{
+			Proxy:            opts.proxyFunc(),
+			NetDialContext:   unixDialContext(NewResolver(opts).dialContext((&net.Dialer{}).DialContext)),
+			HandshakeTimeout: wsHandshakeTimeout,
+			TLSClientConfig:  tlsConfig,
+		},
+		writeTimeout: opts.WriteTimeout,
+		conns:        conns,
+		open:         map[string]*wsConn{},
+		retryAt:      map[string]time.Time{},
+		backoff:      map[string]time.Duration{},
+	}
+}
+
+// wsURL returns the WebSocket URL of a target given as ws, wss, http or https
+// URL.
+func wsURL(target string) string {
+	switch {
+	case strings.HasPrefix(target, "http://"):
+		return "ws://" + strings.TrimPrefix(target, "http://")
+	case strings.HasPrefix(target, "https://"):
+		return "wss://" + strings.TrimPrefix(target, "https://")
+	}
+	return target
+}
+
+func (c *websocketClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	res.Reset()
+	target := wsURL(req.URI().String())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wc, err := c.conn(target, req)
+	if err != nil {
+		return err
+	}
+	if req.Header.IsHead() {
+		// warm-up requests only open the connection
+		res.SetStatusCode(fasthttp.StatusNoContent)
+		return nil
+	}
+	if err := c.write(wc, req.Body()); err != nil {
+		// the target may have closed an idle connection, try a new one once
+		if wc, err = c.conn(target, req); err != nil {
+			return err
+		}
+		if err := c.write(wc, req.Body()); err != nil {
+			return err
+		}
+	}
+	res.SetStatusCode(fasthttp.StatusNoContent)
+	return nil
+}
+
+func (c *websocketClient) write(wc *wsConn, frame []byte) error {
+	if c.writeTimeout > 0 {
+		wc.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)) //nolint: errcheck
+	}
+	if err := wc.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		wc.fail(err)
+		return err
+	}
+	wc.stats.frames++
+	wc.stats.bytes += int64(len(frame))
+	return nil
+}
+
+// conn returns the open connection to target, dialing a new one if there is
+// none or it broke.
+func (c *websocketClient) conn(target string, req *fasthttp.Request) (*wsConn, error) {
+	wc, reconnect := c.open[target]
+	if wc != nil {
+		select {
+		case <-wc.broken:
+		default:
+			return wc, nil
+		}
+	}
+	if retryAt := c.retryAt[target]; time.Now().Before(retryAt) {
+		return nil, fmt.Errorf("reconnecting to %s in %v", target, time.Until(retryAt).Round(time.Millisecond))
+	}
+	header := http.Header{}
+	req.Header.VisitAll(func(k, v []byte) {
+		switch key := string(k); {
+		case HopHeaders[key], strings.EqualFold(key, fasthttp.HeaderContentType), strings.EqualFold(key, fasthttp.HeaderUserAgent):
+		default:
+			header.Add(key, string(v))
+		}
+	})
+	conn, _, err := c.dialer.Dial(target, header)
+	if err != nil {
+		backoff := c.backoff[target] * 2
+		if backoff < wsMinBackoff {
+			backoff = wsMinBackoff
+		} else if backoff > wsMaxBackoff {
+			backoff = wsMaxBackoff
+		}
+		c.backoff[target] = backoff
+		c.retryAt[target] = time.Now().Add(backoff)
+		return nil, fmt.Errorf("failed to open WebSocket to %s: %w", target, err)
+	}
+	delete(c.backoff, target)
+	delete(c.retryAt, target)
+	if c.conns != nil {
+		atomic.AddInt64(c.conns, 1)
+	}
+	wc = &wsConn{conn: conn, stats: &wsConnStats{target: target, opened: time.Now()}, broken: make(chan struct{})}
+	if reconnect {
+		c.reconnects++
+		log.Infof("Reopened WebSocket to %s", target)
+	}
+	c.all = append(c.all, wc)
+	c.open[target] = wc
+	go wc.read()
+	return wc, nil
+}
+
+// Close closes the connections and logs the stats of every connection the
+// client opened.
+func (c *websocketClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, wc := range c.open {
+		wc.conn.WriteControl(websocket.CloseMessage, //nolint: errcheck
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		wc.fail(nil)
+	}
+	for i, wc := range c.all {
+		// the stats of a connection are final once it broke
+		<-wc.broken
+		s := wc.stats
+		state := "closed"
+		if s.err != nil {
+			state = fmt.Sprintf("broken: %v", s.err)
+		}
+		log.Infof("WebSocket %d to %s: %d frames, %d bytes in %v, %s",
+			i+1, s.target, s.frames, s.bytes, s.closed.Sub(s.opened).Round(time.Millisecond), state)
+	}
+	if c.reconnects > 0 {
+		log.Infof("WebSocket connections: %d, %d reconnects", len(c.all), c.reconnects)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Package tester is the command line tool of cloud-event-tester, which is
+// Main, with its subcommands: the daemon and its control APIs, the
+// coordinator of distributed runs, scenarios, sweeps, the mock receiver and
+// the capture importer. The runs themselves are those of the loadgen
+// package, which test harnesses embed instead of running the binary;
+// LoadScenario reads the phases of a scenario file for them:
+//
+//	phases, err := tester.LoadScenario("soak.yaml")
+//	for _, p := range phases {
+//		result, err := loadgen.Run(ctx, &p.Config, nil)
+//		...
+//	}
+package tester
+
+import (
+	"fmt"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// Phase is one phase of a scenario file.
+type Phase struct {
+	Name   string
+	Config loadgen.Config
+}
+
+// LoadScenario returns the phases of a scenario file, or its settings as one
+// phase if it has none. Scenarios with clusters or workloads are run by the
+// run command only.
+func LoadScenario(path string) ([]Phase, error) {
+	s, err := loadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Clusters) > 0 {
+		return nil, fmt.Errorf("scenario %s has clusters, run it with the run command", s.Name)
+	}
+	if len(s.Workloads) > 0 {
+		return nil, fmt.Errorf("scenario %s has workloads, run it with the run command", s.Name)
+	}
+	configs, err := s.phaseConfigs(nil)
+	if err != nil {
+		return nil, err
+	}
+	phases := make([]Phase, len(configs))
+	for i, p := range configs {
+		phases[i] = Phase{Name: p.name, Config: p.cfg}
+	}
+	return phases, nil
+}
@@ -0,0 +1,152 @@
+package tester
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "bench",
+		summary: "Measure the maximum rate of the generator against in-process sinks",
+		run:     runBench,
+	})
+}
+
+// benchResult is one line of the bench output.
+type benchResult struct {
+	name   string
+	result testing.BenchmarkResult
+}
+
+// nullDoer is a sink without a network: it serializes the request like a
+// connection would and answers at once, so sending to it measures the
+// generator alone.
+type nullDoer struct{}
+
+func (nullDoer) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	if _, err := req.WriteTo(io.Discard); err != nil {
+		return err
+	}
+	res.SetStatusCode(fasthttp.StatusNoContent)
+	return nil
+}
+
+// benchSend measures the per-message work of the perf loop sending to url
+// with client.
+func benchSend(client sender.Doer, url string, body []byte) testing.BenchmarkResult {
+	return testing.Benchmark(func(b *testing.B) {
+		req := loadgen.NewEventRequest(url, body, events.ContentMode{}, nil, nil)
+		defer fasthttp.ReleaseRequest(req)
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(res)
+		var sent uint64
+		// the send loop beats its health on every send
+		var health loadgen.RunHealth
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			health.Beat()
+			if err := client.Do(req, res); err != nil {
+				b.Fatal(err)
+			}
+			atomic.AddUint64(&sent, 1)
+		}
+	})
+}
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	eventFile := fs.String("event-file", filepath.Join("data", "TMP0100.json"), "Event file to send")
+	procs := fs.Int("procs", 1, "GOMAXPROCS during the benchmark")
+	stack := fs.String("http-stack", sender.StackFastHTTP, "HTTP stack of the loopback send (fasthttp/nethttp)")
+	var labels map[string]string
+	fs.Var((*events.LabelsFlag)(&labels), "label", "Label key=value to measure the labeling of events with (repeatable)")
+	fs.Parse(args) //nolint: errcheck
+
+	body, err := events.ReadEventFile(*eventFile)
+	if err != nil {
+		return fmt.Errorf("failed to read event file %s: %w", *eventFile, err)
+	}
+	if *procs < 1 {
+		return fmt.Errorf("procs must be at least 1, got %d", *procs)
+	}
+	cfg := loadgen.DefaultConfig()
+	cfg.HTTPStack = *stack
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(*procs))
+
+	// the sinks live in the same process, so their cost is included: the
+	// numbers are a lower bound of what the generator can do
+	sink := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}}
+	mem := fasthttputil.NewInmemoryListener()
+	defer mem.Close()
+	go sink.Serve(mem) //nolint: errcheck
+	loopback, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen on loopback: %w", err)
+	}
+	defer loopback.Close()
+	go sink.Serve(loopback) //nolint: errcheck
+	url := "http://bench/webhook"
+
+	log.Infof("Benchmarking with %s (%d bytes), GOMAXPROCS %d", *eventFile, len(body), *procs)
+	loopbackClient := loadgen.NewHTTPClient(&cfg, nil)
+	defer sender.CloseClient(loopbackClient)
+	results := []benchResult{
+		{"build request", testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				fasthttp.ReleaseRequest(loadgen.NewEventRequest(url, body, events.ContentMode{}, nil, nil))
+			}
+		})},
+	}
+	if len(labels) > 0 {
+		results = append(results, benchResult{"label event", testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				events.LabelEvent(body, labels)
+			}
+		})})
+	}
+	results = append(results,
+		benchResult{"send null", benchSend(nullDoer{}, url, body)},
+		benchResult{"send in-memory", benchSend(&fasthttp.Client{
+			Dial: func(string) (net.Conn, error) { return mem.Dial() },
+		}, url, body)},
+		benchResult{"send loopback", benchSend(loopbackClient, "http://"+loopback.Addr().String()+"/webhook", body)},
+	)
+
+	fmt.Printf("%-16s %12s %12s %10s %10s\n", "BENCHMARK", "NS/OP", "MSG/S", "ALLOCS/OP", "B/OP")
+	for _, r := range results {
+		ns := r.result.NsPerOp()
+		rate := 0.0
+		if ns > 0 {
+			rate = 1e9 / float64(ns)
+		}
+		fmt.Printf("%-16s %12d %12.0f %10d %10d\n", r.name, ns, rate, r.result.AllocsPerOp(), r.result.AllocedBytesPerOp())
+	}
+	if ns := results[len(results)-1].result.NsPerOp(); ns > 0 {
+		fmt.Printf("\nOne send loop reaches about %.0f msg/s over loopback on %d CPU(s). A run well below\n", 1e9/float64(ns), *procs)
+		fmt.Println("that rate is bound by the target or the network, not by the generator.")
+	}
+	return nil
+}
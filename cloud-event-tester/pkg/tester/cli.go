@@ -0,0 +1,119 @@
+package tester
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "send",
+		summary: "Send the event files to the target once, or as they are written with -watch",
+		run: func(args []string) error {
+			return runTestCommand("send", "NO", "perf", perfOnlyFlags, args)
+		},
+	})
+	registerCommand(&command{
+		name:    "perf",
+		summary: "Send the events at a rate for a duration and report the latency and throughput",
+		run: func(args []string) error {
+			return runTestCommand("perf", "YES", "send", basicOnlyFlags, args)
+		},
+	})
+}
+
+// perfOnlyFlags are the flags of performance runs, not accepted by the send
+// command.
+var perfOnlyFlags = map[string]bool{
+	"rate": true, "duration": true, "delay": true, "check-resp": true, "with-msg": true,
+	"shards": true, "publishers": true, "warmup-conns": true, "workers": true,
+	"connections": true, "queue-size": true, "drop-policy": true,
+	"pacing": true, "bucket-size": true, "distribution": true, "load-model": true,
+	"burst-interval": true, "burst-size": true,
+	"spike-duration": true, "spike-every": true, "spike-factor": true,
+	"batch-size": true, "payload-size": true, "event-mix": true, "event-weights": true,
+	"mutations": true, "trace-file": true,
+	"fault-rate": true, "fault-classes": true,
+	"chaos-abort-rate": true, "chaos-delay": true, "chaos-delay-rate": true,
+	"chaos-pause": true, "chaos-pause-every": true, "chaos-truncate-rate": true,
+	"adaptive-rate": true, "adaptive-min-rate": true, "adaptive-step": true,
+	"breaker-failures": true, "breaker-cooldown": true,
+	"health-url": true, "health-interval": true, "summary-interval": true,
+	"soak": true, "soak-interval": true,
+	"trace-context": true, "tracestate": true, "otlp-endpoint": true,
+	"statsd-addr": true, "statsd-format": true, "statsd-prefix": true, "statsd-sample-rate": true,
+	"pushgateway": true, "push-interval": true, "push-job": true, "remote-write": true,
+	"redis-url": true, "global-rate": true, "rate-key": true,
+	"shard-rate": true, "replicas": true, "ordinal": true,
+	"checkpoint-file": true, "checkpoint-interval": true, "resume": true,
+	"find-max": true, "find-max-min": true, "find-max-step": true,
+	"max-p99-ms": true, "min-achieved-rate": true, "results-db-samples": true,
+	"max-errors": true, "max-consecutive-errors": true, "timeseries-file": true,
+	"tui": true, "join": true,
+}
+
+// basicOnlyFlags are the flags of basic runs, not accepted by the perf
+// command.
+var basicOnlyFlags = map[string]bool{
+	"interval": true, "loop": true, "shuffle": true, "watch": true, "watch-dir": true,
+}
+
+// runTestCommand runs the send or perf command: the tests of the default
+// command in the test mode perf, with the flags of the mode only, so a flag
+// of the other mode is an error rather than silently ignored. The default
+// command, with every flag and -perf, stays as the compatibility mode of
+// the two.
+func runTestCommand(name, perf, other string, excluded map[string]bool, args []string) error {
+	cfg := loadgen.DefaultConfig()
+	var mf mainFlags
+	fs := commandFlags(name, &mf, &cfg, excluded)
+	usage := func() {
+		fmt.Printf("Usage: %s %s [options]\n\nOptions:\n", os.Args[0], name)
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	// the errors are returned with a hint, without the usage
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			usage()
+			return nil
+		}
+		flagName := strings.TrimPrefix(err.Error(), "flag provided but not defined: -")
+		switch {
+		case flagName == "perf":
+			return fmt.Errorf("the %s command sets the test mode, it has no -perf flag", name)
+		case excluded[flagName]:
+			return fmt.Errorf("-%s is not a flag of the %s command, run the %s command", flagName, name, other)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%s: unexpected arguments %s", name, strings.Join(fs.Args(), " "))
+	}
+	initLogger(mf.logFormat)
+	if mf.help {
+		usage()
+		return nil
+	}
+	return runTests(&mf, cfg, args, perf)
+}
+
+// commandFlags returns the flag set of a command with the flags of the
+// default command, but the excluded ones and -perf, bound to mf and cfg.
+func commandFlags(name string, mf *mainFlags, cfg *loadgen.Config, excluded map[string]bool) *flag.FlagSet {
+	all := flag.NewFlagSet(name, flag.ContinueOnError)
+	mf.bind(all, cfg)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	all.VisitAll(func(f *flag.Flag) {
+		if f.Name != "perf" && !excluded[f.Name] {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	return fs
+}
@@ -0,0 +1,36 @@
+package tester
+
+import (
+	"fmt"
+	"sort"
+)
+
+// command is a subcommand of the tester, e.g. `cloud-event-tester k8s emit`.
+// Each command parses its own arguments with a dedicated flag set.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]*command{}
+
+func registerCommand(c *command) {
+	commands[c.name] = c
+}
+
+func lookupCommand(name string) (*command, bool) {
+	c, ok := commands[name]
+	return c, ok
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-20s - %s\n", name, commands[name].summary)
+	}
+}
@@ -0,0 +1,156 @@
+package tester
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// exitRegression is the exit code of a comparison that found a regression.
+const exitRegression = 3
+
+// latencyMetrics are the percentiles of a comparison held against
+// -max-latency-rise.
+var latencyMetrics = map[string]bool{"latency.p50": true, "latency.p90": true, "latency.p95": true, "latency.p99": true, "latency.p999": true}
+
+func init() {
+	registerCommand(&command{
+		name:    "compare",
+		summary: "Compare two report files and fail on regressions",
+		run:     runCompare,
+	})
+}
+
+// regressionThresholds are the changes of a current run off its baseline
+// that are regressions. A threshold of 0 is not checked.
+type regressionThresholds struct {
+	// maxRateDrop is the percentage the average rate may drop by
+	maxRateDrop float64
+	// maxErrorRateRise is the percentage points the error rate may rise by
+	maxErrorRateRise float64
+	// maxLatencyRise is the percentage every latency percentile may rise by
+	maxLatencyRise float64
+}
+
+// regression is the error of a comparison that found regressions.
+type regression struct {
+	metrics []string
+}
+
+func (r *regression) Error() string {
+	return "regression: " + strings.Join(r.metrics, "; ")
+}
+
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	var t regressionThresholds
+	fs.Float64Var(&t.maxRateDrop, "max-rate-drop", 5, "Percentage the average rate may drop by (0 to not check)")
+	fs.Float64Var(&t.maxErrorRateRise, "max-error-rate-rise", 1, "Percentage points the error rate may rise by (0 to not check)")
+	fs.Float64Var(&t.maxLatencyRise, "max-latency-rise", 10, "Percentage the latency percentiles may rise by (0 to not check)")
+	asJSON := fs.Bool("json", false, "Print the comparison as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [options] <baseline.json> <current.json>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) //nolint: errcheck
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("compare needs a baseline and a current report file")
+	}
+
+	var reports [2]*loadgen.RunReport
+	for i, path := range fs.Args() {
+		report, err := readReportFile(path)
+		if err != nil {
+			return err
+		}
+		reports[i] = report
+	}
+	c := compare(reports[0], reports[1])
+	c.A, c.B = fs.Arg(0), fs.Arg(1)
+	regressed := t.check(c)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(c) //nolint: errcheck
+	} else {
+		printComparison(c, regressed)
+	}
+	if len(regressed) == 0 {
+		return nil
+	}
+	r := &regression{}
+	for _, d := range c.Metrics {
+		if msg, ok := regressed[d.Metric]; ok {
+			r.metrics = append(r.metrics, msg)
+		}
+	}
+	return r
+}
+
+// readReportFile reads a JSON report file of -report-file, or a report
+// downloaded from a results server.
+func readReportFile(path string) (*loadgen.RunReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report file %s: %w", path, err)
+	}
+	var report loadgen.RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("report file %s is not a JSON report: %w", path, err)
+	}
+	if report.Result == nil {
+		return nil, fmt.Errorf("report file %s has no result", path)
+	}
+	return &report, nil
+}
+
+// check returns the regressions of a comparison by metric.
+func (t regressionThresholds) check(c *reportComparison) map[string]string {
+	regressed := map[string]string{}
+	for _, d := range c.Metrics {
+		switch {
+		case d.Metric == "avgRate" && t.maxRateDrop > 0 && d.A > 0 && -d.Percent > t.maxRateDrop:
+			regressed[d.Metric] = fmt.Sprintf("avgRate dropped by %.1f%%, at most %g%% allowed", -d.Percent, t.maxRateDrop)
+		case d.Metric == "errorRate" && t.maxErrorRateRise > 0 && d.Delta > t.maxErrorRateRise:
+			regressed[d.Metric] = fmt.Sprintf("errorRate rose by %.3f points, at most %g allowed", d.Delta, t.maxErrorRateRise)
+		case latencyMetrics[d.Metric] && t.maxLatencyRise > 0 && d.A > 0 && d.Percent > t.maxLatencyRise:
+			regressed[d.Metric] = fmt.Sprintf("%s rose by %.1f%%, at most %g%% allowed", d.Metric, d.Percent, t.maxLatencyRise)
+		}
+	}
+	return regressed
+}
+
+// printComparison prints a comparison as a table, marking the regressions.
+func printComparison(c *reportComparison, regressed map[string]string) {
+	fmt.Printf("baseline: %s\ncurrent:  %s\n\n", c.A, c.B)
+	fmt.Printf("%-14s %14s %14s %14s %9s\n", "METRIC", "BASELINE", "CURRENT", "DELTA", "PERCENT")
+	for _, d := range c.Metrics {
+		percent := "-"
+		if d.A != 0 {
+			percent = fmt.Sprintf("%+.1f%%", d.Percent)
+		}
+		mark := ""
+		if _, ok := regressed[d.Metric]; ok {
+			mark = "  REGRESSION"
+		}
+		fmt.Printf("%-14s %14.3f %14.3f %+14.3f %9s%s\n", d.Metric, d.A, d.B, d.Delta, percent, mark)
+	}
+}
+
+// exitOnRegression exits with exitRegression if err is a regression.
+func exitOnRegression(err error) {
+	var r *regression
+	if errors.As(err, &r) {
+		log.Error(r)
+		os.Exit(exitRegression)
+	}
+}
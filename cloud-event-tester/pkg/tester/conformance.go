@@ -0,0 +1,329 @@
+package tester
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "conformance",
+		summary: "Grade the responses of a receiver to a suite of valid and invalid cloud events",
+		run:     runConformance,
+	})
+}
+
+// what a conformance case expects of the receiver
+const (
+	// expectAccept is a 2xx response
+	expectAccept = "accept"
+	// expectReject is a 4xx response
+	expectReject = "reject"
+	// expectOptional is a 2xx response, or 415 or 501 from a receiver that
+	// does not support the optional feature
+	expectOptional = "optional"
+	// expectTolerate is any response but a 5xx, or a dropped connection
+	expectTolerate = "tolerate"
+)
+
+// requirement levels of the conformance cases: the suite fails on the MUST
+// cases, and on the SHOULD ones with -strict
+const (
+	levelMust   = "MUST"
+	levelShould = "SHOULD"
+	levelMay    = "MAY"
+)
+
+// conformanceCase is a request of the conformance suite and the response the
+// HTTP protocol binding of CloudEvents 1.0 expects of a receiver.
+type conformanceCase struct {
+	name    string
+	mode    string
+	level   string
+	expect  string
+	headers map[string]string
+	body    string
+}
+
+// conformanceResult is the grade of the response to one case.
+type conformanceResult struct {
+	Case   string `json:"case"`
+	Mode   string `json:"mode"`
+	Level  string `json:"level"`
+	Expect string `json:"expect"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Pass   bool   `json:"pass"`
+	Note   string `json:"note,omitempty"`
+}
+
+// conformanceEvent is a valid structured event of the suite, with extra
+// attributes, and without the attributes of drop.
+func conformanceEvent(extra map[string]interface{}, drop ...string) string {
+	event := map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              events.NewUUID(),
+		"source":          "/cloud-event-tester/conformance",
+		"type":            "com.github.jzding.cloud-event-tester.conformance",
+		"datacontenttype": "application/json",
+		"data":            map[string]interface{}{"check": "conformance"},
+	}
+	for k, v := range extra {
+		event[k] = v
+	}
+	for _, k := range drop {
+		delete(event, k)
+	}
+	data, _ := json.Marshal(event)
+	return string(data)
+}
+
+// binaryHeaders are the ce- headers of a valid binary event, with extra
+// headers, and without the headers of drop.
+func binaryHeaders(extra map[string]string, drop ...string) map[string]string {
+	h := map[string]string{
+		"Content-Type":   "application/json",
+		"ce-specversion": "1.0",
+		"ce-id":          events.NewUUID(),
+		"ce-source":      "/cloud-event-tester/conformance",
+		"ce-type":        "com.github.jzding.cloud-event-tester.conformance",
+	}
+	for k, v := range extra {
+		h[k] = v
+	}
+	for _, k := range drop {
+		delete(h, k)
+	}
+	return h
+}
+
+// conformanceSuite returns the cases of the suite, valid events in every
+// content mode, edge cases and invalid events, with new IDs.
+func conformanceSuite() []conformanceCase {
+	const data = `{"check":"conformance"}`
+	structured := map[string]string{"Content-Type": "application/cloudevents+json"}
+	batch := map[string]string{"Content-Type": events.BatchContentType}
+	large := strings.Repeat("x", 64*1024-64)
+	return []conformanceCase{
+		{name: "binary minimal", mode: events.ContentBinary, level: levelMust, expect: expectAccept,
+			headers: binaryHeaders(nil), body: data},
+		{name: "binary optional attributes", mode: events.ContentBinary, level: levelMust, expect: expectAccept,
+			headers: binaryHeaders(map[string]string{
+				"ce-time":       "2026-01-02T15:04:05.123Z",
+				"ce-subject":    "conformance",
+				"ce-dataschema": "https://example.com/schemas/conformance.json",
+			}), body: data},
+		{name: "binary extension", mode: events.ContentBinary, level: levelMust, expect: expectAccept,
+			headers: binaryHeaders(map[string]string{"ce-comexampleext": "value", "ce-number": "42"}), body: data},
+		{name: "binary percent-encoded value", mode: events.ContentBinary, level: levelMust, expect: expectAccept,
+			headers: binaryHeaders(map[string]string{"ce-subject": "Euro%20%E2%82%AC%20%25"}), body: data},
+		{name: "binary without data", mode: events.ContentBinary, level: levelMust, expect: expectAccept,
+			headers: binaryHeaders(nil, "Content-Type")},
+		{name: "binary missing specversion", mode: events.ContentBinary, level: levelShould, expect: expectReject,
+			headers: binaryHeaders(nil, "ce-specversion"), body: data},
+		{name: "binary missing id", mode: events.ContentBinary, level: levelShould, expect: expectReject,
+			headers: binaryHeaders(nil, "ce-id"), body: data},
+		{name: "binary missing source", mode: events.ContentBinary, level: levelShould, expect: expectReject,
+			headers: binaryHeaders(nil, "ce-source"), body: data},
+		{name: "binary missing type", mode: events.ContentBinary, level: levelShould, expect: expectReject,
+			headers: binaryHeaders(nil, "ce-type"), body: data},
+		{name: "binary unknown specversion", mode: events.ContentBinary, level: levelShould, expect: expectReject,
+			headers: binaryHeaders(map[string]string{"ce-specversion": "0.1"}), body: data},
+		{name: "binary oversized extension", mode: events.ContentBinary, level: levelMay, expect: expectTolerate,
+			headers: binaryHeaders(map[string]string{"ce-oversized": strings.Repeat("x", 16*1024)}), body: data},
+
+		{name: "structured minimal", mode: events.ContentStructured, level: levelMust, expect: expectAccept,
+			headers: structured, body: conformanceEvent(nil, "datacontenttype")},
+		{name: "structured charset", mode: events.ContentStructured, level: levelMust, expect: expectAccept,
+			headers: map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"}, body: conformanceEvent(nil)},
+		{name: "structured optional attributes", mode: events.ContentStructured, level: levelMust, expect: expectAccept,
+			headers: structured, body: conformanceEvent(map[string]interface{}{
+				"time":       "2026-01-02T15:04:05.123Z",
+				"subject":    "conformance",
+				"dataschema": "https://example.com/schemas/conformance.json",
+			})},
+		{name: "structured extensions", mode: events.ContentStructured, level: levelMust, expect: expectAccept,
+			headers: structured, body: conformanceEvent(map[string]interface{}{"comexampleext": "value", "number": 42, "flag": true})},
+		{name: "structured data_base64", mode: events.ContentStructured, level: levelMust, expect: expectAccept,
+			headers: structured, body: conformanceEvent(map[string]interface{}{
+				"datacontenttype": "application/octet-stream",
+				"data_base64":     "Y29uZm9ybWFuY2U=",
+			}, "data")},
+		{name: "structured 64 KiB event", mode: events.ContentStructured, level: levelShould, expect: expectAccept,
+			headers: structured, body: conformanceEvent(map[string]interface{}{"data": large})},
+		{name: "structured malformed JSON", mode: events.ContentStructured, level: levelShould, expect: expectReject,
+			headers: structured, body: `{"specversion":"1.0","id":`},
+		{name: "structured missing id", mode: events.ContentStructured, level: levelShould, expect: expectReject,
+			headers: structured, body: conformanceEvent(nil, "id")},
+		{name: "structured empty id", mode: events.ContentStructured, level: levelShould, expect: expectReject,
+			headers: structured, body: conformanceEvent(map[string]interface{}{"id": ""})},
+		{name: "structured invalid attribute name", mode: events.ContentStructured, level: levelShould, expect: expectReject,
+			headers: structured, body: conformanceEvent(map[string]interface{}{"Bad-Name": "value"})},
+		{name: "structured unsupported format", mode: events.ContentStructured, level: levelShould, expect: expectReject,
+			headers: map[string]string{"Content-Type": "application/cloudevents+xml"}, body: "<event/>"},
+		{name: "structured oversized attribute", mode: events.ContentStructured, level: levelMay, expect: expectTolerate,
+			headers: structured, body: conformanceEvent(map[string]interface{}{"subject": strings.Repeat("x", 256*1024)})},
+
+		{name: "batch of two events", mode: "batch", level: levelMay, expect: expectOptional,
+			headers: batch, body: "[" + conformanceEvent(nil) + "," + conformanceEvent(nil) + "]"},
+		{name: "batch empty", mode: "batch", level: levelMay, expect: expectOptional,
+			headers: batch, body: "[]"},
+		{name: "batch with an invalid event", mode: "batch", level: levelShould, expect: expectReject,
+			headers: batch, body: "[" + conformanceEvent(nil) + "," + conformanceEvent(nil, "type") + "]"},
+	}
+}
+
+func runConformance(args []string) error {
+	cfg := loadgen.DefaultConfig()
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	cfg.BindFlags(fs)
+	strict := fs.Bool("strict", false, "Fail on the SHOULD cases too, not only on the MUST ones")
+	only := fs.String("case", "", "Run the cases whose name contains this text only")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	fs.Parse(args) //nolint: errcheck
+	cfg.ApplyEnv()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if strings.ToLower(cfg.Transport) != sender.TransportHTTP {
+		return fmt.Errorf("the conformance suite grades the HTTP protocol binding, it needs the http transport")
+	}
+
+	var cases []conformanceCase
+	for _, c := range conformanceSuite() {
+		if strings.Contains(c.name, *only) {
+			cases = append(cases, c)
+		}
+	}
+	if len(cases) == 0 {
+		return fmt.Errorf("no conformance case matches %q", *only)
+	}
+	auth, err := sender.NewAuthenticator(&cfg.Options)
+	if err != nil {
+		return err
+	}
+	defer auth.Close()
+	client := sender.WithAuth(loadgen.NewHTTPClient(&cfg, nil), auth)
+	defer sender.CloseClient(client)
+
+	log.Infof("Running %d conformance cases against %s", len(cases), cfg.URL)
+	results := make([]conformanceResult, len(cases))
+	for i, c := range cases {
+		results[i] = sendConformanceCase(client, &cfg, c)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results) //nolint: errcheck
+	} else {
+		printConformance(results)
+	}
+	var failed []string
+	for _, r := range results {
+		if !r.Pass && (r.Level == levelMust || *strict && r.Level == levelShould) {
+			failed = append(failed, r.Case)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d conformance cases failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// sendConformanceCase sends the request of a case and grades the response.
+func sendConformanceCase(client sender.Doer, cfg *loadgen.Config, c conformanceCase) conformanceResult {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	req.Header.SetMethod("POST")
+	req.SetRequestURI(sender.TargetURI(cfg.URL))
+	sender.SetHeaders(req, cfg.Headers)
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if c.headers["Content-Type"] == "" {
+		req.Header.Del("Content-Type")
+	}
+	req.SetBodyString(c.body)
+
+	r := conformanceResult{Case: c.name, Mode: c.mode, Level: c.level, Expect: c.expect}
+	if err := client.Do(req, res); err != nil {
+		r.Error = err.Error()
+		if c.expect == expectTolerate {
+			r.Pass, r.Note = true, "connection dropped"
+		}
+		return r
+	}
+	r.Status = res.StatusCode()
+	r.Pass, r.Note = gradeConformance(c.expect, r.Status)
+	return r
+}
+
+// gradeConformance grades the status of the response to a case.
+func gradeConformance(expect string, status int) (bool, string) {
+	ok, clientError := status >= 200 && status < 300, status >= 400 && status < 500
+	switch expect {
+	case expectAccept:
+		if !ok {
+			return false, "a valid event was not accepted"
+		}
+	case expectReject:
+		if ok {
+			return false, "an invalid event was accepted"
+		}
+		if !clientError {
+			return false, "an invalid event is a client error, expected 4xx"
+		}
+	case expectOptional:
+		if status == fasthttp.StatusUnsupportedMediaType || status == fasthttp.StatusNotImplemented {
+			return true, "not supported"
+		}
+		if !ok {
+			return false, "a valid event was not accepted"
+		}
+	case expectTolerate:
+		if status >= 500 {
+			return false, "a server error instead of accepting or rejecting the event"
+		}
+	}
+	return true, ""
+}
+
+// printConformance prints the results as a pass/fail matrix.
+func printConformance(results []conformanceResult) {
+	passed := 0
+	fmt.Printf("%-34s %-10s %-6s %-8s %6s  %s\n", "CASE", "MODE", "LEVEL", "EXPECT", "STATUS", "RESULT")
+	for _, r := range results {
+		grade := "pass"
+		if r.Pass {
+			passed++
+		} else {
+			grade = "FAIL"
+		}
+		status := fmt.Sprint(r.Status)
+		if r.Status == 0 {
+			status = "-"
+		}
+		note := r.Note
+		if r.Error != "" {
+			note = strings.TrimSpace(note + " " + r.Error)
+		}
+		if note != "" {
+			grade += " (" + note + ")"
+		}
+		fmt.Printf("%-34s %-10s %-6s %-8s %6s  %s\n", r.Case, r.Mode, r.Level, r.Expect, status, grade)
+	}
+	fmt.Printf("%d of %d cases passed\n", passed, len(results))
+}
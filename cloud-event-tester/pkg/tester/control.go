@@ -0,0 +1,231 @@
+package tester
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// daemonSettings are the keys of the run settings the control API cannot
+// set: the commands the tester runs and the files it reads or writes on the
+// host of the daemon, which stay those the daemon was started with. The
+// event file of a run is one of its data directory, see apiEventFile.
+var daemonSettings = []string{
+	"generator", "generatorCommand",
+	"dataDir", "eventWeights", "targetsFile", "mutations",
+	"caCert", "clientCert", "clientKey", "tokenFile", "kubeconfig",
+	"schemaDir", "schemas", "dataSchema",
+	"reportFile", "timeseriesFile", "resultsDb", "junitFile",
+	"captureDir", "traceFile", "checkpointFile",
+}
+
+// decodeRunRequest decodes the settings of a run started over the control
+// API onto the daemon's settings base. It fails if the request sets a
+// setting of the daemon.
+func (d *daemon) decodeRunRequest(r io.Reader, base loadgen.Config) (loadgen.Config, error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&fields); err != nil {
+		return base, err
+	}
+	// the rate is a number of messages per second or a rate string, which
+	// replaces the period of the daemon's rate
+	var (
+		rateSet    bool
+		rate       int
+		ratePeriod time.Duration
+	)
+	for key, raw := range fields {
+		// encoding/json matches the keys regardless of case
+		for _, setting := range daemonSettings {
+			if strings.EqualFold(key, setting) {
+				return base, fmt.Errorf("%s is a setting of the daemon, it cannot be set over the control API", setting)
+			}
+		}
+		if strings.EqualFold(key, "rate") {
+			var err error
+			if rate, ratePeriod, err = loadgen.ParseRateJSON(raw); err != nil {
+				return base, err
+			}
+			rateSet = true
+			delete(fields, key)
+			continue
+		}
+		value, err := apiDuration(key, raw)
+		if err != nil {
+			return base, err
+		}
+		fields[key] = value
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return base, err
+	}
+	cfg := base
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return base, err
+	}
+	if rateSet {
+		cfg.Rate, cfg.RatePeriod = rate, ratePeriod
+	}
+	if cfg.EventFile != base.EventFile {
+		if cfg.EventFile, err = d.apiEventFile(cfg.EventFile); err != nil {
+			return base, err
+		}
+	}
+	return cfg, nil
+}
+
+// durationSettings are the lower case keys of the settings of a run that
+// are a time.Duration, which encoding/json reads and writes in nanoseconds.
+var durationSettings = func() map[string]bool {
+	settings := map[string]bool{}
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(loadgen.Config{})) {
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Type == reflect.TypeOf(time.Duration(0)) && name != "" && name != "-" {
+			settings[strings.ToLower(name)] = true
+		}
+	}
+	return settings
+}()
+
+// apiDuration returns the JSON value of a setting of a run given over the
+// control API, with a duration string like "10s" or "2h" turned into what
+// the setting decodes: the seconds of duration, the whole seconds of delay
+// and the nanoseconds of the time.Duration settings. Other values are
+// returned as they are.
+func apiDuration(key string, raw json.RawMessage) (json.RawMessage, error) {
+	var text string
+	if json.Unmarshal(raw, &text) != nil {
+		return raw, nil
+	}
+	key = strings.ToLower(key)
+	if key != "duration" && key != "delay" && !durationSettings[key] {
+		return raw, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number or a duration like 10s, got %q", key, text)
+	}
+	switch {
+	case key == "duration":
+		return json.Marshal(d.Seconds())
+	case key == "delay" && d%time.Second != 0:
+		return nil, fmt.Errorf("delay must be whole seconds, got %q", text)
+	case key == "delay":
+		return json.Marshal(int(d / time.Second))
+	}
+	return json.Marshal(int64(d))
+}
+
+// apiEventFile returns the path of the event file name of a run started
+// over the control API, which must be a file of the daemon's data
+// directory, empty for all of them.
+func (d *daemon) apiEventFile(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("eventFile must be the name of a file of the data directory %s, got %q", d.cfg.DataDir, name)
+	}
+	return filepath.Join(d.cfg.DataDir, name), nil
+}
+
+// controlToken guards the control APIs of a daemon with a bearer token,
+// which every request must send in its Authorization header. A nil token
+// lets every request through.
+type controlToken struct {
+	token []byte
+}
+
+func newControlToken(token string) *controlToken {
+	if token == "" {
+		return nil
+	}
+	return &controlToken{token: []byte(token)}
+}
+
+// warnOpen warns if a control API listens on more than the loopback
+// interface without a token.
+func (t *controlToken) warnOpen(api, addr string) {
+	if t != nil || addr == "" {
+		return
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil && host != "" {
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return
+		}
+	}
+	log.Warnf("The %s listens on %s without -control-token, anyone who reaches it can start runs", api, addr)
+}
+
+// valid reports whether the Authorization header value carries the token.
+func (t *controlToken) valid(authorization string) bool {
+	if t == nil {
+		return true
+	}
+	got := strings.TrimPrefix(authorization, "Bearer ")
+	return got != authorization && subtle.ConstantTimeCompare([]byte(got), t.token) == 1
+}
+
+// wrap returns h answering the requests without the token with 401.
+func (t *controlToken) wrap(h http.Handler) http.Handler {
+	if t == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.valid(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cloud-event-tester"`)
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serverOptions returns the interceptors failing the RPCs without the token
+// in their authorization metadata with Unauthenticated.
+func (t *controlToken) serverOptions() []grpc.ServerOption {
+	if t == nil {
+		return nil
+	}
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if t.valid(v) {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
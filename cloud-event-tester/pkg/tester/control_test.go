@@ -0,0 +1,132 @@
+package tester
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+func TestDecodeRunRequestRate(t *testing.T) {
+	tests := []struct {
+		body   string
+		rate   int
+		period time.Duration
+	}{
+		// the daemon's rate of 90/m is 3/2s
+		{`{}`, 3, 2 * time.Second},
+		{`{"duration": 5}`, 3, 2 * time.Second},
+		{`{"rate": 50}`, 50, time.Second},
+		{`{"Rate": 50}`, 50, time.Second},
+		{`{"rate": "50"}`, 50, time.Second},
+		{`{"rate": "100/h"}`, 1, 36 * time.Second},
+		{`{"rate": 0.5}`, 1, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		d := newDaemon(loadgen.DefaultConfig())
+		d.cfg.Rate, d.cfg.RatePeriod = 3, 2*time.Second
+		cfg, err := d.decodeRunRequest(strings.NewReader(tt.body), d.cfg.Clone())
+		if err != nil {
+			t.Errorf("decodeRunRequest(%s) failed: %v", tt.body, err)
+			continue
+		}
+		if cfg.Rate != tt.rate || cfg.Period() != tt.period {
+			t.Errorf("decodeRunRequest(%s) has rate %s, want %s", tt.body,
+				loadgen.FormatRate(cfg.Rate, cfg.RatePeriod), loadgen.FormatRate(tt.rate, tt.period))
+		}
+	}
+}
+
+func TestDecodeRunRequestDurations(t *testing.T) {
+	tests := []struct {
+		body  string
+		check func(loadgen.Config) bool
+	}{
+		{`{"duration": 1.5}`, func(c loadgen.Config) bool { return c.Duration == 1.5 }},
+		{`{"duration": "90s"}`, func(c loadgen.Config) bool { return c.Duration == 90 }},
+		{`{"duration": "1m30.5s"}`, func(c loadgen.Config) bool { return c.Duration == 90.5 }},
+		{`{"delay": 3}`, func(c loadgen.Config) bool { return c.Delay == 3 }},
+		{`{"delay": "10s"}`, func(c loadgen.Config) bool { return c.Delay == 10 }},
+		{`{"interval": 2000000000}`, func(c loadgen.Config) bool { return c.Interval == 2*time.Second }},
+		{`{"interval": "2s"}`, func(c loadgen.Config) bool { return c.Interval == 2*time.Second }},
+		{`{"BurstInterval": "500ms"}`, func(c loadgen.Config) bool { return c.BurstInterval == 500*time.Millisecond }},
+		{`{"requestTimeout": "1m"}`, func(c loadgen.Config) bool { return c.RequestTimeout == time.Minute }},
+		// strings of other settings are left alone
+		{`{"checkResp": "MULTI_THREAD"}`, func(c loadgen.Config) bool { return c.CheckResp == "MULTI_THREAD" }},
+	}
+	for _, tt := range tests {
+		d := newDaemon(loadgen.DefaultConfig())
+		cfg, err := d.decodeRunRequest(strings.NewReader(tt.body), d.cfg.Clone())
+		if err != nil {
+			t.Errorf("decodeRunRequest(%s) failed: %v", tt.body, err)
+			continue
+		}
+		if !tt.check(cfg) {
+			t.Errorf("decodeRunRequest(%s) has duration %g, delay %d, interval %v, burstInterval %v, requestTimeout %v",
+				tt.body, cfg.Duration, cfg.Delay, cfg.Interval, cfg.BurstInterval, cfg.RequestTimeout)
+		}
+	}
+	for _, body := range []string{
+		`{"duration": "soon"}`,
+		`{"delay": "1.5s"}`,
+		`{"interval": "10"}`,
+		`{"burstInterval": "fast"}`,
+	} {
+		d := newDaemon(loadgen.DefaultConfig())
+		if _, err := d.decodeRunRequest(strings.NewReader(body), d.cfg.Clone()); err == nil {
+			t.Errorf("decodeRunRequest(%s) succeeded, want an error", body)
+		}
+	}
+}
+
+func TestDecodeRunRequestDaemonSettings(t *testing.T) {
+	for _, body := range []string{
+		`{"generator": "exec", "generatorCommand": "touch /tmp/pwned"}`,
+		`{"GENERATORCOMMAND": "touch /tmp/pwned"}`,
+		`{"reportFile": "/etc/cron.d/report"}`,
+		`{"traceFile": "/tmp/trace"}`,
+		`{"captureDir": "/tmp"}`,
+		`{"checkpointFile": "/tmp/checkpoint"}`,
+		`{"resultsDb": "/tmp/results.db"}`,
+		`{"dataDir": "/etc"}`,
+		`{"eventFile": "/etc/passwd"}`,
+		`{"eventFile": "../../etc/passwd"}`,
+		`{"eventFile": ".."}`,
+		`{"rate": "fast"}`,
+		`not json`,
+	} {
+		d := newDaemon(loadgen.DefaultConfig())
+		if _, err := d.decodeRunRequest(strings.NewReader(body), d.cfg.Clone()); err == nil {
+			t.Errorf("decodeRunRequest(%s) succeeded, want an error", body)
+		}
+	}
+	d := newDaemon(loadgen.DefaultConfig())
+	cfg, err := d.decodeRunRequest(strings.NewReader(`{"eventFile": "TMP0100.json"}`), d.cfg.Clone())
+	if err != nil {
+		t.Fatalf("decodeRunRequest failed: %v", err)
+	}
+	if cfg.EventFile != "data/TMP0100.json" {
+		t.Errorf("eventFile is %q, want the file of the data directory data/TMP0100.json", cfg.EventFile)
+	}
+}
+
+func TestControlToken(t *testing.T) {
+	token := newControlToken("s3cret")
+	for header, want := range map[string]bool{
+		"Bearer s3cret":  true,
+		"Bearer s3cret ": false,
+		"Bearer wrong":   false,
+		"s3cret":         false,
+		"Basic s3cret":   false,
+		"":               false,
+	} {
+		if got := token.valid(header); got != want {
+			t.Errorf("valid(%q) = %v, want %v", header, got, want)
+		}
+	}
+	var none *controlToken
+	if !none.valid("") {
+		t.Errorf("a nil token must let every request through")
+	}
+}
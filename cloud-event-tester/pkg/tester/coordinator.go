@@ -0,0 +1,474 @@
+package tester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "coordinator",
+		summary: "Split a performance run among remote workers and aggregate their results",
+		run:     runCoordinator,
+	})
+}
+
+// coordinatorStartDelay is how long after the last worker joined the workers
+// start sending, so they start together although they learn of the start one
+// after the other.
+const coordinatorStartDelay = 2 * time.Second
+
+// workerAssignment is the part of a distributed run a worker is given when it
+// joins: the settings of the run with its share of the rate, and when to
+// start.
+type workerAssignment struct {
+	ID      string         `json:"id"`
+	Index   int            `json:"index"`
+	Workers int            `json:"workers"`
+	StartAt time.Time      `json:"startAt"`
+	Config  loadgen.Config `json:"config"`
+}
+
+// workerReport is what a worker posts when its part of the run finished. The
+// latency histogram is sent in full, so the coordinator can compute the
+// percentiles of the whole run rather than averaging those of the workers.
+type workerReport struct {
+	Result    *report.Result         `json:"result,omitempty"`
+	Histogram *hdrhistogram.Snapshot `json:"histogram,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// workerResult is the outcome of one worker in the report of a distributed
+// run.
+type workerResult struct {
+	ID     string         `json:"id"`
+	Name   string         `json:"name"`
+	Rate   int            `json:"rate"`
+	Result *report.Result `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// distributedReport is the report of a distributed run: the aggregate result
+// of all workers and the result of each.
+type distributedReport struct {
+	Config  loadgen.Config `json:"config"`
+	Result  *report.Result `json:"result"`
+	Workers []workerResult `json:"workers"`
+}
+
+// coordinator hands out the parts of a distributed run to the workers that
+// join it and collects their results. The run starts once the expected
+// number of workers joined; it ends when all of them reported.
+type coordinator struct {
+	cfg    loadgen.Config
+	expect int
+
+	mu      sync.Mutex
+	workers []workerResult
+	hists   []*hdrhistogram.Histogram
+	startAt time.Time
+	// started is closed when the last worker joined, reported when all
+	// reported
+	started  chan struct{}
+	reported chan struct{}
+	pending  int
+}
+
+func newCoordinator(cfg loadgen.Config, expect int) *coordinator {
+	return &coordinator{
+		cfg:      cfg,
+		expect:   expect,
+		started:  make(chan struct{}),
+		reported: make(chan struct{}),
+		pending:  expect,
+	}
+}
+
+func runCoordinator(args []string) error {
+	cfg := loadgen.DefaultConfig()
+	fs := flag.NewFlagSet("coordinator", flag.ExitOnError)
+	cfg.BindFlags(fs)
+	addr := fs.String("addr", ":8091", "Listen address the workers join")
+	expect := fs.Int("expect", 0, "Workers to wait for before the run starts")
+	output := fs.String("output", "", "File to write the report of the run and each worker to")
+	fs.Parse(args) //nolint: errcheck
+	cfg.ApplyEnv()
+	if envAddr := os.Getenv("COORDINATOR_ADDR"); envAddr != "" {
+		*addr = envAddr
+	}
+	if envExpect := os.Getenv("EXPECT_WORKERS"); envExpect != "" {
+		if n, err := strconv.Atoi(envExpect); err == nil {
+			*expect = n
+		}
+	}
+	if *expect <= 0 {
+		return fmt.Errorf("set the number of workers to wait for with -expect")
+	}
+	cfg.Perf = "YES"
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Continuous() {
+		return fmt.Errorf("the workers of a distributed run need a duration")
+	}
+	if cfg.FindMax != "" {
+		return fmt.Errorf("find-max probes the rates in one process, it cannot be distributed")
+	}
+	if cfg.Rate < *expect || cfg.BurstSize > 0 && cfg.BurstSize < *expect {
+		return fmt.Errorf("rate %d is too low to be split among %d workers", cfg.Rate, *expect)
+	}
+
+	c := newCoordinator(cfg, *expect)
+	srv := &http.Server{Addr: *addr, Handler: c.handler()}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Coordinator stopped: %v", err)
+		}
+	}()
+	defer srv.Close()
+	log.Infof("Coordinator listening on %s, waiting for %d workers", *addr, *expect)
+
+	ctx, stop := signalContext()
+	defer stop()
+	select {
+	case <-c.reported:
+	case <-ctx.Done():
+		log.Warnf("Coordinator interrupted, reporting the workers that finished")
+	}
+
+	rep := c.report()
+	result := rep.Result
+	loadgen.PublishReport(&cfg, result)
+	loadgen.WriteReportFile(&cfg, result)
+	loadgen.WriteTimeseriesFile(&cfg, result)
+	loadgen.WriteJUnitFile(&cfg, result)
+	loadgen.WriteResultsDB(&cfg, result)
+	loadgen.NotifyCompletion(&cfg, result, nil)
+	if *output != "" {
+		data, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			return err
+		}
+		log.Infof("Writing report to %s", *output)
+		if err := os.WriteFile(*output, data, 0644); err != nil {
+			return err
+		}
+	}
+	var sla loadgen.SLAViolation
+	sla.AddViolations(result)
+	return sla.Err()
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/join", c.handleJoin)
+	mux.HandleFunc("/results/", c.handleResults)
+	return mux
+}
+
+// handleJoin serves POST /join?name=. It holds the request until all workers
+// joined and answers with the assignment of the worker.
+func (c *coordinator) handleJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	c.mu.Lock()
+	index := len(c.workers)
+	if index >= c.expect {
+		c.mu.Unlock()
+		http.Error(w, fmt.Sprintf("all %d workers joined", c.expect), http.StatusConflict)
+		return
+	}
+	id := loadgen.NewRunID()
+	c.workers = append(c.workers, workerResult{ID: id, Name: name})
+	c.hists = append(c.hists, nil)
+	log.Infof("Worker %s joined (%d/%d)", name, index+1, c.expect)
+	if index+1 == c.expect {
+		c.startAt = time.Now().Add(coordinatorStartDelay)
+		c.assignRates()
+		close(c.started)
+		log.Infof("All workers joined, starting at %s", c.startAt.Format(time.RFC3339Nano))
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.started:
+	case <-r.Context().Done():
+		c.leave(id, name)
+		return
+	}
+	c.mu.Lock()
+	index = c.index(id)
+	wr := c.workers[index]
+	a := workerAssignment{ID: wr.ID, Index: index, Workers: c.expect, StartAt: c.startAt, Config: c.workerConfig(index, wr)}
+	c.mu.Unlock()
+	writeJSON(w, http.StatusOK, a)
+}
+
+// leave frees the slot of a worker that gave up waiting for the run to
+// start, so another can take it. Once the run started the slot stays taken.
+func (c *coordinator) leave(id, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.started:
+		return
+	default:
+	}
+	i := c.index(id)
+	c.workers = append(c.workers[:i], c.workers[i+1:]...)
+	c.hists = c.hists[:len(c.workers)]
+	log.Warnf("Worker %s left before the run started (%d/%d)", name, len(c.workers), c.expect)
+}
+
+// index returns the index of the worker with the given ID, -1 if none.
+func (c *coordinator) index(id string) int {
+	for i, wr := range c.workers {
+		if wr.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// assignRates splits the rate among the workers like among replicas, the
+// remainder going to the first ones.
+func (c *coordinator) assignRates() {
+	for i, rate := range loadgen.ShardRates(c.cfg.Rate, c.expect) {
+		c.workers[i].Rate = rate
+	}
+}
+
+// workerConfig returns the settings of the part of the run of a worker. The
+// reports of the run are the coordinator's, so the workers only send.
+func (c *coordinator) workerConfig(index int, wr workerResult) loadgen.Config {
+	cfg := c.cfg.Clone()
+	cfg.Rate = wr.Rate
+	if cfg.BurstSize > 0 {
+		cfg.BurstSize = loadgen.ShardRates(c.cfg.BurstSize, c.expect)[index]
+	}
+	cfg.Delay = 0
+	cfg.ShardRate = false
+	cfg.ResultsServer, cfg.ReportFile, cfg.TimeseriesFile, cfg.JUnitFile, cfg.NotifyURL = "", "", "", "", ""
+	cfg.ResultsDB, cfg.ResultsDBSamples = "", 0
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	cfg.Labels["worker"] = wr.Name
+	return cfg
+}
+
+// handleResults serves POST /results/{id}, the report of a worker.
+func (c *coordinator) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/results/")
+	var rep workerReport
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+		http.Error(w, fmt.Sprintf("invalid worker report: %v", err), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i := c.index(id); i >= 0 {
+		wr := &c.workers[i]
+		if wr.Result != nil || wr.Error != "" {
+			http.Error(w, "worker already reported", http.StatusConflict)
+			return
+		}
+		wr.Result, wr.Error = rep.Result, rep.Error
+		if rep.Histogram != nil {
+			c.hists[i] = hdrhistogram.Import(rep.Histogram)
+		}
+		if wr.Error != "" {
+			log.Errorf("Worker %s failed: %s", wr.Name, wr.Error)
+		} else if wr.Result != nil {
+			log.Infof("Worker %s finished: %d msg at %.2f msg/s", wr.Name, wr.Result.TotalMsg, wr.Result.AvgRate)
+		}
+		if c.pending--; c.pending == 0 {
+			close(c.reported)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Error(w, fmt.Sprintf("unknown worker %s", id), http.StatusNotFound)
+}
+
+// report aggregates the results of the workers that reported into the result
+// of the whole run: counters and rates add up, the latency histograms are
+// merged and the timelines added second by second. The checks and SLA
+// thresholds are evaluated on the aggregate.
+func (c *coordinator) report() *distributedReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agg := &report.Result{Mode: "perf", Labels: c.cfg.Labels, LoadModel: strings.ToLower(c.cfg.LoadModel)}
+	latency := report.NewLatencyHistogram()
+	for i, wr := range c.workers {
+		res := wr.Result
+		if res == nil {
+			if wr.Error == "" {
+				log.Warnf("Worker %s did not report", wr.Name)
+			}
+			agg.Interrupted = true
+			continue
+		}
+		if agg.StartTime.IsZero() || res.StartTime.Before(agg.StartTime) {
+			agg.StartTime = res.StartTime
+		}
+		if res.EndTime.After(agg.EndTime) {
+			agg.EndTime = res.EndTime
+		}
+		if res.TotalSeconds > agg.TotalSeconds {
+			agg.TotalSeconds = res.TotalSeconds
+		}
+		agg.TotalMsg += res.TotalMsg
+		agg.AvgRate += res.AvgRate
+		agg.BatchSize = res.BatchSize
+		agg.Events += res.Events
+		agg.EventRate += res.EventRate
+		agg.RequestedRate += res.RequestedRate
+		agg.DueMsg += res.DueMsg
+		agg.OverDelivered += res.OverDelivered
+		agg.Skipped += res.Skipped
+		agg.Connections += res.Connections
+		agg.Interrupted = agg.Interrupted || res.Interrupted
+		agg.SchemaViolations += res.SchemaViolations
+		for kind, n := range res.Errors {
+			if agg.Errors == nil {
+				agg.Errors = map[string]int{}
+			}
+			agg.Errors[kind] += n
+		}
+		for kind, n := range res.AssertionFailures {
+			if agg.AssertionFailures == nil {
+				agg.AssertionFailures = map[string]int{}
+			}
+			agg.AssertionFailures[kind] += n
+		}
+		for code, n := range res.StatusCodes {
+			if agg.StatusCodes == nil {
+				agg.StatusCodes = map[string]int{}
+			}
+			agg.StatusCodes[code] += n
+		}
+		for _, f := range res.Faults {
+			agg.Faults = loadgen.AddFaultStats(agg.Faults, f)
+		}
+		for _, t := range res.Timeline {
+			for len(agg.Timeline) < t.Second {
+				agg.Timeline = append(agg.Timeline, report.TickStats{Second: len(agg.Timeline) + 1})
+			}
+			at := &agg.Timeline[t.Second-1]
+			at.Sent += t.Sent
+			at.TotalMsg += t.TotalMsg
+			at.QueueDepth += t.QueueDepth
+			at.Errors += t.Errors
+			at.Rate += t.Rate
+		}
+		if c.hists[i] != nil {
+			latency.Merge(c.hists[i])
+		}
+	}
+	agg.ErrorRate = loadgen.ErrorRate(c.cfg.CheckResp, agg.TotalMsg, agg.FailedSends())
+	agg.Latency = report.SummarizeLatency(latency)
+	log.Infof("=== Distributed Run: %d workers ===", len(c.workers))
+	log.Infof("Total Msg Sent: %d", agg.TotalMsg)
+	agg.Latency.Log()
+	log.Infof("Average Msg/Second: %2.2f", agg.AvgRate)
+	agg.Checks = append(loadgen.PerfChecks(&c.cfg, agg, c.cfg.RedisURL != ""), loadgen.SLAChecks(&c.cfg, agg)...)
+	loadgen.LogChecks(agg)
+	return &distributedReport{Config: c.cfg.Redacted(), Result: agg, Workers: append([]workerResult(nil), c.workers...)}
+}
+
+// runWorker joins the coordinator of a distributed run, sends its part of the
+// run and reports the result back. Secrets are not part of the assignment;
+// the worker sends with its own, from cfg.
+func runWorker(ctx context.Context, coordinatorURL string, cfg *loadgen.Config) error {
+	coordinatorURL = strings.TrimRight(coordinatorURL, "/")
+	name, _ := os.Hostname()
+	name = fmt.Sprintf("%s-%d", name, os.Getpid())
+	log.Infof("Joining coordinator %s as %s", coordinatorURL, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, coordinatorURL+"/join?name="+name, nil)
+	if err != nil {
+		return err
+	}
+	// the coordinator answers once all workers joined
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to join coordinator: %w", err)
+	}
+	defer sender.DrainBody(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body) //nolint: errcheck
+		return fmt.Errorf("coordinator refused to join: %s: %s", resp.Status, strings.TrimSpace(msg.String()))
+	}
+	var a workerAssignment
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return fmt.Errorf("malformed assignment: %w", err)
+	}
+	part := a.Config
+	part.BearerToken, part.OAuthClientSecret, part.KafkaPassword = cfg.BearerToken, cfg.OAuthClientSecret, cfg.KafkaPassword
+	log.Infof("Worker %d of %d: %s msg/sec, starting in %v", a.Index+1, a.Workers, part.RateText(), time.Until(a.StartAt).Round(time.Millisecond))
+	select {
+	case <-time.After(time.Until(a.StartAt)):
+	case <-ctx.Done():
+	}
+
+	var rep workerReport
+	result, err := loadgen.Run(ctx, &part, nil)
+	if err != nil {
+		rep.Error = err.Error()
+	} else {
+		rep.Result = result
+		if result.LatencyHistogram != nil {
+			rep.Histogram = result.LatencyHistogram.Export()
+		}
+	}
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	// the run may have been cancelled, the report should still reach the
+	// coordinator
+	postCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	post, err := http.NewRequestWithContext(postCtx, http.MethodPost, coordinatorURL+"/results/"+a.ID, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	post.Header.Set("Content-Type", "application/json")
+	presp, err := http.DefaultClient.Do(post)
+	if err != nil {
+		return fmt.Errorf("failed to report to coordinator: %w", err)
+	}
+	sender.DrainBody(presp.Body)
+	if presp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("coordinator rejected the report: %s", presp.Status)
+	}
+	log.Infof("Reported to coordinator %s", coordinatorURL)
+	if rep.Error != "" {
+		return fmt.Errorf("%s", rep.Error)
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "daemon",
+		summary: "Run as a long-lived sidecar that waits for remote triggers",
+		run:     runDaemon,
+	})
+}
+
+// run states reported by the control API
+const (
+	runStateRunning   = "running"
+	runStateCompleted = "completed"
+	runStateFailed    = "failed"
+	runStateCancelled = "cancelled"
+)
+
+// run is a test run started through the control API.
+type run struct {
+	ID      string         `json:"id"`
+	State   string         `json:"state"`
+	Config  loadgen.Config `json:"config"`
+	Created time.Time      `json:"created"`
+	Result  *report.Result `json:"result,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	// Live are the stats of the latest second of a performance run
+	Live *report.TickStats `json:"live,omitempty"`
+
+	cancel context.CancelFunc
+	subs   map[chan report.TickStats]struct{}
+	done   chan struct{}
+}
+
+var (
+	errRunActive     = errors.New("a run is already in progress")
+	errInvalidConfig = errors.New("invalid run config")
+	errRunNotFound   = errors.New("run not found")
+	errRunFinished   = errors.New("run already finished")
+)
+
+// daemon keeps the tester idle until a run is started over its control API.
+// Only one run is active at a time; finished runs are kept for inspection.
+type daemon struct {
+	cfg loadgen.Config
+	// probes are the health probes the runs report to, see
+	// startMetricsServer
+	probes *loadgen.HealthProbes
+
+	mu     sync.Mutex
+	runs   map[string]*run
+	active *run
+}
+
+type daemonStatus struct {
+	State string `json:"state"`
+	Last  *run   `json:"last,omitempty"`
+}
+
+func newDaemon(cfg loadgen.Config) *daemon {
+	return &daemon{cfg: cfg, runs: map[string]*run{}}
+}
+
+func runDaemon(args []string) error {
+	d := newDaemon(loadgen.DefaultConfig())
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	d.cfg.BindFlags(fs)
+	controlAddr := fs.String("control-addr", "127.0.0.1:8089", "Listen address of the control API")
+	controlToken := fs.String("control-token", "", "Bearer token the requests to the control APIs must send (none if empty)")
+	grpcAddr := fs.String("grpc-addr", "", "Listen address of the gRPC control API (disabled if empty)")
+	metricsAddr := fs.String("metrics-addr", "", "Listen address of the health and metrics endpoints (disabled if empty)")
+	scheduleFile := fs.String("schedule", "", "Schedule file of recurring runs")
+	fs.Parse(args) //nolint: errcheck
+	d.cfg.ApplyEnv()
+	if envControlAddr := os.Getenv("CONTROL_ADDR"); envControlAddr != "" {
+		*controlAddr = envControlAddr
+	}
+	if envControlToken := os.Getenv("CONTROL_TOKEN"); envControlToken != "" {
+		*controlToken = envControlToken
+	}
+	if envGRPCAddr := os.Getenv("GRPC_ADDR"); envGRPCAddr != "" {
+		*grpcAddr = envGRPCAddr
+	}
+	if envMetricsAddr := os.Getenv("METRICS_ADDR"); envMetricsAddr != "" {
+		*metricsAddr = envMetricsAddr
+	}
+	d.probes = startMetricsServer(*metricsAddr)
+	if envScheduleFile := os.Getenv("SCHEDULE_FILE"); envScheduleFile != "" {
+		*scheduleFile = envScheduleFile
+	}
+	var sched *schedule
+	if *scheduleFile != "" {
+		var err error
+		if sched, err = loadSchedule(*scheduleFile); err != nil {
+			return err
+		}
+	}
+
+	token := newControlToken(*controlToken)
+	token.warnOpen("control API", *controlAddr)
+	token.warnOpen("gRPC control API", *grpcAddr)
+	srv := &http.Server{Addr: *controlAddr, Handler: token.wrap(d.handler())}
+	var grpcSrv *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		grpcSrv = newGRPCServer(d, token.serverOptions()...)
+		go func() {
+			log.Infof("gRPC control API listening on %s", *grpcAddr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Errorf("gRPC server stopped: %v", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if sched != nil {
+		go sched.run(ctx, d)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Infof("Shutting down daemon")
+		cancel()
+		d.stopActive()
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+		srv.Close()
+	}()
+
+	// an idle daemon is ready to accept runs
+	d.probes.SetReady(true)
+	log.Infof("Daemon idle, waiting for runs on %s", *controlAddr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/runs", d.handleRuns)
+	mux.HandleFunc("/runs/", d.handleRun)
+	mux.HandleFunc("/trigger", d.handleTrigger)
+	mux.HandleFunc("/stop", d.handleStop)
+	mux.HandleFunc("/status", d.handleStatus)
+	return mux
+}
+
+// start launches a run with the given settings in the background. It fails
+// if the settings are invalid or another run is in progress.
+func (d *daemon) start(cfg loadgen.Config) (*run, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active != nil {
+		return nil, fmt.Errorf("%w: %s", errRunActive, d.active.ID)
+	}
+	cfg.RateDial = loadgen.NewRateDial(&cfg)
+	ctx, cancel := context.WithCancel(loadgen.WithHealthProbes(context.Background(), d.probes))
+	r := &run{
+		ID:      loadgen.NewRunID(),
+		State:   runStateRunning,
+		Config:  cfg,
+		Created: time.Now(),
+		cancel:  cancel,
+		subs:    map[chan report.TickStats]struct{}{},
+		done:    make(chan struct{}),
+	}
+	d.runs[r.ID] = r
+	d.active = r
+	go func() {
+		log.Infof("Run %s started", r.ID)
+		result, err := loadgen.Run(ctx, &cfg, func(stats report.TickStats) { d.publish(r, stats) })
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		defer close(r.done)
+		d.active = nil
+		r.Result = result
+		for sub := range r.subs {
+			close(sub)
+		}
+		r.subs = nil
+		switch {
+		case err != nil:
+			log.Errorf("Run %s failed: %v", r.ID, err)
+			r.State = runStateFailed
+			r.Error = err.Error()
+		case ctx.Err() != nil:
+			r.State = runStateCancelled
+		default:
+			r.State = runStateCompleted
+		}
+		log.Infof("Run %s %s, daemon idle", r.ID, r.State)
+	}()
+	return r.snapshot(), nil
+}
+
+// publish hands the stats of a run to its subscribers. Slow subscribers
+// miss updates rather than holding up the run.
+func (d *daemon) publish(r *run, stats report.TickStats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r.Live = &stats
+	for sub := range r.subs {
+		select {
+		case sub <- stats:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving the per-second stats of a run. The
+// channel is closed when the run finishes.
+func (d *daemon) subscribe(id string) (<-chan report.TickStats, func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.runs[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errRunNotFound, id)
+	}
+	sub := make(chan report.TickStats, 16)
+	if r.subs == nil {
+		// already finished
+		close(sub)
+		return sub, func() {}, nil
+	}
+	r.subs[sub] = struct{}{}
+	unsubscribe := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := r.subs[sub]; ok {
+			delete(r.subs, sub)
+			close(sub)
+		}
+	}
+	return sub, unsubscribe, nil
+}
+
+// cancelRun stops the given run if it is still in progress.
+func (d *daemon) cancelRun(id string) (*run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.runs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errRunNotFound, id)
+	}
+	if r.State == runStateRunning {
+		r.cancel()
+	}
+	return r.snapshot(), nil
+}
+
+// setRate changes the rate of the given run to rate messages per period
+// while it is in progress.
+func (d *daemon) setRate(id string, rate int, period time.Duration) (*run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.runs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errRunNotFound, id)
+	}
+	if r.State != runStateRunning {
+		return nil, fmt.Errorf("%w: %s", errRunFinished, id)
+	}
+	if err := r.Config.ValidateRateChange(rate, period); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	r.Config.RateDial.Set(rate)
+	r.Config.Rate = rate
+	return r.snapshot(), nil
+}
+
+func (d *daemon) stopActive() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active != nil {
+		d.active.cancel()
+	}
+}
+
+func (d *daemon) get(id string) (*run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.runs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errRunNotFound, id)
+	}
+	return r.snapshot(), nil
+}
+
+func (d *daemon) list() []*run {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	runs := make([]*run, 0, len(d.runs))
+	for _, r := range d.runs {
+		runs = append(runs, r.snapshot())
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Created.Before(runs[j].Created) })
+	return runs
+}
+
+func (d *daemon) status() daemonStatus {
+	runs := d.list()
+	s := daemonStatus{State: "idle"}
+	if len(runs) > 0 {
+		s.Last = runs[len(runs)-1]
+		if s.Last.State == runStateRunning {
+			s.State = runStateRunning
+		}
+	}
+	return s
+}
+
+// snapshot returns a copy of the run that is safe to encode outside the lock.
+func (r *run) snapshot() *run {
+	c := *r
+	c.Config = r.Config.Redacted()
+	c.subs = nil
+	return &c
+}
+
+// handleRuns serves POST /runs and GET /runs.
+func (d *daemon) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, d.list())
+	case http.MethodPost:
+		// settings in the body override the daemon's configured settings
+		cfg, err := d.decodeRunRequest(r.Body, d.cfg.Clone())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid run config: %v", err), http.StatusBadRequest)
+			return
+		}
+		d.respondStart(w, cfg)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRun serves GET /runs/{id}, PATCH /runs/{id} and DELETE /runs/{id}.
+func (d *daemon) handleRun(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/runs/")
+	var (
+		found *run
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		found, err = d.get(id)
+	case http.MethodPatch:
+		// the rate is the only setting changed while a run is in progress
+		var change struct {
+			Rate json.RawMessage `json:"rate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil || change.Rate == nil {
+			http.Error(w, "invalid change, expected {\"rate\": <msg/s>}", http.StatusBadRequest)
+			return
+		}
+		rate, period, rateErr := loadgen.ParseRateJSON(change.Rate)
+		if rateErr != nil {
+			http.Error(w, rateErr.Error(), http.StatusBadRequest)
+			return
+		}
+		found, err = d.setRate(id, rate, period)
+	case http.MethodDelete:
+		found, err = d.cancelRun(id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, errInvalidConfig):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errRunFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+// handleTrigger starts a run with the daemon's configured settings.
+func (d *daemon) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.respondStart(w, d.cfg)
+}
+
+func (d *daemon) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.stopActive()
+	writeJSON(w, http.StatusOK, d.status())
+}
+
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.status())
+}
+
+func (d *daemon) respondStart(w http.ResponseWriter, cfg loadgen.Config) {
+	started, err := d.start(cfg)
+	switch {
+	case errors.Is(err, errInvalidConfig):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+started.ID)
+	writeJSON(w, http.StatusCreated, started)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package tester
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// deliveryMeter measures the one-way delivery latency of the events stamped
+// with their send time by -send-time: the time from the send to the receipt
+// of an event, across any brokers and relays in between. It needs the clocks
+// of the sender and the receiver to agree, or the send times to be on the
+// clock of the receiver with -clock-sync-url, or the offset of the clocks to
+// be known; events received before they were sent are counted as skewed
+// rather than recorded.
+type deliveryMeter struct {
+	// offset is the offset of the clock of the receiver from that of the
+	// sender
+	offset time.Duration
+	mu     sync.Mutex
+	// total is the whole run of the receiver, second the last second
+	total, second *hdrhistogram.Histogram
+	skewed        int64
+}
+
+func newDeliveryMeter(offset time.Duration) *deliveryMeter {
+	return &deliveryMeter{offset: offset, total: report.NewLatencyHistogram(), second: report.NewLatencyHistogram()}
+}
+
+// observe records the delivery latency of a structured event, if it has a
+// send time.
+func (m *deliveryMeter) observe(ev map[string]interface{}, received time.Time) {
+	if s, ok := ev[events.SendTimeAttr].(string); ok {
+		m.record(s, received)
+	}
+}
+
+// observeHeaders records the delivery latency of a binary event, if it has a
+// send time.
+func (m *deliveryMeter) observeHeaders(req *fasthttp.Request, received time.Time) {
+	if s := req.Header.Peek("Ce-" + events.SendTimeAttr); len(s) > 0 {
+		m.record(string(s), received)
+	}
+}
+
+func (m *deliveryMeter) record(sendTime string, received time.Time) {
+	if m == nil {
+		return
+	}
+	sent, err := time.Parse(time.RFC3339Nano, sendTime)
+	if err != nil {
+		return
+	}
+	d := received.Sub(sent) - m.offset
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d < 0 {
+		m.skewed++
+		return
+	}
+	report.RecordLatency(m.total, d)
+	report.RecordLatency(m.second, d)
+}
+
+// tick returns the stats of the last second and starts the next one, nil if
+// no stamped event arrived in it.
+func (m *deliveryMeter) tick() *report.LatencyStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := report.SummarizeLatency(m.second)
+	m.second.Reset()
+	return stats
+}
+
+// logSummary logs the delivery latency of all stamped events received.
+func (m *deliveryMeter) logSummary() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l := report.SummarizeLatency(m.total); l != nil {
+		log.Infof("Delivery latency (ms) of %d events: min %.3f p50 %.3f p90 %.3f p95 %.3f p99 %.3f p99.9 %.3f max %.3f",
+			l.Count, l.Min, l.P50, l.P90, l.P95, l.P99, l.P999, l.Max)
+		log.Infof("Delivery latency (ms) mean %.3f stddev %.3f", l.Mean, l.StdDev)
+	}
+	if m.skewed > 0 {
+		log.Warnf("%d events were received before they were sent, the clocks of the sender and the receiver disagree", m.skewed)
+	}
+}
@@ -0,0 +1,268 @@
+package tester
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// expectationsFile is the expectations file of a receiver: the events it
+// must receive. The windows of the expectations start with the first event
+// received; the receiver stops once all of them have passed, or after
+// Timeout from its start if events are late or never arrive.
+type expectationsFile struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	Expectations []expectation `yaml:"expectations"`
+}
+
+// expectation is the number of cloud events of a type and source a
+// receiver must receive Within the window, all events if it is 0, and the
+// attributes every one of them must have. Type and Source match exactly or,
+// ending with *, by prefix; empty they match any. Count is the exact
+// number, Min and Max bound it; with none of them set at least one event
+// is expected.
+type expectation struct {
+	Name       string        `yaml:"name"`
+	Type       string        `yaml:"type"`
+	Source     string        `yaml:"source"`
+	Count      *int          `yaml:"count"`
+	Min        *int          `yaml:"min"`
+	Max        *int          `yaml:"max"`
+	Within     time.Duration `yaml:"within"`
+	Attributes []string      `yaml:"attributes"`
+
+	// received counts the matching events in the window, late those after
+	// it and missing the events without each of the attributes
+	received, late int
+	missing        map[string]int
+}
+
+// loadExpectations reads and checks an expectations file.
+func loadExpectations(path string) (*expectationSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f expectationsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid expectations file %s: %w", path, err)
+	}
+	if len(f.Expectations) == 0 {
+		return nil, fmt.Errorf("expectations file %s has no expectations", path)
+	}
+	if f.Timeout < 0 {
+		return nil, fmt.Errorf("expectations file %s: timeout must not be negative, got %v", path, f.Timeout)
+	}
+	for i := range f.Expectations {
+		e := &f.Expectations[i]
+		if e.Name == "" {
+			e.Name = fmt.Sprintf("expectation %d", i+1)
+		}
+		switch {
+		case e.Count != nil && (e.Min != nil || e.Max != nil):
+			return nil, fmt.Errorf("%s: count is the exact number, it cannot be combined with min or max", e.Name)
+		case e.Count != nil && *e.Count < 0, e.Min != nil && *e.Min < 0, e.Max != nil && *e.Max < 0:
+			return nil, fmt.Errorf("%s: the counts must not be negative", e.Name)
+		case e.Min != nil && e.Max != nil && *e.Min > *e.Max:
+			return nil, fmt.Errorf("%s: min %d is above max %d", e.Name, *e.Min, *e.Max)
+		case e.Within < 0:
+			return nil, fmt.Errorf("%s: within must not be negative, got %v", e.Name, e.Within)
+		}
+		if e.Count == nil && e.Min == nil && e.Max == nil {
+			one := 1
+			e.Min = &one
+		}
+		e.missing = map[string]int{}
+	}
+	return &expectationSet{file: f, start: time.Now()}, nil
+}
+
+// matches reports whether an event of type typ from source is one the
+// expectation counts.
+func (e *expectation) matches(typ, source string) bool {
+	return matchAttribute(e.Type, typ) && matchAttribute(e.Source, source)
+}
+
+// matchAttribute matches an attribute value exactly or, if pattern ends with
+// *, by prefix; an empty pattern matches any value.
+func matchAttribute(pattern, value string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == "" || pattern == value
+}
+
+// met reports whether the expectation is met.
+func (e *expectation) met() bool {
+	return e.countMet() && len(e.missing) == 0
+}
+
+// countMet reports whether the number of events meets the expectation,
+// whatever their attributes.
+func (e *expectation) countMet() bool {
+	switch {
+	case e.Count != nil:
+		return e.received == *e.Count
+	case e.Min != nil && e.received < *e.Min:
+		return false
+	case e.Max != nil && e.received > *e.Max:
+		return false
+	}
+	return true
+}
+
+// want describes the events the expectation wants.
+func (e *expectation) want() string {
+	var n string
+	switch {
+	case e.Count != nil:
+		n = fmt.Sprintf("%d events", *e.Count)
+	case e.Min != nil && e.Max != nil:
+		n = fmt.Sprintf("%d to %d events", *e.Min, *e.Max)
+	case e.Min != nil:
+		n = fmt.Sprintf("at least %d events", *e.Min)
+	default:
+		n = fmt.Sprintf("at most %d events", *e.Max)
+	}
+	return n + e.describe()
+}
+
+// describe describes the events the expectation matches and its window.
+func (e *expectation) describe() string {
+	var s string
+	if e.Type != "" {
+		s += " of type " + e.Type
+	}
+	if e.Source != "" {
+		s += " from " + e.Source
+	}
+	if e.Within > 0 {
+		s += fmt.Sprintf(" within %v", e.Within)
+	}
+	return s
+}
+
+// expectationSet checks the events of a receiver against its expectations.
+// It is safe for concurrent use; a nil set expects nothing.
+type expectationSet struct {
+	file  expectationsFile
+	start time.Time
+
+	mu sync.Mutex
+	// first is when the first event was received, zero before
+	first time.Time
+}
+
+// observe checks a structured event received at received.
+func (s *expectationSet) observe(ev map[string]interface{}, received time.Time) {
+	if s == nil {
+		return
+	}
+	typ, _ := ev["type"].(string)
+	source, _ := ev["source"].(string)
+	s.record(typ, source, received, func(attr string) bool {
+		return ev[attr] != nil
+	})
+}
+
+// observeHeaders checks a binary event received at received, whose data is
+// the body and data content type the Content-Type.
+func (s *expectationSet) observeHeaders(req *fasthttp.Request, received time.Time) {
+	if s == nil {
+		return
+	}
+	typ := events.DecodeHeaderValue(string(req.Header.Peek("Ce-Type")))
+	source := events.DecodeHeaderValue(string(req.Header.Peek("Ce-Source")))
+	s.record(typ, source, received, func(attr string) bool {
+		switch attr {
+		case "data":
+			return len(req.Body()) > 0
+		case "datacontenttype":
+			return len(req.Header.ContentType()) > 0
+		}
+		return len(req.Header.Peek("Ce-"+attr)) > 0
+	})
+}
+
+func (s *expectationSet) record(typ, source string, received time.Time, has func(attr string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first.IsZero() {
+		s.first = received
+	}
+	for i := range s.file.Expectations {
+		e := &s.file.Expectations[i]
+		if !e.matches(typ, source) {
+			continue
+		}
+		if e.Within > 0 && received.Sub(s.first) > e.Within {
+			e.late++
+			continue
+		}
+		e.received++
+		for _, attr := range e.Attributes {
+			if !has(attr) {
+				e.missing[attr]++
+			}
+		}
+	}
+}
+
+// done reports whether the receiver can stop at now: every window has
+// passed since the first event, or the timeout since the start. Without a
+// window of every expectation and a timeout the receiver runs until it is
+// stopped.
+func (s *expectationSet) done(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	if s.file.Timeout > 0 && now.Sub(s.start) >= s.file.Timeout {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first.IsZero() {
+		return false
+	}
+	for _, e := range s.file.Expectations {
+		if e.Within == 0 || now.Sub(s.first) <= e.Within {
+			return false
+		}
+	}
+	return true
+}
+
+// diff returns the expectations not met as a diff of the expected events,
+// the lines starting with -, and the received ones, starting with +.
+func (s *expectationSet) diff() (unmet int, lines []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.file.Expectations {
+		e := &s.file.Expectations[i]
+		if e.met() {
+			continue
+		}
+		unmet++
+		lines = append(lines, e.Name+":")
+		if !e.countMet() {
+			got := fmt.Sprintf("%d events%s", e.received, e.describe())
+			if e.late > 0 {
+				got += fmt.Sprintf(", %d after it", e.late)
+			}
+			lines = append(lines, "- "+e.want(), "+ "+got)
+		}
+		for _, attr := range e.Attributes {
+			if n := e.missing[attr]; n > 0 {
+				lines = append(lines, "- every event with "+attr, fmt.Sprintf("+ %d events without %s", n, attr))
+			}
+		}
+	}
+	return unmet, lines
+}
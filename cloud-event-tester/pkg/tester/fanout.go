@@ -0,0 +1,200 @@
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "run",
+		summary: "Run a scenario file, its workloads or against each of its clusters concurrently",
+		run:     runScenario,
+	})
+}
+
+// clusterResult is the outcome of a fan-out run against one cluster.
+type clusterResult struct {
+	Cluster string         `json:"cluster"`
+	URL     string         `json:"url"`
+	Result  *report.Result `json:"result,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	// RateDelta is the relative difference of the average rate to the
+	// first cluster, in percent.
+	RateDelta float64 `json:"rateDelta,omitempty"`
+}
+
+// fanOutReport compares the runs of a scenario against several clusters.
+type fanOutReport struct {
+	Scenario string          `json:"scenario"`
+	Config   loadgen.Config  `json:"config"`
+	Clusters []clusterResult `json:"clusters"`
+}
+
+func runScenario(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	config := fs.String("config", "", "Scenario file to run (required)")
+	output := fs.String("o", "", "Write the JSON report to this file")
+	fs.Parse(args) //nolint: errcheck
+
+	if *config == "" {
+		return fmt.Errorf("-config is required")
+	}
+	s, err := loadScenario(*config)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext()
+	defer stop()
+
+	var rep interface{}
+	var sla loadgen.SLAViolation
+	switch {
+	case len(s.Workloads) > 0:
+		if len(s.Phases) > 0 || len(s.Clusters) > 0 {
+			return fmt.Errorf("scenario %s has workloads, which cannot be combined with phases or clusters", s.Name)
+		}
+		wr, err := runWorkloads(ctx, s)
+		if err != nil {
+			return err
+		}
+		for _, w := range wr.Workloads {
+			sla.AddViolations(w.Result)
+		}
+		rep = wr
+	case len(s.Clusters) == 0:
+		phases, err := s.phaseConfigs(nil)
+		if err != nil {
+			return err
+		}
+		for _, p := range phases {
+			if err := p.cfg.Validate(); err != nil {
+				return fmt.Errorf("phase %s: %w", p.name, err)
+			}
+		}
+		log.Infof("Running scenario %s", s.Name)
+		var reports []*loadgen.RunReport
+		for i := range phases {
+			if len(phases) > 1 {
+				log.Infof("=== Phase %d/%d: %s ===", i+1, len(phases), phases[i].name)
+			}
+			result, err := loadgen.Run(ctx, &phases[i].cfg, nil)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, loadgen.NewRunReport(&phases[i].cfg, result))
+			sla.AddViolations(result)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if rep = reports; len(s.Phases) == 0 {
+			rep = reports[0]
+		}
+	default:
+		if err := s.singlePhase("fan-out runs"); err != nil {
+			return err
+		}
+		fo := fanOut(ctx, s)
+		for _, cr := range fo.Clusters {
+			sla.AddViolations(cr.Result)
+		}
+		rep = fo
+	}
+
+	if *output == "" {
+		return sla.Err()
+	}
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.Infof("Writing report to %s", *output)
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	return sla.Err()
+}
+
+// fanOut runs the load of a scenario against each of its clusters at the same
+// time and compares the results.
+func fanOut(ctx context.Context, s *scenario) *fanOutReport {
+	rep := &fanOutReport{Scenario: s.Name, Config: s.Config.Redacted(), Clusters: make([]clusterResult, len(s.Clusters))}
+	var wg sync.WaitGroup
+	for i, cl := range s.Clusters {
+		name := cl.Name
+		if name == "" {
+			name = cl.Context
+		}
+		if name == "" {
+			name = fmt.Sprintf("cluster-%d", i)
+		}
+		cfg := s.Config.Clone()
+		cl.apply(&cfg, name)
+		rep.Clusters[i] = clusterResult{Cluster: name, URL: cfg.URL}
+
+		wg.Add(1)
+		go func(cr *clusterResult, cfg loadgen.Config) {
+			defer wg.Done()
+			log.Infof("Starting run against cluster %s", cr.Cluster)
+			result, err := loadgen.Run(ctx, &cfg, nil)
+			cr.Result = result
+			if err != nil {
+				log.Errorf("Run against cluster %s failed: %v", cr.Cluster, err)
+				cr.Error = err.Error()
+			}
+		}(&rep.Clusters[i], cfg)
+	}
+	wg.Wait()
+
+	base := rep.Clusters[0].Result
+	log.Infof("=== Cluster Comparison ===")
+	for i := range rep.Clusters {
+		cr := &rep.Clusters[i]
+		if cr.Result == nil {
+			log.Infof("%-20s failed: %s", cr.Cluster, cr.Error)
+			continue
+		}
+		if base != nil && base.AvgRate > 0 && i > 0 {
+			cr.RateDelta = (cr.Result.AvgRate - base.AvgRate) / base.AvgRate * 100
+		}
+		log.Infof("%-20s %8d msg %8.1f s %10.2f msg/s %+7.1f%%",
+			cr.Cluster, cr.Result.TotalMsg, cr.Result.TotalSeconds, cr.Result.AvgRate, cr.RateDelta)
+	}
+	return rep
+}
+
+// apply overrides the target settings of a run with those of the cluster
+// with the given name.
+func (cl *clusterTarget) apply(cfg *loadgen.Config, name string) {
+	if cl.Context != "" {
+		cfg.KubeContext = cl.Context
+	}
+	if cl.Kubeconfig != "" {
+		cfg.Kubeconfig = cl.Kubeconfig
+	}
+	// a target given for the cluster replaces the target of the scenario
+	if cl.URL != "" || cl.TargetSelector != "" || cl.TargetService != "" {
+		if cl.URL != "" {
+			cfg.URL, cfg.Targets, cfg.TargetsFile = cl.URL, nil, ""
+		}
+		cfg.TargetSelector, cfg.TargetService = cl.TargetSelector, cl.TargetService
+	}
+	if cl.TargetNamespace != "" {
+		cfg.TargetNamespace = cl.TargetNamespace
+	}
+	// the clusters must not share a checkpoint or a trace file
+	if cfg.CheckpointFile != "" {
+		cfg.CheckpointFile += "." + name
+	}
+	cfg.TraceFile = loadgen.SuffixPath(cfg.TraceFile, name)
+}
@@ -0,0 +1,218 @@
+package tester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	controlv1 "github.com/jzding/cloud-event-tools/cloud-event-tester/api/control/v1"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// controlServer exposes the daemon over gRPC, mirroring the REST control API.
+type controlServer struct {
+	controlv1.UnimplementedControlServiceServer
+	d *daemon
+}
+
+func newGRPCServer(d *daemon, opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(opts...)
+	controlv1.RegisterControlServiceServer(srv, &controlServer{d: d})
+	return srv
+}
+
+func (s *controlServer) StartRun(ctx context.Context, req *controlv1.StartRunRequest) (*controlv1.Run, error) {
+	cfg, err := s.d.applyProtoConfig(s.d.cfg.Clone(), req.GetConfig())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r, err := s.d.start(cfg)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return runToProto(r), nil
+}
+
+func (s *controlServer) GetRun(ctx context.Context, req *controlv1.GetRunRequest) (*controlv1.Run, error) {
+	r, err := s.d.get(req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return runToProto(r), nil
+}
+
+func (s *controlServer) SetRate(ctx context.Context, req *controlv1.SetRateRequest) (*controlv1.Run, error) {
+	rate, period, err := loadgen.ParseRate(req.GetRate())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r, err := s.d.setRate(req.GetId(), rate, period)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return runToProto(r), nil
+}
+
+func (s *controlServer) CancelRun(ctx context.Context, req *controlv1.CancelRunRequest) (*controlv1.Run, error) {
+	r, err := s.d.cancelRun(req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return runToProto(r), nil
+}
+
+func (s *controlServer) ListRuns(ctx context.Context, req *controlv1.ListRunsRequest) (*controlv1.ListRunsResponse, error) {
+	resp := &controlv1.ListRunsResponse{}
+	for _, r := range s.d.list() {
+		resp.Runs = append(resp.Runs, runToProto(r))
+	}
+	return resp, nil
+}
+
+func (s *controlServer) StreamStats(req *controlv1.StreamStatsRequest, stream controlv1.ControlService_StreamStatsServer) error {
+	sub, unsubscribe, err := s.d.subscribe(req.GetId())
+	if err != nil {
+		return grpcError(err)
+	}
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case stats, ok := <-sub:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(&controlv1.Stats{
+				RunId:    req.GetId(),
+				Second:   int32(stats.Second),
+				Sent:     stats.Sent,
+				TotalMsg: int64(stats.TotalMsg),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidConfig):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errRunActive), errors.Is(err, errRunFinished):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errRunNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// applyProtoConfig overrides the settings that are set in the request: its
+// settings as those of the body of POST /runs, then the fields of their
+// own. It fails if the request sets a setting of the daemon, see
+// daemonSettings.
+func (d *daemon) applyProtoConfig(cfg loadgen.Config, pc *controlv1.RunConfig) (loadgen.Config, error) {
+	if pc == nil {
+		return cfg, nil
+	}
+	if settings := pc.GetSettings(); settings != nil {
+		data, err := json.Marshal(settings.AsMap())
+		if err != nil {
+			return cfg, err
+		}
+		if cfg, err = d.decodeRunRequest(bytes.NewReader(data), cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if pc.Url != nil {
+		cfg.URL, cfg.Targets, cfg.TargetsFile = pc.GetUrl(), nil, ""
+	}
+	if pc.Rate != nil {
+		rate, period, err := loadgen.ParseRate(pc.GetRate())
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Rate, cfg.RatePeriod = rate, period
+	}
+	if pc.Duration != nil {
+		cfg.Duration = pc.GetDuration()
+	}
+	if pc.Delay != nil {
+		cfg.Delay = int(pc.GetDelay())
+	}
+	if pc.CheckResp != nil {
+		cfg.CheckResp = pc.GetCheckResp()
+	}
+	if pc.WithMessage != nil {
+		cfg.WithMessage = pc.GetWithMessage()
+	}
+	if pc.Perf != nil {
+		cfg.Perf = pc.GetPerf()
+	}
+	if pc.DataDir != nil {
+		return cfg, fmt.Errorf("dataDir is a setting of the daemon, it cannot be set over the control API")
+	}
+	if pc.EventFile != nil {
+		file, err := d.apiEventFile(pc.GetEventFile())
+		if err != nil {
+			return cfg, err
+		}
+		cfg.EventFile = file
+	}
+	return cfg, nil
+}
+
+// configToProto returns the settings of a run, all of them in its settings
+// as GET /runs/{id} reports them.
+func configToProto(cfg loadgen.Config) *controlv1.RunConfig {
+	rate, delay := loadgen.FormatRate(cfg.Rate, cfg.RatePeriod), int32(cfg.Delay)
+	pc := &controlv1.RunConfig{
+		Url:         &cfg.URL,
+		Rate:        &rate,
+		Duration:    &cfg.Duration,
+		Delay:       &delay,
+		CheckResp:   &cfg.CheckResp,
+		WithMessage: &cfg.WithMessage,
+		Perf:        &cfg.Perf,
+		DataDir:     &cfg.DataDir,
+		EventFile:   &cfg.EventFile,
+	}
+	var settings map[string]interface{}
+	if data, err := json.Marshal(cfg); err == nil && json.Unmarshal(data, &settings) == nil {
+		pc.Settings, _ = structpb.NewStruct(settings)
+	}
+	return pc
+}
+
+func runToProto(r *run) *controlv1.Run {
+	pr := &controlv1.Run{
+		Id:      r.ID,
+		State:   r.State,
+		Config:  configToProto(r.Config),
+		Created: timestamppb.New(r.Created),
+		Error:   r.Error,
+	}
+	if res := r.Result; res != nil {
+		pr.Result = &controlv1.RunResult{
+			Mode:         res.Mode,
+			StartTime:    timestamppb.New(res.StartTime),
+			EndTime:      timestamppb.New(res.EndTime),
+			TotalSeconds: res.TotalSeconds,
+			TotalMsg:     int64(res.TotalMsg),
+			AvgRate:      res.AvgRate,
+			Succeeded:    int32(res.Succeeded),
+			Files:        int32(res.Files),
+		}
+	}
+	return pr
+}
@@ -0,0 +1,36 @@
+package tester
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// startMetricsServer serves the health and readiness probes on addr in the
+// background, and the profiles of net/http/pprof under /debug/pprof/ to
+// profile a running tester. It returns the probes the runs report their
+// health to, see loadgen.WithHealthProbes, or nil and does nothing if addr is empty.
+func startMetricsServer(addr string) *loadgen.HealthProbes {
+	if addr == "" {
+		return nil
+	}
+	probes := loadgen.NewHealthProbes()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probes.HandleHealthz)
+	mux.HandleFunc("/readyz", probes.HandleReadyz)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		log.Infof("Metrics and health endpoints listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Errorf("Metrics server stopped: %v", err)
+		}
+	}()
+	return probes
+}
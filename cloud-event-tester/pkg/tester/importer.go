@@ -0,0 +1,506 @@
+package tester
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "import",
+		summary: "Import the webhook requests of a HAR or pcap capture as a recording to replay",
+		run:     runImport,
+	})
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	capture := fs.String("capture", "", "HAR file or pcap/pcapng capture of the webhook traffic (required)")
+	out := fs.String("out", "", "Recording to write, replayed with replay -recording (required)")
+	var imp captureImport
+	imp.bind(fs)
+	fs.Parse(args) //nolint: errcheck
+	imp.applyEnv()
+
+	if *capture == "" || *out == "" {
+		return fmt.Errorf("-capture and -out are required")
+	}
+	n, err := imp.run(*capture, *out)
+	if err != nil {
+		return err
+	}
+	log.Infof("Imported %d events from %s to %s", n, *capture, *out)
+	return nil
+}
+
+// anonymizeFlag is the repeatable -anonymize flag, a JSONPath of the values
+// to anonymize.
+type anonymizeFlag []string
+
+func (a *anonymizeFlag) String() string {
+	if a == nil {
+		return ""
+	}
+	return strings.Join(*a, ",")
+}
+
+func (a *anonymizeFlag) Set(value string) error {
+	if _, err := events.ParseJSONPath(value); err != nil {
+		return err
+	}
+	*a = append(*a, value)
+	return nil
+}
+
+// captureImport imports the POST requests of a capture of production webhook
+// traffic, a HAR file saved from a browser or proxy or a pcap of plain HTTP,
+// as a timed recording, so replay -original-timing sends them as they were
+// captured. The events are recorded as receive -record records them, and
+// the values at the anonymize paths replaced by pseudonyms.
+type captureImport struct {
+	// pathPrefix selects the requests to URLs with this path prefix
+	pathPrefix string
+	anonymize  anonymizeFlag
+	salt       string
+}
+
+func (imp *captureImport) bind(fs *flag.FlagSet) {
+	fs.StringVar(&imp.pathPrefix, "path-prefix", "", "Import the POST requests of a capture to URLs with this path prefix only")
+	fs.Var(&imp.anonymize, "anonymize", "JSONPath of event values to replace by pseudonyms when importing a capture, like $.data.values[*].resource (repeatable)")
+	fs.StringVar(&imp.salt, "anonymize-salt", "", "Salt of the pseudonyms, the same salt gives the same pseudonyms across imports (default random)")
+}
+
+func (imp *captureImport) applyEnv() {
+	if envPrefix := os.Getenv("IMPORT_PATH_PREFIX"); envPrefix != "" {
+		imp.pathPrefix = envPrefix
+	}
+	if envAnonymize := os.Getenv("IMPORT_ANONYMIZE"); envAnonymize != "" {
+		for _, path := range strings.Split(envAnonymize, ",") {
+			if err := imp.anonymize.Set(strings.TrimSpace(path)); err != nil {
+				log.Warnf("Ignoring IMPORT_ANONYMIZE path: %v", err)
+			}
+		}
+	}
+	if envSalt := os.Getenv("IMPORT_ANONYMIZE_SALT"); envSalt != "" {
+		imp.salt = envSalt
+	}
+}
+
+// isCapture reports whether a file is a HAR file or pcap capture to import
+// rather than a recording, by its extension.
+func isCapture(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".har", ".pcap", ".pcapng", ".cap":
+		return true
+	}
+	return false
+}
+
+// capturedRequest is an HTTP request of a capture.
+type capturedRequest struct {
+	at     time.Time
+	method string
+	url    string
+	header http.Header
+	body   []byte
+}
+
+// run imports the capture at in to the recording out and returns the number
+// of events recorded.
+func (imp *captureImport) run(in, out string) (int, error) {
+	anon, err := newAnonymizer(imp.anonymize, imp.salt)
+	if err != nil {
+		return 0, err
+	}
+	var reqs []capturedRequest
+	switch strings.ToLower(filepath.Ext(in)) {
+	case ".har":
+		reqs, err = readHAR(in)
+	default:
+		reqs, err = readPcap(in)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read capture %s: %w", in, err)
+	}
+	sort.SliceStable(reqs, func(i, j int) bool { return reqs[i].at.Before(reqs[j].at) })
+
+	rec, err := events.NewRecorder(out)
+	if err != nil {
+		return 0, err
+	}
+	skipped := 0
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	for i, c := range reqs {
+		if !imp.selects(c) || len(bytes.TrimSpace(c.body)) == 0 {
+			skipped++
+			continue
+		}
+		req.Reset()
+		for name, values := range c.header {
+			switch strings.ToLower(name) {
+			case "content-length", "transfer-encoding", "connection":
+				continue
+			}
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		req.SetBody(c.body)
+		structured, err := events.StructuredEvents(req)
+		if err == nil {
+			for j, event := range structured {
+				if !json.Valid(event) {
+					err = fmt.Errorf("event is not JSON")
+					break
+				}
+				if structured[j], err = anon.event(event); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			log.Warnf("Skipping request %d of %s to %s: %v", i+1, in, c.url, err)
+			skipped++
+			continue
+		}
+		if err := rec.RecordEvents(c.at, structured); err != nil {
+			rec.Close() //nolint: errcheck
+			return 0, err
+		}
+	}
+	n, err := rec.Close()
+	if err != nil {
+		return n, err
+	}
+	if skipped > 0 {
+		log.Infof("Skipped %d of the %d requests of %s: not POST requests with a body to %s*", skipped, len(reqs), in, imp.pathPrefix)
+	}
+	if anon != nil {
+		log.Infof("Anonymized %d values at %s", anon.values, imp.anonymize.String())
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no webhook requests in capture %s", in)
+	}
+	return n, nil
+}
+
+// selects reports whether a captured request is one to import.
+func (imp *captureImport) selects(c capturedRequest) bool {
+	if c.method != http.MethodPost {
+		return false
+	}
+	if imp.pathPrefix == "" {
+		return true
+	}
+	u, err := url.Parse(c.url)
+	return err == nil && strings.HasPrefix(u.Path, imp.pathPrefix)
+}
+
+// harFile is the part of a HAR file with the requests.
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Request         struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					// Encoding is base64 for binary bodies, as some
+					// tools write them
+					Encoding string `json:"encoding"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// readHAR returns the requests of a HAR file.
+func readHAR(path string) ([]capturedRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("not a HAR file: %w", err)
+	}
+	reqs := make([]capturedRequest, 0, len(har.Log.Entries))
+	for i, e := range har.Log.Entries {
+		c := capturedRequest{at: e.StartedDateTime, method: strings.ToUpper(e.Request.Method), url: e.Request.URL, header: http.Header{}}
+		for _, h := range e.Request.Headers {
+			// the pseudo-headers of HTTP/2, like :authority
+			if !strings.HasPrefix(h.Name, ":") {
+				c.header.Add(h.Name, h.Value)
+			}
+		}
+		if pd := e.Request.PostData; pd != nil {
+			c.body = []byte(pd.Text)
+			if pd.Encoding == "base64" {
+				if c.body, err = base64.StdEncoding.DecodeString(pd.Text); err != nil {
+					return nil, fmt.Errorf("entry %d: %w", i+1, err)
+				}
+			}
+			if c.header.Get("Content-Type") == "" && pd.MimeType != "" {
+				c.header.Set("Content-Type", pd.MimeType)
+			}
+		}
+		reqs = append(reqs, c)
+	}
+	return reqs, nil
+}
+
+// packetReader reads the packets of a pcap or pcapng file.
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// pcapngMagic starts the section header block of a pcapng file.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// readPcap returns the HTTP/1.x requests of a pcap or pcapng capture, with
+// the time their first byte was captured. The TCP streams are reassembled;
+// TLS traffic cannot be read, capture it in plain text in front of the
+// receiver or save a HAR file instead.
+func readPcap(path string) ([]capturedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var src packetReader
+	if magic, _ := br.Peek(len(pcapngMagic)); bytes.Equal(magic, pcapngMagic) {
+		src, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	} else {
+		src, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	factory := &httpStreamFactory{}
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(factory))
+	for {
+		data, ci, err := src.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// a capture stopped while it was written ends in a partial
+			// packet; the requests before it are still imported
+			log.Warnf("Capture %s ends in a truncated packet, ignoring it", path)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		packet := gopacket.NewPacket(data, src.LinkType(), gopacket.NoCopy)
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok || packet.NetworkLayer() == nil {
+			continue
+		}
+		assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, ci.Timestamp)
+	}
+	assembler.FlushAll()
+	return factory.reqs, nil
+}
+
+// httpStreamFactory collects the requests of the TCP streams of a capture.
+// The assembler calls it and its streams from one goroutine.
+type httpStreamFactory struct {
+	reqs []capturedRequest
+}
+
+func (f *httpStreamFactory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	return &httpStream{factory: f, flow: fmt.Sprintf("%v:%v", netFlow, tcpFlow)}
+}
+
+// httpStream buffers the bytes of a TCP stream and parses its requests when
+// it ends; the responses of the other direction do not parse and are
+// ignored.
+type httpStream struct {
+	factory *httpStreamFactory
+	flow    string
+	data    []byte
+	// seen are the capture times of the bytes from the offsets on
+	seen []streamChunk
+}
+
+type streamChunk struct {
+	offset int
+	at     time.Time
+}
+
+func (s *httpStream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		// the requests after lost bytes cannot be told from the rest
+		if r.Skip > 0 && len(s.data) > 0 {
+			s.parse()
+		}
+		if len(r.Bytes) == 0 {
+			continue
+		}
+		s.seen = append(s.seen, streamChunk{offset: len(s.data), at: r.Seen})
+		s.data = append(s.data, r.Bytes...)
+	}
+}
+
+func (s *httpStream) ReassemblyComplete() {
+	s.parse()
+}
+
+// parse adds the requests of the buffered bytes to the factory and empties
+// the buffer.
+func (s *httpStream) parse() {
+	defer func() { s.data, s.seen = nil, nil }()
+	rd := bytes.NewReader(s.data)
+	br := bufio.NewReader(rd)
+	for {
+		offset := len(s.data) - rd.Len() - br.Buffered()
+		if _, err := br.Peek(1); err != nil {
+			return
+		}
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			log.Debugf("No more HTTP requests in TCP stream %s: %v", s.flow, err)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Debugf("Truncated HTTP request in TCP stream %s: %v", s.flow, err)
+			return
+		}
+		s.factory.reqs = append(s.factory.reqs, capturedRequest{
+			at:     s.at(offset),
+			method: req.Method,
+			url:    "http://" + req.Host + req.URL.RequestURI(),
+			header: req.Header,
+			body:   body,
+		})
+	}
+}
+
+// at returns the capture time of the byte at offset.
+func (s *httpStream) at(offset int) time.Time {
+	i := sort.Search(len(s.seen), func(i int) bool { return s.seen[i].offset > offset })
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.seen[i-1].at
+}
+
+// anonymizer replaces the strings at its paths in events by pseudonyms: the
+// same value always becomes the same pseudonym, so the events of a resource
+// still belong together, but the value cannot be read back. Numbers,
+// booleans and nulls are kept. A nil anonymizer keeps events as they are.
+type anonymizer struct {
+	paths  [][]events.PathStep
+	key    []byte
+	values int
+}
+
+func newAnonymizer(paths []string, salt string) (*anonymizer, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	a := &anonymizer{key: []byte(salt)}
+	if salt == "" {
+		a.key = make([]byte, 32)
+		if _, err := rand.Read(a.key); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range paths {
+		steps, err := events.ParseJSONPath(p)
+		if err != nil {
+			return nil, err
+		}
+		a.paths = append(a.paths, steps)
+	}
+	return a, nil
+}
+
+// event returns an event with the values at the paths anonymized.
+func (a *anonymizer) event(event []byte) ([]byte, error) {
+	if a == nil {
+		return event, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(event))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("event is not JSON: %w", err)
+	}
+	for _, steps := range a.paths {
+		if len(steps) == 0 {
+			doc = a.value(doc)
+			continue
+		}
+		doc, _ = events.MutatePath(doc, steps, func(v interface{}, ok bool) (interface{}, bool, bool) {
+			if !ok {
+				return nil, false, false
+			}
+			return a.value(v), true, true
+		})
+	}
+	return json.Marshal(doc)
+}
+
+// value anonymizes the strings of a value, those of objects and arrays
+// included.
+func (a *anonymizer) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		a.values++
+		return a.pseudonym(v)
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = a.value(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = a.value(e)
+		}
+	}
+	return v
+}
+
+// pseudonym returns the pseudonym of a string.
+func (a *anonymizer) pseudonym(s string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(s)) //nolint: errcheck
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
@@ -0,0 +1,253 @@
+package tester
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var captureStart = time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+// writeCapture writes a file into a temporary directory and returns its
+// path.
+func writeCapture(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// pcapOf returns a pcap capture of a TCP connection sending the segments
+// to port 9087, one packet each, a second apart.
+func pcapOf(t *testing.T, segments ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	seq := uint32(1000)
+	packet := func(at time.Time, tcp *layers.TCP, payload []byte) {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+		tcp.SrcPort, tcp.DstPort, tcp.Window = 40000, 9087, 65535
+		tcp.SetNetworkLayerForChecksum(ip) //nolint: errcheck
+		data := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(data, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+			t.Fatal(err)
+		}
+		ci := gopacket.CaptureInfo{Timestamp: at, CaptureLength: len(data.Bytes()), Length: len(data.Bytes())}
+		if err := w.WritePacket(ci, data.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packet(captureStart, &layers.TCP{Seq: seq, SYN: true}, nil)
+	seq++
+	for i, s := range segments {
+		packet(captureStart.Add(time.Duration(i+1)*time.Second), &layers.TCP{Seq: seq, ACK: true, PSH: true}, []byte(s))
+		seq += uint32(len(s))
+	}
+	packet(captureStart.Add(time.Duration(len(segments)+1)*time.Second), &layers.TCP{Seq: seq, ACK: true, FIN: true}, nil)
+	return buf.Bytes()
+}
+
+// postRequest returns an HTTP/1.1 POST request with a JSON body.
+func postRequest(path, body string) string {
+	return "POST " + path + " HTTP/1.1\r\nHost: receiver:9087\r\nContent-Type: application/json\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestReadPcap(t *testing.T) {
+	first := postRequest("/webhook", `{"id":"1"}`)
+	second := postRequest("/webhook", `{"id":"2"}`)
+	tests := []struct {
+		name     string
+		segments []string
+		bodies   []string
+	}{
+		{"one request", []string{first}, []string{`{"id":"1"}`}},
+		{"two requests", []string{first, second}, []string{`{"id":"1"}`, `{"id":"2"}`}},
+		{"keep-alive in one segment", []string{first + second}, []string{`{"id":"1"}`, `{"id":"2"}`}},
+		{"request split over segments", []string{first[:20], first[20:]}, []string{`{"id":"1"}`}},
+		{"truncated body", []string{first, second[:len(second)-4]}, []string{`{"id":"1"}`}},
+		{"truncated headers", []string{first, "POST /webhook HTTP/1.1\r\nHost: rec"}, []string{`{"id":"1"}`}},
+		{"not HTTP", []string{"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"}, nil},
+	}
+	for _, tt := range tests {
+		reqs, err := readPcap(writeCapture(t, "capture.pcap", pcapOf(t, tt.segments...)))
+		if err != nil {
+			t.Errorf("%s: readPcap failed: %v", tt.name, err)
+			continue
+		}
+		if len(reqs) != len(tt.bodies) {
+			t.Errorf("%s: readPcap read %d requests, want %d", tt.name, len(reqs), len(tt.bodies))
+			continue
+		}
+		for i, req := range reqs {
+			if string(req.body) != tt.bodies[i] || req.method != "POST" || req.url != "http://receiver:9087/webhook" {
+				t.Errorf("%s: request %d is %s %s %s, want POST http://receiver:9087/webhook %s", tt.name, i, req.method, req.url, req.body, tt.bodies[i])
+			}
+		}
+		// a request is captured when its first byte is
+		if len(reqs) > 0 && !reqs[0].at.Equal(captureStart.Add(time.Second)) {
+			t.Errorf("%s: request captured at %v, want %v", tt.name, reqs[0].at, captureStart.Add(time.Second))
+		}
+	}
+}
+
+func TestReadPcapTruncated(t *testing.T) {
+	capture := pcapOf(t, postRequest("/webhook", `{"id":"1"}`), postRequest("/webhook", `{"id":"2"}`))
+	// the capture ends in the middle of the FIN packet, as when tcpdump is
+	// killed while it writes
+	reqs, err := readPcap(writeCapture(t, "capture.pcap", capture[:len(capture)-10]))
+	if err != nil {
+		t.Fatalf("readPcap of a truncated capture failed: %v", err)
+	}
+	if len(reqs) != 2 {
+		t.Errorf("readPcap read %d requests of a truncated capture, want 2", len(reqs))
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":          nil,
+		"not a capture":  []byte("GET / HTTP/1.1\r\n\r\n"),
+		"partial header": capture[:10],
+	} {
+		if _, err := readPcap(writeCapture(t, "capture.pcap", data)); err == nil {
+			t.Errorf("%s: readPcap succeeded, want an error", name)
+		}
+	}
+}
+
+const testHAR = `{"log": {"entries": [
+  {"startedDateTime": "2026-10-14T10:00:02Z", "request": {"method": "post", "url": "http://receiver:9087/webhook",
+    "headers": [{"name": ":authority", "value": "receiver"}, {"name": "X-Tenant", "value": "blue"}],
+    "postData": {"mimeType": "application/json", "text": "{\"id\": \"2\"}"}}},
+  {"startedDateTime": "2026-10-14T10:00:01Z", "request": {"method": "POST", "url": "http://receiver:9087/webhook",
+    "headers": [{"name": "Content-Type", "value": "application/json"}],
+    "postData": {"mimeType": "text/plain", "text": "` + "eyJpZCI6ICIxIn0=" + `", "encoding": "base64"}}},
+  {"startedDateTime": "2026-10-14T10:00:03Z", "request": {"method": "GET", "url": "http://receiver:9087/health", "headers": []}}
+]}}`
+
+func TestReadHAR(t *testing.T) {
+	reqs, err := readHAR(writeCapture(t, "capture.har", []byte(testHAR)))
+	if err != nil {
+		t.Fatalf("readHAR failed: %v", err)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("readHAR read %d requests, want 3", len(reqs))
+	}
+	if reqs[0].method != "POST" || string(reqs[0].body) != `{"id": "2"}` || reqs[0].header.Get("Content-Type") != "application/json" ||
+		reqs[0].header.Get("X-Tenant") != "blue" || len(reqs[0].header) != 2 {
+		t.Errorf("first request is %s with headers %v and body %s", reqs[0].method, reqs[0].header, reqs[0].body)
+	}
+	// the header of the request wins over the MIME type of its body
+	if string(reqs[1].body) != `{"id": "1"}` || reqs[1].header.Get("Content-Type") != "application/json" {
+		t.Errorf("base64 request has headers %v and body %s", reqs[1].header, reqs[1].body)
+	}
+	if reqs[2].method != "GET" || reqs[2].body != nil {
+		t.Errorf("GET request is %s with body %q", reqs[2].method, reqs[2].body)
+	}
+
+	for name, har := range map[string]string{
+		"not JSON":       `not a HAR file`,
+		"truncated":      testHAR[:len(testHAR)/2],
+		"invalid time":   `{"log": {"entries": [{"startedDateTime": "yesterday"}]}}`,
+		"invalid base64": `{"log": {"entries": [{"request": {"method": "POST", "postData": {"text": "%%%", "encoding": "base64"}}}]}}`,
+	} {
+		if _, err := readHAR(writeCapture(t, "capture.har", []byte(har))); err == nil {
+			t.Errorf("%s: readHAR succeeded, want an error", name)
+		}
+	}
+}
+
+func TestCaptureImport(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "recording.ndjson")
+	imp := captureImport{}
+	n, err := imp.run(writeCapture(t, "capture.har", []byte(testHAR)), out)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("imported %d events, want the 2 POST requests", n)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in the order they were captured
+	want := `{"receivedAt":"2026-10-14T10:00:01Z","event":{"id":"1"}}` + "\n" +
+		`{"receivedAt":"2026-10-14T10:00:02Z","event":{"id":"2"}}` + "\n"
+	if string(data) != want {
+		t.Errorf("recording is\n%s\nwant\n%s", data, want)
+	}
+
+	imp = captureImport{pathPrefix: "/other"}
+	if _, err := imp.run(writeCapture(t, "capture.har", []byte(testHAR)), out); err == nil {
+		t.Errorf("import without requests to the path prefix succeeded, want an error")
+	}
+
+	// requests with bodies that are not JSON are skipped
+	notJSON := `{"log": {"entries": [
+	  {"startedDateTime": "2026-10-14T10:00:01Z", "request": {"method": "POST", "url": "http://receiver/webhook", "postData": {"text": "hello"}}},
+	  {"startedDateTime": "2026-10-14T10:00:02Z", "request": {"method": "POST", "url": "http://receiver/webhook", "postData": {"text": "{\"id\": \"3\","}}},
+	  {"startedDateTime": "2026-10-14T10:00:03Z", "request": {"method": "POST", "url": "http://receiver/webhook", "postData": {"text": "{\"id\": \"4\"}"}}}
+	]}}`
+	imp = captureImport{}
+	if n, err := imp.run(writeCapture(t, "capture.har", []byte(notJSON)), out); err != nil || n != 1 {
+		t.Errorf("import of requests that are not JSON = %d, %v, want the 1 JSON event", n, err)
+	}
+}
+
+func TestAnonymizer(t *testing.T) {
+	a, err := newAnonymizer([]string{"$.data.resource", "$.Events[*].OriginOfCondition"}, "salt")
+	if err != nil {
+		t.Fatalf("newAnonymizer failed: %v", err)
+	}
+	event := `{"data":{"resource":"/cluster/node/a","value":3},"Events":[{"OriginOfCondition":{"@odata.id":"/redfish/v1/Systems/1"}},{"OriginOfCondition":7}]}`
+	got, err := a.event([]byte(event))
+	if err != nil {
+		t.Fatalf("event failed: %v", err)
+	}
+	if strings.Contains(string(got), "/cluster/node/a") || strings.Contains(string(got), "/redfish/v1/Systems/1") {
+		t.Errorf("anonymized event %s has the original values", got)
+	}
+	if !strings.Contains(string(got), `"value":3`) || !strings.Contains(string(got), `"OriginOfCondition":7`) {
+		t.Errorf("anonymized event %s lost the numbers", got)
+	}
+	if a.values != 2 {
+		t.Errorf("anonymized %d values, want 2", a.values)
+	}
+	// the same salt gives the same pseudonyms
+	b, _ := newAnonymizer([]string{"$.data.resource", "$.Events[*].OriginOfCondition"}, "salt")
+	if again, _ := b.event([]byte(event)); !bytes.Equal(got, again) {
+		t.Errorf("pseudonyms differ with the same salt: %s and %s", got, again)
+	}
+
+	if _, err := a.event([]byte(`{"data":`)); err == nil {
+		t.Errorf("event of a truncated event succeeded, want an error")
+	}
+	if _, err := newAnonymizer([]string{"$..resource"}, ""); err == nil {
+		t.Errorf("newAnonymizer with a malformed path succeeded, want an error")
+	}
+	var none *anonymizer
+	if got, err := none.event([]byte(`not json`)); err != nil || string(got) != `not json` {
+		t.Errorf("nil anonymizer changed the event to %s, %v", got, err)
+	}
+}
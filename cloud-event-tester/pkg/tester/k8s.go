@@ -0,0 +1,283 @@
+package tester
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// scenarioDataPath is where the event files of a scenario are mounted in
+// generated pods.
+const scenarioDataPath = "/app/scenario-data/"
+
+func init() {
+	registerCommand(&command{
+		name:    "k8s",
+		summary: "Kubernetes helpers (emit, run)",
+		run:     runK8s,
+	})
+}
+
+func runK8s(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s k8s emit|run [options]", os.Args[0])
+	}
+	switch args[0] {
+	case "emit":
+		return runK8sEmit(args[1:])
+	case "run":
+		return runK8sRun(args[1:])
+	default:
+		return fmt.Errorf("unknown k8s command %q", args[0])
+	}
+}
+
+func runK8sEmit(args []string) error {
+	fs := flag.NewFlagSet("k8s emit", flag.ExitOnError)
+	config := fs.String("config", "", "Scenario file to render (required)")
+	image := fs.String("image", "", "Container image (overrides scenario)")
+	replicas := fs.Int("replicas", 0, "Number of tester pods, the total rate is divided among them (overrides scenario)")
+	namespace := fs.String("namespace", "", "Namespace of the generated objects (overrides scenario)")
+	kind := fs.String("kind", "", "Workload kind, Job, StatefulSet or Deployment (overrides scenario)")
+	withServiceMonitor := fs.Bool("service-monitor", false, "Also emit a Service and ServiceMonitor for the metrics port")
+	output := fs.String("o", "", "Output file (default stdout)")
+	fs.Parse(args) //nolint: errcheck
+
+	if *config == "" {
+		return fmt.Errorf("-config is required")
+	}
+	s, err := loadScenario(*config)
+	if err != nil {
+		return err
+	}
+	if err := s.singlePhase("k8s emit"); err != nil {
+		return err
+	}
+	if *image != "" {
+		s.Kubernetes.Image = *image
+	}
+	if *replicas > 0 {
+		s.Kubernetes.Replicas = *replicas
+	}
+	if *namespace != "" {
+		s.Kubernetes.Namespace = *namespace
+	}
+	if *kind != "" {
+		s.Kubernetes.Kind = *kind
+	}
+	if *withServiceMonitor {
+		s.Kubernetes.ServiceMonitor = true
+	}
+
+	objects, err := renderScenario(s)
+	if err != nil {
+		return err
+	}
+	data, err := toYAML(objects...)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	log.Infof("Writing manifests for scenario %s to %s", s.Name, *output)
+	return os.WriteFile(*output, data, 0644)
+}
+
+// renderScenario builds the Kubernetes objects that run the given scenario:
+// a ConfigMap with the event files, a Job, StatefulSet or Deployment running
+// the tester and, optionally, a Service and ServiceMonitor for the metrics
+// port.
+func renderScenario(s *scenario) ([]interface{}, error) {
+	k := s.Kubernetes
+	if k.Replicas < 1 {
+		return nil, fmt.Errorf("replicas must be at least 1, got %d", k.Replicas)
+	}
+
+	data, err := scenarioEventData(s)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{"app": s.Name}
+	cm := &configMap{
+		typeMeta: typeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		Metadata: loadgen.ObjectMeta{Name: s.Name + "-data", Namespace: k.Namespace, Labels: labels},
+		Data:     data,
+	}
+
+	// Job and StatefulSet pods know their ordinal and shard the total rate
+	// themselves; Deployment pods are interchangeable and get an equal share.
+	kindLower := strings.ToLower(k.Kind)
+	rateEnv := []envVar{{Name: "MSG_PER_SEC", Value: loadgen.FormatRate(s.Rate, s.RatePeriod)}}
+	if k.Replicas > 1 {
+		if kindLower == "deployment" {
+			podRate := s.Rate / k.Replicas
+			if podRate == 0 {
+				return nil, fmt.Errorf("rate %d is too low to be divided among %d replicas", s.Rate, k.Replicas)
+			}
+			if s.Rate%k.Replicas != 0 {
+				log.Warnf("Rate %d is not divisible by %d replicas, each pod sends %d msg/sec", s.Rate, k.Replicas, podRate)
+			}
+			rateEnv[0].Value = loadgen.FormatRate(podRate, s.RatePeriod)
+		} else {
+			rateEnv = append(rateEnv,
+				envVar{Name: "SHARD_RATE", Value: "YES"},
+				envVar{Name: "REPLICAS", Value: strconv.Itoa(k.Replicas)})
+		}
+	}
+
+	if len(s.Labels) > 0 {
+		rateEnv = append(rateEnv, envVar{Name: "TEST_LABELS", Value: events.FormatLabels(s.Labels)})
+	}
+
+	args := []string{"-data-dir", scenarioDataPath, "-metrics-addr", ":" + strconv.Itoa(k.MetricsPort)}
+	if s.EventFile != "" {
+		args = append(args, "-event-file", scenarioDataPath+filepath.Base(s.EventFile))
+	}
+	if s.EventMix {
+		args = append(args, "-event-mix")
+	}
+	c := container{
+		Name:  s.Name,
+		Image: k.Image,
+		Args:  args,
+		Env: append(rateEnv, []envVar{
+			{Name: "TEST_DURATION_SEC", Value: strconv.FormatFloat(s.Duration, 'f', -1, 64)},
+			{Name: "INITIAL_DELAY_SEC", Value: strconv.Itoa(s.Delay)},
+			{Name: "CHECK_RESP", Value: s.CheckResp},
+			{Name: "WITH_MESSAGE_FIELD", Value: s.WithMessage},
+			{Name: "TEST_DEST_URL", Value: s.URL},
+			{Name: "PERF", Value: s.Perf},
+			fieldEnv("MY_NODE_NAME", "spec.nodeName"),
+			fieldEnv("MY_POD_NAME", "metadata.name"),
+			fieldEnv("MY_POD_NAMESPACE", "metadata.namespace"),
+			fieldEnv("MY_POD_IP", "status.podIP"),
+		}...),
+		Ports:        []containerPort{{Name: "metrics", ContainerPort: k.MetricsPort}},
+		VolumeMounts: []volumeMount{{Name: "scenario-data", MountPath: scenarioDataPath}},
+		// restart a wedged generator; pods are ready once the initial delay is over
+		LivenessProbe: &probe{
+			HTTPGet:          &httpGetAction{Path: "/healthz", Port: k.MetricsPort},
+			PeriodSeconds:    10,
+			FailureThreshold: 3,
+		},
+		ReadinessProbe: &probe{
+			HTTPGet:       &httpGetAction{Path: "/readyz", Port: k.MetricsPort},
+			PeriodSeconds: 5,
+		},
+	}
+	template := podTemplateSpec{
+		Metadata: loadgen.ObjectMeta{Name: s.Name, Labels: labels},
+		Spec: podSpec{
+			Containers: []container{c},
+			Volumes: []volume{{
+				Name:      "scenario-data",
+				ConfigMap: &configMapVolumeSource{Name: cm.Metadata.Name},
+			}},
+		},
+	}
+
+	objects := []interface{}{cm}
+	switch kindLower {
+	case "job":
+		template.Spec.RestartPolicy = "Never"
+		objects = append(objects, &job{
+			typeMeta: typeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			Metadata: loadgen.ObjectMeta{Name: s.Name, Namespace: k.Namespace, Labels: labels},
+			Spec: jobSpec{
+				Parallelism:    intPtr(k.Replicas),
+				Completions:    intPtr(k.Replicas),
+				CompletionMode: "Indexed",
+				BackoffLimit:   intPtr(0),
+				Template:       template,
+			},
+		})
+	case "statefulset":
+		objects = append(objects, &statefulSet{
+			typeMeta: typeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+			Metadata: loadgen.ObjectMeta{Name: s.Name, Namespace: k.Namespace, Labels: labels},
+			Spec: statefulSetSpec{
+				Replicas:            intPtr(k.Replicas),
+				ServiceName:         s.Name,
+				PodManagementPolicy: "Parallel",
+				Selector:            labelSelector{MatchLabels: labels},
+				Template:            template,
+			},
+		})
+	case "deployment":
+		objects = append(objects, &deployment{
+			typeMeta: typeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			Metadata: loadgen.ObjectMeta{Name: s.Name, Namespace: k.Namespace, Labels: labels},
+			Spec: deploymentSpec{
+				Replicas: intPtr(k.Replicas),
+				Selector: labelSelector{MatchLabels: labels},
+				Template: template,
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q (Job, StatefulSet or Deployment)", k.Kind)
+	}
+
+	if k.ServiceMonitor {
+		objects = append(objects,
+			&service{
+				typeMeta: typeMeta{APIVersion: "v1", Kind: "Service"},
+				Metadata: loadgen.ObjectMeta{Name: s.Name + "-metrics", Namespace: k.Namespace, Labels: labels},
+				Spec: serviceSpec{
+					Selector: labels,
+					Ports:    []servicePort{{Name: "metrics", Port: k.MetricsPort, TargetPort: k.MetricsPort}},
+				},
+			},
+			&serviceMonitor{
+				typeMeta: typeMeta{APIVersion: "monitoring.coreos.com/v1", Kind: "ServiceMonitor"},
+				Metadata: loadgen.ObjectMeta{Name: s.Name, Namespace: k.Namespace, Labels: labels},
+				Spec: serviceMonitorSpec{
+					Selector:  labelSelector{MatchLabels: labels},
+					Endpoints: []serviceMonitorEndpoint{{Port: "metrics", Path: "/metrics", Interval: "15s"}},
+				},
+			})
+	}
+	return objects, nil
+}
+
+// scenarioEventData loads the event files of a scenario into ConfigMap data,
+// keyed by file name. Their includes are inlined, as the ConfigMap holds the
+// event files only; their ${VAR} references are expanded in the pods.
+func scenarioEventData(s *scenario) (map[string]string, error) {
+	var files []string
+	if s.EventFile != "" {
+		files = []string{s.EventFile}
+	} else {
+		var err error
+		files, err = filepath.Glob(filepath.Join(s.DataDir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no event files found for scenario %s", s.Name)
+	}
+	data := make(map[string]string, len(files))
+	for _, file := range files {
+		content, err := events.IncludeFiles(file, nil)
+		if err != nil {
+			return nil, err
+		}
+		data[filepath.Base(file)] = string(content)
+	}
+	return data, nil
+}
+
+func fieldEnv(name, fieldPath string) envVar {
+	return envVar{Name: name, ValueFrom: &envVarSource{FieldRef: &objectFieldSelector{FieldPath: fieldPath}}}
+}
@@ -0,0 +1,416 @@
+package tester
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+const (
+	// jobPollInterval is how often k8s run checks the pods and Job it started
+	jobPollInterval = 2 * time.Second
+	// jobGracePeriod is how long k8s run waits for a Job beyond its initial
+	// delay and duration by default, for scheduling and image pulls
+	jobGracePeriod = 5 * time.Minute
+)
+
+// podStartFailures are the waiting reasons of containers that will not start
+// without intervention, so k8s run gives up instead of waiting for them.
+var podStartFailures = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// jobPodList is the part of the pods of a Job used to follow them.
+type jobPodList struct {
+	Items []struct {
+		Metadata loadgen.ObjectMeta `json:"metadata"`
+		Status   struct {
+			Phase             string `json:"phase"`
+			ContainerStatuses []struct {
+				State struct {
+					Waiting *struct {
+						Reason  string `json:"reason"`
+						Message string `json:"message"`
+					} `json:"waiting"`
+					Terminated *struct {
+						ExitCode int `json:"exitCode"`
+					} `json:"terminated"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// jobStatus is the part of the status of a Job used to wait for it.
+type jobStatus struct {
+	Status struct {
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+	} `json:"status"`
+}
+
+// runK8sRun renders the Job and ConfigMap of a run like k8s emit, applies
+// them to the cluster, follows the logs of the pods until the Job finished
+// and writes the reports of the pods. The objects are deleted afterwards
+// unless -keep is given.
+func runK8sRun(args []string) error {
+	parse := func(cfg *loadgen.Config, out io.Writer) *k8sRunFlags {
+		fs := flag.NewFlagSet("k8s run", flag.ExitOnError)
+		fs.SetOutput(out)
+		var kf k8sRunFlags
+		kf.bind(fs, cfg)
+		fs.Parse(args) //nolint: errcheck
+		return &kf
+	}
+	cfg := loadgen.DefaultConfig()
+	kf := parse(&cfg, os.Stderr)
+	cfg.ApplyEnv()
+
+	client, err := loadgen.NewKubeClient(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	s := &scenario{Config: loadgen.DefaultConfig(), Kubernetes: kubernetesSpec{Namespace: client.Namespace}}
+	if kf.config != "" {
+		if s, err = loadScenario(kf.config); err != nil {
+			return err
+		}
+		if err := s.singlePhase("k8s run"); err != nil {
+			return err
+		}
+	}
+	s.setDefaults()
+	// the flags given override the scenario, and the environment both
+	parse(&s.Config, io.Discard)
+	s.Config.ApplyEnv()
+	if kf.image != "" {
+		s.Kubernetes.Image = kf.image
+	}
+	// -replicas sets the pods of the Job, which shard the rate among them
+	if s.Replicas > 0 {
+		s.Kubernetes.Replicas = s.Replicas
+	}
+	if kf.namespace != "" {
+		s.Kubernetes.Namespace = kf.namespace
+	}
+	if kf.name != "" {
+		s.Name = kf.name
+	}
+	s.Kubernetes.Kind = "Job"
+	if err := s.Config.Validate(); err != nil {
+		return err
+	}
+	// a name of its own per run, so runs do not collide with earlier ones
+	s.Name = fmt.Sprintf("%s-%s", s.Name, strconv.FormatInt(time.Now().Unix(), 36))
+
+	objects, err := renderScenario(s)
+	if err != nil {
+		return err
+	}
+	cm, j := objects[0].(*configMap), objects[1].(*job)
+	c := &j.Spec.Template.Spec.Containers[0]
+	// the pods print their report after the logs, for the report to be read
+	// back from the log stream, and check the SLA thresholds themselves
+	c.Env = append(c.Env, envVar{Name: "REPORT_FILE", Value: "-"}, envVar{Name: "REPORT_FORMAT", Value: loadgen.ReportJSON})
+	for name, v := range map[string]float64{
+		"MAX_ERROR_RATE":    s.MaxErrorRate,
+		"MAX_P99_MS":        s.MaxP99Ms,
+		"MIN_ACHIEVED_RATE": s.MinAchievedRate,
+	} {
+		if v > 0 {
+			c.Env = append(c.Env, envVar{Name: name, Value: strconv.FormatFloat(v, 'f', -1, 64)})
+		}
+	}
+	for name, n := range map[string]int{
+		"MAX_ERRORS":             s.MaxErrors,
+		"MAX_CONSECUTIVE_ERRORS": s.MaxConsecutiveErrors,
+	} {
+		if n > 0 {
+			c.Env = append(c.Env, envVar{Name: name, Value: strconv.Itoa(n)})
+		}
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	ns := url.PathEscape(s.Kubernetes.Namespace)
+	cmPath := fmt.Sprintf("/api/v1/namespaces/%s/configmaps", ns)
+	jobPath := fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", ns)
+	if err := client.Create(ctx, cmPath, cm); err != nil {
+		return fmt.Errorf("failed to create ConfigMap: %w", err)
+	}
+	if !kf.keep {
+		defer deleteObject(client, cmPath+"/"+cm.Metadata.Name, "ConfigMap")
+	}
+	if err := client.Create(ctx, jobPath, j); err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+	if !kf.keep {
+		defer deleteObject(client, jobPath+"/"+j.Metadata.Name, "Job")
+	}
+	log.Infof("Started Job %s/%s with %d pods on %s", s.Kubernetes.Namespace, j.Metadata.Name, s.Kubernetes.Replicas, client.Server)
+
+	// a continuous run is followed until it is stopped
+	timeout := kf.timeout
+	if timeout <= 0 && !s.Continuous() {
+		timeout = time.Duration(s.Delay)*time.Second + time.Duration(s.Duration*float64(time.Second)) + jobGracePeriod
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	jr := &jobRun{client: client, namespace: s.Kubernetes.Namespace, name: j.Metadata.Name,
+		container: c.Name, replicas: s.Kubernetes.Replicas}
+	reports, err := jr.follow(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sla loadgen.SLAViolation
+	for _, r := range reports {
+		sla.AddViolations(r.Result)
+	}
+	var report interface{} = reports
+	if len(reports) == 1 {
+		report = reports[0]
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if kf.output == "" {
+		fmt.Println(string(data))
+	} else {
+		log.Infof("Writing report to %s", kf.output)
+		if err := os.WriteFile(kf.output, data, 0644); err != nil {
+			return err
+		}
+	}
+	if err := sla.Err(); err != nil {
+		return err
+	}
+	return jr.err
+}
+
+// k8sRunFlags are the flags of k8s run besides the run settings.
+type k8sRunFlags struct {
+	config    string
+	image     string
+	namespace string
+	name      string
+	timeout   time.Duration
+	keep      bool
+	output    string
+}
+
+func (kf *k8sRunFlags) bind(fs *flag.FlagSet, cfg *loadgen.Config) {
+	cfg.BindFlags(fs)
+	fs.StringVar(&kf.config, "config", "", "Scenario file with the run settings (flags given override it)")
+	fs.StringVar(&kf.image, "image", "", "Container image (overrides scenario)")
+	fs.StringVar(&kf.namespace, "namespace", "", "Namespace of the Job (default: scenario, or namespace of the context)")
+	fs.StringVar(&kf.name, "name", "", "Name prefix of the Job and ConfigMap (default: scenario name)")
+	fs.DurationVar(&kf.timeout, "timeout", 0, "Longest wait for the Job to finish (default: delay and duration plus 5m, none for a continuous run)")
+	fs.BoolVar(&kf.keep, "keep", false, "Keep the Job and ConfigMap when the run is over")
+	fs.StringVar(&kf.output, "o", "", "Write the JSON report to this file (default stdout)")
+}
+
+// deleteObject deletes an object k8s run created. It runs when the run is
+// over, also when it was interrupted, so it does not use the context of the
+// run.
+func deleteObject(client *loadgen.KubeClient, path, kind string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.Delete(ctx, path); err != nil {
+		log.Warnf("Failed to delete %s: %v", kind, err)
+		return
+	}
+	log.Infof("Deleted %s %s", kind, path[strings.LastIndex(path, "/")+1:])
+}
+
+// jobRun follows the pods of a Job started by k8s run.
+type jobRun struct {
+	client    *loadgen.KubeClient
+	namespace string
+	name      string
+	container string
+	replicas  int
+
+	mu      sync.Mutex
+	reports map[string]*loadgen.RunReport
+	// err is set when a pod failed for another reason than its SLA
+	err error
+}
+
+// follow streams the logs of the pods of the Job to the log until the Job
+// finished and returns the reports the pods printed, ordered by pod name.
+func (jr *jobRun) follow(ctx context.Context) ([]*loadgen.RunReport, error) {
+	jr.reports = map[string]*loadgen.RunReport{}
+	var wg sync.WaitGroup
+	followed := map[string]bool{}
+	for {
+		pods, err := jr.pods(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			name := pod.Metadata.Name
+			if followed[name] || pod.Status.Phase == "Pending" {
+				for _, cs := range pod.Status.ContainerStatuses {
+					if w := cs.State.Waiting; w != nil && podStartFailures[w.Reason] {
+						return nil, fmt.Errorf("pod %s cannot start: %s: %s", name, w.Reason, w.Message)
+					}
+				}
+				continue
+			}
+			followed[name] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				jr.streamLogs(ctx, name)
+			}()
+		}
+		done, err := jr.finished(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if done && len(followed) >= jr.replicas {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, fmt.Errorf("job %s did not finish: %w", jr.name, ctx.Err())
+		case <-time.After(jobPollInterval):
+		}
+	}
+	wg.Wait()
+
+	pods, err := jr.pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*loadgen.RunReport
+	for _, pod := range pods.Items {
+		name := pod.Metadata.Name
+		for _, cs := range pod.Status.ContainerStatuses {
+			if t := cs.State.Terminated; t != nil && t.ExitCode != 0 && t.ExitCode != exitSLAViolation && jr.err == nil {
+				jr.err = fmt.Errorf("pod %s of job %s exited with %d", name, jr.name, t.ExitCode)
+			}
+		}
+		if r := jr.reports[name]; r != nil {
+			reports = append(reports, r)
+		} else if jr.err == nil {
+			jr.err = fmt.Errorf("pod %s of job %s printed no report", name, jr.name)
+		}
+	}
+	if len(reports) == 0 {
+		return nil, jr.err
+	}
+	return reports, nil
+}
+
+func (jr *jobRun) pods(ctx context.Context) (*jobPodList, error) {
+	var pods jobPodList
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods", url.PathEscape(jr.namespace))
+	if err := jr.client.Get(ctx, path, url.Values{"labelSelector": {"job-name=" + jr.name}}, &pods); err != nil {
+		return nil, fmt.Errorf("failed to list pods of job %s: %w", jr.name, err)
+	}
+	return &pods, nil
+}
+
+// finished reports whether every pod of the Job succeeded or failed.
+func (jr *jobRun) finished(ctx context.Context) (bool, error) {
+	var j jobStatus
+	path := fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", url.PathEscape(jr.namespace), url.PathEscape(jr.name))
+	if err := jr.client.Get(ctx, path, nil, &j); err != nil {
+		return false, fmt.Errorf("failed to get job %s: %w", jr.name, err)
+	}
+	return j.Status.Succeeded+j.Status.Failed >= jr.replicas, nil
+}
+
+// streamLogs copies the log of a pod to the log until the pod terminated and
+// keeps the report it prints. A stream the API server closes while the pod
+// still runs is opened again from the time of the last line.
+func (jr *jobRun) streamLogs(ctx context.Context, pod string) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", url.PathEscape(jr.namespace), url.PathEscape(pod))
+	prefix := ""
+	if jr.replicas > 1 {
+		prefix = "[" + pod + "] "
+	}
+	var last time.Time
+	var report []string
+	inReport := false
+	for ctx.Err() == nil {
+		query := url.Values{"follow": {"true"}, "timestamps": {"true"}, "container": {jr.container}}
+		if !last.IsZero() {
+			query.Set("sinceTime", last.Format(time.RFC3339))
+		}
+		body, err := jr.client.Stream(ctx, path, query)
+		if err != nil {
+			log.Warnf("Failed to follow the log of pod %s: %v", pod, err)
+			return
+		}
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			ts, line, _ := strings.Cut(scanner.Text(), " ")
+			t, err := time.Parse(time.RFC3339Nano, ts)
+			if err == nil {
+				if !t.After(last) {
+					// sinceTime has a resolution of seconds, skip lines seen
+					continue
+				}
+				last = t
+			}
+			// the report is the indented JSON object the pod prints last
+			switch {
+			case !inReport && line == "{":
+				inReport, report = true, []string{line}
+			case inReport:
+				if report = append(report, line); line == "}" {
+					inReport = false
+					jr.addReport(pod, strings.Join(report, "\n"))
+				}
+			default:
+				fmt.Fprintln(os.Stderr, prefix+line)
+			}
+		}
+		body.Close()
+
+		var pods jobPodList
+		podPath := fmt.Sprintf("/api/v1/namespaces/%s/pods", url.PathEscape(jr.namespace))
+		query = url.Values{"fieldSelector": {"metadata.name=" + pod}}
+		if err := jr.client.Get(ctx, podPath, query, &pods); err != nil || len(pods.Items) == 0 ||
+			pods.Items[0].Status.Phase != "Running" {
+			return
+		}
+	}
+}
+
+func (jr *jobRun) addReport(pod, data string) {
+	var r loadgen.RunReport
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		log.Warnf("Malformed report of pod %s: %v", pod, err)
+		return
+	}
+	jr.mu.Lock()
+	jr.reports[pod] = &r
+	jr.mu.Unlock()
+}
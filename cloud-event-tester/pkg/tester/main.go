@@ -0,0 +1,477 @@
+package tester
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// Main runs the cloud-event-tester command with the arguments of the
+// process.
+func Main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := lookupCommand(os.Args[1]); ok {
+			initLogger("")
+			if err := cmd.run(os.Args[2:]); err != nil {
+				exitOnSLA(err)
+				exitOnRegression(err)
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	// command line flags, the compatibility mode of the send and perf
+	// commands with every flag
+	cfg := loadgen.DefaultConfig()
+	var mf mainFlags
+	mf.bind(flag.CommandLine, &cfg)
+	flag.Parse()
+	initLogger(mf.logFormat)
+
+	if mf.help {
+		showHelp()
+		return
+	}
+	if err := runTests(&mf, cfg, os.Args[1:], ""); err != nil {
+		exitOnSLA(err)
+		log.Fatal(err)
+	}
+}
+
+// runTests runs the tests of the parsed flags mf and cfg, or the phases of
+// the scenario file of mf, parsed again from args. perf, if not empty, is
+// the test mode of the command run, whatever PERF or the scenario set.
+func runTests(mf *mainFlags, cfg loadgen.Config, args []string, perf string) error {
+	cfg.ApplyEnv()
+	if envMetricsAddr := os.Getenv("METRICS_ADDR"); envMetricsAddr != "" {
+		mf.metricsAddr = envMetricsAddr
+	}
+	if envCPUProfile := os.Getenv("CPU_PROFILE"); envCPUProfile != "" {
+		mf.cpuProfile = envCPUProfile
+	}
+	if envMemProfile := os.Getenv("MEM_PROFILE"); envMemProfile != "" {
+		mf.memProfile = envMemProfile
+	}
+	phases := []phaseConfig{{cfg: cfg}}
+	if mf.config != "" {
+		var err error
+		if phases, err = scenarioPhases(mf.config, args); err != nil {
+			return err
+		}
+	}
+	// check every phase before the first one starts
+	for i := range phases {
+		p := &phases[i]
+		if perf != "" {
+			p.cfg.Perf = perf
+		}
+		if err := p.cfg.Validate(); err != nil {
+			if len(phases) > 1 {
+				return fmt.Errorf("phase %s: %w", p.name, err)
+			}
+			return err
+		}
+	}
+	probes := startMetricsServer(mf.metricsAddr)
+	profile, err := startSelfProfile(mf.cpuProfile, mf.memProfile)
+	if err != nil {
+		return err
+	}
+	defer profile.stop()
+
+	log.Infof("Cloud Event Tester starting...")
+	ctx, stop := signalContext()
+	defer stop()
+	ctx = loadgen.WithHealthProbes(ctx, probes)
+	if envJoin := os.Getenv("COORDINATOR_URL"); envJoin != "" {
+		mf.join = envJoin
+	}
+	if mf.join != "" {
+		return runWorker(ctx, mf.join, &phases[0].cfg)
+	}
+	var sla loadgen.SLAViolation
+	for i := range phases {
+		cfg := &phases[i].cfg
+		if len(phases) > 1 {
+			log.Infof("=== Phase %d/%d: %s ===", i+1, len(phases), phases[i].name)
+		}
+		switch {
+		case cfg.TargetsFile != "":
+			log.Infof("Target URLs: from %s", cfg.TargetsFile)
+		case len(cfg.Targets) > 1:
+			log.Infof("Target URLs: %d weighted targets", len(cfg.Targets))
+		default:
+			log.Infof("Target URL: %s", cfg.URL)
+		}
+		log.Infof("Test Mode: %s", func() string {
+			if cfg.IsPerf() {
+				return "Performance"
+			}
+			return "Basic"
+		}())
+		var dash *dashboard
+		var onTick loadgen.StatsListener
+		if mf.tui && cfg.IsPerf() {
+			if dash = newDashboard(cfg); dash != nil {
+				onTick = dash.tick
+			}
+		} else if mf.tui {
+			log.Warnf("The dashboard shows performance runs only")
+		}
+		result, err := loadgen.Run(dash.context(ctx), cfg, onTick)
+		dash.close()
+		if err != nil {
+			return err
+		}
+		sla.AddViolations(result)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return sla.Err()
+}
+
+// exitOnSLA exits with exitSLAViolation if err is an SLA violation.
+func exitOnSLA(err error) {
+	var v *loadgen.SLAViolation
+	if errors.As(err, &v) {
+		log.Error(v)
+		os.Exit(exitSLAViolation)
+	}
+}
+
+// mainFlags are the flags of the default command besides the run settings.
+type mainFlags struct {
+	config      string
+	metricsAddr string
+	join        string
+	help        bool
+	logFormat   string
+	tui         bool
+	cpuProfile  string
+	memProfile  string
+}
+
+func (m *mainFlags) bind(fs *flag.FlagSet, cfg *loadgen.Config) {
+	cfg.BindFlags(fs)
+	fs.StringVar(&m.config, "config", "", "Scenario file with the run settings and phases (flags given override it)")
+	fs.StringVar(&m.metricsAddr, "metrics-addr", "", "Listen address of the health and metrics endpoints (disabled if empty)")
+	fs.StringVar(&m.join, "join", "", "URL of the coordinator of a distributed run to send a part of it for")
+	fs.BoolVar(&m.help, "help", false, "Show help message")
+	fs.StringVar(&m.logFormat, "log-format", "", "Log format (text/json, default: LOG_FORMAT or text)")
+	fs.BoolVar(&m.tui, "tui", false, "Show a live dashboard of performance runs instead of the log")
+	fs.StringVar(&m.cpuProfile, "cpuprofile", "", "Write the CPU profile of the tester over the runs to this file, for go tool pprof")
+	fs.StringVar(&m.memProfile, "memprofile", "", "Write the heap profile of the tester at the end of the runs to this file, for go tool pprof")
+}
+
+// scenarioPhases loads the run settings of the default command from a
+// scenario file. The command line is parsed again on top of each phase, so
+// the flags given override the file, and the environment overrides both as
+// it does without a file.
+func scenarioPhases(path string, args []string) ([]phaseConfig, error) {
+	s, err := loadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Clusters) > 0 {
+		return nil, fmt.Errorf("scenario %s has clusters, run it with the run command", s.Name)
+	}
+	if len(s.Workloads) > 0 {
+		return nil, fmt.Errorf("scenario %s has workloads, run it with the run command", s.Name)
+	}
+	return s.phaseConfigs(func(c *loadgen.Config) {
+		fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		var mf mainFlags
+		mf.bind(fs, c)
+		// the same arguments were parsed successfully before
+		fs.Parse(args) //nolint: errcheck
+		c.ApplyEnv()
+	})
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM so
+// a run can stop, drain in-flight requests and print its summary. A second
+// signal terminates the process immediately. stop releases the signal
+// handler, so runs in sequence in one process each get their own.
+func signalContext() (ctx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Infof("Received %v, stopping the test (send again to force exit)", sig)
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+func showHelp() {
+	fmt.Println("Cloud Event Tester - A standalone tool for testing cloud events")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Printf("  %s <command> [options]\n", os.Args[0])
+	fmt.Println("")
+	fmt.Println("Commands:")
+	printCommands()
+	fmt.Println("")
+	fmt.Printf("Run %s <command> -help for the options of a command.\n", os.Args[0])
+	fmt.Println("")
+	fmt.Println("Environment Variables (override flags):")
+	fmt.Println("  TEST_DEST_URL         - Target webhook URL")
+	fmt.Println("  MSG_PER_SEC          - Messages per second, or per unit of time like 90/m")
+	fmt.Println("  TEST_DURATION_SEC    - Test duration in seconds (fractions allowed)")
+	fmt.Println("  INITIAL_DELAY_SEC    - Initial delay in seconds")
+	fmt.Println("  CHECK_RESP           - Check response (YES/NO/MULTI_THREAD)")
+	fmt.Println("  WITH_MESSAGE_FIELD   - Include message field (YES/NO)")
+	fmt.Println("  PERF                 - Performance test mode without a command (YES/NO)")
+	fmt.Println("  EVENT_GENERATOR      - Generate events instead of event files")
+	fmt.Println("  GENERATOR_COMMAND    - Command of the exec generator that writes JSON events")
+	fmt.Println("  EVENT_MIX            - Send a random mix of the event files (YES/NO)")
+	fmt.Println("  EVENT_WEIGHTS        - Manifest of the event files to mix and their weights")
+	fmt.Println("  SEND_INTERVAL        - Basic mode: wait between two sends (e.g. 250ms)")
+	fmt.Println("  LOOP_COUNT           - Basic mode: times the event files are sent")
+	fmt.Println("  SHUFFLE_FILES        - Basic mode: send the event files in random order (YES/NO)")
+	fmt.Println("  TRANSPORT            - Transport of the events (http/websocket/kafka)")
+	fmt.Println("  KAFKA_BROKERS        - Comma separated Kafka bootstrap brokers")
+	fmt.Println("  KAFKA_TOPIC          - Kafka topic of the events")
+	fmt.Println("  KAFKA_SASL_MECHANISM - Kafka SASL mechanism (plain/scram-sha-256/scram-sha-512)")
+	fmt.Println("  KAFKA_USERNAME       - Kafka SASL username")
+	fmt.Println("  KAFKA_PASSWORD       - Kafka SASL password")
+	fmt.Println("  KAFKA_TLS            - Connect to the Kafka brokers with TLS (YES/NO)")
+	fmt.Println("  HTTP_STACK           - HTTP stack to send with (fasthttp/nethttp)")
+	fmt.Println("  HTTP_VERSION         - HTTP version to send with (1.1/2/3)")
+	fmt.Println("  MAX_CONNS_PER_HOST   - Maximum connections to each target")
+	fmt.Println("  CONN_WAIT_TIMEOUT    - Wait for a free connection (duration)")
+	fmt.Println("  READ_TIMEOUT         - Response read timeout (duration)")
+	fmt.Println("  WRITE_TIMEOUT        - Request write timeout (duration)")
+	fmt.Println("  REQUEST_TIMEOUT      - Deadline of a whole send (duration, 0 for none)")
+	fmt.Println("  READ_BUFFER_SIZE     - Read buffer of a connection in bytes")
+	fmt.Println("  WRITE_BUFFER_SIZE    - Write buffer of a connection in bytes")
+	fmt.Println("  KEEP_ALIVE           - How long an idle connection is kept open (duration)")
+	fmt.Println("  NO_KEEP_ALIVE        - Send every request on a new connection (YES/NO)")
+	fmt.Println("  CONN_MAX_LIFETIME    - Close connections open this long (duration)")
+	fmt.Println("  RAW_HEADER_NAMES     - Keep the case of header names (YES/NO)")
+	fmt.Println("  LATENCY_BREAKDOWN    - Time the DNS, connect, TLS and time-to-first-byte phases of every Nth request")
+	fmt.Println("  TEST_PROXY           - HTTP proxy of every target")
+	fmt.Println("  SOCKS5_PROXY         - SOCKS5 proxy of every target")
+	fmt.Println("  HTTP_PROXY, HTTPS_PROXY, NO_PROXY - Proxies of the targets without -proxy or -socks5")
+	fmt.Println("  TLS_CA_CERT          - CA bundle to verify HTTPS targets with")
+	fmt.Println("  TLS_CLIENT_CERT      - Client certificate for mutual TLS")
+	fmt.Println("  TLS_CLIENT_KEY       - Key of the client certificate")
+	fmt.Println("  TLS_SERVER_NAME      - Server name sent as SNI and verified against target certificates")
+	fmt.Println("  TLS_INSECURE_SKIP_VERIFY - Do not verify target certificates (YES/NO)")
+	fmt.Println("  HOST_HEADER          - Host header sent instead of the host of the URL")
+	fmt.Println("  RESOLVE              - host:addr entries to connect to instead of DNS")
+	fmt.Println("  AUTH_BEARER_TOKEN    - Static bearer token of the events")
+	fmt.Println("  AUTH_TOKEN_FILE      - File with the bearer token, read every minute")
+	fmt.Println("  OAUTH_TOKEN_URL      - OAuth2 client credentials token endpoint")
+	fmt.Println("  OAUTH_CLIENT_ID      - OAuth2 client ID")
+	fmt.Println("  OAUTH_CLIENT_SECRET  - OAuth2 client secret")
+	fmt.Println("  OAUTH_SCOPES         - OAuth2 scopes to request")
+	fmt.Println("  SIGN_SECRET          - Secret the request bodies are signed with as an HMAC")
+	fmt.Println("  SIGN_HEADER          - Header of the signature")
+	fmt.Println("  SIGN_FORMAT          - Signature format (hmac-sha256/hmac-sha512/github/svix)")
+	fmt.Println("  SHARDS               - Pacing loops the rate is split among")
+	fmt.Println("  PUBLISHERS           - Independent publishers to simulate")
+	fmt.Println("  WARMUP_CONNS         - Connections opened before the measured phase")
+	fmt.Println("  PACING               - Pacing strategy (uniform/token-bucket/leaky-bucket)")
+	fmt.Println("  BUCKET_SIZE          - Token bucket capacity, the largest burst")
+	fmt.Println("  DISTRIBUTION         - Gaps between sends (constant/poisson/uniform)")
+	fmt.Println("  BURST_SIZE           - Messages sent back to back every burst interval")
+	fmt.Println("  BURST_INTERVAL       - Time between the starts of two bursts")
+	fmt.Println("  SPIKE_FACTOR         - Factor the rate is multiplied by during spikes")
+	fmt.Println("  SPIKE_EVERY          - Time from the start of one spike to the next")
+	fmt.Println("  SPIKE_DURATION       - How long each spike lasts")
+	fmt.Println("  LOAD_MODEL           - Load model of a performance run (open/closed)")
+	fmt.Println("  WORKERS              - Concurrent senders in MULTI_THREAD mode")
+	fmt.Println("  CONNECTIONS          - Persistent connections of MULTI_THREAD mode")
+	fmt.Println("  QUEUE_SIZE           - Send queue of MULTI_THREAD mode")
+	fmt.Println("  DROP_POLICY          - Full send queue policy (block/drop-new/drop-old)")
+	fmt.Println("  CONTENT_MODE         - CloudEvents content mode (structured/binary)")
+	fmt.Println("  CONTENT_TYPE         - Content-Type of the events (auto or a media type)")
+	fmt.Println("  CONTENT_TYPE_MISMATCH - Send the events with a mismatching Content-Type (YES/NO)")
+	fmt.Println("  BATCH_SIZE           - Events per request of a performance run")
+	fmt.Println("  PAYLOAD_SIZE         - Pad the event of a performance run to this many bytes")
+	fmt.Println("  STREAM_BODIES        - Stream the event files from disk (YES/NO)")
+	fmt.Println("  MAX_BODY_BYTES       - Fail event files and bodies larger than this, e.g. 64MB")
+	fmt.Println("  FAULT_RATE           - Percentage of sends that are broken events")
+	fmt.Println("  FAULT_CLASSES        - Comma separated fault classes to inject")
+	fmt.Println("  CHAOS_DELAY_RATE     - Percentage of sends delayed by up to CHAOS_DELAY")
+	fmt.Println("  CHAOS_ABORT_RATE     - Percentage of sends aborted in the middle of the body")
+	fmt.Println("  CHAOS_TRUNCATE_RATE  - Percentage of sends with the event cut short")
+	fmt.Println("  CHAOS_PAUSE          - Traffic pauses of this long every CHAOS_PAUSE_EVERY")
+	fmt.Println("  ADAPTIVE_RATE        - Adapt the rate of performance runs to 429/503 responses (YES/NO)")
+	fmt.Println("  ADAPTIVE_MIN_RATE    - Lowest rate of ADAPTIVE_RATE, in msg/s")
+	fmt.Println("  ADAPTIVE_STEP        - Msg/s ADAPTIVE_RATE adds every second without throttling")
+	fmt.Println("  BREAKER_FAILURES     - Failed sends in a row that open the circuit breaker of performance runs")
+	fmt.Println("  BREAKER_COOLDOWN     - How long the open circuit breaker holds the sends")
+	fmt.Println("  HEALTH_URL           - Health endpoint of the target polled during performance runs")
+	fmt.Println("  HEALTH_INTERVAL      - Time between two polls of HEALTH_URL")
+	fmt.Println("  SOAK_MODE            - Sample the tester itself during performance runs (YES/NO)")
+	fmt.Println("  SUMMARY_INTERVAL     - Time between two progress summaries of a performance run")
+	fmt.Println("  SOAK_INTERVAL        - Time between two samples of soak mode")
+	fmt.Println("  SEQUENCE_EVENTS      - Number the events for receivers to detect loss (YES/NO)")
+	fmt.Println("  SEND_TIME_STAMP      - Stamp the events with their send time for receivers (YES/NO)")
+	fmt.Println("  CLOCK_SYNC_URL       - Clock endpoint of the receiver to put the send times on its clock")
+	fmt.Println("  CLOCK_SYNC_INTERVAL  - Time between two clock syncs during the run")
+	fmt.Println("  REFRESH_IDS          - Send every event with a new id and time (YES/NO)")
+	fmt.Println("  DUPLICATES           - Send every event this many more times")
+	fmt.Println("  DUPLICATE_JITTER     - Delay every copy by a random time up to this")
+	fmt.Println("  DUPLICATE_INTERLEAVE - Send the copies between the following events (YES/NO)")
+	fmt.Println("  DUPLICATE_CHECK_URL  - Query endpoint asked how many events the consumer processed")
+	fmt.Println("  DUPLICATE_CHECK_PATH - JSONPath of the count of processed events")
+	fmt.Println("  DUPLICATE_CHECK_WAIT - Time the consumer is given to settle before it is asked")
+	fmt.Println("  DATA_ENCODING        - Encoding of the event data (protobuf/avro)")
+	fmt.Println("  DATA_SCHEMA          - Protobuf descriptor set or Avro schema of the encoded data")
+	fmt.Println("  DATA_MESSAGE         - Full name of the protobuf message of the data")
+	fmt.Println("  EVENT_HEADERS        - Extra headers \"Name: value\",... of the events")
+	fmt.Println("  TEST_LABELS          - Labels key=value,... attached to events and results")
+	fmt.Println("  EXPECT_STATUS        - Expected response status codes, like 200,202 or 2xx")
+	fmt.Println("  EXPECT_BODY          - Regular expression the response bodies must match")
+	fmt.Println("  EXPECT_HEADERS       - Required response headers \"Name: regexp\",...")
+	fmt.Println("  SCHEMA_DIR           - Directory of JSON Schemas of the event data")
+	fmt.Println("  SCHEMAS              - Schema files type=file,... of event types")
+	fmt.Println("  SCHEMA_STRICT        - Abort the run on a schema violation (YES/NO)")
+	fmt.Println("  RESULTS_SERVER       - Results server to upload the run report to")
+	fmt.Println("  REPORT_FILE          - File to write the run report to, - for stdout")
+	fmt.Println("  MUTATIONS_FILE       - YAML file of JSONPath rules applied to the events of a performance run as they are sent")
+	fmt.Println("  CAPTURE_DIR          - Directory to write the status, headers and body of failed responses to")
+	fmt.Println("  CAPTURE_MAX          - Most failed responses captured in a run")
+	fmt.Println("  CAPTURE_MAX_BODY     - Bytes of a captured response body kept")
+	fmt.Println("  TRACE_FILE           - File to write a line of JSON for every request of a performance run to")
+	fmt.Println("  TRACE_CONTEXT        - Send every event of a performance run with a new W3C trace context (YES/NO)")
+	fmt.Println("  TRACESTATE           - W3C tracestate sent with the trace context")
+	fmt.Println("  OTLP_ENDPOINT        - OTLP/HTTP collector the spans of the sends are exported to")
+	fmt.Println("  OTEL_SERVICE_NAME    - Service name of the exported spans")
+	fmt.Println("  STATSD_ADDR          - StatsD server host:port the metrics of a performance run are streamed to")
+	fmt.Println("  STATSD_PREFIX        - Prefix of the StatsD metric names")
+	fmt.Println("  STATSD_FORMAT        - StatsD format (statsd/dogstatsd)")
+	fmt.Println("  STATSD_SAMPLE_RATE   - Share of the send latencies sent as StatsD timers")
+	fmt.Println("  PUSHGATEWAY_URL      - Prometheus Pushgateway the metrics of a performance run are pushed to")
+	fmt.Println("  REMOTE_WRITE_URL     - Prometheus remote write endpoint the metrics of a performance run are written to")
+	fmt.Println("  PUSH_INTERVAL        - Time between two pushes of the metrics so far")
+	fmt.Println("  PUSH_JOB             - Job label of the pushed metrics")
+	fmt.Println("  RESULTS_DB           - SQLite database every run is appended to")
+	fmt.Println("  RESULTS_DB_SAMPLES   - Requests of a performance run sampled into RESULTS_DB")
+	fmt.Println("  REPORT_FORMAT        - Format of the report file (json/csv/html)")
+	fmt.Println("  TIMESERIES_FILE      - CSV file to write the stats of every second of a performance run to")
+	fmt.Println("  MAX_ERROR_RATE       - Largest percentage of failed sends (SLA)")
+	fmt.Println("  MAX_P99_MS           - Largest p99 latency in milliseconds (SLA)")
+	fmt.Println("  MAX_ERRORS           - Failed sends that stop performance runs early (SLA)")
+	fmt.Println("  MAX_CONSECUTIVE_ERRORS - Failed sends in a row that stop performance runs early (SLA)")
+	fmt.Println("  MIN_ACHIEVED_RATE    - Smallest percentage of the requested rate (SLA)")
+	fmt.Println("  FIND_MAX             - Search for the highest rate meeting the SLA (step/binary)")
+	fmt.Println("  FIND_MAX_MIN         - Lowest rate of the find-max search")
+	fmt.Println("  FIND_MAX_STEP        - Rate step of the find-max search")
+	fmt.Println("  JUNIT_FILE           - File to write the checks of the run to as JUnit XML")
+	fmt.Println("  NOTIFY_URL           - Webhook notified when a run finishes")
+	fmt.Println("  NOTIFY_FORMAT        - Notification format (json/slack)")
+	fmt.Println("  NOTIFY_ON            - Notify on every run or on failures only (all/failure)")
+	fmt.Println("  PROXY_API            - cloud-event-proxy API addresses probed for -url auto")
+	fmt.Println("  PROXY_RESOURCE       - Resource required at the discovered cloud-event-proxy")
+	fmt.Println("  SUBSCRIBE_ENDPOINT   - Consumer endpoint subscribed to the resource around the run")
+	fmt.Println("  TARGETS_FILE         - File of weighted target URLs, replacing TEST_DEST_URL")
+	fmt.Println("  TARGET_SELECTOR      - Kubernetes label selector of target pods")
+	fmt.Println("  TARGET_SERVICE       - Kubernetes service of target endpoints, [namespace/]name[:port]")
+	fmt.Println("  TARGET_NAMESPACE     - Namespace for target discovery")
+	fmt.Println("  TARGET_PORT          - Target port of discovered pods")
+	fmt.Println("  TARGET_SPREAD        - Spread load across discovered endpoints (YES/NO)")
+	fmt.Println("  TARGET_REFRESH       - Time between two discoveries of the targets of performance runs")
+	fmt.Println("  KUBE_CONTEXT         - Kubeconfig context for target discovery")
+	fmt.Println("  TEST_BACKUP_URL      - Backup webhook URL for failover")
+	fmt.Println("  FAILOVER_AFTER_SEC   - Seconds unreachable before failing over")
+	fmt.Println("  REDIS_URL            - Redis URL of a shared token bucket")
+	fmt.Println("  GLOBAL_RATE          - Combined messages per second of all testers")
+	fmt.Println("  RATE_KEY             - Redis key of the shared token bucket")
+	fmt.Println("  SHARD_RATE           - Divide the rate among replicas (YES/NO)")
+	fmt.Println("  REPLICAS             - Number of replicas sharing the rate")
+	fmt.Println("  REPLICA_ORDINAL      - Ordinal of this replica")
+	fmt.Println("  CHECKPOINT_FILE      - Checkpoint file of performance runs")
+	fmt.Println("  CHECKPOINT_INTERVAL_SEC - Seconds between checkpoints")
+	fmt.Println("  RESUME               - Resume from the checkpoint file (YES/NO)")
+	fmt.Println("  METRICS_ADDR         - Listen address of the health and metrics endpoints")
+	fmt.Println("  CPU_PROFILE          - Write the CPU profile of the tester over the runs to this file")
+	fmt.Println("  MEM_PROFILE          - Write the heap profile of the tester at the end of the runs to this file")
+	fmt.Println("  COORDINATOR_URL      - Coordinator of a distributed run to join as a worker")
+	fmt.Println("  LOG_LEVEL           - Log level (debug, info, warn, error)")
+	fmt.Println("  LOG_FORMAT          - Log format (text, json)")
+	fmt.Println("")
+	fmt.Println("Examples:")
+	fmt.Println("  # Send all events in data directory")
+	fmt.Println("  ./cloud-event-tester send -url http://localhost:8080/webhook")
+	fmt.Println("")
+	fmt.Println("  # Send a specific event file")
+	fmt.Println("  ./cloud-event-tester send -url http://localhost:8080/webhook -event-file data/TMP0100.json")
+	fmt.Println("")
+	fmt.Println("  # Re-send an event file whenever it is saved")
+	fmt.Println("  ./cloud-event-tester send -url http://localhost:8080/webhook -event-file my-event.json -watch")
+	fmt.Println("")
+	fmt.Println("  # Run performance test")
+	fmt.Println("  ./cloud-event-tester perf -url http://localhost:8080/webhook -rate 50 -duration 60")
+	fmt.Println("")
+	fmt.Println("  # Run a resumable soak test")
+	fmt.Println("  ./cloud-event-tester perf -duration 172800 -checkpoint-file soak.json -resume")
+	fmt.Println("")
+	fmt.Println("  # Run a scenario against all of its clusters at once")
+	fmt.Println("  ./cloud-event-tester run -config scenarios/fanout.yaml -o fanout-report.json")
+	fmt.Println("")
+	fmt.Println("  # Render Kubernetes manifests for a scenario")
+	fmt.Println("  ./cloud-event-tester k8s emit -config scenarios/example.yaml -replicas 3")
+	fmt.Println("")
+	fmt.Println("  # Run as a sidecar and trigger a performance test remotely")
+	fmt.Println("  ./cloud-event-tester daemon -control-addr :8089 -control-token \"$TOKEN\" -perf YES")
+	fmt.Println("  curl -X POST -H \"Authorization: Bearer $TOKEN\" http://localhost:8089/trigger")
+	fmt.Println("")
+	fmt.Println("  # Run scenarios on a schedule")
+	fmt.Println("  ./cloud-event-tester daemon -schedule scenarios/schedule.yaml")
+}
+
+// Log formats selectable with -log-format
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// initLogger sets the level and format of the log from LOG_LEVEL and the
+// given format, or LOG_FORMAT if it is empty.
+func initLogger(format string) {
+	if format == "" {
+		format = os.Getenv("LOG_FORMAT")
+	}
+	switch strings.ToLower(format) {
+	case "", logText:
+	case logJSON:
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	default:
+		log.Fatalf("log format %q is not text or json", format)
+	}
+	lvl, ok := os.LookupEnv("LOG_LEVEL")
+	// LOG_LEVEL not set, let's default to debug
+	if !ok {
+		lvl = "debug"
+	}
+	// parse string, this is built-in feature of logrus
+	ll, err := log.ParseLevel(lvl)
+	if err != nil {
+		ll = log.DebugLevel
+	}
+	// set global log level
+	log.SetLevel(ll)
+}
@@ -0,0 +1,220 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// The types below are a minimal subset of the Kubernetes API objects needed
+// to run the tester in a cluster, with loadgen.ObjectMeta. They only carry
+// json tags; YAML output is produced from the JSON form so field order
+// matches the struct order.
+
+type typeMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+type envVarSource struct {
+	FieldRef *objectFieldSelector `json:"fieldRef,omitempty"`
+}
+
+type objectFieldSelector struct {
+	FieldPath string `json:"fieldPath"`
+}
+
+type envVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *envVarSource `json:"valueFrom,omitempty"`
+}
+
+type containerPort struct {
+	Name          string `json:"name"`
+	ContainerPort int    `json:"containerPort"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+type httpGetAction struct {
+	Path string `json:"path"`
+	Port int    `json:"port"`
+}
+
+type probe struct {
+	HTTPGet          *httpGetAction `json:"httpGet,omitempty"`
+	PeriodSeconds    int            `json:"periodSeconds,omitempty"`
+	FailureThreshold int            `json:"failureThreshold,omitempty"`
+}
+
+type container struct {
+	Name           string          `json:"name"`
+	Image          string          `json:"image"`
+	Args           []string        `json:"args,omitempty"`
+	Env            []envVar        `json:"env,omitempty"`
+	Ports          []containerPort `json:"ports,omitempty"`
+	VolumeMounts   []volumeMount   `json:"volumeMounts,omitempty"`
+	LivenessProbe  *probe          `json:"livenessProbe,omitempty"`
+	ReadinessProbe *probe          `json:"readinessProbe,omitempty"`
+}
+
+type configMapVolumeSource struct {
+	Name string `json:"name"`
+}
+
+type volume struct {
+	Name      string                 `json:"name"`
+	ConfigMap *configMapVolumeSource `json:"configMap,omitempty"`
+}
+
+type podSpec struct {
+	Containers    []container `json:"containers"`
+	Volumes       []volume    `json:"volumes,omitempty"`
+	RestartPolicy string      `json:"restartPolicy,omitempty"`
+}
+
+type podTemplateSpec struct {
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Spec     podSpec            `json:"spec"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels"`
+}
+
+type jobSpec struct {
+	Parallelism    *int            `json:"parallelism,omitempty"`
+	Completions    *int            `json:"completions,omitempty"`
+	CompletionMode string          `json:"completionMode,omitempty"`
+	BackoffLimit   *int            `json:"backoffLimit,omitempty"`
+	Template       podTemplateSpec `json:"template"`
+}
+
+type job struct {
+	typeMeta
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Spec     jobSpec            `json:"spec"`
+}
+
+type deploymentSpec struct {
+	Replicas *int            `json:"replicas,omitempty"`
+	Selector labelSelector   `json:"selector"`
+	Template podTemplateSpec `json:"template"`
+}
+
+type deployment struct {
+	typeMeta
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Spec     deploymentSpec     `json:"spec"`
+}
+
+type statefulSetSpec struct {
+	Replicas            *int            `json:"replicas,omitempty"`
+	ServiceName         string          `json:"serviceName"`
+	PodManagementPolicy string          `json:"podManagementPolicy,omitempty"`
+	Selector            labelSelector   `json:"selector"`
+	Template            podTemplateSpec `json:"template"`
+}
+
+type statefulSet struct {
+	typeMeta
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Spec     statefulSetSpec    `json:"spec"`
+}
+
+type configMap struct {
+	typeMeta
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Data     map[string]string  `json:"data"`
+}
+
+type servicePort struct {
+	Name       string `json:"name"`
+	Port       int    `json:"port"`
+	TargetPort int    `json:"targetPort"`
+}
+
+type serviceSpec struct {
+	Selector map[string]string `json:"selector"`
+	Ports    []servicePort     `json:"ports"`
+}
+
+type service struct {
+	typeMeta
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Spec     serviceSpec        `json:"spec"`
+}
+
+type serviceMonitorEndpoint struct {
+	Port     string `json:"port"`
+	Path     string `json:"path"`
+	Interval string `json:"interval,omitempty"`
+}
+
+type serviceMonitorSpec struct {
+	Selector  labelSelector            `json:"selector"`
+	Endpoints []serviceMonitorEndpoint `json:"endpoints"`
+}
+
+type serviceMonitor struct {
+	typeMeta
+	Metadata loadgen.ObjectMeta `json:"metadata"`
+	Spec     serviceMonitorSpec `json:"spec"`
+}
+
+// toYAML renders the given objects as a multi-document YAML stream.
+func toYAML(objects ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		// JSON is valid YAML, decoding it into a node keeps the key order
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		clearStyle(&node)
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return nil, err
+		}
+		enc.Close()
+	}
+	return buf.Bytes(), nil
+}
+
+// clearStyle drops the flow/quoted styles inherited from the JSON input so
+// the output reads like hand-written YAML. Strings that YAML 1.1 parsers
+// (including kubectl) would read as booleans, e.g. YES/NO, stay quoted.
+func clearStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle
+	if node.Kind != yaml.ScalarNode || !yaml11Bools[strings.ToLower(node.Value)] {
+		node.Style &^= yaml.DoubleQuotedStyle
+	}
+	for _, n := range node.Content {
+		clearStyle(n)
+	}
+}
+
+var yaml11Bools = map[string]bool{
+	"y": true, "yes": true, "n": true, "no": true,
+	"true": true, "false": true, "on": true, "off": true,
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,191 @@
+package tester
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "mock-server",
+		summary: "Answer events with configurable status codes, latency and failures",
+		run:     runMockServer,
+	})
+}
+
+// latency distributions of the mock server selectable with -latency-dist
+const (
+	latencyConstant    = "constant"
+	latencyUniform     = "uniform"
+	latencyExponential = "exponential"
+	latencyNormal      = "normal"
+)
+
+// mockStats counts the requests of a mock server.
+type mockStats struct {
+	Requests  uint64
+	Failed    uint64
+	Throttled uint64
+}
+
+// mockServer is a webhook target behaving as configured, the counterpart of
+// the sender features that react to the target: it answers every request
+// after a latency drawn from a distribution around the mean latency, and a
+// share of them with a failure status picked from a list, with a
+// Retry-After header on the 429 and 503 ones, so retries, adaptive rate
+// control and the circuit breaker can be tried without a real consumer.
+// Unlike receive it does not check the events.
+type mockServer struct {
+	status     int
+	failRate   float64
+	failStatus []int
+	retryAfter string
+	latency    func() time.Duration
+	stats      mockStats
+}
+
+func runMockServer(args []string) error {
+	fs := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	listen := fs.String("listen", ":9088", "Listen address for incoming events")
+	status := fs.Int("status", fasthttp.StatusOK, "Status code of the successful responses")
+	failRate := fs.Float64("fail-rate", 0, "Percentage of the requests answered with a failure status")
+	failStatus := fs.String("fail-status", "503", "Comma separated status codes of the failed responses, picked at random")
+	retryAfter := fs.Duration("retry-after", 0, "Retry-After of the failed 429 and 503 responses, in whole seconds (default: none)")
+	latency := fs.Duration("latency", 0, "Mean latency of the responses")
+	latencyDist := fs.String("latency-dist", latencyConstant, "Distribution of the latency around its mean (constant/uniform/exponential/normal)")
+	latencyStddev := fs.Duration("latency-stddev", 0, "Standard deviation of the normal latency (default: a quarter of the mean)")
+	fs.Parse(args) //nolint: errcheck
+	if envListen := os.Getenv("MOCK_LISTEN"); envListen != "" {
+		*listen = envListen
+	}
+
+	m, err := newMockServer(*status, *failRate, *failStatus, *retryAfter, *latency, *latencyDist, *latencyStddev)
+	if err != nil {
+		return err
+	}
+	srv := &fasthttp.Server{Handler: m.handle, Name: "cloud-event-tester"}
+	ctx, stop := signalContext()
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown() //nolint: errcheck
+	}()
+	go m.logStats(ctx.Done())
+
+	log.Infof("Mock server answering on %s with %d after %v %s latency", *listen, m.status, *latency, strings.ToLower(*latencyDist))
+	if m.failRate > 0 {
+		log.Infof("Mock failures: %g%% of the requests with %s%s", *failRate, *failStatus, func() string {
+			if m.retryAfter == "" {
+				return ""
+			}
+			return ", Retry-After " + m.retryAfter + " s on 429 and 503"
+		}())
+	}
+	if err := srv.ListenAndServe(*listen); err != nil {
+		return err
+	}
+	log.Infof("Mock server stopped: %d requests, %d failed", atomic.LoadUint64(&m.stats.Requests), atomic.LoadUint64(&m.stats.Failed))
+	return nil
+}
+
+func newMockServer(status int, failRate float64, failStatus string, retryAfter, latency time.Duration, dist string, stddev time.Duration) (*mockServer, error) {
+	if status < 100 || status > 599 {
+		return nil, fmt.Errorf("status must be a valid HTTP status code, got %d", status)
+	}
+	if failRate < 0 || failRate > 100 {
+		return nil, fmt.Errorf("fail rate must be a percentage between 0 and 100, got %g", failRate)
+	}
+	if latency < 0 || stddev < 0 {
+		return nil, fmt.Errorf("latency must not be negative")
+	}
+	m := &mockServer{status: status, failRate: failRate / 100}
+	for _, s := range strings.Split(failStatus, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("fail status %q is not a valid HTTP status code", s)
+		}
+		m.failStatus = append(m.failStatus, code)
+	}
+	if retryAfter > 0 {
+		m.retryAfter = strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))
+	}
+
+	mean := float64(latency)
+	switch strings.ToLower(dist) {
+	case latencyConstant:
+		m.latency = func() time.Duration { return latency }
+	case latencyUniform:
+		m.latency = func() time.Duration { return time.Duration(2 * rand.Float64() * mean) }
+	case latencyExponential:
+		m.latency = func() time.Duration { return time.Duration(rand.ExpFloat64() * mean) }
+	case latencyNormal:
+		if stddev == 0 {
+			stddev = latency / 4
+		}
+		sd := float64(stddev)
+		m.latency = func() time.Duration {
+			if d := time.Duration(mean + rand.NormFloat64()*sd); d > 0 {
+				return d
+			}
+			return 0
+		}
+	default:
+		return nil, fmt.Errorf("latency distribution %q is not constant, uniform, exponential or normal", dist)
+	}
+	return m, nil
+}
+
+func (m *mockServer) handle(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	atomic.AddUint64(&m.stats.Requests, 1)
+	if d := m.latency(); d > 0 {
+		time.Sleep(d)
+	}
+	if m.failRate > 0 && rand.Float64() < m.failRate {
+		status := m.failStatus[rand.Intn(len(m.failStatus))]
+		atomic.AddUint64(&m.stats.Failed, 1)
+		if m.retryAfter != "" && (status == fasthttp.StatusTooManyRequests || status == fasthttp.StatusServiceUnavailable) {
+			ctx.Response.Header.Set("Retry-After", m.retryAfter)
+			atomic.AddUint64(&m.stats.Throttled, 1)
+		}
+		ctx.Error("mock failure", status)
+		return
+	}
+	ctx.SetStatusCode(m.status)
+}
+
+// logStats prints the requests answered in every second that had any.
+func (m *mockServer) logStats(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last mockStats
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		cur := mockStats{
+			Requests:  atomic.LoadUint64(&m.stats.Requests),
+			Failed:    atomic.LoadUint64(&m.stats.Failed),
+			Throttled: atomic.LoadUint64(&m.stats.Throttled),
+		}
+		if cur.Requests != last.Requests {
+			log.Infof("|Mock requests/s:|%d|failed:|%d|retry-after:|%d|total:|%d|",
+				cur.Requests-last.Requests, cur.Failed-last.Failed, cur.Throttled-last.Throttled, cur.Requests)
+		}
+		last = cur
+	}
+}
@@ -0,0 +1,77 @@
+package tester
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// selfProfile profiles the tester itself over its runs, to tell whether the
+// tester or the target is the bottleneck of a run at a high rate: with
+// -cpuprofile the CPU profile of all runs, and with -memprofile the heap
+// profile at their end, for go tool pprof. A nil profile profiles nothing.
+type selfProfile struct {
+	cpuFile *os.File
+	memPath string
+}
+
+// startSelfProfile starts the CPU profile, if configured.
+func startSelfProfile(cpuPath, memPath string) (*selfProfile, error) {
+	if cpuPath == "" && memPath == "" {
+		return nil, nil
+	}
+	p := &selfProfile{memPath: memPath}
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start the CPU profile: %w", err)
+		}
+		p.cpuFile = f
+		log.Infof("CPU Profile: profiling the tester to %s", cpuPath)
+	}
+	return p, nil
+}
+
+// stop writes the profiles. Failures are logged; they do not fail the run.
+func (p *selfProfile) stop() {
+	if p == nil {
+		return
+	}
+	if p.cpuFile != nil {
+		pprof.StopCPUProfile()
+		if err := p.cpuFile.Close(); err != nil {
+			log.Errorf("Failed to write the CPU profile %s: %v", p.cpuFile.Name(), err)
+		} else {
+			log.Infof("CPU Profile: written to %s", p.cpuFile.Name())
+		}
+	}
+	if p.memPath != "" {
+		if err := writeHeapProfile(p.memPath); err != nil {
+			log.Errorf("Failed to write the heap profile %s: %v", p.memPath, err)
+		} else {
+			log.Infof("Heap Profile: written to %s", p.memPath)
+		}
+	}
+}
+
+// writeHeapProfile writes the heap profile, the memory allocated since the
+// start and, after a garbage collection, the memory still in use.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
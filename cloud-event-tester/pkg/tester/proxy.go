@@ -0,0 +1,36 @@
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "proxy",
+		summary: "cloud-event-proxy helpers (discover)",
+		run:     runProxy,
+	})
+}
+
+func runProxy(args []string) error {
+	if len(args) == 0 || args[0] != "discover" {
+		return fmt.Errorf("usage: %s proxy discover [-proxy-api addresses]", os.Args[0])
+	}
+	fs := flag.NewFlagSet("proxy discover", flag.ExitOnError)
+	addrs := fs.String("proxy-api", loadgen.DefaultProxyAPI, "Comma separated addresses of the cloud-event-proxy REST API to probe")
+	fs.Parse(args[1:]) //nolint: errcheck
+
+	api, err := loadgen.DiscoverProxy(context.Background(), *addrs)
+	if err != nil {
+		return err
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(api)
+}
@@ -0,0 +1,386 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "receive",
+		summary: "Receive cloud events, validate them and print ingest statistics",
+		run:     runReceive,
+	})
+}
+
+// maxLoggedInvalid is how many invalid events the receiver logs as warnings;
+// the rest are only logged at debug level.
+const maxLoggedInvalid = 10
+
+// receiveStats counts the events of a receiver.
+type receiveStats struct {
+	Requests uint64
+	Valid    uint64
+	Invalid  uint64
+	Bytes    uint64
+}
+
+// receiver is a sink for events: it accepts binary, structured and batched
+// cloud events and Redfish events, checks their required attributes and
+// counts them, so a run can be checked end to end with this tool alone.
+// With a recorder it captures the valid events for replay. The numbered
+// events of runs with -sequence are checked for loss, duplicates and
+// reordering, and the delivery latency of those with -send-time measured;
+// GET /runs/<run ID> returns the counts of a run, for the -duplicate-check-url
+// of the sender, and GET /clock its time, for the -clock-sync-url of the
+// sender. With expectations it checks the events received against
+// them and fails if any is not met.
+type receiver struct {
+	status    int
+	strict    bool
+	stats     receiveStats
+	sequences *sequenceTracker
+	delivery  *deliveryMeter
+	recorder  *events.Recorder
+	expect    *expectationSet
+	// recordFailed is set once recording an event failed
+	recordFailed int32
+}
+
+func runReceive(args []string) error {
+	fs := flag.NewFlagSet("receive", flag.ExitOnError)
+	listen := fs.String("listen", ":9087", "Listen address for incoming events")
+	status := fs.Int("status", fasthttp.StatusNoContent, "Status code valid events are answered with")
+	strict := fs.Bool("strict", false, "Reject JSON bodies that are neither cloud events nor Redfish events")
+	record := fs.String("record", "", "NDJSON file to record the valid events to, with the time they were received")
+	clockOffset := fs.Duration("clock-offset", 0, "Offset of the clock of the receiver from that of the sender, as measured by PTP or NTP, taken off the delivery latency")
+	expect := fs.String("expect", "", "Expectations file (YAML) of the events to receive; the receiver fails with a diff if they are not met")
+	fs.Parse(args) //nolint: errcheck
+	if envListen := os.Getenv("RECEIVE_LISTEN"); envListen != "" {
+		*listen = envListen
+	}
+	if envRecord := os.Getenv("RECEIVE_RECORD"); envRecord != "" {
+		*record = envRecord
+	}
+	if envOffset := os.Getenv("RECEIVE_CLOCK_OFFSET"); envOffset != "" {
+		if d, err := time.ParseDuration(envOffset); err == nil {
+			*clockOffset = d
+		}
+	}
+	if envExpect := os.Getenv("RECEIVE_EXPECT"); envExpect != "" {
+		*expect = envExpect
+	}
+	if *status < 200 || *status > 599 {
+		return fmt.Errorf("status must be a valid HTTP status code, got %d", *status)
+	}
+
+	rc := &receiver{status: *status, strict: *strict, sequences: newSequenceTracker(), delivery: newDeliveryMeter(*clockOffset)}
+	if *clockOffset != 0 {
+		log.Infof("Clock Offset: the delivery latency is corrected by %s", *clockOffset)
+	}
+	if *record != "" {
+		rec, err := events.NewRecorder(*record)
+		if err != nil {
+			return err
+		}
+		rc.recorder = rec
+		log.Infof("Recording events to %s", *record)
+	}
+	if *expect != "" {
+		set, err := loadExpectations(*expect)
+		if err != nil {
+			return err
+		}
+		rc.expect = set
+		log.Infof("Expecting the %d expectations of %s", len(set.file.Expectations), *expect)
+	}
+	srv := &fasthttp.Server{Handler: rc.handle, Name: "cloud-event-tester"}
+	ctx, stop := signalContext()
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown() //nolint: errcheck
+	}()
+	go rc.logStats(ctx.Done())
+	if rc.expect != nil {
+		go rc.awaitExpectations(ctx.Done(), stop)
+	}
+
+	start := time.Now()
+	log.Infof("Receiving events on %s", *listen)
+	err := srv.ListenAndServe(*listen)
+	if rc.recorder != nil {
+		n, cerr := rc.recorder.Close()
+		if cerr != nil {
+			log.Errorf("Failed to write recording %s: %v", *record, cerr)
+		} else {
+			log.Infof("Recorded %d events to %s", n, *record)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start).Seconds()
+	log.Infof("Receiver stopped after %.1f s: %s, %.2f valid events/s", elapsed, rc.summary(),
+		float64(atomic.LoadUint64(&rc.stats.Valid))/elapsed)
+	rc.sequences.logRuns()
+	rc.delivery.logSummary()
+	return rc.checkExpectations()
+}
+
+// awaitExpectations stops the receiver once the windows of its
+// expectations have passed, or their timeout.
+func (rc *receiver) awaitExpectations(done <-chan struct{}, stop func()) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if rc.expect.done(now) {
+				log.Infof("Expectations: all windows passed, stopping")
+				stop()
+				return
+			}
+		}
+	}
+}
+
+// checkExpectations prints the expectations not met as a diff and fails if
+// there are any.
+func (rc *receiver) checkExpectations() error {
+	if rc.expect == nil {
+		return nil
+	}
+	unmet, lines := rc.expect.diff()
+	total := len(rc.expect.file.Expectations)
+	if unmet == 0 {
+		log.Infof("Expectations: all %d met", total)
+		return nil
+	}
+	fmt.Println("--- expected")
+	fmt.Println("+++ received")
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return fmt.Errorf("%d of %d expectations not met", unmet, total)
+}
+
+func (rc *receiver) handle(ctx *fasthttp.RequestCtx) {
+	if ctx.IsGet() && string(ctx.Path()) == "/clock" {
+		body, _ := json.Marshal(loadgen.ClockTime{UnixNano: time.Now().UnixNano()})
+		ctx.SetContentType("application/json")
+		ctx.SetBody(body)
+		return
+	}
+	if run := strings.TrimPrefix(string(ctx.Path()), "/runs/"); ctx.IsGet() && run != string(ctx.Path()) {
+		rc.serveRun(ctx, run)
+		return
+	}
+	if !ctx.IsPost() {
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	received := time.Now()
+	atomic.AddUint64(&rc.stats.Requests, 1)
+	atomic.AddUint64(&rc.stats.Bytes, uint64(len(ctx.PostBody())))
+	valid, err := validateEvents(&ctx.Request, rc.strict, stampObserver{rc.sequences, rc.delivery, rc.expect, received})
+	atomic.AddUint64(&rc.stats.Valid, uint64(valid))
+	if err != nil {
+		if n := atomic.AddUint64(&rc.stats.Invalid, 1); n <= maxLoggedInvalid {
+			log.Warnf("Invalid event from %s: %v", ctx.RemoteAddr(), err)
+		} else {
+			log.Debugf("Invalid event from %s: %v", ctx.RemoteAddr(), err)
+		}
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if rc.recorder != nil {
+		if err := rc.recorder.Record(received, &ctx.Request); err != nil && atomic.CompareAndSwapInt32(&rc.recordFailed, 0, 1) {
+			log.Errorf("Failed to record event: %v", err)
+		}
+	}
+	ctx.SetStatusCode(rc.status)
+}
+
+// serveRun answers with the sequence counts of a run as JSON.
+func (rc *receiver) serveRun(ctx *fasthttp.RequestCtx, run string) {
+	counts, ok := rc.sequences.counts(run)
+	if !ok {
+		ctx.Error(fmt.Sprintf("no sequenced events of run %s received", run), fasthttp.StatusNotFound)
+		return
+	}
+	body, _ := json.Marshal(struct {
+		RunID string `json:"runId"`
+		sequenceCounts
+	}{run, counts})
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// validateEvents checks the events of a request and returns the number of
+// valid ones. Binary mode cloud events carry their attributes in ce- headers,
+// structured ones in the JSON body and batches are a JSON array of
+// structured events; a batch with one invalid event is rejected as a whole.
+// A body with an Events array is a Redfish event like the sample events.
+// Other JSON bodies are accepted as events unless strict is set. The
+// attributes of the valid events are passed to obs.
+func validateEvents(req *fasthttp.Request, strict bool, obs eventObserver) (int, error) {
+	if specversion := req.Header.Peek("Ce-Specversion"); len(specversion) > 0 {
+		for _, attr := range []string{"Ce-Id", "Ce-Source", "Ce-Type"} {
+			if len(req.Header.Peek(attr)) == 0 {
+				return 0, fmt.Errorf("binary event without %s header", attr)
+			}
+		}
+		obs.observeHeaders(req)
+		return 1, nil
+	}
+	body := bytes.TrimSpace(req.Body())
+	if len(body) == 0 {
+		return 0, errors.New("empty body")
+	}
+	if bytes.HasPrefix(req.Header.ContentType(), []byte("application/cloudevents-batch+json")) || body[0] == '[' {
+		var batch []map[string]interface{}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return 0, fmt.Errorf("malformed batch: %w", err)
+		}
+		for i, ev := range batch {
+			if err := validateAttributes(ev); err != nil {
+				return 0, fmt.Errorf("event %d of batch: %w", i, err)
+			}
+		}
+		for _, ev := range batch {
+			obs.observe(ev)
+		}
+		return len(batch), nil
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return 0, fmt.Errorf("malformed event: %w", err)
+	}
+	switch {
+	case ev["specversion"] != nil:
+		if err := validateAttributes(ev); err != nil {
+			return 0, err
+		}
+		obs.observe(ev)
+	case ev["Events"] != nil:
+		if err := validateRedfish(ev); err != nil {
+			return 0, err
+		}
+	case strict:
+		return 0, errors.New("neither a cloud event nor a Redfish event")
+	}
+	return 1, nil
+}
+
+// eventObserver is passed the attributes of the valid events of a request.
+type eventObserver interface {
+	observe(ev map[string]interface{})
+	observeHeaders(req *fasthttp.Request)
+}
+
+// stampObserver records the attributes of -sequence and -send-time of the
+// events of a request received at received, and checks them against the
+// expectations.
+type stampObserver struct {
+	sequences *sequenceTracker
+	delivery  *deliveryMeter
+	expect    *expectationSet
+	received  time.Time
+}
+
+func (o stampObserver) observe(ev map[string]interface{}) {
+	o.sequences.observe(ev)
+	o.delivery.observe(ev, o.received)
+	o.expect.observe(ev, o.received)
+}
+
+func (o stampObserver) observeHeaders(req *fasthttp.Request) {
+	o.sequences.observeHeaders(req)
+	o.delivery.observeHeaders(req, o.received)
+	o.expect.observeHeaders(req, o.received)
+}
+
+// validateAttributes checks the required attributes of a structured event.
+func validateAttributes(ev map[string]interface{}) error {
+	for _, attr := range []string{"specversion", "id", "source", "type"} {
+		if v, ok := ev[attr].(string); !ok || v == "" {
+			return fmt.Errorf("missing attribute %s", attr)
+		}
+	}
+	return nil
+}
+
+// validateRedfish checks that a Redfish event has records and that each has
+// the attributes consumers match events on.
+func validateRedfish(ev map[string]interface{}) error {
+	records, ok := ev["Events"].([]interface{})
+	if !ok || len(records) == 0 {
+		return errors.New("redfish event without records")
+	}
+	for i, r := range records {
+		record, ok := r.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("record %d of redfish event is not an object", i)
+		}
+		for _, attr := range []string{"EventType", "MessageId"} {
+			if v, ok := record[attr].(string); !ok || v == "" {
+				return fmt.Errorf("record %d of redfish event without %s", i, attr)
+			}
+		}
+	}
+	return nil
+}
+
+// logStats prints the events received in every second that had any.
+func (rc *receiver) logStats(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last receiveStats
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		cur := receiveStats{
+			Requests: atomic.LoadUint64(&rc.stats.Requests),
+			Valid:    atomic.LoadUint64(&rc.stats.Valid),
+			Invalid:  atomic.LoadUint64(&rc.stats.Invalid),
+			Bytes:    atomic.LoadUint64(&rc.stats.Bytes),
+		}
+		if cur.Requests != last.Requests {
+			log.Infof("|Received events/s:|%d|invalid:|%d|bytes/s:|%d|total:|%d|",
+				cur.Valid-last.Valid, cur.Invalid-last.Invalid, cur.Bytes-last.Bytes, cur.Valid)
+			if c, runs := rc.sequences.totals(); runs > 0 {
+				log.Infof("|Sequenced runs:|%d|missing:|%d|duplicates:|%d|reordered:|%d|",
+					runs, c.Missing, c.Duplicates, c.Reordered)
+			}
+			if l := rc.delivery.tick(); l != nil {
+				log.Infof("|Delivery latency ms p50:|%.3f|p99:|%.3f|max:|%.3f|", l.P50, l.P99, l.Max)
+			}
+		}
+		last = cur
+	}
+}
+
+func (rc *receiver) summary() string {
+	return fmt.Sprintf("%d requests, %d valid events, %d invalid, %d bytes",
+		atomic.LoadUint64(&rc.stats.Requests), atomic.LoadUint64(&rc.stats.Valid),
+		atomic.LoadUint64(&rc.stats.Invalid), atomic.LoadUint64(&rc.stats.Bytes))
+}
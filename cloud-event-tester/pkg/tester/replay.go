@@ -0,0 +1,95 @@
+package tester
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "replay",
+		summary: "Replay an NDJSON recording of events to the target",
+		run:     runReplay,
+	})
+}
+
+func runReplay(args []string) error {
+	cfg := loadgen.DefaultConfig()
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	// -loop of the run config is how many times the recording is replayed
+	cfg.BindFlags(fs)
+	file := fs.String("recording", "", "NDJSON recording to replay, one event per line, or a HAR or pcap capture to import and replay (required)")
+	timing := loadgen.ReplayTiming{Speed: 1}
+	fs.BoolVar(&timing.Original, "original-timing", false, "Keep the gaps between the events of a recording made by receive -record")
+	fs.Float64Var(&timing.Speed, "speed", timing.Speed, "Speed multiplier of -original-timing, 2 replays twice as fast")
+	var imp captureImport
+	imp.bind(fs)
+	fs.Parse(args) //nolint: errcheck
+	cfg.ApplyEnv()
+	imp.applyEnv()
+	if envRecording := os.Getenv("REPLAY_RECORDING"); envRecording != "" {
+		*file = envRecording
+	}
+	if envTiming := os.Getenv("REPLAY_ORIGINAL_TIMING"); envTiming != "" {
+		timing.Original = strings.ToUpper(envTiming) == "YES"
+	}
+	if envSpeed := os.Getenv("REPLAY_SPEED"); envSpeed != "" {
+		if speed, err := strconv.ParseFloat(envSpeed, 64); err == nil {
+			timing.Speed = speed
+		}
+	}
+
+	if *file == "" {
+		return fmt.Errorf("-recording is required")
+	}
+	if cfg.Rate < 0 {
+		return fmt.Errorf("rate must not be negative, got %d", cfg.Rate)
+	}
+	if timing.Speed <= 0 {
+		return fmt.Errorf("speed must be positive, got %g", timing.Speed)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if isCapture(*file) {
+		recording, err := importToTemp(&imp, *file)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(recording)
+		*file = recording
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	result, err := loadgen.Replay(ctx, &cfg, *file, cfg.Loop, timing)
+	if result != nil {
+		result.Labels = cfg.Labels
+		loadgen.PublishReport(&cfg, result)
+	}
+	loadgen.NotifyCompletion(&cfg, result, err)
+	return err
+}
+
+// importToTemp imports a capture to a temporary recording to replay and
+// returns its path.
+func importToTemp(imp *captureImport, capture string) (string, error) {
+	f, err := os.CreateTemp("", "replay-*.ndjson")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	n, err := imp.run(capture, f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	log.Infof("Imported %d events from capture %s", n, capture)
+	return f.Name(), nil
+}
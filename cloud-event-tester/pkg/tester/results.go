@@ -0,0 +1,348 @@
+package tester
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+var reportsBucket = []byte("reports")
+
+func init() {
+	registerCommand(&command{
+		name:    "results-server",
+		summary: "Store run reports and serve a browse and comparison API",
+		run:     runResultsServer,
+	})
+}
+
+// resultsServer stores the reports uploaded by testers in a bolt database.
+type resultsServer struct {
+	db *bolt.DB
+}
+
+// reportSummary is the listing entry of a stored report.
+type reportSummary struct {
+	ID           string            `json:"id"`
+	Received     time.Time         `json:"received"`
+	Host         string            `json:"host,omitempty"`
+	URL          string            `json:"url"`
+	Mode         string            `json:"mode"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	TotalSeconds float64           `json:"totalSeconds,omitempty"`
+	TotalMsg     int               `json:"totalMsg"`
+	AvgRate      float64           `json:"avgRate,omitempty"`
+	Interrupted  bool              `json:"interrupted,omitempty"`
+}
+
+// metricDelta compares one metric of two reports.
+type metricDelta struct {
+	Metric  string  `json:"metric"`
+	A       float64 `json:"a"`
+	B       float64 `json:"b"`
+	Delta   float64 `json:"delta"`
+	Percent float64 `json:"percent,omitempty"`
+}
+
+type reportComparison struct {
+	A       string        `json:"a"`
+	B       string        `json:"b"`
+	Metrics []metricDelta `json:"metrics"`
+}
+
+var errReportNotFound = errors.New("report not found")
+
+func runResultsServer(args []string) error {
+	fs := flag.NewFlagSet("results-server", flag.ExitOnError)
+	addr := fs.String("addr", ":8088", "Listen address")
+	dbPath := fs.String("db", "results.db", "Database file of the stored reports")
+	fs.Parse(args) //nolint: errcheck
+	if envResultsDB := os.Getenv("RESULTS_DB"); envResultsDB != "" {
+		*dbPath = envResultsDB
+	}
+
+	db, err := bolt.Open(*dbPath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", *dbPath, err)
+	}
+	defer db.Close()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(reportsBucket)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	s := &resultsServer{db: db}
+	log.Infof("Results server listening on %s, storing reports in %s", *addr, *dbPath)
+	return http.ListenAndServe(*addr, s.handler())
+}
+
+func (s *resultsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/reports", s.handleReports)
+	mux.HandleFunc("/api/reports/", s.handleReport)
+	mux.HandleFunc("/api/compare", s.handleCompare)
+	mux.HandleFunc("/reports/", s.handleReport)
+	mux.HandleFunc("/", s.handleIndex)
+	return mux
+}
+
+// store saves a report under a new ID. IDs start with the receive time so
+// the database keeps reports in order. The values of sensitive headers are
+// redacted, in case the tester that uploaded it did not.
+func (s *resultsServer) store(report *loadgen.RunReport) error {
+	report.Config = report.Config.Redacted()
+	received := time.Now().UTC()
+	report.Received = &received
+	report.ID = received.Format("20060102T150405Z") + "-" + loadgen.NewRunID()[:6]
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(reportsBucket).Put([]byte(report.ID), data)
+	})
+}
+
+func (s *resultsServer) get(id string) (*loadgen.RunReport, error) {
+	report := &loadgen.RunReport{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(reportsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("%w: %s", errReportNotFound, id)
+		}
+		return json.Unmarshal(data, report)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// list returns the summaries of the stored reports, newest first, that have
+// all the given labels.
+func (s *resultsServer) list(labels map[string]string) ([]reportSummary, error) {
+	var summaries []reportSummary
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(reportsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var report loadgen.RunReport
+			if err := json.Unmarshal(v, &report); err != nil {
+				log.Errorf("Skipping unreadable report %s: %v", k, err)
+				continue
+			}
+			if report.Result == nil || !hasLabels(report.Result.Labels, labels) {
+				continue
+			}
+			summaries = append(summaries, summarize(&report))
+		}
+		return nil
+	})
+	return summaries, err
+}
+
+func summarize(report *loadgen.RunReport) reportSummary {
+	r := report.Result
+	return reportSummary{
+		ID:           report.ID,
+		Received:     *report.Received,
+		Host:         report.Host,
+		URL:          report.Config.URL,
+		Mode:         r.Mode,
+		Labels:       r.Labels,
+		TotalSeconds: r.TotalSeconds,
+		TotalMsg:     r.TotalMsg,
+		AvgRate:      r.AvgRate,
+		Interrupted:  r.Interrupted,
+	}
+}
+
+func hasLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// compare computes the differences of the main metrics of two reports, b
+// relative to a.
+func compare(a, b *loadgen.RunReport) *reportComparison {
+	c := &reportComparison{A: a.ID, B: b.ID}
+	add := func(metric string, va, vb float64) {
+		d := metricDelta{Metric: metric, A: va, B: vb, Delta: vb - va}
+		if va != 0 {
+			d.Percent = (vb - va) / va * 100
+		}
+		c.Metrics = append(c.Metrics, d)
+	}
+	ra, rb := a.Result, b.Result
+	add("totalSeconds", ra.TotalSeconds, rb.TotalSeconds)
+	add("totalMsg", float64(ra.TotalMsg), float64(rb.TotalMsg))
+	add("avgRate", ra.AvgRate, rb.AvgRate)
+	add("succeeded", float64(ra.Succeeded), float64(rb.Succeeded))
+	add("errorRate", ra.ErrorRate, rb.ErrorRate)
+	if la, lb := ra.Latency, rb.Latency; la != nil && lb != nil {
+		add("latency.p50", la.P50, lb.P50)
+		add("latency.p90", la.P90, lb.P90)
+		add("latency.p95", la.P95, lb.P95)
+		add("latency.p99", la.P99, lb.P99)
+		add("latency.p999", la.P999, lb.P999)
+	}
+	return c
+}
+
+// handleReports serves POST /api/reports and GET /api/reports. The listing
+// can be filtered with label=key=value parameters.
+func (s *resultsServer) handleReports(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		report := &loadgen.RunReport{}
+		if err := json.NewDecoder(r.Body).Decode(report); err != nil {
+			http.Error(w, fmt.Sprintf("invalid report: %v", err), http.StatusBadRequest)
+			return
+		}
+		if report.Result == nil {
+			http.Error(w, "invalid report: no result", http.StatusBadRequest)
+			return
+		}
+		if err := s.store(report); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Stored report %s from %s", report.ID, report.Host)
+		writeJSON(w, http.StatusCreated, report)
+	case http.MethodGet:
+		labels, err := events.ParseLabels(strings.Join(r.URL.Query()["label"], ","))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		summaries, err := s.list(labels)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, summaries)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleReport serves GET /api/reports/{id}, the JSON report, and
+// /reports/{id}, the HTML report to share.
+func (s *resultsServer) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	report, err := s.get(id)
+	if errors.Is(err, errReportNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/reports/") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := loadgen.WriteReportHTML(w, report); err != nil {
+			log.Errorf("Failed to render report %s: %v", id, err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleCompare serves GET /api/compare?a={id}&b={id}.
+func (s *resultsServer) handleCompare(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("a") == "" || q.Get("b") == "" {
+		http.Error(w, "parameters a and b are required", http.StatusBadRequest)
+		return
+	}
+	var reports [2]*loadgen.RunReport
+	for i, id := range []string{q.Get("a"), q.Get("b")} {
+		report, err := s.get(id)
+		if errors.Is(err, errReportNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reports[i] = report
+	}
+	writeJSON(w, http.StatusOK, compare(reports[0], reports[1]))
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"labels": events.FormatLabels,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Cloud Event Tester Results</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Cloud Event Tester Results</h1>
+<form action="/api/compare">
+<table>
+<tr><th>A</th><th>B</th><th>Report</th><th>Received</th><th>Host</th><th>Target</th><th>Mode</th><th>Labels</th><th>Seconds</th><th>Messages</th><th>Msg/s</th></tr>
+{{range .}}<tr>
+<td><input type="radio" name="a" value="{{.ID}}"></td>
+<td><input type="radio" name="b" value="{{.ID}}"></td>
+<td><a href="/reports/{{.ID}}">{{.ID}}</a></td>
+<td>{{.Received.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.Host}}</td>
+<td>{{.URL}}</td>
+<td>{{.Mode}}{{if .Interrupted}} (interrupted){{end}}</td>
+<td>{{labels .Labels}}</td>
+<td>{{printf "%.1f" .TotalSeconds}}</td>
+<td>{{.TotalMsg}}</td>
+<td>{{printf "%.2f" .AvgRate}}</td>
+</tr>
+{{end}}</table>
+<p><button type="submit">Compare</button></p>
+</form>
+</body>
+</html>
+`))
+
+// handleIndex serves a simple HTML listing of the stored reports.
+func (s *resultsServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	summaries, err := s.list(nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, summaries); err != nil {
+		log.Errorf("Failed to render index: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package tester
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// scenario describes a complete test run in a file, so the same settings can
+// be used for local runs and for in-cluster deployments.
+type scenario struct {
+	Name           string `yaml:"name"`
+	loadgen.Config `yaml:",inline"`
+	Kubernetes     kubernetesSpec `yaml:"kubernetes"`
+	// Clusters the same load is run against concurrently, see fanOut
+	Clusters []clusterTarget `yaml:"clusters"`
+	// Phases are run one after the other, see phaseConfigs
+	Phases []phase `yaml:"phases"`
+	// Workloads are run at the same time, see workloadConfigs
+	Workloads []phase `yaml:"workloads"`
+}
+
+// phase is one step of a scenario, or one of its workloads. It holds any run
+// settings; those it sets override the settings of the scenario for the
+// phase.
+type phase struct {
+	Name string
+	node yaml.Node
+}
+
+func (p *phase) UnmarshalYAML(n *yaml.Node) error {
+	var named struct {
+		Name string `yaml:"name"`
+	}
+	if err := n.Decode(&named); err != nil {
+		return err
+	}
+	p.Name, p.node = named.Name, *n
+	return nil
+}
+
+// phaseConfig holds the settings of one phase of a scenario run.
+type phaseConfig struct {
+	name string
+	cfg  loadgen.Config
+}
+
+// phaseConfigs returns the settings of the phases of the scenario, or of the
+// scenario itself if it has none. override, if not nil, is applied to each of
+// them last, for the settings given on the command line.
+func (s *scenario) phaseConfigs(override func(*loadgen.Config)) ([]phaseConfig, error) {
+	if len(s.Phases) == 0 {
+		cfg := s.Config.Clone()
+		cfg.Scenario = s.Name
+		if override != nil {
+			override(&cfg)
+		}
+		return []phaseConfig{{name: s.Name, cfg: cfg}}, nil
+	}
+	return s.overlayConfigs("phase", s.Phases, override)
+}
+
+// overlayConfigs returns the settings of the phases or workloads of the
+// scenario, of the given kind, each over the settings of the scenario.
+func (s *scenario) overlayConfigs(kind string, overlays []phase, override func(*loadgen.Config)) ([]phaseConfig, error) {
+	configs := make([]phaseConfig, len(overlays))
+	for i, p := range overlays {
+		cfg := s.Config.Clone()
+		if err := p.node.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s %d of scenario %s: %w", kind, i+1, s.Name, err)
+		}
+		cfg.Scenario = s.Name
+		if override != nil {
+			override(&cfg)
+		}
+		name := p.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", kind, i+1)
+		}
+		configs[i] = phaseConfig{name: name, cfg: cfg}
+	}
+	return configs, nil
+}
+
+// singlePhase returns an error if the scenario has phases or workloads, for
+// the uses of a scenario that run its settings once.
+func (s *scenario) singlePhase(use string) error {
+	if len(s.Phases) > 0 {
+		return fmt.Errorf("scenario %s has phases, which are not supported by %s", s.Name, use)
+	}
+	if len(s.Workloads) > 0 {
+		return fmt.Errorf("scenario %s has workloads, which are not supported by %s", s.Name, use)
+	}
+	return nil
+}
+
+// clusterTarget is one cluster of a fan-out run. Its settings override the
+// target settings of the scenario.
+type clusterTarget struct {
+	Name            string `yaml:"name"`
+	Context         string `yaml:"context"`
+	Kubeconfig      string `yaml:"kubeconfig"`
+	URL             string `yaml:"url"`
+	TargetSelector  string `yaml:"targetSelector"`
+	TargetService   string `yaml:"targetService"`
+	TargetNamespace string `yaml:"targetNamespace"`
+}
+
+// kubernetesSpec holds the settings used when rendering a scenario into
+// Kubernetes manifests.
+type kubernetesSpec struct {
+	Namespace      string `yaml:"namespace"`
+	Image          string `yaml:"image"`
+	Kind           string `yaml:"kind"`
+	Replicas       int    `yaml:"replicas"`
+	ServiceMonitor bool   `yaml:"serviceMonitor"`
+	MetricsPort    int    `yaml:"metricsPort"`
+}
+
+// loadScenario reads a scenario file and fills in defaults for the settings
+// that are not specified.
+func loadScenario(path string) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &scenario{Config: loadgen.DefaultConfig()}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %w", path, err)
+	}
+	s.setDefaults()
+	return s, nil
+}
+
+func (s *scenario) setDefaults() {
+	if s.Name == "" {
+		s.Name = "cloud-event-test"
+	}
+	if s.Kubernetes.Namespace == "" {
+		s.Kubernetes.Namespace = "default"
+	}
+	if s.Kubernetes.Image == "" {
+		s.Kubernetes.Image = "quay.io/redhat-cne/hw-event-proxy-e2e-test:latest"
+	}
+	if s.Kubernetes.Kind == "" {
+		s.Kubernetes.Kind = "Job"
+	}
+	if s.Kubernetes.Replicas == 0 {
+		s.Kubernetes.Replicas = 1
+	}
+	if s.Kubernetes.MetricsPort == 0 {
+		s.Kubernetes.MetricsPort = 9091
+	}
+}
@@ -0,0 +1,146 @@
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// schedule lists the recurring runs of a daemon, so lab regressions run
+// continuously without an external scheduler.
+type schedule struct {
+	ReportDir string          `yaml:"reportDir"`
+	Entries   []scheduleEntry `yaml:"schedules"`
+}
+
+// scheduleEntry runs a scenario every interval.
+type scheduleEntry struct {
+	Name     string        `yaml:"name"`
+	Every    time.Duration `yaml:"every"`
+	Scenario string        `yaml:"scenario"`
+	// RunAtStart also runs the scenario when the daemon starts instead of
+	// waiting for the first interval.
+	RunAtStart bool `yaml:"runAtStart"`
+}
+
+// scheduleReport is written for every occurrence of a scheduled run.
+type scheduleReport struct {
+	Schedule string `json:"schedule"`
+	Scenario string `json:"scenario"`
+	Error    string `json:"error,omitempty"`
+	Run      *run   `json:"run,omitempty"`
+}
+
+func loadSchedule(path string) (*schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &schedule{}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule %s: %w", path, err)
+	}
+	if s.ReportDir == "" {
+		s.ReportDir = "reports"
+	}
+	for i := range s.Entries {
+		e := &s.Entries[i]
+		if e.Scenario == "" {
+			return nil, fmt.Errorf("schedule %d in %s has no scenario", i, path)
+		}
+		if e.Every <= 0 {
+			return nil, fmt.Errorf("schedule %d in %s needs a positive interval", i, path)
+		}
+		if e.Name == "" {
+			e.Name = trimExt(filepath.Base(e.Scenario))
+		}
+		// catch typos when the daemon starts instead of at the first occurrence
+		sc, err := loadScenario(e.Scenario)
+		if err != nil {
+			return nil, err
+		}
+		if err := sc.singlePhase("schedules"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// run starts the scheduled runs until ctx is cancelled.
+func (s *schedule) run(ctx context.Context, d *daemon) {
+	for _, e := range s.Entries {
+		log.Infof("Scheduled %s every %v", e.Name, e.Every)
+		go s.runEntry(ctx, d, e)
+	}
+}
+
+func (s *schedule) runEntry(ctx context.Context, d *daemon, e scheduleEntry) {
+	ticker := time.NewTicker(e.Every)
+	defer ticker.Stop()
+	if e.RunAtStart {
+		s.occurrence(ctx, d, e)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.occurrence(ctx, d, e)
+		}
+	}
+}
+
+// occurrence runs the scenario of a schedule once and writes its report. An
+// occurrence is skipped if another run is in progress.
+func (s *schedule) occurrence(ctx context.Context, d *daemon, e scheduleEntry) {
+	started := time.Now()
+	report := &scheduleReport{Schedule: e.Name, Scenario: e.Scenario}
+	sc, err := loadScenario(e.Scenario)
+	if err == nil {
+		var r *run
+		if r, err = d.start(sc.Config); err == nil {
+			log.Infof("Scheduled run %s of %s started", r.ID, e.Name)
+			select {
+			case <-r.done:
+			case <-ctx.Done():
+				return
+			}
+			report.Run, err = d.get(r.ID)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, errRunActive) {
+			log.Warnf("Skipping scheduled run of %s: %v", e.Name, err)
+		} else {
+			log.Errorf("Scheduled run of %s failed: %v", e.Name, err)
+		}
+		report.Error = err.Error()
+	}
+	if err := s.writeReport(started, report); err != nil {
+		log.Errorf("Failed to write report of %s: %v", e.Name, err)
+	}
+}
+
+func (s *schedule) writeReport(started time.Time, report *scheduleReport) error {
+	if err := os.MkdirAll(s.ReportDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.ReportDir, report.Schedule+"-"+started.UTC().Format("20060102T150405Z")+".json")
+	log.Infof("Writing report %s", path)
+	return os.WriteFile(path, data, 0644)
+}
+
+func trimExt(name string) string {
+	return name[:len(name)-len(filepath.Ext(name))]
+}
@@ -0,0 +1,166 @@
+package tester
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+)
+
+// sequenceChunk is the number of sequence numbers of one chunk of the
+// numbers a receiver has seen.
+const sequenceChunk = 1 << 16
+
+// sequenceCounts are the sequenced events a receiver has seen of one run.
+// Missing are the numbers below the highest one not seen (yet), Reordered
+// the events that arrived after one with a higher number.
+type sequenceCounts struct {
+	Received   uint64 `json:"received"`
+	Highest    int64  `json:"highest"`
+	Missing    int64  `json:"missing"`
+	Duplicates uint64 `json:"duplicates"`
+	Reordered  uint64 `json:"reordered"`
+}
+
+// runSequence tracks the sequence numbers seen of one run, in chunks of a
+// bitmap so the numbers a run never sent take no memory.
+type runSequence struct {
+	chunks map[int64]*[sequenceChunk / 64]uint64
+	unique int64
+	counts sequenceCounts
+}
+
+// seen marks a sequence number as received.
+func (r *runSequence) seen(seq int64) {
+	r.counts.Received++
+	chunk := r.chunks[seq/sequenceChunk]
+	if chunk == nil {
+		chunk = new([sequenceChunk / 64]uint64)
+		r.chunks[seq/sequenceChunk] = chunk
+	}
+	i := seq % sequenceChunk
+	word, bit := &chunk[i/64], uint64(1)<<(i%64)
+	if *word&bit != 0 {
+		r.counts.Duplicates++
+		return
+	}
+	*word |= bit
+	r.unique++
+	if seq < r.counts.Highest {
+		r.counts.Reordered++
+		return
+	}
+	r.counts.Highest = seq
+}
+
+func (r *runSequence) snapshot() sequenceCounts {
+	c := r.counts
+	c.Missing = c.Highest - r.unique
+	return c
+}
+
+// sequenceTracker detects the lost, duplicated and reordered events of the
+// runs that sent sequenced events to a receiver. A nil tracker tracks
+// nothing.
+type sequenceTracker struct {
+	mu   sync.Mutex
+	runs map[string]*runSequence
+}
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{runs: map[string]*runSequence{}}
+}
+
+// observe records the sequence number of a structured event, if it has
+// one.
+func (t *sequenceTracker) observe(ev map[string]interface{}) {
+	run, _ := ev[events.SequenceRunAttr].(string)
+	seq, ok := ev[events.SequenceSeqAttr].(float64)
+	if !ok {
+		// an integer attribute may also be sent as a string
+		s, _ := ev[events.SequenceSeqAttr].(string)
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return
+		}
+		seq = float64(n)
+	}
+	t.record(run, int64(seq))
+}
+
+// observeHeaders records the sequence number of a binary event, if it has
+// one.
+func (t *sequenceTracker) observeHeaders(req *fasthttp.Request) {
+	seq, err := strconv.ParseInt(string(req.Header.Peek("Ce-"+events.SequenceSeqAttr)), 10, 64)
+	if err != nil {
+		return
+	}
+	t.record(string(req.Header.Peek("Ce-"+events.SequenceRunAttr)), seq)
+}
+
+func (t *sequenceTracker) record(run string, seq int64) {
+	if t == nil || run == "" || seq < 1 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.runs[run]
+	if !ok {
+		r = &runSequence{chunks: map[int64]*[sequenceChunk / 64]uint64{}}
+		t.runs[run] = r
+		log.Infof("Sequence: receiving the events of run %s", run)
+	}
+	r.seen(seq)
+}
+
+// counts returns the counts of a run, false if no event of it was seen.
+func (t *sequenceTracker) counts(run string) (sequenceCounts, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.runs[run]
+	if !ok {
+		return sequenceCounts{}, false
+	}
+	return r.snapshot(), true
+}
+
+// totals returns the counts of all runs together.
+func (t *sequenceTracker) totals() (sequenceCounts, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var all sequenceCounts
+	for _, r := range t.runs {
+		c := r.snapshot()
+		all.Received += c.Received
+		all.Missing += c.Missing
+		all.Duplicates += c.Duplicates
+		all.Reordered += c.Reordered
+	}
+	return all, len(t.runs)
+}
+
+// logRuns logs the counts of every run.
+func (t *sequenceTracker) logRuns() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	runs := make([]string, 0, len(t.runs))
+	for run := range t.runs {
+		runs = append(runs, run)
+	}
+	sort.Strings(runs)
+	for _, run := range runs {
+		c := t.runs[run].snapshot()
+		msg := fmt.Sprintf("Sequence: run %s: %d events up to %d, %d missing, %d duplicates, %d reordered",
+			run, c.Received, c.Highest, c.Missing, c.Duplicates, c.Reordered)
+		if c.Missing > 0 || c.Duplicates > 0 {
+			log.Warn(msg)
+		} else {
+			log.Info(msg)
+		}
+	}
+}
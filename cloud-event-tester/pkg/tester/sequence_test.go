@@ -0,0 +1,180 @@
+package tester
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+// network steps of a send between the sender and the receiver
+const (
+	deliver   = "deliver"
+	drop      = "drop"
+	duplicate = "duplicate"
+	// hold delivers the request after the next one
+	hold = "hold"
+)
+
+// fakeNetwork is a sender.Doer passing the requests to a receiver, losing,
+// duplicating or reordering them by the step of each send.
+type fakeNetwork struct {
+	steps    []string
+	sequence *sequenceTracker
+	send     int
+	held     *fasthttp.Request
+	// sent and delivered are the IDs of the events in the order they were
+	// sent and received
+	sent, delivered []string
+}
+
+var _ sender.Doer = (*fakeNetwork)(nil)
+
+func (n *fakeNetwork) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	step := n.steps[n.send]
+	n.send++
+	n.sent = append(n.sent, requestIDs(req)...)
+	switch step {
+	case drop:
+		return errors.New("connection reset")
+	case hold:
+		n.held = new(fasthttp.Request)
+		req.CopyTo(n.held)
+		res.SetStatusCode(fasthttp.StatusAccepted)
+		return nil
+	case duplicate:
+		n.receive(req)
+	}
+	n.receive(req)
+	if n.held != nil {
+		n.receive(n.held)
+		n.held = nil
+	}
+	res.SetStatusCode(fasthttp.StatusAccepted)
+	return nil
+}
+
+func (n *fakeNetwork) receive(req *fasthttp.Request) {
+	if _, err := validateEvents(req, true, stampObserver{sequences: n.sequence, received: time.Now()}); err == nil {
+		n.delivered = append(n.delivered, requestIDs(req)...)
+	}
+}
+
+// requestIDs returns the IDs of the events of a request.
+func requestIDs(req *fasthttp.Request) []string {
+	if id := req.Header.Peek("Ce-Id"); len(id) > 0 {
+		return []string{string(id)}
+	}
+	evs, _ := events.StructuredEvents(req)
+	ids := make([]string, len(evs))
+	for i, ev := range evs {
+		var attrs struct{ ID string }
+		json.Unmarshal(ev, &attrs) //nolint: errcheck
+		ids[i] = attrs.ID
+	}
+	return ids
+}
+
+// sequencedEvent returns event seq of run as a structured cloud event.
+func sequencedEvent(run string, seq int) string {
+	return fmt.Sprintf(`{"specversion":"1.0","id":"%s-%d","source":"/test","type":"test","%s":"%s","%s":%d}`,
+		run, seq, events.SequenceRunAttr, run, events.SequenceSeqAttr, seq)
+}
+
+// TestSequenceTracker sends the numbered events of a run through a network
+// that loses, duplicates and reorders some of them, and checks the receiver
+// counts exactly those.
+func TestSequenceTracker(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  []string
+		binary bool
+		// batch sends the events in batches of two
+		batch  bool
+		failed int
+		want   sequenceCounts
+	}{
+		{
+			name:  "all delivered",
+			steps: []string{deliver, deliver, deliver, deliver},
+			want:  sequenceCounts{Received: 4, Highest: 4},
+		},
+		{
+			name:   "lost",
+			steps:  []string{deliver, drop, deliver, drop},
+			failed: 2,
+			want:   sequenceCounts{Received: 2, Highest: 3, Missing: 1},
+		},
+		{
+			name:   "lost, duplicated and reordered",
+			steps:  []string{deliver, drop, duplicate, hold, deliver, deliver},
+			failed: 1,
+			want:   sequenceCounts{Received: 6, Highest: 6, Missing: 1, Duplicates: 1, Reordered: 1},
+		},
+		{
+			name:   "binary",
+			steps:  []string{hold, deliver, drop, duplicate},
+			binary: true,
+			failed: 1,
+			want:   sequenceCounts{Received: 4, Highest: 4, Missing: 1, Duplicates: 1, Reordered: 1},
+		},
+		{
+			name:   "batches",
+			steps:  []string{deliver, drop, duplicate},
+			batch:  true,
+			failed: 1,
+			want:   sequenceCounts{Received: 6, Highest: 6, Missing: 2, Duplicates: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const run = "run1"
+			n := &fakeNetwork{steps: tt.steps, sequence: newSequenceTracker()}
+			var client sender.Doer = n
+			req := fasthttp.AcquireRequest()
+			res := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseRequest(req)
+			defer fasthttp.ReleaseResponse(res)
+			failed, seq := 0, 0
+			var want []string
+			for range tt.steps {
+				req.Reset()
+				var body string
+				if tt.batch {
+					body = "[" + sequencedEvent(run, seq+1) + "," + sequencedEvent(run, seq+2) + "]"
+					want = append(want, fmt.Sprintf("%s-%d", run, seq+1), fmt.Sprintf("%s-%d", run, seq+2))
+					seq += 2
+				} else {
+					seq++
+					body = sequencedEvent(run, seq)
+					want = append(want, fmt.Sprintf("%s-%d", run, seq))
+				}
+				if err := events.SetEvent(req, []byte(body), events.ContentMode{Binary: tt.binary}); err != nil {
+					t.Fatalf("SetEvent failed: %v", err)
+				}
+				if err := client.Do(req, res); err != nil {
+					failed++
+				}
+			}
+			if fmt.Sprint(n.sent) != fmt.Sprint(want) {
+				t.Errorf("events sent in the order %v, want %v", n.sent, want)
+			}
+			if failed != tt.failed {
+				t.Errorf("%d sends failed, want %d", failed, tt.failed)
+			}
+			got, ok := n.sequence.counts(run)
+			if !ok {
+				t.Fatalf("no events of run %s counted, delivered %v", run, n.delivered)
+			}
+			if got != tt.want {
+				t.Errorf("counts = %+v, want %+v, delivered %v", got, tt.want, n.delivered)
+			}
+		})
+	}
+}
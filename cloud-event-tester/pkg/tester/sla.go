@@ -0,0 +1,5 @@
+package tester
+
+// exitSLAViolation is the exit code of a run that violated its SLA
+// thresholds; other failures exit with 1.
+const exitSLAViolation = 2
@@ -0,0 +1,144 @@
+package tester
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "sweep",
+		summary: "Run a performance segment for each of a sequence of payload sizes",
+		run:     runSweep,
+	})
+}
+
+// sweepSegment is one line of the table of a payload size sweep. MBPerSec is
+// the throughput of the payloads alone, without the headers.
+type sweepSegment struct {
+	Size      int                  `json:"size"`
+	TotalMsg  int                  `json:"totalMsg"`
+	AvgRate   float64              `json:"avgRate"`
+	MBPerSec  float64              `json:"mbPerSec"`
+	ErrorRate float64              `json:"errorRate"`
+	Latency   *report.LatencyStats `json:"latency,omitempty"`
+}
+
+func runSweep(args []string) error {
+	cfg := loadgen.DefaultConfig()
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	cfg.BindFlags(fs)
+	sizes := fs.String("sizes", "1KB,10KB,100KB,1MB", "Comma separated payload sizes of the segments, in B, KB or MB")
+	stopErrorRate := fs.Float64("stop-error-rate", 0, "Stop after the first segment whose error rate exceeds this percentage (0 to run every size)")
+	asJSON := fs.Bool("json", false, "Print the table as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s sweep [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) //nolint: errcheck
+	cfg.ApplyEnv()
+	if envSizes := os.Getenv("SWEEP_SIZES"); envSizes != "" {
+		*sizes = envSizes
+	}
+	// every segment is a performance run
+	cfg.Perf = "YES"
+
+	list, err := parseSizes(*sizes)
+	if err != nil {
+		return err
+	}
+	if *stopErrorRate < 0 {
+		return fmt.Errorf("stop-error-rate must not be negative, got %g", *stopErrorRate)
+	}
+	cfg.PayloadSize = list[0]
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Continuous() {
+		return fmt.Errorf("the segments of a sweep need a duration")
+	}
+	if cfg.FindMax != "" {
+		return fmt.Errorf("a sweep runs its segments at the rate given, find-max is not supported")
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	var segments []sweepSegment
+	var sla loadgen.SLAViolation
+	for i, size := range list {
+		seg := cfg.Clone()
+		seg.PayloadSize = size
+		seg.ReportFile = loadgen.SuffixPath(cfg.ReportFile, loadgen.FormatBytes(uint64(size)))
+		seg.TimeseriesFile = loadgen.SuffixPath(cfg.TimeseriesFile, loadgen.FormatBytes(uint64(size)))
+		seg.JUnitFile = loadgen.SuffixPath(cfg.JUnitFile, loadgen.FormatBytes(uint64(size)))
+		seg.TraceFile = loadgen.SuffixPath(cfg.TraceFile, loadgen.FormatBytes(uint64(size)))
+		log.Infof("=== Segment %d/%d: %s payload ===", i+1, len(list), loadgen.FormatBytes(uint64(size)))
+		result, err := loadgen.Run(ctx, &seg, nil)
+		if err != nil {
+			return err
+		}
+		sla.AddViolations(result)
+		segments = append(segments, sweepSegment{
+			Size:      size,
+			TotalMsg:  result.TotalMsg,
+			AvgRate:   result.AvgRate,
+			MBPerSec:  result.AvgRate * float64(size*cfg.BatchSize) / (1 << 20),
+			ErrorRate: result.ErrorRate,
+			Latency:   result.Latency,
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		if *stopErrorRate > 0 && result.ErrorRate > *stopErrorRate {
+			log.Warnf("Stopping the sweep: %.2f%% of the sends of the %s segment failed", result.ErrorRate, loadgen.FormatBytes(uint64(size)))
+			break
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(segments) //nolint: errcheck
+	} else {
+		printSweep(segments)
+	}
+	return sla.Err()
+}
+
+// parseSizes parses a comma separated list of sizes, in bytes or with a
+// binary unit.
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, f := range sender.SplitList(s) {
+		size, err := loadgen.ParseSize(f)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no payload sizes given")
+	}
+	return sizes, nil
+}
+
+// printSweep prints the segments of a sweep as a table.
+func printSweep(segments []sweepSegment) {
+	fmt.Printf("%-10s %10s %12s %10s %9s %10s %10s %10s\n", "SIZE", "SENT", "RATE(MSG/S)", "MB/S", "ERRORS", "P50(MS)", "P99(MS)", "MAX(MS)")
+	for _, s := range segments {
+		p50, p99, slowest := "-", "-", "-"
+		if s.Latency != nil {
+			p50, p99, slowest = fmt.Sprintf("%.3f", s.Latency.P50), fmt.Sprintf("%.3f", s.Latency.P99), fmt.Sprintf("%.3f", s.Latency.Max)
+		}
+		fmt.Printf("%-10s %10d %12.1f %10.2f %8.2f%% %10s %10s %10s\n",
+			loadgen.FormatBytes(uint64(s.Size)), s.TotalMsg, s.AvgRate, s.MBPerSec, s.ErrorRate, p50, p99, slowest)
+	}
+}
@@ -0,0 +1,237 @@
+package tester
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "tap",
+		summary: "Receive live events and mirror them to a second target",
+		run:     runTap,
+	})
+}
+
+// tapEvent is a received event queued for mirroring.
+type tapEvent struct {
+	header http.Header
+	body   []byte
+}
+
+// tapStats counts what the tap did with the received events.
+type tapStats struct {
+	Received      uint64
+	Forwarded     uint64
+	ForwardErrors uint64
+	Sampled       uint64
+	Mirrored      uint64
+	MirrorErrors  uint64
+	Dropped       uint64
+}
+
+// tap receives events, optionally forwards them to the primary consumer and
+// mirrors a sample of them to a second target in the background, so
+// production-like traffic can be replayed into a staging consumer.
+type tap struct {
+	forward string
+	mirror  string
+	sample  float64
+
+	queue chan tapEvent
+	stats tapStats
+}
+
+func runTap(args []string) error {
+	fs := flag.NewFlagSet("tap", flag.ExitOnError)
+	listen := fs.String("listen", ":9087", "Listen address for incoming events")
+	forward := fs.String("forward", "", "Primary consumer URL events are passed on to (default: acknowledge events without forwarding)")
+	mirror := fs.String("mirror", "", "URL of the second target events are mirrored to (required)")
+	sample := fs.Float64("sample", 1, "Fraction of the events mirrored, 1 mirrors every event")
+	queueSize := fs.Int("queue", 1000, "Events buffered for mirroring before new ones are dropped")
+	workers := fs.Int("workers", 4, "Concurrent mirror requests")
+	fs.Parse(args) //nolint: errcheck
+	if envMirror := os.Getenv("TAP_MIRROR_URL"); envMirror != "" {
+		*mirror = envMirror
+	}
+	if envForward := os.Getenv("TAP_FORWARD_URL"); envForward != "" {
+		*forward = envForward
+	}
+	if envSample := os.Getenv("TAP_SAMPLE"); envSample != "" {
+		if v, err := strconv.ParseFloat(envSample, 64); err == nil {
+			*sample = v
+		}
+	}
+
+	if *mirror == "" {
+		return fmt.Errorf("-mirror is required")
+	}
+	if *sample <= 0 || *sample > 1 {
+		return fmt.Errorf("sample must be in (0, 1], got %v", *sample)
+	}
+	if *queueSize < 1 || *workers < 1 {
+		return fmt.Errorf("queue and workers must be at least 1")
+	}
+	t := &tap{forward: *forward, mirror: *mirror, sample: *sample, queue: make(chan tapEvent, *queueSize)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.mirrorLoop()
+		}()
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: t}
+	ctx, stop := signalContext()
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx) //nolint: errcheck
+	}()
+	go t.logStats(ctx)
+
+	log.Infof("Tap listening on %s, mirroring %.0f%% of events to %s", *listen, *sample*100, *mirror)
+	if *forward != "" {
+		log.Infof("Forwarding events to %s", *forward)
+	}
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	// mirror what is still queued before summarizing
+	close(t.queue)
+	wg.Wait()
+	log.Infof("Tap stopped: %s", t.summary())
+	return nil
+}
+
+func (t *tap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	atomic.AddUint64(&t.stats.Received, 1)
+	t.enqueue(tapEvent{header: r.Header.Clone(), body: body})
+
+	if t.forward == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// the sender gets the response of the primary consumer
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	buildTapRequest(req, t.forward, r.Header, body)
+	if err := fasthttp.Do(req, res); err != nil {
+		atomic.AddUint64(&t.stats.ForwardErrors, 1)
+		log.Errorf("Failed to forward event: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	atomic.AddUint64(&t.stats.Forwarded, 1)
+	res.Header.VisitAll(func(k, v []byte) {
+		if !sender.HopHeaders[string(k)] {
+			w.Header().Add(string(k), string(v))
+		}
+	})
+	w.WriteHeader(res.StatusCode())
+	w.Write(res.Body()) //nolint: errcheck
+}
+
+// enqueue queues an event for mirroring if it is sampled. When the queue is
+// full the event is dropped rather than slowing down the primary path.
+func (t *tap) enqueue(ev tapEvent) {
+	if t.sample < 1 && rand.Float64() >= t.sample {
+		return
+	}
+	atomic.AddUint64(&t.stats.Sampled, 1)
+	select {
+	case t.queue <- ev:
+	default:
+		atomic.AddUint64(&t.stats.Dropped, 1)
+	}
+}
+
+func (t *tap) mirrorLoop() {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	for ev := range t.queue {
+		req.Reset()
+		buildTapRequest(req, t.mirror, ev.header, ev.body)
+		if err := fasthttp.Do(req, res); err != nil {
+			atomic.AddUint64(&t.stats.MirrorErrors, 1)
+			log.Debugf("Failed to mirror event: %v", err)
+			continue
+		}
+		atomic.AddUint64(&t.stats.Mirrored, 1)
+	}
+}
+
+// buildTapRequest prepares a POST of a received event to url, keeping the
+// headers of the original request such as the content type and the ce-
+// attributes of binary mode CloudEvents.
+func buildTapRequest(req *fasthttp.Request, url string, header http.Header, body []byte) {
+	req.Header.SetMethod("POST")
+	req.SetRequestURI(url)
+	for k, vs := range header {
+		if sender.HopHeaders[k] {
+			continue
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.SetBody(body)
+}
+
+func (t *tap) logStats(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Infof("Tap: %s, queued %d", t.summary(), len(t.queue))
+		}
+	}
+}
+
+func (t *tap) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "received %d", atomic.LoadUint64(&t.stats.Received))
+	if t.forward != "" {
+		fmt.Fprintf(&b, ", forwarded %d (%d errors)", atomic.LoadUint64(&t.stats.Forwarded), atomic.LoadUint64(&t.stats.ForwardErrors))
+	}
+	fmt.Fprintf(&b, ", sampled %d, mirrored %d (%d errors), dropped %d",
+		atomic.LoadUint64(&t.stats.Sampled), atomic.LoadUint64(&t.stats.Mirrored),
+		atomic.LoadUint64(&t.stats.MirrorErrors), atomic.LoadUint64(&t.stats.Dropped))
+	return b.String()
+}
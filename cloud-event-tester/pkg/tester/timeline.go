@@ -0,0 +1,72 @@
+package tester
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/sender"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "timeline",
+		summary: "Play an ordered manifest of events at their times and check the response to each",
+		run:     runTimeline,
+	})
+}
+
+func runTimeline(args []string) error {
+	cfg := loadgen.DefaultConfig()
+	fs := flag.NewFlagSet("timeline", flag.ExitOnError)
+	cfg.BindFlags(fs)
+	file := fs.String("manifest", "", "Timeline manifest (YAML) of the ordered events to play (required)")
+	speed := fs.Float64("speed", 1, "Speed multiplier of the times of the steps, 2 plays twice as fast")
+	fs.Parse(args) //nolint: errcheck
+	cfg.ApplyEnv()
+	if envManifest := os.Getenv("TIMELINE_MANIFEST"); envManifest != "" {
+		*file = envManifest
+	}
+	if envSpeed := os.Getenv("TIMELINE_SPEED"); envSpeed != "" {
+		if s, err := strconv.ParseFloat(envSpeed, 64); err == nil {
+			*speed = s
+		}
+	}
+
+	if *file == "" {
+		return fmt.Errorf("-manifest is required")
+	}
+	if *speed <= 0 {
+		return fmt.Errorf("speed must be positive, got %g", *speed)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if strings.ToLower(cfg.Transport) != sender.TransportHTTP {
+		return fmt.Errorf("timeline steps check the response to each event, they need the http transport")
+	}
+	m, err := loadgen.LoadTimeline(*file, cfg.AssertionSpec())
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	result, err := loadgen.PlayTimeline(ctx, &cfg, m, *speed)
+	if result != nil {
+		result.Labels = cfg.Labels
+		loadgen.PublishReport(&cfg, result)
+		loadgen.WriteReportFile(&cfg, result)
+		loadgen.WriteJUnitFile(&cfg, result)
+	}
+	loadgen.NotifyCompletion(&cfg, result, err)
+	if err != nil {
+		return err
+	}
+	if failed := len(m.Steps) - result.Succeeded; failed > 0 {
+		return fmt.Errorf("%d of %d timeline steps failed", failed, len(m.Steps))
+	}
+	return nil
+}
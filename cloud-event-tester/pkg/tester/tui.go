@@ -0,0 +1,193 @@
+package tester
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+const (
+	// dashboardWindow is the number of seconds of the rate sparkline
+	dashboardWindow = 60
+	// dashboardLogLines are the latest log lines shown below the stats
+	dashboardLogLines = 5
+	// dashboardMaxLog bounds the log kept while the dashboard is shown, to
+	// be written out when it closes
+	dashboardMaxLog = 1 << 20
+)
+
+// sparkBlocks are the bars of the sparkline, from lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboard is the live view of -tui: the rate, counts and latency of a
+// performance run and the sparkline of the rate of the last minute, redrawn
+// every second on the alternate screen of the terminal. The log is held back
+// while it is shown, only its latest lines are, and written out when it
+// closes, so the summary of the run ends up where it would without it.
+type dashboard struct {
+	out       io.Writer
+	cfg       *loadgen.Config
+	requested float64
+	latency   *report.SharedHistogram
+
+	mu      sync.Mutex
+	rates   []uint64
+	errors  int
+	last    report.TickStats
+	log     bytes.Buffer
+	dropped bool
+	logOut  io.Writer
+}
+
+// newDashboard shows the dashboard of a run on stdout, or returns nil if
+// stdout is not a terminal.
+func newDashboard(cfg *loadgen.Config) *dashboard {
+	if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		log.Warnf("Not showing the dashboard, stdout is not a terminal")
+		return nil
+	}
+	d := &dashboard{
+		out:       os.Stdout,
+		cfg:       cfg,
+		requested: loadgen.RequestedRate(cfg),
+		latency:   report.NewSharedHistogram(nil),
+		logOut:    log.StandardLogger().Out,
+	}
+	log.SetOutput(d)
+	// the alternate screen keeps the terminal as it was
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l")
+	d.draw()
+	return d
+}
+
+// Write keeps a log line for the dashboard and for when it closes.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.log.Len()+len(p) > dashboardMaxLog {
+		// keep the newer half
+		rest := d.log.Bytes()[d.log.Len()/2:]
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[i+1:]
+		}
+		kept := append([]byte(nil), rest...)
+		d.log.Reset()
+		d.log.Write(kept)
+		d.dropped = true
+	}
+	return d.log.Write(p)
+}
+
+// context returns ctx with the histogram of the latencies the dashboard
+// shows, see loadgen.WithLiveLatency.
+func (d *dashboard) context(ctx context.Context) context.Context {
+	if d == nil {
+		return ctx
+	}
+	return loadgen.WithLiveLatency(ctx, d.latency)
+}
+
+// tick is the loadgen.StatsListener of the run.
+func (d *dashboard) tick(stats report.TickStats) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.rates = append(d.rates, stats.Sent)
+	if len(d.rates) > dashboardWindow {
+		d.rates = d.rates[len(d.rates)-dashboardWindow:]
+	}
+	d.errors += stats.Errors
+	d.last = stats
+	d.mu.Unlock()
+	d.draw()
+}
+
+func (d *dashboard) draw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "Cloud Event Tester  %s\n\n", d.cfg.URL)
+	if d.cfg.Continuous() {
+		fmt.Fprintf(&b, "  Elapsed    %ds, until stopped\n", d.last.Second)
+	} else {
+		fmt.Fprintf(&b, "  Elapsed    %ds of %gs\n", d.last.Second, d.cfg.Duration)
+	}
+	fmt.Fprintf(&b, "  Rate       %d msg/s of %.0f requested\n", d.last.Sent, d.requested)
+	fmt.Fprintf(&b, "  Sent       %d\n", d.last.TotalMsg)
+	fmt.Fprintf(&b, "  Errors     %d (%d in the last second)\n", d.errors, d.last.Errors)
+	if d.last.QueueDepth > 0 {
+		fmt.Fprintf(&b, "  Queue      %d\n", d.last.QueueDepth)
+	}
+	if l := d.latency.Stats(); l != nil {
+		fmt.Fprintf(&b, "  Latency    p50 %.3f  p90 %.3f  p99 %.3f  max %.3f ms\n", l.P50, l.P90, l.P99, l.Max)
+	} else {
+		b.WriteString("  Latency    -\n")
+	}
+	fmt.Fprintf(&b, "\n  Last %ds  %s\n\n", dashboardWindow, sparkline(d.rates))
+	for _, line := range lastLines(d.log.Bytes(), dashboardLogLines) {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+	io.WriteString(d.out, b.String()) //nolint: errcheck
+}
+
+// close restores the terminal and the log and writes out the log held back.
+func (d *dashboard) close() {
+	if d == nil {
+		return
+	}
+	fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	log.SetOutput(d.logOut)
+	if d.dropped {
+		fmt.Fprintln(d.logOut, "[earlier log lines dropped]")
+	}
+	d.logOut.Write(d.log.Bytes()) //nolint: errcheck
+	d.log.Reset()
+}
+
+// sparkline draws values as bars scaled to the largest.
+func sparkline(values []uint64) string {
+	var peak uint64
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 {
+			i = int(v * uint64(len(sparkBlocks)-1) / peak)
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+// lastLines returns the last n lines of text, searched from its end since
+// the log held back grows long.
+func lastLines(text []byte, n int) []string {
+	text = bytes.TrimRight(text, "\n")
+	var lines []string
+	for len(text) > 0 && len(lines) < n {
+		i := bytes.LastIndexByte(text, '\n')
+		lines = append([]string{string(text[i+1:])}, lines...)
+		if i < 0 {
+			break
+		}
+		text = text[:i]
+	}
+	return lines
+}
@@ -0,0 +1,237 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/events"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+)
+
+// maxExtensionNameLength is the length extension names should not exceed,
+// so they fit the attribute names of every protocol binding.
+const maxExtensionNameLength = 20
+
+// attributeName is the form of the names of all context attributes.
+var attributeName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+func init() {
+	registerCommand(&command{
+		name:    "validate",
+		summary: "Check event files against the CloudEvents 1.0 specification",
+		run:     runValidate,
+	})
+}
+
+// eventDiagnosis is the outcome of validating one event file.
+type eventDiagnosis struct {
+	errors   []string
+	warnings []string
+	// plain is set for JSON events that are not cloud events, like the
+	// Redfish sample events
+	plain bool
+}
+
+func (d *eventDiagnosis) errorf(format string, args ...interface{}) {
+	d.errors = append(d.errors, fmt.Sprintf(format, args...))
+}
+
+func (d *eventDiagnosis) warnf(format string, args ...interface{}) {
+	d.warnings = append(d.warnings, fmt.Sprintf(format, args...))
+}
+
+func runValidate(args []string) error {
+	cfg := loadgen.DefaultConfig()
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "Directory containing the event files to check")
+	fs.StringVar(&cfg.EventFile, "event-file", "", "Single event file to check (overrides data-dir)")
+	fs.StringVar(&cfg.SchemaDir, "schema-dir", "", "Directory of JSON Schemas of the event data, named <event type>.json")
+	fs.Var((*events.LabelsFlag)(&cfg.Schemas), "schema", "JSON Schema file of the data of an event type, type=file (repeatable)")
+	strict := fs.Bool("strict", false, "Fail on JSON events that are not cloud events")
+	fs.Parse(args) //nolint: errcheck
+
+	schemas, err := events.LoadSchemas(cfg.SchemaDir, cfg.Schemas)
+	if err != nil {
+		return err
+	}
+
+	files, err := loadgen.EventFiles(&cfg)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no event files found to check")
+	}
+	var seq int64
+	invalid, plain := 0, 0
+	for _, file := range files {
+		name := filepath.Base(file)
+		d := &eventDiagnosis{}
+		event, err := events.ReadEventFile(file)
+		if err == nil {
+			// placeholders are checked as rendered
+			event, err = events.RenderEvent(name, event, &seq)
+		}
+		if err != nil {
+			d.errorf("%v", err)
+		} else {
+			validateCloudEvent(event, d)
+			if err := schemas.Check(name, event); err != nil {
+				d.errorf("%v", err)
+			}
+		}
+		if d.plain && *strict {
+			d.errorf("not a cloud event, it has no specversion")
+		}
+		switch {
+		case len(d.errors) > 0:
+			invalid++
+			fmt.Printf("%s: INVALID, %d errors, %d warnings\n", name, len(d.errors), len(d.warnings))
+		case d.plain:
+			plain++
+			fmt.Printf("%s: not a cloud event, sent as is or as the data of a new one in binary mode\n", name)
+		case len(d.warnings) > 0:
+			fmt.Printf("%s: OK, %d warnings\n", name, len(d.warnings))
+		default:
+			fmt.Printf("%s: OK\n", name)
+		}
+		for _, e := range d.errors {
+			fmt.Printf("  error: %s\n", e)
+		}
+		for _, w := range d.warnings {
+			fmt.Printf("  warning: %s\n", w)
+		}
+	}
+	fmt.Printf("%d files: %d valid, %d invalid, %d not cloud events\n", len(files), len(files)-invalid-plain, invalid, plain)
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d event files are invalid", invalid, len(files))
+	}
+	return nil
+}
+
+// validateCloudEvent checks an event in the JSON format of CloudEvents 1.0:
+// the required attributes, the types of all attributes and the names and
+// values of the extensions. JSON objects without any of the required
+// attributes are not taken for cloud events.
+func validateCloudEvent(event []byte, d *eventDiagnosis) {
+	var attrs map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(event))
+	dec.UseNumber()
+	if err := dec.Decode(&attrs); err != nil {
+		d.errorf("not a JSON object: %v", err)
+		return
+	}
+	if attrs["specversion"] == nil && attrs["id"] == nil && attrs["source"] == nil && attrs["type"] == nil {
+		d.plain = true
+		return
+	}
+
+	str := func(name string, required bool) (string, bool) {
+		raw, ok := attrs[name]
+		if !ok {
+			if required {
+				d.errorf("required attribute %s is missing", name)
+			}
+			return "", false
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			d.errorf("attribute %s must be a string, got %s", name, raw)
+			return "", false
+		}
+		if s == "" && required {
+			d.errorf("required attribute %s is empty", name)
+			return "", false
+		}
+		return s, true
+	}
+	if v, ok := str("specversion", true); ok && v != "1.0" {
+		d.errorf("specversion is %q, not 1.0", v)
+	}
+	str("id", true)
+	str("type", true)
+	if v, ok := str("source", true); ok {
+		if _, err := url.Parse(v); err != nil {
+			d.errorf("source %q is not a URI reference: %v", v, err)
+		}
+	}
+	if v, ok := str("datacontenttype", false); ok {
+		if _, _, err := mime.ParseMediaType(v); err != nil {
+			d.errorf("datacontenttype %q is not a media type: %v", v, err)
+		}
+	}
+	if v, ok := str("dataschema", false); ok {
+		if u, err := url.Parse(v); err != nil || !u.IsAbs() {
+			d.errorf("dataschema %q is not an absolute URI", v)
+		}
+	}
+	if v, ok := str("subject", false); ok && v == "" {
+		d.errorf("subject must not be empty if present")
+	}
+	if v, ok := str("time", false); ok {
+		if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+			d.errorf("time %q is not an RFC 3339 timestamp", v)
+		}
+	}
+	if _, ok := attrs["data_base64"]; ok {
+		if _, ok := attrs["data"]; ok {
+			d.errorf("data and data_base64 are mutually exclusive")
+		}
+		if v, ok := str("data_base64", false); ok {
+			if _, err := base64.StdEncoding.DecodeString(v); err != nil {
+				d.errorf("data_base64 is not base64: %v", err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch name {
+		case "specversion", "id", "type", "source", "datacontenttype", "dataschema", "subject", "time", "data", "data_base64":
+			continue
+		}
+		if !attributeName.MatchString(name) {
+			d.errorf("extension name %q must consist of lower-case letters and digits", name)
+			continue
+		}
+		if len(name) > maxExtensionNameLength {
+			d.warnf("extension name %q is longer than %d characters", name, maxExtensionNameLength)
+		}
+		validateExtensionValue(name, attrs[name], d)
+	}
+}
+
+// validateExtensionValue checks that an extension has a value of one of the
+// types of context attributes in JSON: a string, a boolean or an integer.
+func validateExtensionValue(name string, raw json.RawMessage, d *eventDiagnosis) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	dec.Decode(&v) //nolint: errcheck
+	switch v := v.(type) {
+	case nil:
+		d.warnf("extension %s is null, which consumers treat as absent", name)
+	case string, bool:
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil || n < math.MinInt32 || n > math.MaxInt32 {
+			d.errorf("extension %s is %s, numbers must be 32-bit integers", name, v)
+		}
+	default:
+		d.errorf("extension %s must be a string, boolean or integer, got %s", name, raw)
+	}
+}
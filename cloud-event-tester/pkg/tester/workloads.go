@@ -0,0 +1,147 @@
+package tester
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/loadgen"
+	"github.com/jzding/cloud-event-tools/cloud-event-tester/pkg/report"
+)
+
+// workloadResult is the outcome of one workload of a scenario.
+type workloadResult struct {
+	Workload string         `json:"workload"`
+	URL      string         `json:"url"`
+	Result   *report.Result `json:"result,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// workloadTotals are the workloads of a scenario together. AvgRate is the
+// sum of their rates, Latency that of the sends of all performance runs.
+type workloadTotals struct {
+	TotalMsg  int                  `json:"totalMsg"`
+	Failed    int                  `json:"failed"`
+	AvgRate   float64              `json:"avgRate"`
+	ErrorRate float64              `json:"errorRate"`
+	Latency   *report.LatencyStats `json:"latency,omitempty"`
+}
+
+// workloadReport is the report of a scenario with workloads: the stats of
+// each and their combined summary.
+type workloadReport struct {
+	Scenario  string           `json:"scenario"`
+	Workloads []workloadResult `json:"workloads"`
+	Total     workloadTotals   `json:"total"`
+}
+
+// workloadConfigs returns the settings of the workloads of the scenario. A
+// workload that keeps the report, JUnit, trace or checkpoint file of the
+// scenario gets its own, named after it.
+func (s *scenario) workloadConfigs() ([]phaseConfig, error) {
+	workloads, err := s.overlayConfigs("workload", s.Workloads, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i := range workloads {
+		w := &workloads[i]
+		if names[w.name] {
+			return nil, fmt.Errorf("scenario %s has two workloads named %s", s.Name, w.name)
+		}
+		names[w.name] = true
+		if w.cfg.ReportFile == s.ReportFile {
+			w.cfg.ReportFile = loadgen.SuffixPath(s.ReportFile, w.name)
+		}
+		if w.cfg.TimeseriesFile == s.TimeseriesFile {
+			w.cfg.TimeseriesFile = loadgen.SuffixPath(s.TimeseriesFile, w.name)
+		}
+		if w.cfg.JUnitFile == s.JUnitFile {
+			w.cfg.JUnitFile = loadgen.SuffixPath(s.JUnitFile, w.name)
+		}
+		if w.cfg.TraceFile == s.TraceFile {
+			w.cfg.TraceFile = loadgen.SuffixPath(s.TraceFile, w.name)
+		}
+		if w.cfg.CheckpointFile != "" && w.cfg.CheckpointFile == s.CheckpointFile {
+			w.cfg.CheckpointFile += "." + w.name
+		}
+	}
+	return workloads, nil
+}
+
+// runWorkloads runs the workloads of a scenario at the same time, each with
+// its own targets, rate and events and stats of its own, and sums them up.
+// All workloads are validated before the first one starts.
+func runWorkloads(ctx context.Context, s *scenario) (*workloadReport, error) {
+	workloads, err := s.workloadConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workloads {
+		if err := w.cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("workload %s: %w", w.name, err)
+		}
+	}
+	log.Infof("Running scenario %s: %d workloads", s.Name, len(workloads))
+	rep := &workloadReport{Scenario: s.Name, Workloads: make([]workloadResult, len(workloads))}
+	var wg sync.WaitGroup
+	for i := range workloads {
+		rep.Workloads[i] = workloadResult{Workload: workloads[i].name, URL: workloads[i].cfg.URL}
+		wg.Add(1)
+		go func(wr *workloadResult, cfg *loadgen.Config) {
+			defer wg.Done()
+			log.Infof("Starting workload %s", wr.Workload)
+			result, err := loadgen.Run(ctx, cfg, nil)
+			wr.Result = result
+			if err != nil {
+				log.Errorf("Workload %s failed: %v", wr.Workload, err)
+				wr.Error = err.Error()
+			}
+		}(&rep.Workloads[i], &workloads[i].cfg)
+	}
+	wg.Wait()
+
+	latency := report.NewLatencyHistogram()
+	attempts := 0
+	log.Infof("=== Workload Summary ===")
+	for i, wr := range rep.Workloads {
+		r := wr.Result
+		if r == nil {
+			log.Infof("%-20s failed: %s", wr.Workload, wr.Error)
+			continue
+		}
+		failed := r.FailedSends()
+		rep.Total.TotalMsg += r.TotalMsg
+		rep.Total.Failed += failed
+		rep.Total.AvgRate += r.AvgRate
+		// NO counts failed sends as sent, see loadgen.ErrorRate
+		attempts += r.TotalMsg
+		if strings.ToUpper(workloads[i].cfg.CheckResp) != "NO" {
+			attempts += failed
+		}
+		if r.LatencyHistogram != nil {
+			latency.Merge(r.LatencyHistogram)
+		}
+		log.Infof("%-20s %8d msg %8.1f s %10.2f msg/s %7.2f%% errors%s",
+			wr.Workload, r.TotalMsg, r.TotalSeconds, r.AvgRate, r.ErrorRate, formatP99(r.Latency))
+	}
+	if attempts > 0 {
+		rep.Total.ErrorRate = 100 * float64(rep.Total.Failed) / float64(attempts)
+	}
+	rep.Total.Latency = report.SummarizeLatency(latency)
+	log.Infof("%-20s %8d msg %10s %10.2f msg/s %7.2f%% errors%s",
+		"total", rep.Total.TotalMsg, "", rep.Total.AvgRate, rep.Total.ErrorRate, formatP99(rep.Total.Latency))
+	return rep, nil
+}
+
+// formatP99 formats the p99 latency of a summary line, nothing without
+// latency.
+func formatP99(l *report.LatencyStats) string {
+	if l == nil {
+		return ""
+	}
+	return fmt.Sprintf(" p99 %.3f ms", l.P99)
+}